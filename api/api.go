@@ -2,33 +2,145 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/GetStream/stream-backend-homework-assignment/api/validator"
+	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // A DB provides a storage layer that persists messages.
+//
+// ListMessages loads as much of the reactions of the messages as reactions
+// asks for. Hidden messages are left out unless withHidden is set. GetMessage
+// lists the reactions of the message in the given sort order, only those of
+// reactionType unless it is empty. InsertMessage generates the id of the
+// message unless it is set, ErrDuplicateMessage is returned if it is taken.
+// InsertMessage, InsertReaction and InsertReactions keep the creation time
+// of what they store if it is set, and use the current time otherwise.
 type DB interface {
-	ListMessages(ctx context.Context, limit, offset int, excludeMsgIDs ...string) ([]Message, error)
+	ListMessages(ctx context.Context, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error)
+	// ListMessagesAfter lists the messages created after the message
+	// identified by afterID, oldest first. ErrNotFound is returned if that
+	// message does not exist.
+	ListMessagesAfter(ctx context.Context, afterID string, limit int, reactions ReactionLoad, withHidden bool) ([]Message, error)
+	// ListMessagesBetween lists a page of the messages created from the
+	// time from up to, but not including, the time to, oldest first.
+	ListMessagesBetween(ctx context.Context, from, to time.Time, limit, offset int, reactions ReactionLoad, withHidden bool) ([]Message, error)
+	// SearchMessages returns a page of the messages whose text matches the
+	// words of query, best matches first.
+	SearchMessages(ctx context.Context, query string, limit, offset int, reactions ReactionLoad, withHidden bool) ([]SearchResult, error)
 	InsertMessage(ctx context.Context, msg Message) (Message, error)
+	// InsertReaction and InsertReactions return ErrNotFound if the reacted
+	// message does not exist.
 	InsertReaction(ctx context.Context, reaction Reaction) (Reaction, error)
+	InsertReactions(ctx context.Context, reactions []Reaction) ([]Reaction, error)
+	GetMessage(ctx context.Context, messageID string, sort ReactionSort, reactionType string) (Message, error)
+	GetReaction(ctx context.Context, messageID, reactionID string) (Reaction, error)
+	// GetReactionByID returns ErrNotFound if no message has the reaction.
+	GetReactionByID(ctx context.Context, reactionID string) (Reaction, error)
+	// FindReaction returns ErrNotFound if the user has not reacted to the
+	// message with the type.
+	FindReaction(ctx context.Context, messageID, userID, reactionType string) (Reaction, error)
+	// DeleteReaction returns ErrNotFound if the message has no such
+	// reaction.
+	DeleteReaction(ctx context.Context, messageID, reactionID string) error
+	// ToggleReaction deletes the reactions of the user with the type of
+	// reaction to its message, or inserts reaction if there are none, in a
+	// single transaction. It returns ErrNotFound if the message does not
+	// exist.
+	ToggleReaction(ctx context.Context, reaction Reaction) (ReactionToggle, error)
+	ListReactionsByUser(ctx context.Context, userID string) ([]Reaction, error)
+	LatestMessageTime(ctx context.Context) (time.Time, error)
+	CountMessages(ctx context.Context) (int, error)
+	CountReactions(ctx context.Context, messageID string) (int, error)
+	// GetThread leaves out hidden messages and their replies unless
+	// withHidden is set.
+	GetThread(ctx context.Context, messageID string, maxDepth, limit int, withHidden bool) ([]ThreadMessage, error)
+	SetMessagePinned(ctx context.Context, messageID string, pinned bool) (Message, error)
+	SetMessageHidden(ctx context.Context, messageID string, hidden bool) (Message, error)
+	// UpdateMessageText returns ErrNotFound if the message does not exist.
+	UpdateMessageText(ctx context.Context, messageID, text string) (Message, error)
+	// DeleteMessage deletes a message with its replies and reactions. It
+	// returns ErrNotFound if the message does not exist.
+	DeleteMessage(ctx context.Context, messageID string) error
+	ReactionSummary(ctx context.Context, messageID string) (ReactionSummary, error)
+	EmojiCounts(ctx context.Context, messageID string) (map[string]int, error)
+	// ScoreHistogram returns the number of reactions of a message per score.
+	ScoreHistogram(ctx context.Context, messageID string) (map[int]int, error)
 }
 
 // A Cache provides a storage layer that caches messages.
+//
+// Like for DB, ListMessages only loads the reactions of the messages if
+// withReactions is set, and GetMessage lists the reactions like the DB does.
+// Unlike the DB, the cache lists hidden messages too, flagged as such.
 type Cache interface {
-	ListMessages(ctx context.Context) ([]Message, error)
+	ListMessages(ctx context.Context, before time.Time, order Order, limit int, withReactions bool) ([]Message, error)
 	InsertMessage(ctx context.Context, msg Message) error
+	// InsertReaction stores nothing if the message is not cached.
 	InsertReaction(ctx context.Context, msgId string, reaction Reaction) error
+	GetMessage(ctx context.Context, messageID string, sort ReactionSort, reactionType string) (Message, error)
+	GetReaction(ctx context.Context, messageID, reactionID string) (Reaction, error)
+	FindReaction(ctx context.Context, messageID, userID, reactionType string) (Reaction, error)
+	DeleteReaction(ctx context.Context, messageID, reactionID string) error
+	SetTyping(ctx context.Context, userID string, ttl time.Duration) error
+	ListTyping(ctx context.Context) ([]string, error)
+	SetMessagePinned(ctx context.Context, msg Message) error
+	SetMessageHidden(ctx context.Context, messageID string, hidden bool) error
+	SetMessageText(ctx context.Context, messageID, text string) error
+	DeleteMessage(ctx context.Context, messageID string) error
+	ReactionSummary(ctx context.Context, messageID string) (ReactionSummary, error)
+	EmojiCounts(ctx context.Context, messageID string) (map[string]int, error)
+	GetMessageCount(ctx context.Context) (int, error)
+	SetMessageCount(ctx context.Context, n int, ttl time.Duration) error
+	Flush(ctx context.Context) (int, error)
+}
+
+// A RateLimiter counts requests in fixed time windows.
+type RateLimiter interface {
+	// Hit records a request for key and returns the number of requests
+	// recorded for key in the current window and when the window resets.
+	Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+}
+
+// ErrNotFound is returned by the storage layers when the requested item does
+// not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicateReaction is returned by the DB when a reaction conflicts with an
+// existing one.
+var ErrDuplicateReaction = errors.New("duplicate reaction")
+
+// ErrDuplicateMessage is returned by the DB when a message is inserted with the
+// id of an existing one.
+var ErrDuplicateMessage = errors.New("duplicate message")
+
+// APIVersion is the version reported in the envelope of every response. Bump
+// it whenever the shape of the responses changes.
+const APIVersion = "1"
+
+// envelope wraps the body of every successful response.
+type envelope struct {
+	APIVersion string `json:"api_version"`
+	Data       any    `json:"data"`
 }
 
 type ValidationErrorResponse struct {
-	Kind   string                      `json:"kind"`
-	Errors []validator.ValidationError `json:"errors"`
+	APIVersion string                      `json:"api_version"`
+	Kind       string                      `json:"kind"`
+	Errors     []validator.ValidationError `json:"errors"`
 }
 
 // API provides the REST endpoints for the application.
@@ -37,236 +149,2141 @@ type API struct {
 	DB     DB
 	Cache  Cache
 	Val    *validator.Validator
+	// Hub receives real-time events, such as created reactions. Optional; the
+	// event stream endpoint is only served when set.
+	Hub *Hub
+	// LogLevel is the level of the handler of Logger, which admins can
+	// change at runtime. Optional; the log level endpoint is only served when
+	// set.
+	LogLevel *slog.LevelVar
+
+	// DefaultReactionScore is the score given to reactions created without
+	// one. Defaults to 1, matching the DB default.
+	DefaultReactionScore int
+	// MaxReactionScore is the highest score a single reaction may have.
+	// Defaults to 100.
+	MaxReactionScore int
+	// ReactionScoreRanges bounds the score of reactions per canonical type.
+	// Types without a range may score from 1 to MaxReactionScore.
+	ReactionScoreRanges map[string]ScoreRange
+	// ReactionAliases maps alternative spellings of reaction types to their
+	// canonical type. Types are lowercased before the lookup. Defaults to
+	// DefaultReactionAliases.
+	ReactionAliases map[string]string
+	// ReactionTypes are the reaction types clients offer, along with how to
+	// display them. Reactions of other types can still be created.
+	// Defaults to DefaultReactionTypes.
+	ReactionTypes []ReactionType
+	// CollapseWhitespace collapses the runs of whitespace within message
+	// texts, including line breaks, to single spaces. The whitespace
+	// surrounding message texts is trimmed either way.
+	CollapseWhitespace bool
+	// TypingTTL is how long a user is reported as typing after their last
+	// typing notification. Defaults to 5 seconds.
+	TypingTTL time.Duration
+	// MaxReactionsPerMessage caps the number of reactions a single message
+	// can have. Zero means unlimited.
+	MaxReactionsPerMessage int
+	// MessageCountTTL is how long the total number of messages is cached
+	// before it is counted again. Defaults to 5 seconds.
+	MessageCountTTL time.Duration
+	// MaxOffset is the number of items the pages of list endpoints may skip
+	// at most, since skipping many rows is expensive for the DB. Defaults to
+	// 10000.
+	MaxOffset int
+	// RefreshSize is the number of latest messages RefreshLoop reloads into
+	// the cache. Defaults to 10.
+	RefreshSize int
+
+	// CursorKey signs the pagination cursors, so that clients can't forge
+	// them. Defaults to a random key, in which case cursors are only valid
+	// for the running process.
+	CursorKey []byte
+
+	// AdminToken is the bearer token required by the admin endpoints.
+	// Optional; the admin endpoints are only served when set.
+	AdminToken string
+	// UserTokens maps bearer tokens to the ids of the users they
+	// authenticate. Only authenticated users may edit and delete their
+	// messages and delete their reactions.
+	// Optional; without it, all requests are anonymous.
+	UserTokens map[string]string
+	// ModeratorToken is the bearer token granting the moderator role, which
+	// may pin and hide messages and is shown hidden messages. The AdminToken grants
+	// the role too. Optional; without either token nobody is a moderator.
+	ModeratorToken string
+
+	// RateLimiter limits the number of POST requests per client. Optional;
+	// requests are not limited when unset.
+	RateLimiter RateLimiter
+	// RateLimit is the number of POST requests a client may make per
+	// RateLimitWindow. Defaults to 60.
+	RateLimit int
+	// RateLimitWindow is the window RateLimit applies to. Defaults to one
+	// minute.
+	RateLimitWindow time.Duration
+	// TrustedProxies are the networks of the proxies in front of the API.
+	// Requests from them are attributed to the client named by their
+	// X-Forwarded-For or X-Real-IP header. Optional; by default requests are
+	// attributed to the peer address.
+	TrustedProxies []netip.Prefix
+
+	// Auditor records the audit trail of mutations. Defaults to a LogAuditor
+	// writing through Logger.
+	Auditor Auditor
+
+	// Enrichers enrich the created messages in order before they are
+	// stored. Optional.
+	Enrichers []MessageEnricher
+	// SkipEnricherErrors makes message creation log the errors of enrichers
+	// and carry on without their changes. By default a failing enricher
+	// fails the creation.
+	SkipEnricherErrors bool
+
+	// WriteBehind makes message creation respond as soon as the message is
+	// cached, leaving it to Flush or FlushLoop to persist it to the DB. This
+	// trades durability for latency: pending messages are lost if the
+	// process exits without flushing, and replies to missing messages and
+	// reused ids are only detected when flushing, after the messages were
+	// acknowledged. Such messages are dropped. Requests changing a pending
+	// message or its reactions flush the pending messages first, while the
+	// reads served by the DB alone, such as threads and searches, only see
+	// pending messages once they are flushed.
+	WriteBehind bool
+
+	// Now returns the current time, which messages, reactions and audit
+	// events are created at and messages are listed before. Defaults to
+	// time.Now.
+	Now func() time.Time
 
-	once sync.Once
-	mux  *http.ServeMux
+	once    sync.Once
+	handler http.Handler
+
+	// cacheHits and cacheMisses count the message lookups served from the
+	// cache alone and those that consulted the DB.
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+
+	// pending are the messages created in write-behind mode and not
+	// flushed yet.
+	pendingMu sync.Mutex
+	pending   []Message
+	flushMu   sync.Mutex
 }
 
+// statusClientClosedRequest is the non-standard status, popularized by nginx,
+// for requests the client cancelled before the response was written.
+const statusClientClosedRequest = 499
+
 // pageSize defines the number of items displayed on a single page in pagination.
 var pageSize = 10
 
+// DefaultReactionAliases are the reaction type aliases used when
+// API.ReactionAliases is not set.
+var DefaultReactionAliases = map[string]string{
+	"thumbsup":   "thumbs_up",
+	"+1":         "thumbs_up",
+	"thumbsdown": "thumbs_down",
+	"-1":         "thumbs_down",
+}
+
+// DefaultReactionTypes are the reaction types used when API.ReactionTypes is
+// not set.
+var DefaultReactionTypes = []ReactionType{
+	{Name: "like", Emoji: "❤️"},
+	{Name: "thumbs_up", Emoji: "👍"},
+	{Name: "thumbs_down", Emoji: "👎"},
+	{Name: "party", Emoji: "🎉"},
+}
+
+const (
+	defaultReactionScore    = 1
+	defaultMaxReactionScore = 100
+	defaultTypingTTL        = 5 * time.Second
+	defaultMessageCountTTL  = 5 * time.Second
+	defaultRefreshSize      = 10
+	defaultMaxOffset        = 10000
+	defaultRateLimit        = 60
+	defaultRateLimitWindow  = time.Minute
+
+	// maxThreadDepth and maxThreadSize bound the replies returned for a
+	// thread, so that deep or busy threads can't make the query run away.
+	maxThreadDepth = 10
+	maxThreadSize  = 500
+)
+
 func (a *API) setupRoutes() {
+	// Embedding code can easily forget the optional dependencies, default them
+	// instead of panicking on the first request.
+	if a.Logger == nil {
+		a.Logger = slog.Default()
+	}
+	if a.Val == nil {
+		a.Val = validator.New()
+	}
+	if a.Auditor == nil {
+		a.Auditor = &LogAuditor{Logger: a.Logger}
+	}
+	if a.CursorKey == nil {
+		a.CursorKey = make([]byte, 32)
+		if _, err := rand.Read(a.CursorKey); err != nil {
+			panic(fmt.Sprintf("generate cursor key: %v", err))
+		}
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /messages", a.listMessages)
-	mux.HandleFunc("POST /messages", a.createMessage)
-	mux.HandleFunc("POST /messages/{messageID}/reactions", a.createReaction)
+	mux.HandleFunc("GET /messages", a.handle(a.listMessages))
+	mux.Handle("HEAD /messages", withoutBody(a.handle(a.listMessages)))
+	mux.Handle("POST /messages", a.rateLimit(a.handle(a.createMessage)))
+	mux.HandleFunc("GET /messages/typing", a.handle(a.listTyping))
+	mux.HandleFunc("GET /messages/search", a.handle(a.searchMessages))
+	mux.Handle("POST /messages/typing", a.rateLimit(a.handle(a.startTyping)))
+	mux.Handle("POST /messages/{messageID}/pin", a.rateLimit(a.requireModerator(a.handle(a.flushed(a.pinMessage)))))
+	mux.Handle("DELETE /messages/{messageID}/pin", a.rateLimit(a.requireModerator(a.handle(a.flushed(a.unpinMessage)))))
+	mux.Handle("POST /messages/{messageID}/hide", a.rateLimit(a.requireModerator(a.handle(a.flushed(a.hideMessage)))))
+	mux.Handle("DELETE /messages/{messageID}/hide", a.rateLimit(a.requireModerator(a.handle(a.flushed(a.unhideMessage)))))
+	mux.Handle("POST /messages/{messageID}/reactions", a.rateLimit(a.handle(a.flushed(a.createReaction))))
+	mux.Handle("POST /messages/{messageID}/reactions/batch", a.rateLimit(a.handle(a.flushed(a.createReactions))))
+	mux.Handle("POST /messages/{messageID}/reactions/toggle", a.rateLimit(a.handle(a.flushed(a.toggleReaction))))
+	mux.HandleFunc("GET /messages/{messageID}", a.handle(a.getMessage))
+	mux.HandleFunc("PATCH /messages/{messageID}", a.handle(a.flushed(a.updateMessage)))
+	mux.HandleFunc("DELETE /messages/{messageID}", a.handle(a.flushed(a.deleteMessage)))
+	// GET /messages/day/{date} and GET /messages/{messageID}/thread overlap,
+	// which the mux does not allow, so getMessageChild tells them apart.
+	mux.HandleFunc("GET /messages/{messageID}/{child}", a.handle(a.getMessageChild))
+	mux.HandleFunc("GET /messages/{messageID}/reactions/summary", a.handle(a.reactionSummary))
+	mux.HandleFunc("GET /messages/{messageID}/reactions/emojis", a.handle(a.emojiCounts))
+	mux.HandleFunc("GET /messages/{messageID}/reactions/histogram", a.handle(a.scoreHistogram))
+	mux.HandleFunc("GET /messages/{messageID}/reactions/{reactionID}", a.handle(a.getReaction))
+	mux.HandleFunc("DELETE /messages/{messageID}/reactions/{reactionID}", a.handle(a.deleteReaction))
+	mux.HandleFunc("GET /users/{userID}/reactions", a.handle(a.userReactions))
+	mux.HandleFunc("GET /reactions/types", a.handle(a.listReactionTypes))
+	mux.HandleFunc("GET /reactions/{reactionID}", a.handle(a.getReactionByID))
+	if a.Hub != nil {
+		mux.HandleFunc("GET /events", a.streamEvents)
+	}
+	if a.AdminToken != "" {
+		mux.Handle("POST /admin/cache/flush", a.rateLimit(a.requireAdmin(a.handle(a.flushCache))))
+		if a.LogLevel != nil {
+			mux.Handle("POST /admin/loglevel", a.rateLimit(a.requireAdmin(a.handle(a.setLogLevel))))
+		}
+	}
 
-	a.mux = mux
+	a.handler = a.logRequests(a.authenticate(a.prettyPrint(a.rejectEmptySegments(mux))))
 }
 
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.once.Do(a.setupRoutes)
-	a.Logger.Info("Request received", "method", r.Method, "path", r.URL.Path)
-	a.mux.ServeHTTP(w, r)
+	a.handler.ServeHTTP(w, r)
 }
 
-func (a *API) respond(w http.ResponseWriter, status int, body any) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(body); err != nil {
+// respond writes data wrapped in the response envelope.
+func (a *API) respond(w http.ResponseWriter, status int, data any) {
+	a.writeJSON(w, status, envelope{APIVersion: APIVersion, Data: data})
+}
+
+func (a *API) respondInvalid(w http.ResponseWriter, kind string, errs []validator.ValidationError) {
+	a.writeJSON(w, http.StatusBadRequest, &ValidationErrorResponse{
+		APIVersion: APIVersion,
+		Kind:       kind,
+		Errors:     errs,
+	})
+}
+
+func (a *API) writeJSON(w http.ResponseWriter, status int, body any) {
+	var (
+		b   []byte
+		err error
+	)
+	if _, pretty := w.(prettyWriter); pretty {
+		b, err = json.MarshalIndent(body, "", "  ")
+	} else {
+		b, err = json.Marshal(body)
+	}
+	if err != nil {
 		a.Logger.Error("Could not encode JSON body", "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	b = append(b, '\n')
+
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		a.Logger.Error("Could not write JSON body", "error", err.Error())
 	}
 }
 
-func (a *API) respondError(w http.ResponseWriter, status int, err error, msg string) {
-	type response struct {
-		Error string `json:"error"`
+// decodeReqBody decodes the JSON request body into dst. If the body can't be
+// decoded, the returned error describes what is wrong with it.
+func decodeReqBody(r *http.Request, dst any) error {
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if err == nil {
+		return nil
+	}
+
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		msg       string
+	)
+	switch {
+	case errors.Is(err, io.EOF):
+		msg = "Request body must not be empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		msg = "Request body contains malformed JSON"
+	case errors.As(err, &syntaxErr):
+		msg = fmt.Sprintf("Request body contains malformed JSON at position %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		msg = fmt.Sprintf("Field %q must be of type %s", typeErr.Field, jsonType(typeErr.Type))
+	case errors.As(err, &typeErr):
+		msg = fmt.Sprintf("Request body must be of type %s", jsonType(typeErr.Type))
+	default:
+		msg = "Could not decode request body"
 	}
-	a.Logger.Error("Error", "error", err.Error())
-	a.respond(w, status, response{Error: msg})
+	return apiError(http.StatusBadRequest, err, msg)
 }
 
-func (a *API) validateReqBody(w http.ResponseWriter, s interface{}) bool {
-	errs := a.Val.ValidateStruct(s)
-	if errs != nil {
-		a.respond(w, http.StatusBadRequest, &ValidationErrorResponse{
-			Errors: errs,
-			Kind:   "body",
-		})
-		return false
+// jsonType returns the name of the JSON type that decodes into t.
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
 	}
-	return true
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// validateReqBody validates the decoded request body s. A *bodyError is
+// returned if it is invalid.
+func (a *API) validateReqBody(s interface{}) error {
+	if errs := a.Val.ValidateStruct(s); errs != nil {
+		return &bodyError{Errors: errs}
+	}
+	return nil
+}
+
+// boolParam returns the boolean query parameter name, false if it is not set.
+// A *paramError is returned if it is not a boolean.
+func (a *API) boolParam(r *http.Request, name string) (bool, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return false, nil
+	}
+	if err := a.validateParam(s, "boolean"); err != nil {
+		return false, err
+	}
+	b, _ := strconv.ParseBool(s)
+	return b, nil
+}
+
+// validateParam validates the query or path parameter s against tag. A
+// *paramError is returned if it is invalid.
+func (a *API) validateParam(s interface{}, tag string) error {
+	if errs := a.Val.Validate(s, tag); errs != nil {
+		return &paramError{Errors: errs}
+	}
+	return nil
+}
+
+// pagination holds the pagination query parameters of list endpoints.
+type pagination struct {
+	Page  int `validate:"gte=1"`
+	Limit int `validate:"gte=1,lte=100"`
+}
+
+// paginationValidator validates pagination parameters, which don't depend on
+// the configuration of the API.
+var paginationValidator = validator.New()
+
+// A paramError reports invalid query or path parameters.
+type paramError struct {
+	Errors []validator.ValidationError
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid %s parameter: %v", strings.ToLower(e.Errors[0].Field), e.Errors[0].Message)
+}
+
+// A bodyError reports an invalid request body.
+type bodyError struct {
+	Errors []validator.ValidationError
+}
+
+func (e *bodyError) Error() string {
+	return fmt.Sprintf("invalid %s field: %v", strings.ToLower(e.Errors[0].Field), e.Errors[0].Message)
 }
 
-func (a *API) validateParam(w http.ResponseWriter, s interface{}, tag string) bool {
-	errs := a.Val.Validate(s, tag)
+// paginationParams parses the page and limit query parameters of list
+// endpoints into a limit and an offset. Absent parameters default to the first
+// page of pageSize items. A *paramError is returned for invalid parameters,
+// including pages beyond MaxOffset.
+func (a *API) paginationParams(r *http.Request) (limit, offset int, err error) {
+	p := pagination{
+		Page:  1,
+		Limit: pageSize,
+	}
+	params := []struct {
+		field string
+		dst   *int
+	}{
+		{"Page", &p.Page},
+		{"Limit", &p.Limit},
+	}
+
+	var errs []validator.ValidationError
+	for _, param := range params {
+		v := r.URL.Query().Get(strings.ToLower(param.field))
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, validator.ValidationError{
+				Field:   param.field,
+				Message: fmt.Sprintf("%s must be an integer", param.field),
+			})
+			continue
+		}
+		*param.dst = n
+	}
+	if errs == nil {
+		errs = paginationValidator.ValidateStruct(p)
+	}
 	if errs != nil {
-		a.respond(w, http.StatusBadRequest, &ValidationErrorResponse{
-			Errors: errs,
-			Kind:   "param",
-		})
-		return false
+		return 0, 0, &paramError{Errors: errs}
 	}
-	return true
+	if err := a.checkPage(p.Page, p.Limit); err != nil {
+		return 0, 0, err
+	}
+	return p.Limit, p.Limit * (p.Page - 1), nil
 }
 
-func (a *API) listMessages(w http.ResponseWriter, r *http.Request) {
-	type response struct {
-		Messages []Message `json:"messages"`
+// checkPage returns a *paramError if the page of limit items skips more than
+// MaxOffset items, whether it was requested with the page parameter or a
+// cursor.
+func (a *API) checkPage(page, limit int) error {
+	maxOffset := a.MaxOffset
+	if maxOffset == 0 {
+		maxOffset = defaultMaxOffset
+	}
+	// The page is bounded by dividing rather than multiplying, which could
+	// overflow for huge pages.
+	if page-1 > maxOffset/limit {
+		return &paramError{Errors: []validator.ValidationError{{
+			Field:   "Page",
+			Message: fmt.Sprintf("Page must not skip more than %d items, list older messages with the before parameter instead", maxOffset),
+		}}}
+	}
+	return nil
+}
+
+func (a *API) listMessages(w http.ResponseWriter, r *http.Request) error {
+	limit, offset, err := a.paginationParams(r)
+	if err != nil {
+		return err
+	}
+
+	// Clients syncing only need some fields, such as the ids, which may save
+	// loading the reactions.
+	fields, err := fieldsParam(r)
+	if err != nil {
+		return err
+	}
+	reactions := reactionLoad(r, fields)
+	withHidden := roleFrom(r.Context()) == RoleModerator
+
+	if afterID := r.URL.Query().Get("after_id"); afterID != "" {
+		return a.listMessagesAfter(w, r, afterID, limit, reactions, withHidden, fields)
+	}
+
+	order := OrderDesc
+	if o := r.URL.Query().Get("order"); o != "" {
+		if err := a.validateParam(o, "oneof=asc desc"); err != nil {
+			return err
+		}
+		order = Order(o)
+	}
+
+	// All layers list messages created before the same bound, so that
+	// messages inserted while paging don't shift the pages.
+	before := a.now()
+	if b := r.URL.Query().Get("before"); b != "" {
+		before, err = time.Parse(time.RFC3339Nano, b)
+		if err != nil {
+			return apiError(http.StatusBadRequest, err, "Invalid before timestamp")
+		}
+	}
+
+	// A cursor continues a listing, it takes precedence over the page and
+	// before parameters.
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		c, err := decodeCursor(a.CursorKey, token)
+		if err != nil {
+			return apiError(http.StatusBadRequest, err, "Invalid cursor")
+		}
+		if err := a.checkPage(c.Page, limit); err != nil {
+			return err
+		}
+		before, offset = c.Before, limit*(c.Page-1)
 	}
 
-	p := r.URL.Query().Get("page")
-	if p == "" {
-		p = "1"
+	if st := r.URL.Query().Get("stream"); st != "" {
+		if err := a.validateParam(st, "boolean"); err != nil {
+			return err
+		}
+		if stream, _ := strconv.ParseBool(st); stream {
+			return a.streamMessages(w, r, before, order, reactions, withHidden, fields)
+		}
 	}
-	page, err := strconv.Atoi(p)
-	if page < 1 {
-		page = 1
+
+	if a.notModified(w, r) {
+		return nil
 	}
 
+	total, err := a.countMessages(r.Context())
 	if err != nil {
-		a.respondError(w, http.StatusBadRequest, err, "Invalid page number")
-		return
+		// The total is informational, serve the list without it.
+		a.Logger.Error("Could not count messages", "error", err.Error())
+		total = -1
+	} else {
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	}
 
-	limit := pageSize
-	offset := limit * (page - 1)
 	msgs := make([]Message, 0)
 
+	// Clients can skip the cache to compare its effectiveness, the page is
+	// then served from the DB alone.
+	bypassCache, _ := strconv.ParseBool(r.Header.Get("X-Bypass-Cache"))
+	if bypassCache {
+		a.Logger.Info("Bypassing cache for message list")
+	}
+
 	// Currently we only store the last page of messages in cache, so we only need to check in cache
 	// only when on the first page.
-	if page == 1 {
-		cached, err := a.Cache.ListMessages(r.Context())
+	if offset == 0 && !bypassCache {
+		cached, err := a.Cache.ListMessages(r.Context(), before, order, limit, reactions == ReactionsLoaded)
 		if err != nil {
-			a.respondError(w, http.StatusInternalServerError, err, "Could not list messages")
-			return
+			return apiError(http.StatusInternalServerError, err, "Could not list messages")
+		}
+
+		// The cache only holds the latest messages, so the oldest messages it
+		// holds are only the oldest messages overall if it holds all of them.
+		if order == OrderDesc || len(cached) == total {
+			for _, msg := range cached {
+				if withHidden || !msg.Hidden {
+					msgs = append(msgs, msg)
+				}
+			}
+			a.Logger.Info("Got messages from cache", "count", len(msgs))
+		}
+		a.countCacheLookup(len(msgs) >= limit)
+	}
+
+	if len(msgs) < limit {
+		// Get any remaining messages from DB
+		msgIDs := make([]string, len(msgs))
+		for i, msg := range msgs {
+			msgIDs[i] = msg.ID
+		}
+
+		dbMsgs, err := a.DB.ListMessages(r.Context(), before, order, limit-len(msgs), offset, reactions, withHidden, msgIDs...)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, err, "Could not list messages")
+		}
+
+		msgs = append(msgs, dbMsgs...)
+		a.Logger.Info("Got remaining messages from DB", "count", len(dbMsgs))
+	}
+
+	// Both layers list pinned messages first, but the DB may return pinned
+	// messages the cache did not have.
+	slices.SortStableFunc(msgs, func(m1, m2 Message) int {
+		switch {
+		case m1.Pinned == m2.Pinned:
+			return 0
+		case m1.Pinned:
+			return -1
+		default:
+			return 1
+		}
+	})
+
+	if expands(r, "reaction_users") {
+		for i := range msgs {
+			msgs[i].ReactionUsers = reactionUsers(msgs[i].Reactions)
 		}
+	}
 
-		msgs = append(msgs, cached...)
-		a.Logger.Info("Got messages from cache", "count", len(msgs))
+	// A short page is the last one, there is no next page to link to. Nor is
+	// there past MaxOffset, where the cursor would be rejected.
+	var next string
+	if len(msgs) == limit && a.checkPage(offset/limit+2, limit) == nil {
+		next = encodeCursor(a.CursorKey, cursor{Before: before, Page: offset/limit + 2})
+		w.Header().Set("X-Next-Cursor", next)
+		w.Header().Set("Link", nextLink(r, next))
 	}
 
-	// Get any remaining messages from DB
-	msgIDs := make([]string, len(msgs))
-	for i, msg := range msgs {
-		msgIDs[i] = msg.ID
+	return a.respondMessageList(w, r, msgs, fields, next)
+}
+
+// listMessagesAfter lists the messages created after the message identified by
+// afterID, oldest first, for clients syncing incrementally. The next page
+// starts after the last listed message. The cache is not consulted, it only
+// holds the latest messages.
+func (a *API) listMessagesAfter(w http.ResponseWriter, r *http.Request, afterID string, limit int, reactions ReactionLoad, withHidden bool, fields []string) error {
+	if err := a.validateParam(afterID, "uuid"); err != nil {
+		return err
 	}
 
-	dbMsgs, err := a.DB.ListMessages(r.Context(), limit, offset, msgIDs...)
+	msgs, err := a.DB.ListMessagesAfter(r.Context(), afterID, limit, reactions, withHidden)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Message not found")
+	}
 	if err != nil {
-		a.respondError(w, http.StatusInternalServerError, err, "Could not list messages")
-		return
+		return apiError(http.StatusInternalServerError, err, "Could not list messages")
+	}
+	if msgs == nil {
+		msgs = make([]Message, 0)
 	}
 
-	msgs = append(msgs, dbMsgs...)
-	a.Logger.Info("Got remaining messages from DB", "count", len(dbMsgs))
-	res := response{
-		Messages: msgs,
+	if expands(r, "reaction_users") {
+		for i := range msgs {
+			msgs[i].ReactionUsers = reactionUsers(msgs[i].Reactions)
+		}
 	}
 
-	a.respond(w, http.StatusOK, res)
+	return a.respondMessageList(w, r, msgs, fields, "")
+}
+
+// streamPageSize is the number of messages streamMessages loads from the DB at
+// once.
+const streamPageSize = 100
+
+// streamMessages writes all messages created before the given time, in the
+// same shape as a listing, without buffering them: the messages are loaded
+// from the DB page by page and each page is flushed to the client as soon as
+// it is encoded. The cache is skipped, it only holds the latest messages.
+//
+// Errors after the first page can't change the status anymore, the response
+// is cut short instead so that clients see an invalid body.
+func (a *API) streamMessages(w http.ResponseWriter, r *http.Request, before time.Time, order Order, reactions ReactionLoad, withHidden bool, fields []string) error {
+	page := func(offset int) ([]Message, error) {
+		msgs, err := a.DB.ListMessages(r.Context(), before, order, streamPageSize, offset, reactions, withHidden)
+		if expands(r, "reaction_users") {
+			for i := range msgs {
+				msgs[i].ReactionUsers = reactionUsers(msgs[i].Reactions)
+			}
+		}
+		return msgs, err
+	}
+
+	msgs, err := page(0)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not list messages")
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	encode := func(msg Message) error {
+		if fields == nil {
+			return enc.Encode(msg)
+		}
+		trimmed, err := trimMessages([]Message{msg}, fields)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(trimmed[0])
+	}
+	fmt.Fprintf(w, `{"api_version":%q,"data":{"messages":[`, APIVersion)
+
+	for offset := 0; ; {
+		for i, msg := range msgs {
+			if offset+i > 0 {
+				io.WriteString(w, ",")
+			}
+			if err := encode(msg); err != nil {
+				a.Logger.Error("Could not stream messages", "error", err.Error())
+				return nil
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			a.Logger.Error("Could not flush messages", "error", err.Error())
+			return nil
+		}
+		if len(msgs) < streamPageSize {
+			break
+		}
+
+		offset += len(msgs)
+		if msgs, err = page(offset); err != nil {
+			a.Logger.Error("Could not list messages", "error", err.Error())
+			return nil
+		}
+	}
+	io.WriteString(w, "]}}\n")
+	return nil
+}
+
+// countMessages returns the total number of messages. The count is cached for
+// MessageCountTTL, since counting all messages on every list request is
+// expensive.
+func (a *API) countMessages(ctx context.Context) (int, error) {
+	n, err := a.Cache.GetMessageCount(ctx)
+	if err == nil {
+		return n, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		a.Logger.Error("Could not get message count from cache", "error", err.Error())
+	}
+
+	n, err = a.DB.CountMessages(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	ttl := a.MessageCountTTL
+	if ttl <= 0 {
+		ttl = defaultMessageCountTTL
+	}
+	if err := a.Cache.SetMessageCount(ctx, n, ttl); err != nil {
+		a.Logger.Error("Could not cache message count", "error", err.Error())
+	}
+	return n, nil
+}
+
+// expands reports whether the comma separated expand query parameter contains
+// the given field.
+func expands(r *http.Request, field string) bool {
+	for _, f := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		if strings.TrimSpace(f) == field {
+			return true
+		}
+	}
+	return false
+}
+
+// reactionUsers groups the IDs of the users that reacted by reaction type.
+func reactionUsers(reactions []Reaction) map[string][]string {
+	users := make(map[string][]string)
+	for _, rc := range reactions {
+		if !slices.Contains(users[rc.Type], rc.UserID) {
+			users[rc.Type] = append(users[rc.Type], rc.UserID)
+		}
+	}
+	return users
+}
+
+// notModified sets the Last-Modified header to the creation time of the latest
+// message and reports whether the request's If-Modified-Since header makes a
+// 304 response sufficient, in which case the response has been written.
+func (a *API) notModified(w http.ResponseWriter, r *http.Request) bool {
+	latest, err := a.DB.LatestMessageTime(r.Context())
+	if err != nil {
+		// Conditional requests are an optimization, serve the full list instead.
+		a.Logger.Error("Could not get latest message time", "error", err.Error())
+		return false
+	}
+	// HTTP dates have second precision.
+	latest = latest.UTC().Truncate(time.Second)
+	if !latest.IsZero() {
+		w.Header().Set("Last-Modified", latest.Format(http.TimeFormat))
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || latest.After(ims) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
 }
 
-func (a *API) createMessage(w http.ResponseWriter, r *http.Request) {
+func (a *API) createMessage(w http.ResponseWriter, r *http.Request) error {
 	type (
+		attachment struct {
+			URL  string `json:"url" validate:"required,http_url"`
+			Type string `json:"type" validate:"required"`
+			Name string `json:"name"`
+			Size int64  `json:"size" validate:"gte=0"`
+		}
 		request struct {
-			Text   string `json:"text" validate:"required"`
-			UserID string `json:"user_id" validate:"required"`
+			// ID is generated by clients that create messages offline.
+			// Retrying with the same id is idempotent.
+			ID          string       `json:"id" validate:"omitempty,uuid"`
+			Text        string       `json:"text" validate:"required"`
+			UserID      string       `json:"user_id" validate:"required,user_id"`
+			ParentID    string       `json:"parent_id" validate:"omitempty,uuid"`
+			Attachments []attachment `json:"attachments" validate:"max=10,dive"`
 		}
 		response struct {
-			ID        string `json:"id"`
-			Text      string `json:"text"`
-			UserID    string `json:"user_id"`
-			CreatedAt string `json:"created_at"`
+			ID          string       `json:"id"`
+			Text        string       `json:"text"`
+			UserID      string       `json:"user_id"`
+			ParentID    string       `json:"parent_id,omitempty"`
+			CreatedAt   string       `json:"created_at"`
+			Attachments []Attachment `json:"attachments,omitempty"`
+			// A new message has no reactions yet, the empty list keeps
+			// the shape of the other message responses.
+			Reactions []Reaction `json:"reactions"`
 		}
 	)
 
 	var body request
-	err := json.NewDecoder(r.Body).Decode(&body)
-	if err != nil {
-		a.respondError(w, http.StatusBadRequest, err, "Could not decode request body")
-		return
+	if err := decodeReqBody(r, &body); err != nil {
+		return err
 	}
 
-	if valid := a.validateReqBody(w, &body); !valid {
-		return
+	// Text that is nothing but whitespace is empty, which the validation
+	// rejects.
+	body.Text = a.normalizeText(body.Text)
+	if err := a.validateReqBody(&body); err != nil {
+		return err
 	}
-	err = r.Body.Close()
+	err := r.Body.Close()
 	if err != nil {
-		a.respondError(w, http.StatusInternalServerError, err, "Could not close request body")
-		return
+		return apiError(http.StatusInternalServerError, err, "Could not close request body")
 	}
 
-	msg, err := a.DB.InsertMessage(r.Context(), Message{
-		Text:      body.Text,
-		UserID:    body.UserID,
-		CreatedAt: time.Now(),
-	})
+	var attachments []Attachment
+	for _, at := range body.Attachments {
+		attachments = append(attachments, Attachment(at))
+	}
+
+	status := http.StatusCreated
+	msg := Message{
+		ID:          body.ID,
+		Text:        body.Text,
+		UserID:      body.UserID,
+		ParentID:    body.ParentID,
+		CreatedAt:   a.now().UTC(),
+		Attachments: attachments,
+	}
+	msg, err = a.enrich(r.Context(), msg)
 	if err != nil {
-		a.respondError(w, http.StatusInternalServerError, err, "Could not insert message")
-		return
+		return apiError(http.StatusInternalServerError, err, "Could not enrich message")
+	}
+	// A retry is compared with the message as it would have been stored, the
+	// first attempt stored the normalized and enriched message too.
+	want := msg
+	if a.WriteBehind {
+		msg = a.enqueueMessage(msg)
+	} else {
+		msg, err = a.DB.InsertMessage(r.Context(), msg)
+	}
+	if errors.Is(err, ErrDuplicateMessage) {
+		// A retry returns the message the first attempt created, as long as
+		// it is the same message.
+		msg, err = a.DB.GetMessage(r.Context(), body.ID, ReactionSortCreated, "")
+		if err != nil {
+			return apiError(http.StatusInternalServerError, err, "Could not insert message")
+		}
+		if msg.UserID != want.UserID || msg.Text != want.Text || msg.ParentID != want.ParentID || !slices.Equal(msg.Attachments, want.Attachments) {
+			return apiError(http.StatusConflict, fmt.Errorf("message %s exists", body.ID), "Message ID already in use")
+		}
+		status = http.StatusOK
+	}
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusUnprocessableEntity, err, "Parent message not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not insert message")
 	}
 
-	if err := a.Cache.InsertMessage(r.Context(), msg); err != nil {
-		a.Logger.Error("Could not cache message", "error", err.Error())
+	if status == http.StatusCreated {
+		a.audit(r, AuditMessageCreate, msg.UserID, msg.ID)
+		if err := a.Cache.InsertMessage(r.Context(), msg); err != nil {
+			a.Logger.Error("Could not cache message", "error", err.Error())
+		}
 	}
 
 	res := response{
-		ID:        msg.ID,
-		Text:      msg.Text,
-		UserID:    msg.UserID,
-		CreatedAt: msg.CreatedAt.Format(time.RFC1123),
+		ID:          msg.ID,
+		Text:        msg.Text,
+		UserID:      msg.UserID,
+		ParentID:    msg.ParentID,
+		CreatedAt:   msg.CreatedAt.Format(time.RFC1123),
+		Attachments: msg.Attachments,
+		Reactions:   []Reaction{},
 	}
 
-	a.respond(w, http.StatusCreated, res)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", "/messages/"+msg.ID)
+	}
+	a.respond(w, status, res)
+	return nil
 }
 
-// createReaction handles the creation of a reaction for a given message.
-func (a *API) createReaction(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		Type   string `json:"type" validate:"required"`
-		Score  int    `json:"score"`
-		UserID string `json:"user_id" validate:"required"`
+// getMessage returns a single message with its reactions. The cache is
+// consulted first and the message is loaded from the DB on a miss. Messages
+// loaded from the DB are not cached, the cache only holds the most recent
+// messages. The reactions are listed oldest first, or highest-scored first
+// with sort=score. With type set only the reactions of that type are listed
+// and counted.
+func (a *API) getMessage(w http.ResponseWriter, r *http.Request) error {
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
 	}
 
-	messageID := r.PathValue("messageID")
-	if !a.validateParam(w, messageID, "required,uuid") {
-		return
+	sort := ReactionSortCreated
+	if s := r.URL.Query().Get("sort"); s != "" {
+		if err := a.validateParam(s, "oneof=created score"); err != nil {
+			return err
+		}
+		sort = ReactionSort(s)
+	}
+	var reactionType string
+	if typ := r.URL.Query().Get("type"); typ != "" {
+		reactionType = a.normalizeReactionType(typ)
 	}
 
-	var body request
-	err := json.NewDecoder(r.Body).Decode(&body)
+	msg, err := a.loadMessage(r, messageID, sort, reactionType)
 	if err != nil {
-		a.respondError(w, http.StatusBadRequest, err, "Could not decode request body")
-		return
+		return err
 	}
 
-	err = r.Body.Close()
+	// Both layers load the reactions along with the message, so the message
+	// has the shape of an expanded listing whichever layer served it.
+	if expands(r, "reaction_users") {
+		msg.ReactionUsers = reactionUsers(msg.Reactions)
+	}
+
+	a.respondMessages(w, r, http.StatusOK, msg, []Message{msg}, true)
+	return nil
+}
+
+// loadMessage returns a message with its reactions from the cache, or from the
+// DB on a miss. Hidden messages are only returned to moderators.
+func (a *API) loadMessage(r *http.Request, messageID string, sort ReactionSort, reactionType string) (Message, error) {
+	msg, err := a.Cache.GetMessage(r.Context(), messageID, sort, reactionType)
+	a.countCacheLookup(err == nil)
 	if err != nil {
-		a.respondError(w, http.StatusInternalServerError, err, "Invalid request body")
-		return
+		if !errors.Is(err, ErrNotFound) {
+			a.Logger.Error("Could not get message from cache", "error", err.Error())
+		}
+
+		msg, err = a.DB.GetMessage(r.Context(), messageID, sort, reactionType)
+		if errors.Is(err, ErrNotFound) {
+			return Message{}, apiError(http.StatusNotFound, err, "Message not found")
+		}
+		if err != nil {
+			return Message{}, apiError(http.StatusInternalServerError, err, "Could not get message")
+		}
 	}
+	if msg.Hidden && roleFrom(r.Context()) != RoleModerator {
+		return Message{}, apiError(http.StatusNotFound, errors.New("message is hidden"), "Message not found")
+	}
+	return msg, nil
+}
 
-	if !a.validateReqBody(w, &body) {
-		return
+// getThread returns a message and its replies, flattened in thread order with
+// the depth of each message. Hidden messages and their replies are only
+// returned to moderators.
+func (a *API) getThread(w http.ResponseWriter, r *http.Request) error {
+	type response struct {
+		Messages []ThreadMessage `json:"messages"`
 	}
 
-	reaction, err := a.DB.InsertReaction(r.Context(), Reaction{
-		MessageID: messageID,
-		Type:      body.Type,
-		Score:     body.Score,
-		UserID:    body.UserID,
-		CreatedAt: time.Now(),
-	})
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
 
+	withHidden := roleFrom(r.Context()) == RoleModerator
+	msgs, err := a.DB.GetThread(r.Context(), messageID, maxThreadDepth, maxThreadSize, withHidden)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Message not found")
+	}
 	if err != nil {
-		a.respondError(w, http.StatusInternalServerError, err, fmt.Sprintf("could not create reaction for message with id %s", messageID))
-		return
+		return apiError(http.StatusInternalServerError, err, "Could not get thread")
 	}
 
-	err = a.Cache.InsertReaction(r.Context(), messageID, reaction)
-	if err != nil {
-		a.Logger.Error("Could not cache reaction", "error", err.Error())
-		a.respondError(w, http.StatusInternalServerError, err, "Internal server error")
-		return
+	a.respond(w, http.StatusOK, response{Messages: msgs})
+	return nil
+}
+
+// getMessageChild serves GET /messages/day/{date} and
+// GET /messages/{messageID}/thread. "day" is not a valid message id, so the
+// paths can't be mistaken for each other.
+func (a *API) getMessageChild(w http.ResponseWriter, r *http.Request) error {
+	child := r.PathValue("child")
+	switch {
+	case r.PathValue("messageID") == "day":
+		r.SetPathValue("date", child)
+		return a.listMessagesOnDay(w, r)
+	case child == "thread":
+		return a.getThread(w, r)
 	}
+	return apiError(http.StatusNotFound, nil, "Not found")
+}
 
-	a.respond(w, http.StatusCreated, Reaction{
-		ID:        reaction.ID,
-		MessageID: reaction.MessageID,
-		Type:      reaction.Type,
-		Score:     reaction.Score,
-		UserID:    reaction.UserID,
-		CreatedAt: reaction.CreatedAt,
-	})
+// listMessagesOnDay lists the messages created on the calendar day given as
+// YYYY-MM-DD, in the server's time zone, oldest first. The page and limit
+// parameters page through the day. The cache is not consulted, it only holds
+// the latest messages.
+func (a *API) listMessagesOnDay(w http.ResponseWriter, r *http.Request) error {
+	day, err := time.ParseInLocation(time.DateOnly, r.PathValue("date"), time.Local)
+	if err != nil {
+		return &paramError{Errors: []validator.ValidationError{{
+			Field:   "date",
+			Message: "date must be a date formatted as YYYY-MM-DD",
+		}}}
+	}
+	limit, offset, err := a.paginationParams(r)
+	if err != nil {
+		return err
+	}
+	fields, err := fieldsParam(r)
+	if err != nil {
+		return err
+	}
+	withHidden := roleFrom(r.Context()) == RoleModerator
+
+	msgs, err := a.DB.ListMessagesBetween(r.Context(), day, day.AddDate(0, 0, 1), limit, offset, reactionLoad(r, fields), withHidden)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not list messages")
+	}
+	if msgs == nil {
+		msgs = make([]Message, 0)
+	}
+
+	if expands(r, "reaction_users") {
+		for i := range msgs {
+			msgs[i].ReactionUsers = reactionUsers(msgs[i].Reactions)
+		}
+	}
+
+	return a.respondMessageList(w, r, msgs, fields, "")
+}
+
+// searchMessages lists the messages whose text matches the q parameter, best
+// matches first. Each result has a highlight of the matches.
+func (a *API) searchMessages(w http.ResponseWriter, r *http.Request) error {
+	type (
+		result struct {
+			messageJSON
+			Highlight string `json:"highlight"`
+		}
+		response struct {
+			Messages []result `json:"messages"`
+		}
+	)
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if err := a.validateParam(query, "required"); err != nil {
+		return err
+	}
+	limit, offset, err := a.paginationParams(r)
+	if err != nil {
+		return err
+	}
+	withHidden := roleFrom(r.Context()) == RoleModerator
+
+	results, err := a.DB.SearchMessages(r.Context(), query, limit, offset, reactionLoad(r, nil), withHidden)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not search messages")
+	}
+
+	res := response{Messages: make([]result, len(results))}
+	for i, sr := range results {
+		// messageJSON does not encode nil reactions as an empty list like
+		// Message does.
+		if sr.Reactions == nil {
+			sr.Reactions = []Reaction{}
+		}
+		res.Messages[i] = result{messageJSON: messageJSON(sr.Message), Highlight: sr.Highlight}
+	}
+	a.respond(w, http.StatusOK, res)
+	return nil
+}
+
+// pinMessage pins a message to the top of the message list.
+func (a *API) pinMessage(w http.ResponseWriter, r *http.Request) error {
+	return a.setMessagePinned(w, r, true)
+}
+
+// unpinMessage removes a message's pin.
+func (a *API) unpinMessage(w http.ResponseWriter, r *http.Request) error {
+	return a.setMessagePinned(w, r, false)
+}
+
+func (a *API) setMessagePinned(w http.ResponseWriter, r *http.Request, pinned bool) error {
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+
+	msg, err := a.DB.SetMessagePinned(r.Context(), messageID, pinned)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Message not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not update message")
+	}
+
+	action := AuditMessageUnpin
+	if pinned {
+		action = AuditMessagePin
+	}
+	a.audit(r, action, "", messageID)
+
+	if err := a.Cache.SetMessagePinned(r.Context(), msg); err != nil {
+		a.Logger.Error("Could not update cached message", "error", err.Error())
+	}
+
+	a.respondMessages(w, r, http.StatusOK, msg, []Message{msg}, true)
+	return nil
+}
+
+// hideMessage hides a message from everyone but moderators.
+func (a *API) hideMessage(w http.ResponseWriter, r *http.Request) error {
+	return a.setMessageHidden(w, r, true)
+}
+
+// unhideMessage shows a hidden message to everyone again.
+func (a *API) unhideMessage(w http.ResponseWriter, r *http.Request) error {
+	return a.setMessageHidden(w, r, false)
+}
+
+func (a *API) setMessageHidden(w http.ResponseWriter, r *http.Request, hidden bool) error {
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+
+	msg, err := a.DB.SetMessageHidden(r.Context(), messageID, hidden)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Message not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not update message")
+	}
+
+	action := AuditMessageUnhide
+	if hidden {
+		action = AuditMessageHide
+	}
+	a.audit(r, action, "", messageID)
+
+	if err := a.Cache.SetMessageHidden(r.Context(), messageID, hidden); err != nil {
+		a.Logger.Error("Could not update cached message", "error", err.Error())
+	}
+
+	a.respondMessages(w, r, http.StatusOK, msg, []Message{msg}, true)
+	return nil
+}
+
+// updateMessage edits the text of a message. Only its author and moderators
+// may edit a message.
+func (a *API) updateMessage(w http.ResponseWriter, r *http.Request) error {
+	type request struct {
+		Text string `json:"text" validate:"required"`
+	}
+
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+
+	var body request
+	if err := decodeReqBody(r, &body); err != nil {
+		return err
+	}
+	body.Text = a.normalizeText(body.Text)
+	if err := a.validateReqBody(&body); err != nil {
+		return err
+	}
+
+	if err := a.authorizeMessage(w, r, messageID); err != nil {
+		return err
+	}
+
+	msg, err := a.DB.UpdateMessageText(r.Context(), messageID, body.Text)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Message not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not update message")
+	}
+	a.audit(r, AuditMessageUpdate, userFrom(r.Context()), messageID)
+
+	if err := a.Cache.SetMessageText(r.Context(), messageID, msg.Text); err != nil {
+		a.Logger.Error("Could not update cached message", "error", err.Error())
+	}
+
+	a.respondMessages(w, r, http.StatusOK, msg, []Message{msg}, true)
+	return nil
+}
+
+// deleteMessage deletes a message with its replies and reactions. Only its
+// author and moderators may delete a message.
+func (a *API) deleteMessage(w http.ResponseWriter, r *http.Request) error {
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+
+	if err := a.authorizeMessage(w, r, messageID); err != nil {
+		return err
+	}
+
+	err := a.DB.DeleteMessage(r.Context(), messageID)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Message not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not delete message")
+	}
+	a.audit(r, AuditMessageDelete, userFrom(r.Context()), messageID)
+
+	if err := a.Cache.DeleteMessage(r.Context(), messageID); err != nil {
+		a.Logger.Error("Could not delete cached message", "error", err.Error())
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// authorizeMessage checks that the client may change the message identified
+// by messageID. Moderators may change every message, users only their own.
+func (a *API) authorizeMessage(w http.ResponseWriter, r *http.Request, messageID string) error {
+	if roleFrom(r.Context()) == RoleModerator {
+		return nil
+	}
+	userID, err := requireUser(w, r)
+	if err != nil {
+		return err
+	}
+
+	// The DB holds every message, the cache only the latest ones.
+	msg, err := a.DB.GetMessage(r.Context(), messageID, ReactionSortCreated, "")
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Message not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not get message")
+	}
+	if msg.UserID != userID {
+		return apiError(http.StatusForbidden, fmt.Errorf("message %s belongs to %s", messageID, msg.UserID), "Message belongs to another user")
+	}
+	return nil
+}
+
+// requireUser returns the id of the authenticated user, or an error for
+// anonymous requests.
+func requireUser(w http.ResponseWriter, r *http.Request) (string, error) {
+	userID := userFrom(r.Context())
+	if userID == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return "", apiError(http.StatusUnauthorized, errors.New("anonymous request"), "Unauthorized")
+	}
+	return userID, nil
+}
+
+// createReaction handles the creation of a reaction for a given message. A
+// reaction repeating the type of a cached reaction of the same user returns
+// the existing reaction with status 200 instead. With if_absent=true the DB is
+// checked for such a reaction too, for clients that retry creations of
+// reactions that are no longer cached. With counts=true the response includes
+// the number of reactions of the message and of the reaction's type, counted
+// after the insert. With include=message the response is the reacted message
+// instead, with its reactions, as served by GET /messages/{messageID}.
+func (a *API) createReaction(w http.ResponseWriter, r *http.Request) error {
+	type (
+		request struct {
+			Type   string `json:"type" validate:"required"`
+			Emoji  string `json:"emoji" validate:"omitempty,emoji"`
+			Score  *int   `json:"score"`
+			UserID string `json:"user_id" validate:"required,user_id"`
+		}
+		response struct {
+			Reaction
+			// The counts include the created reaction, so they are only
+			// zero when not requested.
+			MessageReactionCount int `json:"message_reaction_count,omitempty"`
+			TypeCount            int `json:"type_count,omitempty"`
+		}
+	)
+
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+
+	withCounts, err := a.boolParam(r, "counts")
+	if err != nil {
+		return err
+	}
+	ifAbsent, err := a.boolParam(r, "if_absent")
+	if err != nil {
+		return err
+	}
+	include := r.URL.Query().Get("include")
+	if err := a.validateParam(include, "omitempty,oneof=message"); err != nil {
+		return err
+	}
+
+	// An empty body is validated like an empty object, so that the client
+	// learns which fields are required.
+	var body request
+	if err := decodeReqBody(r, &body); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	err = r.Body.Close()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Invalid request body")
+	}
+
+	body.Type = a.normalizeReactionType(body.Type)
+	if err := a.validateReqBody(&body); err != nil {
+		return err
+	}
+
+	score := a.defaultReactionScore(body.Type)
+	if body.Score != nil {
+		score = *body.Score
+	}
+	if msg := a.checkReactionScore(body.Type, score); msg != "" {
+		return &bodyError{Errors: []validator.ValidationError{{
+			Field:   "Score",
+			Message: "Score " + msg,
+		}}}
+	}
+
+	// Reacting twice with the same type returns the existing reaction, so
+	// that the user isn't counted twice.
+	status := http.StatusCreated
+	reaction, err := a.Cache.FindReaction(r.Context(), messageID, body.UserID, body.Type)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		a.Logger.Error("Could not find cached reaction", "error", err.Error())
+	}
+	if err != nil && ifAbsent {
+		reaction, err = a.DB.FindReaction(r.Context(), messageID, body.UserID, body.Type)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return apiError(http.StatusInternalServerError, err, "Could not find reaction")
+		}
+	}
+	if err == nil {
+		status = http.StatusOK
+	} else {
+		reaction, err = a.insertReaction(r, Reaction{
+			MessageID: messageID,
+			Type:      body.Type,
+			Emoji:     body.Emoji,
+			Score:     score,
+			UserID:    body.UserID,
+			CreatedAt: a.now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if status == http.StatusCreated {
+		w.Header().Set("Location", "/messages/"+messageID+"/reactions/"+reaction.ID)
+	}
+	if include == "message" {
+		msg, err := a.loadMessage(r, messageID, ReactionSortCreated, "")
+		if err != nil {
+			return err
+		}
+		a.respondMessages(w, r, status, msg, []Message{msg}, true)
+		return nil
+	}
+
+	res := response{
+		Reaction: Reaction{
+			ID:        reaction.ID,
+			MessageID: reaction.MessageID,
+			Type:      reaction.Type,
+			Emoji:     reaction.Emoji,
+			Score:     reaction.Score,
+			UserID:    reaction.UserID,
+			CreatedAt: reaction.CreatedAt,
+		},
+	}
+	if withCounts {
+		summary, err := a.DB.ReactionSummary(r.Context(), messageID)
+		if err != nil {
+			// The reaction was created, serve it without the counts.
+			a.Logger.Error("Could not count reactions", "error", err.Error())
+		} else {
+			res.MessageReactionCount = summary.Total
+			res.TypeCount = summary.Counts[reaction.Type]
+		}
+	}
+
+	a.respond(w, status, res)
+	return nil
+}
+
+// insertReaction stores a new reaction and announces it.
+func (a *API) insertReaction(r *http.Request, rc Reaction) (Reaction, error) {
+	if err := a.checkReactionLimit(r, rc.MessageID, 1); err != nil {
+		return Reaction{}, err
+	}
+
+	reaction, err := a.DB.InsertReaction(r.Context(), rc)
+	if errors.Is(err, ErrNotFound) {
+		return Reaction{}, apiError(http.StatusNotFound, err, "Message not found")
+	}
+	if errors.Is(err, ErrDuplicateReaction) {
+		return Reaction{}, apiError(http.StatusConflict, err, "Reaction already exists")
+	}
+	if err != nil {
+		return Reaction{}, apiError(http.StatusInternalServerError, err, fmt.Sprintf("could not create reaction for message with id %s", rc.MessageID))
+	}
+	a.audit(r, AuditReactionCreate, reaction.UserID, reaction.ID)
+
+	err = a.Cache.InsertReaction(r.Context(), rc.MessageID, reaction)
+	if err != nil {
+		a.Logger.Error("Could not cache reaction", "error", err.Error())
+		return Reaction{}, apiError(http.StatusInternalServerError, err, "Internal server error")
+	}
+
+	if a.Hub != nil {
+		a.Hub.Publish(Event{
+			Type: EventReaction,
+			Data: ReactionEvent{MessageID: rc.MessageID, Reaction: reaction},
+		})
+	}
+	return reaction, nil
+}
+
+// createReactions handles the creation of several reactions for a given
+// message at once. Either all reactions are created or none. With
+// partial=true the invalid reactions are skipped instead, the valid ones are
+// created and the outcome of each reaction is reported with status 207.
+func (a *API) createReactions(w http.ResponseWriter, r *http.Request) error {
+	type (
+		reaction struct {
+			Type   string `json:"type" validate:"required"`
+			Emoji  string `json:"emoji" validate:"omitempty,emoji"`
+			Score  *int   `json:"score"`
+			UserID string `json:"user_id" validate:"required,user_id"`
+		}
+		request struct {
+			// The batch size is capped to bound the size of the insert.
+			Reactions []reaction `json:"reactions" validate:"required,min=1,max=50,dive"`
+		}
+		// partialRequest is a request whose reactions are validated one
+		// by one.
+		partialRequest struct {
+			Reactions []reaction `json:"reactions" validate:"required,min=1,max=50"`
+		}
+		response struct {
+			Reactions []Reaction `json:"reactions"`
+		}
+		// A result is the outcome of a reaction of a partial request.
+		result struct {
+			Status int                         `json:"status"`
+			ID     string                      `json:"id,omitempty"`
+			Errors []validator.ValidationError `json:"errors,omitempty"`
+		}
+		partialResponse struct {
+			Results []result `json:"results"`
+		}
+	)
+
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+	partial, err := a.boolParam(r, "partial")
+	if err != nil {
+		return err
+	}
+
+	var body request
+	if err := decodeReqBody(r, &body); err != nil {
+		return err
+	}
+	if err := r.Body.Close(); err != nil {
+		return apiError(http.StatusInternalServerError, err, "Invalid request body")
+	}
+
+	for i := range body.Reactions {
+		body.Reactions[i].Type = a.normalizeReactionType(body.Reactions[i].Type)
+	}
+	if partial {
+		p := partialRequest(body)
+		err = a.validateReqBody(&p)
+	} else {
+		err = a.validateReqBody(&body)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The reactions of a batch are created at the same time.
+	now := a.now().UTC()
+	var reactions []Reaction
+	// itemErrs are the errors of each reaction of a partial request.
+	itemErrs := make([][]validator.ValidationError, len(body.Reactions))
+	var errs []validator.ValidationError
+	for i, rc := range body.Reactions {
+		if partial {
+			itemErrs[i] = a.Val.ValidateStruct(&rc)
+		}
+		score := a.defaultReactionScore(rc.Type)
+		if rc.Score != nil {
+			score = *rc.Score
+		}
+		if msg := a.checkReactionScore(rc.Type, score); msg != "" {
+			if partial {
+				itemErrs[i] = append(itemErrs[i], validator.ValidationError{
+					Field:   "Score",
+					Message: "Score " + msg,
+				})
+			} else {
+				errs = append(errs, validator.ValidationError{
+					Field:   "Score",
+					Message: fmt.Sprintf("Reactions[%d].Score %s", i, msg),
+				})
+			}
+		}
+		if itemErrs[i] != nil {
+			continue
+		}
+		reactions = append(reactions, Reaction{
+			MessageID: messageID,
+			Type:      rc.Type,
+			Emoji:     rc.Emoji,
+			Score:     score,
+			UserID:    rc.UserID,
+			CreatedAt: now,
+		})
+	}
+	if errs != nil {
+		return &bodyError{Errors: errs}
+	}
+
+	var created []Reaction
+	if len(reactions) > 0 {
+		if err := a.checkReactionLimit(r, messageID, len(reactions)); err != nil {
+			return err
+		}
+
+		created, err = a.DB.InsertReactions(r.Context(), reactions)
+		if errors.Is(err, ErrNotFound) {
+			return apiError(http.StatusNotFound, err, "Message not found")
+		}
+		if errors.Is(err, ErrDuplicateReaction) {
+			return apiError(http.StatusConflict, err, "Reaction already exists")
+		}
+		if err != nil {
+			return apiError(http.StatusInternalServerError, err, "Could not create reactions")
+		}
+	}
+
+	for _, rc := range created {
+		a.audit(r, AuditReactionCreate, rc.UserID, rc.ID)
+		if err := a.Cache.InsertReaction(r.Context(), messageID, rc); err != nil {
+			a.Logger.Error("Could not cache reaction", "error", err.Error())
+		}
+		if a.Hub != nil {
+			a.Hub.Publish(Event{
+				Type: EventReaction,
+				Data: ReactionEvent{MessageID: messageID, Reaction: rc},
+			})
+		}
+	}
+
+	if partial {
+		res := partialResponse{Results: make([]result, len(body.Reactions))}
+		// The reactions are created in the order of the request.
+		next := 0
+		for i := range res.Results {
+			if itemErrs[i] != nil {
+				res.Results[i] = result{Status: http.StatusBadRequest, Errors: itemErrs[i]}
+				continue
+			}
+			res.Results[i] = result{Status: http.StatusCreated, ID: created[next].ID}
+			next++
+		}
+		a.respond(w, http.StatusMultiStatus, res)
+		return nil
+	}
+
+	a.respond(w, http.StatusCreated, response{Reactions: created})
+	return nil
+}
+
+// checkReactionLimit returns an error unless n more reactions can be added to
+// the message without exceeding MaxReactionsPerMessage.
+func (a *API) checkReactionLimit(r *http.Request, messageID string, n int) error {
+	if a.MaxReactionsPerMessage <= 0 {
+		return nil
+	}
+	count, err := a.DB.CountReactions(r.Context(), messageID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not count reactions")
+	}
+	if count+n > a.MaxReactionsPerMessage {
+		return apiError(http.StatusUnprocessableEntity,
+			fmt.Errorf("message %s has %d reactions", messageID, count),
+			"Message has reached the maximum number of reactions")
+	}
+	return nil
+}
+
+// normalizeText trims the whitespace surrounding a message text, and
+// collapses the runs of whitespace within it if CollapseWhitespace is set.
+func (a *API) normalizeText(text string) string {
+	if a.CollapseWhitespace {
+		return strings.Join(strings.Fields(text), " ")
+	}
+	return strings.TrimSpace(text)
+}
+
+// normalizeReactionType returns the canonical form of a reaction type.
+func (a *API) normalizeReactionType(typ string) string {
+	aliases := a.ReactionAliases
+	if aliases == nil {
+		aliases = DefaultReactionAliases
+	}
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	if canonical, ok := aliases[typ]; ok {
+		return canonical
+	}
+	return typ
+}
+
+// now returns the current time of the API's clock.
+func (a *API) now() time.Time {
+	if a.Now == nil {
+		return time.Now()
+	}
+	return a.Now()
+}
+
+func (a *API) reactionTypes() []ReactionType {
+	if a.ReactionTypes == nil {
+		return DefaultReactionTypes
+	}
+	return a.ReactionTypes
+}
+
+// defaultReactionScore returns the score of reactions of type typ created
+// without one.
+func (a *API) defaultReactionScore(typ string) int {
+	for _, rt := range a.reactionTypes() {
+		if rt.Name == typ && rt.DefaultScore != 0 {
+			return rt.DefaultScore
+		}
+	}
+	if a.DefaultReactionScore == 0 {
+		return defaultReactionScore
+	}
+	return a.DefaultReactionScore
+}
+
+func (a *API) maxReactionScore() int {
+	if a.MaxReactionScore == 0 {
+		return defaultMaxReactionScore
+	}
+	return a.MaxReactionScore
+}
+
+// checkReactionScore checks score against the range of the reaction type, or
+// between 1 and the maximum score for types without one. It returns what is
+// wrong with the score, or an empty string if it is in range.
+func (a *API) checkReactionScore(typ string, score int) string {
+	sr, ok := a.ReactionScoreRanges[typ]
+	if !ok {
+		if score < 1 {
+			return "must be at least 1"
+		}
+		if maxScore := a.maxReactionScore(); score > maxScore {
+			return fmt.Sprintf("must not be greater than %d", maxScore)
+		}
+		return ""
+	}
+	if score < sr.Min || score > sr.Max {
+		return fmt.Sprintf("must be between %d and %d for %s reactions", sr.Min, sr.Max, typ)
+	}
+	return ""
+}
+
+// listReactionTypes returns the reaction types clients offer, with their
+// emoji and default score, so that they can render a reaction picker.
+func (a *API) listReactionTypes(w http.ResponseWriter, r *http.Request) error {
+	type response struct {
+		Types []ReactionType `json:"types"`
+	}
+
+	types := slices.Clone(a.reactionTypes())
+	for i := range types {
+		types[i].DefaultScore = a.defaultReactionScore(types[i].Name)
+	}
+	a.respond(w, http.StatusOK, response{Types: types})
+	return nil
+}
+
+// getReaction returns a single reaction of a message. The cache is consulted
+// first and the reaction is loaded from the DB (and cached) on a miss.
+func (a *API) getReaction(w http.ResponseWriter, r *http.Request) error {
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+	reactionID := r.PathValue("reactionID")
+	if err := a.validateParam(reactionID, "required,uuid"); err != nil {
+		return err
+	}
+
+	reaction, err := a.Cache.GetReaction(r.Context(), messageID, reactionID)
+	if err == nil {
+		a.respond(w, http.StatusOK, reaction)
+		return nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		a.Logger.Error("Could not get reaction from cache", "error", err.Error())
+	}
+
+	reaction, err = a.DB.GetReaction(r.Context(), messageID, reactionID)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Reaction not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not get reaction")
+	}
+
+	if err := a.Cache.InsertReaction(r.Context(), messageID, reaction); err != nil {
+		a.Logger.Error("Could not cache reaction", "error", err.Error())
+	}
+
+	a.respond(w, http.StatusOK, reaction)
+	return nil
+}
+
+// getReactionByID returns a reaction along with the id of its message, for
+// clients holding only the id of the reaction.
+func (a *API) getReactionByID(w http.ResponseWriter, r *http.Request) error {
+	type response struct {
+		MessageID string `json:"message_id"`
+		Reaction
+	}
+
+	reactionID := r.PathValue("reactionID")
+	if err := a.validateParam(reactionID, "required,uuid"); err != nil {
+		return err
+	}
+
+	reaction, err := a.DB.GetReactionByID(r.Context(), reactionID)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Reaction not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not get reaction")
+	}
+
+	a.respond(w, http.StatusOK, response{MessageID: reaction.MessageID, Reaction: reaction})
+	return nil
+}
+
+// deleteReaction deletes a reaction of the authenticated user. Reactions of
+// other users can't be deleted.
+func (a *API) deleteReaction(w http.ResponseWriter, r *http.Request) error {
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+	reactionID := r.PathValue("reactionID")
+	if err := a.validateParam(reactionID, "required,uuid"); err != nil {
+		return err
+	}
+
+	userID, err := requireUser(w, r)
+	if err != nil {
+		return err
+	}
+
+	// The DB holds the owner of every reaction, unlike the cache.
+	reaction, err := a.DB.GetReaction(r.Context(), messageID, reactionID)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Reaction not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not get reaction")
+	}
+	if reaction.UserID != userID {
+		return apiError(http.StatusForbidden, fmt.Errorf("reaction %s belongs to %s", reactionID, reaction.UserID), "Reaction belongs to another user")
+	}
+
+	err = a.DB.DeleteReaction(r.Context(), messageID, reactionID)
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Reaction not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not delete reaction")
+	}
+	a.audit(r, AuditReactionDelete, userID, reactionID)
+
+	if err := a.Cache.DeleteReaction(r.Context(), messageID, reactionID); err != nil && !errors.Is(err, ErrNotFound) {
+		a.Logger.Error("Could not delete cached reaction", "error", err.Error())
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// toggleReaction creates the user's reaction of a type to a message, or
+// deletes it if the user already reacted with the type, and responds with
+// whether the user now reacts with the type and how many reactions of the
+// type the message has. Authenticated users may only toggle their own
+// reactions.
+func (a *API) toggleReaction(w http.ResponseWriter, r *http.Request) error {
+	type (
+		request struct {
+			Type   string `json:"type" validate:"required"`
+			UserID string `json:"user_id" validate:"required,user_id"`
+		}
+		response struct {
+			Active bool `json:"active"`
+			Count  int  `json:"count"`
+		}
+	)
+
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+
+	var body request
+	if err := decodeReqBody(r, &body); err != nil {
+		return err
+	}
+	if err := r.Body.Close(); err != nil {
+		return apiError(http.StatusInternalServerError, err, "Invalid request body")
+	}
+	body.Type = a.normalizeReactionType(body.Type)
+	if err := a.validateReqBody(&body); err != nil {
+		return err
+	}
+	if userID := userFrom(r.Context()); userID != "" && userID != body.UserID {
+		return apiError(http.StatusForbidden, fmt.Errorf("user %s toggles reaction of %s", userID, body.UserID), "Reaction belongs to another user")
+	}
+
+	// Only reactions being created count against the limit.
+	if a.MaxReactionsPerMessage > 0 {
+		_, err := a.DB.FindReaction(r.Context(), messageID, body.UserID, body.Type)
+		if errors.Is(err, ErrNotFound) {
+			err = a.checkReactionLimit(r, messageID, 1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	toggle, err := a.DB.ToggleReaction(r.Context(), Reaction{
+		MessageID: messageID,
+		Type:      body.Type,
+		Score:     a.defaultReactionScore(body.Type),
+		UserID:    body.UserID,
+		CreatedAt: a.now().UTC(),
+	})
+	if errors.Is(err, ErrNotFound) {
+		return apiError(http.StatusNotFound, err, "Message not found")
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not toggle reaction")
+	}
+
+	for _, reaction := range toggle.Reactions {
+		if toggle.Active {
+			a.audit(r, AuditReactionCreate, reaction.UserID, reaction.ID)
+			if err := a.Cache.InsertReaction(r.Context(), messageID, reaction); err != nil {
+				a.Logger.Error("Could not cache reaction", "error", err.Error())
+			}
+			if a.Hub != nil {
+				a.Hub.Publish(Event{
+					Type: EventReaction,
+					Data: ReactionEvent{MessageID: messageID, Reaction: reaction},
+				})
+			}
+			continue
+		}
+		a.audit(r, AuditReactionDelete, reaction.UserID, reaction.ID)
+		if err := a.Cache.DeleteReaction(r.Context(), messageID, reaction.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			a.Logger.Error("Could not delete cached reaction", "error", err.Error())
+		}
+	}
+
+	a.respond(w, http.StatusOK, response{Active: toggle.Active, Count: toggle.Count})
+	return nil
+}
+
+// reactionSummary returns the reaction counts and total score of a message.
+// The summary is aggregated in the DB, the cached reactions are aggregated
+// instead if the DB is unavailable.
+func (a *API) reactionSummary(w http.ResponseWriter, r *http.Request) error {
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+
+	summary, err := a.DB.ReactionSummary(r.Context(), messageID)
+	if err != nil {
+		a.Logger.Error("Could not summarize reactions in DB, falling back to cache", "error", err.Error())
+		summary, err = a.Cache.ReactionSummary(r.Context(), messageID)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, err, "Could not summarize reactions")
+		}
+	}
+	if summary.Counts == nil {
+		summary.Counts = make(map[string]int)
+	}
+
+	a.respond(w, http.StatusOK, summary)
+	return nil
+}
+
+// emojiCounts returns the number of reactions per emoji of a message, for
+// rendering a reaction bar. Reactions without an emoji are left out. Like the
+// summary, the counts fall back to the cached reactions if the DB is
+// unavailable.
+func (a *API) emojiCounts(w http.ResponseWriter, r *http.Request) error {
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+
+	counts, err := a.DB.EmojiCounts(r.Context(), messageID)
+	if err != nil {
+		a.Logger.Error("Could not count emojis in DB, falling back to cache", "error", err.Error())
+		counts, err = a.Cache.EmojiCounts(r.Context(), messageID)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, err, "Could not count emojis")
+		}
+	}
+	if counts == nil {
+		counts = make(map[string]int)
+	}
+
+	a.respond(w, http.StatusOK, counts)
+	return nil
+}
+
+// scoreHistogram returns the number of reactions per score of a message, for
+// analytics.
+func (a *API) scoreHistogram(w http.ResponseWriter, r *http.Request) error {
+	messageID := r.PathValue("messageID")
+	if err := a.validateParam(messageID, "required,uuid"); err != nil {
+		return err
+	}
+
+	histogram, err := a.DB.ScoreHistogram(r.Context(), messageID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not count scores")
+	}
+	if histogram == nil {
+		histogram = make(map[int]int)
+	}
+
+	a.respond(w, http.StatusOK, histogram)
+	return nil
+}
+
+// startTyping marks a user as typing for a short period and notifies
+// real-time clients.
+func (a *API) startTyping(w http.ResponseWriter, r *http.Request) error {
+	type request struct {
+		UserID string `json:"user_id" validate:"required,user_id"`
+	}
+
+	var body request
+	if err := decodeReqBody(r, &body); err != nil {
+		return err
+	}
+	if err := a.validateReqBody(&body); err != nil {
+		return err
+	}
+
+	ttl := a.TypingTTL
+	if ttl == 0 {
+		ttl = defaultTypingTTL
+	}
+	if err := a.Cache.SetTyping(r.Context(), body.UserID, ttl); err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not set typing indicator")
+	}
+
+	if a.Hub != nil {
+		a.Hub.Publish(Event{
+			Type: EventTyping,
+			Data: TypingEvent{UserID: body.UserID},
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// listTyping returns the IDs of the users that are currently typing.
+func (a *API) listTyping(w http.ResponseWriter, r *http.Request) error {
+	type response struct {
+		UserIDs []string `json:"user_ids"`
+	}
+
+	userIDs, err := a.Cache.ListTyping(r.Context())
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not list typing users")
+	}
+	if userIDs == nil {
+		userIDs = make([]string, 0)
+	}
+
+	a.respond(w, http.StatusOK, response{UserIDs: userIDs})
+	return nil
+}
+
+// streamEvents streams Hub events to the client as server-sent events until
+// the client disconnects.
+func (a *API) streamEvents(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := a.Hub.Subscribe()
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		a.Logger.Error("Could not flush event stream", "error", err.Error())
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				a.Logger.Error("Could not encode event", "type", e.Type, "error", err.Error())
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// flushCache removes all cached messages, so that they are read from the DB
+// again.
+func (a *API) flushCache(w http.ResponseWriter, r *http.Request) error {
+	type response struct {
+		Deleted int `json:"deleted"`
+	}
+
+	n, err := a.Cache.Flush(r.Context())
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not flush cache")
+	}
+	a.Logger.Info("Flushed cache", "deleted", n)
+	a.audit(r, AuditCacheFlush, "admin", "")
+
+	a.respond(w, http.StatusOK, response{Deleted: n})
+	return nil
+}
+
+// setLogLevel changes the level of the logs, such as to debug an issue in
+// production without a restart.
+func (a *API) setLogLevel(w http.ResponseWriter, r *http.Request) error {
+	type (
+		request struct {
+			Level string `json:"level" validate:"required,oneof=debug info warn error"`
+		}
+		response struct {
+			Level string `json:"level"`
+		}
+	)
+
+	var body request
+	if err := decodeReqBody(r, &body); err != nil {
+		return err
+	}
+	if err := a.validateReqBody(&body); err != nil {
+		return err
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(body.Level)); err != nil {
+		return apiError(http.StatusBadRequest, err, "Invalid log level")
+	}
+	a.LogLevel.Set(level)
+	a.Logger.Info("Changed log level", "level", level.String())
+	a.audit(r, AuditLogLevel, "admin", level.String())
+
+	a.respond(w, http.StatusOK, response{Level: strings.ToLower(level.String())})
+	return nil
+}
+
+// userReactions returns the reactions a user has given, grouped by type. The
+// types with the most reactions come first.
+func (a *API) userReactions(w http.ResponseWriter, r *http.Request) error {
+	type (
+		group struct {
+			Type       string   `json:"type"`
+			Count      int      `json:"count"`
+			MessageIDs []string `json:"message_ids"`
+		}
+		response struct {
+			UserID string  `json:"user_id"`
+			Types  []group `json:"types"`
+		}
+	)
+
+	userID := r.PathValue("userID")
+	if err := a.validateParam(userID, "required,user_id"); err != nil {
+		return err
+	}
+
+	reactions, err := a.DB.ListReactionsByUser(r.Context(), userID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err, "Could not list reactions")
+	}
+
+	groups := make([]group, 0)
+	index := make(map[string]int)
+	for _, rc := range reactions {
+		i, ok := index[rc.Type]
+		if !ok {
+			i = len(groups)
+			index[rc.Type] = i
+			groups = append(groups, group{Type: rc.Type, MessageIDs: make([]string, 0)})
+		}
+		groups[i].Count++
+		groups[i].MessageIDs = append(groups[i].MessageIDs, rc.MessageID)
+	}
+	slices.SortStableFunc(groups, func(g1, g2 group) int {
+		if g1.Count != g2.Count {
+			return g2.Count - g1.Count
+		}
+		return strings.Compare(g1.Type, g2.Type)
+	})
+
+	a.respond(w, http.StatusOK, response{UserID: userID, Types: groups})
+	return nil
 }
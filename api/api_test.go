@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -10,6 +11,8 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -28,58 +31,63 @@ func TestAPI_listMessages(t *testing.T) {
 		{
 			name: "DBError",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
 					return nil, nil
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, offset, limit int, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, offset, limit int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
 					return nil, errors.New("something went wrong")
 				},
 			},
 			wantStatus: 500,
 			wantBody: `{
+				"api_version": "1",
 				"error": "Could not list messages"
 			}`,
 		},
 		{
 			name: "CacheError",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
 					return nil, errors.New("something went wrong")
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, offset, limit int, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, offset, limit int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
 					return nil, nil
 				},
 			},
 			wantStatus: 500,
 			wantBody: `{
+				"api_version": "1",
 				"error": "Could not list messages"
 			}`,
 		},
 		{
 			name: "Empty",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
 					return nil, nil
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, limit, offset int, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
 					return nil, nil
 				},
 			},
 			wantStatus: 200,
 			wantBody: `{
-				"messages": []
+				"api_version": "1",
+				"data": {
+					"messages": []
+				}
 			}`,
 		},
 		{
 			name: "Cache",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
 					return []Message{
 						{
 							ID:        "1",
@@ -102,43 +110,48 @@ func TestAPI_listMessages(t *testing.T) {
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, offset, limit int, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, offset, limit int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
 					// Nothing in DB.
 					return nil, nil
 				},
 			},
 			wantStatus: 200,
 			wantBody: `{
-				"messages": [
-					{
-						"id": "1",
-						"text": "Hello",
-						"user_id": "testuser",
-						"created_at": "2024-01-01T00:00:00Z",
-						"reactions": [
-							{
-								"id": "1",
-								"type": "thumbs_up",
-								"score": 1,
-                                "user_id": "testuser2",
- 								"created_at": "2024-01-01T00:00:00Z"
-							}
-						],
-						"reaction_count": 1
-					}
-				]
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{
+							"id": "1",
+							"text": "Hello",
+							"user_id": "testuser",
+							"created_at": "2024-01-01T00:00:00Z",
+							"pinned": false,
+							"reactions": [
+								{
+									"id": "1",
+									"type": "thumbs_up",
+									"score": 1,
+	                                "user_id": "testuser2",
+	 								"created_at": "2024-01-01T00:00:00Z"
+								}
+							],
+							"reaction_count": 1,
+							"reply_count": 0
+						}
+					]
+				}
 			}`,
 		},
 		{
 			name: "DB",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
 					// Nothing in cache.
 					return nil, nil
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, offset, limit int, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, offset, limit int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
 					return []Message{
 						{
 							ID:        "1",
@@ -162,30 +175,85 @@ func TestAPI_listMessages(t *testing.T) {
 			},
 			wantStatus: 200,
 			wantBody: `{
-				"messages": [
-					{
-						"id": "1",
-						"text": "Hello",
-						"user_id": "testuser",
-						"created_at": "2024-01-01T00:00:00Z",
-						"reactions": [
-							{
-								"id": "1",
-								"type": "thumbs_up",
-								"score": 1,
-                                "user_id": "testuser2",
- 								"created_at": "2024-01-01T00:00:00Z"
-							}
-						],
-						"reaction_count": 1
-					}
-				]
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{
+							"id": "1",
+							"text": "Hello",
+							"user_id": "testuser",
+							"created_at": "2024-01-01T00:00:00Z",
+							"pinned": false,
+							"reactions": [
+								{
+									"id": "1",
+									"type": "thumbs_up",
+									"score": 1,
+	                                "user_id": "testuser2",
+	 								"created_at": "2024-01-01T00:00:00Z"
+								}
+							],
+							"reaction_count": 1,
+							"reply_count": 0
+						}
+					]
+				}
+			}`,
+		},
+		{
+			name: "Attachments",
+			cache: &testcache{
+				listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+					return []Message{
+						{
+							ID:        "1",
+							Text:      "Look",
+							UserID:    "testuser",
+							CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+							Attachments: []Attachment{
+								{URL: "https://example.com/cat.png", Type: "image/png", Name: "cat.png", Size: 1024},
+							},
+							Reactions: []Reaction{},
+						},
+					}, nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, before time.Time, order Order, offset, limit int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{
+							"id": "1",
+							"text": "Look",
+							"user_id": "testuser",
+							"created_at": "2024-01-01T00:00:00Z",
+							"pinned": false,
+							"attachments": [
+								{
+									"url": "https://example.com/cat.png",
+									"type": "image/png",
+									"name": "cat.png",
+									"size": 1024
+								}
+							],
+							"reactions": [],
+							"reaction_count": 0,
+							"reply_count": 0
+						}
+					]
+				}
 			}`,
 		},
 		{
 			name: "Mixed",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
 					return []Message{
 						{
 							ID:            "1",
@@ -199,7 +267,7 @@ func TestAPI_listMessages(t *testing.T) {
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, offset, limit int, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, before time.Time, order Order, offset, limit int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
 					return []Message{
 						{
 							ID:            "2",
@@ -214,24 +282,31 @@ func TestAPI_listMessages(t *testing.T) {
 			},
 			wantStatus: 200,
 			wantBody: `{
-				"messages": [
-				  {
-					"id": "1",
-					"text": "Hello",
-					"user_id": "testuser",
-					"created_at": "2024-01-01T00:00:00Z",
-					"reactions": [],
-					"reaction_count": 0
-				  },
-				  {
-					"id": "2",
-					"text": "World",
-					"user_id": "testuser",
-					"created_at": "2024-01-02T00:00:00Z",
-					"reactions": [],
-					"reaction_count": 0
-				  }
-				]
+				"api_version": "1",
+				"data": {
+					"messages": [
+					  {
+						"id": "1",
+						"text": "Hello",
+						"user_id": "testuser",
+						"created_at": "2024-01-01T00:00:00Z",
+						"pinned": false,
+						"reactions": [],
+						"reaction_count": 0,
+						"reply_count": 0
+					  },
+					  {
+						"id": "2",
+						"text": "World",
+						"user_id": "testuser",
+						"created_at": "2024-01-02T00:00:00Z",
+						"pinned": false,
+						"reactions": [],
+						"reaction_count": 0,
+						"reply_count": 0
+					  }
+					]
+				}
           }`,
 		},
 	}
@@ -264,245 +339,6186 @@ func TestAPI_listMessages(t *testing.T) {
 	}
 }
 
-func TestAPI_createMessage(t *testing.T) {
+func TestAPI_listMessages_pagination(t *testing.T) {
 	tests := []struct {
-		name        string
-		cache       *testcache
-		db          *testdb
-		req         string
-		wantStatus  int
-		wantBody    string
-		containsLog string
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+		wantLimit  int
+		wantOffset int
 	}{
 		{
-			name:       "InvalidJSON",
-			req:        `not json`,
+			name:       "Defaults",
+			query:      "",
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"messages": []}}`,
+			wantLimit:  10,
+			wantOffset: 0,
+		},
+		{
+			name:       "Explicit",
+			query:      "?page=3&limit=20",
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"messages": []}}`,
+			wantLimit:  20,
+			wantOffset: 40,
+		},
+		{
+			name:       "PageZero",
+			query:      "?page=0",
 			wantStatus: 400,
 			wantBody: `{
-				"error": "Could not decode request body"
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "Page",
+						"Message": "Key: 'pagination.Page' Error:Field validation for 'Page' failed on the 'gte' tag"
+					}
+				]
 			}`,
 		},
 		{
-			name: "DBError",
-			req: `{
-				"text": "hello",
-				"user_id": "test"
-			}`,
-			db: &testdb{
-				insertMessage: func(t *testing.T, msg Message) (Message, error) {
-					return Message{}, errors.New("something went wrong")
-				},
-			},
-			wantStatus: 500,
+			name:       "PageNegative",
+			query:      "?page=-1",
+			wantStatus: 400,
 			wantBody: `{
-				"error": "Could not insert message"
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "Page",
+						"Message": "Key: 'pagination.Page' Error:Field validation for 'Page' failed on the 'gte' tag"
+					}
+				]
 			}`,
 		},
 		{
-			name: "CacheError",
-			req: `{
-				"text": "hello",
-				"user_id": "test"
-			}`,
-			cache: &testcache{
-				insertMessage: func(t *testing.T, msg Message) error {
-					return errors.New("something went wrong")
-				},
-			},
-			db: &testdb{
-				insertMessage: func(t *testing.T, msg Message) (Message, error) {
-					return Message{
-						ID:        "1",
-						Text:      msg.Text,
-						UserID:    msg.UserID,
-						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-					}, nil
-				},
-			},
-			wantStatus: 201,
+			name:       "LimitTooLarge",
+			query:      "?limit=1000",
+			wantStatus: 400,
 			wantBody: `{
-				"id": "1",
-				"text": "hello",
-				"user_id": "test",
-				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "Limit",
+						"Message": "Key: 'pagination.Limit' Error:Field validation for 'Limit' failed on the 'lte' tag"
+					}
+				]
 			}`,
-			containsLog: "Could not cache message",
 		},
 		{
-			name: "OK",
-			req: `{
-				"text": "hello",
-				"user_id": "test"
-			}`,
-			db: &testdb{
-				insertMessage: func(t *testing.T, msg Message) (Message, error) {
-					if msg.UserID != "test" {
-						t.Errorf("Got UserID %q, want test", msg.UserID)
-					}
-					if msg.Text != "hello" {
-						t.Errorf("Got Text %q, want test", msg.Text)
-					}
-					return Message{
-						ID:        "1",
-						Text:      msg.Text,
-						UserID:    msg.UserID,
-						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-					}, nil
-				},
-			},
-			cache: &testcache{
-				insertMessage: func(t *testing.T, msg Message) error {
-					if msg.UserID != "test" {
-						t.Errorf("Got UserID %q, want test", msg.UserID)
-					}
-					if msg.Text != "hello" {
-						t.Errorf("Got Text %q, want test", msg.Text)
-					}
-					return nil
-				},
-			},
-			wantStatus: 201,
+			name:       "NotANumber",
+			query:      "?page=one",
+			wantStatus: 400,
 			wantBody: `{
-				"id": "1",
-				"text": "hello",
-				"user_id": "test",
-				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "Page",
+						"Message": "Page must be an integer"
+					}
+				]
 			}`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			buf := &bytes.Buffer{}
-			if tt.db != nil {
-				tt.db.T = t
-			}
-			if tt.cache != nil {
-				tt.cache.T = t
-			}
 			api := &API{
-				DB:     tt.db,
-				Cache:  tt.cache,
-				Logger: slog.New(slog.NewTextHandler(buf, nil)),
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						if limit != tt.wantLimit || offset != tt.wantOffset {
+							t.Errorf("Got limit %d and offset %d, want %d and %d", limit, offset, tt.wantLimit, tt.wantOffset)
+						}
+						return nil, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						return nil, nil
+					},
+				},
+				Logger: slogt.New(t),
 				Val:    validator.New(),
 			}
 
 			srv := httptest.NewServer(api)
 			defer srv.Close()
 
-			req, _ := http.NewRequest("POST", srv.URL+"/messages", strings.NewReader(tt.req))
-			resp, err := http.DefaultClient.Do(req)
+			resp, err := http.Get(srv.URL + "/messages" + tt.query)
 			if err != nil {
 				t.Fatal(err)
 			}
 			checkStatus(t, resp.StatusCode, tt.wantStatus)
 			checkBody(t, resp, tt.wantBody)
-			checkLog(t, buf, tt.containsLog)
 		})
 	}
 }
 
-func TestAPI_createReaction(t *testing.T) {
+func TestPaginationParams(t *testing.T) {
 	tests := []struct {
 		name       string
-		db         *testdb
-		messageID  string
-		req        string
-		wantStatus int
-		wantBody   string
+		query      string
+		maxOffset  int
+		wantLimit  int
+		wantOffset int
+		wantErrs   []string
 	}{
 		{
-			name: "OK",
-			req: `{
-				"type": "thumbsup",
-				"user_id": "test"
-			}`,
-			messageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
-			db: &testdb{
-				insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
-					if reaction.UserID != "test" {
-						t.Errorf("Got UserID %q, want test", reaction.UserID)
-					}
-					if reaction.Type != "thumbsup" {
-						t.Errorf("Got Text %q, want test", reaction.Type)
-					}
-					return Reaction{
-						ID:        "1",
-						MessageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
-						Score:     1,
-						Type:      reaction.Type,
-						UserID:    reaction.UserID,
-						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-					}, nil
-				},
-			},
-			wantStatus: 201,
-			wantBody: `{
-				"id": "1",
-				"type": "thumbsup",
-				"score": 1,	
-				"user_id": "test",
-				"created_at": "2024-01-01T00:00:00Z"
-			}`,
+			name:      "Defaults",
+			wantLimit: pageSize,
+		},
+		{
+			name:       "MaxOffset",
+			query:      "?page=11&limit=10",
+			maxOffset:  100,
+			wantLimit:  10,
+			wantOffset: 100,
+		},
+		{
+			name:      "BeyondMaxOffset",
+			query:     "?page=12&limit=10",
+			maxOffset: 100,
+			wantErrs:  []string{"Page"},
+		},
+		{
+			name:     "BeyondDefaultMaxOffset",
+			query:    "?page=100000",
+			wantErrs: []string{"Page"},
+		},
+		{
+			name:       "Explicit",
+			query:      "?page=3&limit=20",
+			wantLimit:  20,
+			wantOffset: 40,
+		},
+		{
+			name:       "Bounds",
+			query:      "?page=1&limit=100",
+			wantLimit:  100,
+			wantOffset: 0,
+		},
+		{
+			name:     "PageZero",
+			query:    "?page=0",
+			wantErrs: []string{"Page"},
+		},
+		{
+			name:     "LimitZero",
+			query:    "?limit=0",
+			wantErrs: []string{"Limit"},
+		},
+		{
+			name:     "LimitTooLarge",
+			query:    "?limit=101",
+			wantErrs: []string{"Limit"},
+		},
+		{
+			name:     "NotNumbers",
+			query:    "?page=one&limit=ten",
+			wantErrs: []string{"Page", "Limit"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.db == nil {
-				tt.db = &testdb{}
-			}
-			tt.db.T = t
-			api := &API{
-				DB:     tt.db,
-				Logger: slogt.New(t),
-				Val:    validator.New(),
-				Cache:  &testcache{},
+			r := httptest.NewRequest("GET", "/messages"+tt.query, nil)
+			api := &API{MaxOffset: tt.maxOffset}
+			limit, offset, err := api.paginationParams(r)
+			if tt.wantErrs == nil {
+				if err != nil {
+					t.Fatalf("Got error %v, want none", err)
+				}
+				if limit != tt.wantLimit || offset != tt.wantOffset {
+					t.Errorf("Got limit %d and offset %d, want %d and %d", limit, offset, tt.wantLimit, tt.wantOffset)
+				}
+				return
 			}
 
-			srv := httptest.NewServer(api)
-			defer srv.Close()
-
-			req, _ := http.NewRequest("POST", srv.URL+"/messages/"+tt.messageID+"/reactions", strings.NewReader(tt.req))
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				t.Fatal(err)
+			var perr *paramError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Got error %v, want a *paramError", err)
+			}
+			var fields []string
+			for _, e := range perr.Errors {
+				fields = append(fields, e.Field)
+			}
+			if !slices.Equal(fields, tt.wantErrs) {
+				t.Errorf("Got errors for %v, want %v", fields, tt.wantErrs)
 			}
-			checkStatus(t, resp.StatusCode, tt.wantStatus)
-			checkBody(t, resp, tt.wantBody)
 		})
 	}
 }
 
-type testdb struct {
-	T              *testing.T
-	listMessages   func(t *testing.T, limit int, offset int, excludeMsgIDs ...string) ([]Message, error)
-	insertMessage  func(t *testing.T, msg Message) (Message, error)
-	insertReaction func(t *testing.T, reaction Reaction) (Reaction, error)
-}
-
-func (db *testdb) ListMessages(_ context.Context, limit int, offset int, excludeMsgIDs ...string) ([]Message, error) {
-	return db.listMessages(db.T, limit, offset, excludeMsgIDs...)
-}
-
-func (db *testdb) InsertMessage(_ context.Context, msg Message) (Message, error) {
-	return db.insertMessage(db.T, msg)
-}
+func TestAPI_listMessages_cursor(t *testing.T) {
+	key := []byte("secret")
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 
-func (db *testdb) InsertReaction(_ context.Context, reaction Reaction) (Reaction, error) {
+	tests := []struct {
+		name       string
+		cursor     string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Valid",
+			cursor:     encodeCursor(key, cursor{Before: before, Page: 2}),
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"messages": []}}`,
+		},
+		{
+			name: "Tampered",
+			cursor: func() string {
+				payload, _, _ := strings.Cut(encodeCursor(key, cursor{Before: before, Page: 200}), ".")
+				_, sig, _ := strings.Cut(encodeCursor(key, cursor{Before: before, Page: 2}), ".")
+				return payload + "." + sig
+			}(),
+			wantStatus: 400,
+			wantBody:   `{"api_version": "1", "error": "Invalid cursor"}`,
+		},
+		{
+			name:       "WrongKey",
+			cursor:     encodeCursor([]byte("other"), cursor{Before: before, Page: 2}),
+			wantStatus: 400,
+			wantBody:   `{"api_version": "1", "error": "Invalid cursor"}`,
+		},
+		{
+			// Following cursors skips no more items than the page parameter
+			// may.
+			name:       "BeyondDefaultMaxOffset",
+			cursor:     encodeCursor(key, cursor{Before: before, Page: 1002}),
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "Page",
+						"Message": "Page must not skip more than 10000 items, list older messages with the before parameter instead"
+					}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, b time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						if !b.Equal(before) || offset != 10 {
+							t.Errorf("Got before %v and offset %d, want %v and 10", b, offset, before)
+						}
+						return nil, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						t.Error("Listed cached messages for page 2")
+						return nil, nil
+					},
+				},
+				Logger:    slogt.New(t),
+				Val:       validator.New(),
+				CursorKey: key,
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages?page=5&cursor=" + tt.cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_listMessages_nextCursorMaxOffset(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return []Message{{ID: "1", Reactions: []Reaction{}}, {ID: "2", Reactions: []Reaction{}}}, nil
+			},
+		},
+		Cache:     &testcache{T: t},
+		Logger:    slogt.New(t),
+		Val:       validator.New(),
+		CursorKey: []byte("secret"),
+		MaxOffset: 4,
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	// Page 2 skips 2 items, the next page 4 items.
+	resp, err := http.Get(srv.URL + "/messages?page=2&limit=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+	if resp.Header.Get("X-Next-Cursor") == "" {
+		t.Error("Got no next cursor on page 2")
+	}
+
+	// The page after page 3 would skip more than MaxOffset items.
+	resp, err = http.Get(srv.URL + "/messages?page=3&limit=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+	if resp.Header.Get("Link") != "" || resp.Header.Get("X-Next-Cursor") != "" {
+		t.Errorf("Got Link %q and X-Next-Cursor %q on the last page within MaxOffset, want none", resp.Header.Get("Link"), resp.Header.Get("X-Next-Cursor"))
+	}
+}
+
+func TestAPI_listMessages_bypassCache(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				if limit != 2 || len(excludeMsgIDs) != 0 {
+					t.Errorf("Got limit %d excluding %v, want the full page of 2", limit, excludeMsgIDs)
+				}
+				return []Message{
+					{ID: "1", Text: "hello", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Reactions: []Reaction{}},
+				}, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				t.Error("Listed cached messages while bypassing the cache")
+				return nil, nil
+			},
+		},
+		Logger: slogt.New(t),
+		Val:    validator.New(),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/messages?limit=2", nil)
+	req.Header.Set("X-Bypass-Cache", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, resp.StatusCode, 200)
+	checkBody(t, resp, `{
+		"api_version": "1",
+		"data": {
+			"messages": [
+				{"id": "1", "text": "hello", "user_id": "test", "created_at": "2024-01-01T00:00:00Z", "pinned": false, "reactions": [], "reaction_count": 0, "reply_count": 0}
+			]
+		}
+	}`)
+}
+
+func TestAPI_listMessages_nextCursor(t *testing.T) {
+	key := []byte("secret")
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return []Message{{ID: "2", Reactions: []Reaction{}}}, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return []Message{{ID: "1", Reactions: []Reaction{}}}, nil
+			},
+		},
+		Logger:    slogt.New(t),
+		Val:       validator.New(),
+		CursorKey: key,
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp, err := http.Get(srv.URL + "/messages?limit=2&before=" + before.Format(time.RFC3339Nano))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+
+	next := resp.Header.Get("X-Next-Cursor")
+	got, err := decodeCursor(key, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Before.Equal(before) || got.Page != 2 {
+		t.Errorf("Got next cursor %+v, want page 2 before %v", got, before)
+	}
+
+	// The body, the Link header and X-Next-Cursor carry the same cursor.
+	var body struct {
+		Data struct {
+			NextCursor string `json:"next_cursor"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Data.NextCursor != next {
+		t.Errorf("Got next_cursor %q, want %q", body.Data.NextCursor, next)
+	}
+	if got, want := resp.Header.Get("Link"), `</messages?cursor=`+next+`&limit=2>; rel="next"`; got != want {
+		t.Errorf("Got Link %q, want %q", got, want)
+	}
+
+	// The last page links to no next page.
+	last, err := http.Get(srv.URL + "/messages?limit=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer last.Body.Close()
+	checkStatus(t, last.StatusCode, 200)
+	if last.Header.Get("Link") != "" || last.Header.Get("X-Next-Cursor") != "" {
+		t.Errorf("Got Link %q and X-Next-Cursor %q on the last page, want none", last.Header.Get("Link"), last.Header.Get("X-Next-Cursor"))
+	}
+	checkBody(t, last, `{
+		"api_version": "1",
+		"data": {
+			"messages": [
+				{"id": "1", "text": "", "user_id": "", "created_at": "0001-01-01T00:00:00Z", "pinned": false, "reactions": [], "reaction_count": 0, "reply_count": 0},
+				{"id": "2", "text": "", "user_id": "", "created_at": "0001-01-01T00:00:00Z", "pinned": false, "reactions": [], "reaction_count": 0, "reply_count": 0}
+			]
+		}
+	}`)
+}
+
+func TestAPI_listMessages_afterID(t *testing.T) {
+	const afterID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		query      string
+		listAfter  func(t *testing.T, afterID string, limit int, reactions ReactionLoad, withHidden bool) ([]Message, error)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:  "OK",
+			query: "?limit=2&after_id=" + afterID,
+			listAfter: func(t *testing.T, id string, limit int, reactions ReactionLoad, withHidden bool) ([]Message, error) {
+				if id != afterID || limit != 2 {
+					t.Errorf("Got after id %q and limit %d, want %q and 2", id, limit, afterID)
+				}
+				return []Message{
+					{ID: "2", Text: "second", UserID: "test", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Reactions: []Reaction{}},
+					{ID: "3", Text: "third", UserID: "test", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), Reactions: []Reaction{}},
+				}, nil
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{"id": "2", "text": "second", "user_id": "test", "created_at": "2024-01-02T00:00:00Z", "pinned": false, "reactions": [], "reaction_count": 0, "reply_count": 0},
+						{"id": "3", "text": "third", "user_id": "test", "created_at": "2024-01-03T00:00:00Z", "pinned": false, "reactions": [], "reaction_count": 0, "reply_count": 0}
+					]
+				}
+			}`,
+		},
+		{
+			name:  "UpToDate",
+			query: "?after_id=" + afterID,
+			listAfter: func(t *testing.T, id string, limit int, reactions ReactionLoad, withHidden bool) ([]Message, error) {
+				return nil, nil
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"messages": []}}`,
+		},
+		{
+			name:       "InvalidID",
+			query:      "?after_id=not-a-uuid",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:  "NotFound",
+			query: "?after_id=" + afterID,
+			listAfter: func(t *testing.T, id string, limit int, reactions ReactionLoad, withHidden bool) ([]Message, error) {
+				return nil, ErrNotFound
+			},
+			wantStatus: 404,
+			wantBody:   `{"api_version": "1", "error": "Message not found"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T:         t,
+					listAfter: tt.listAfter,
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						t.Error("Listed cached messages for a sync")
+						return nil, nil
+					},
+				},
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_listMessagesOnDay(t *testing.T) {
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name        string
+		path        string
+		listBetween func(t *testing.T, from, to time.Time, limit, offset int, reactions ReactionLoad, withHidden bool) ([]Message, error)
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name: "Messages",
+			path: "/messages/day/2024-01-02?page=2&limit=2",
+			listBetween: func(t *testing.T, from, to time.Time, limit, offset int, reactions ReactionLoad, withHidden bool) ([]Message, error) {
+				if !from.Equal(day) || !to.Equal(day.AddDate(0, 0, 1)) {
+					t.Errorf("Got messages from %v to %v, want the day of %v", from, to, day)
+				}
+				if limit != 2 || offset != 2 {
+					t.Errorf("Got limit %d and offset %d, want 2 and 2", limit, offset)
+				}
+				return []Message{
+					{ID: "3", Text: "third", UserID: "test", CreatedAt: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), Reactions: []Reaction{}},
+					{ID: "4", Text: "fourth", UserID: "test", CreatedAt: time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC), Reactions: []Reaction{}},
+				}, nil
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{"id": "3", "text": "third", "user_id": "test", "created_at": "2024-01-02T10:00:00Z", "pinned": false, "reactions": [], "reaction_count": 0, "reply_count": 0},
+						{"id": "4", "text": "fourth", "user_id": "test", "created_at": "2024-01-02T11:00:00Z", "pinned": false, "reactions": [], "reaction_count": 0, "reply_count": 0}
+					]
+				}
+			}`,
+		},
+		{
+			name: "Empty",
+			path: "/messages/day/2024-01-02",
+			listBetween: func(t *testing.T, from, to time.Time, limit, offset int, reactions ReactionLoad, withHidden bool) ([]Message, error) {
+				return nil, nil
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"messages": []}}`,
+		},
+		{
+			name:       "InvalidDate",
+			path:       "/messages/day/2024-13-01",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [{"Field": "date", "Message": "date must be a date formatted as YYYY-MM-DD"}]
+			}`,
+		},
+		{
+			name:       "UnknownPath",
+			path:       "/messages/84bd9af7-79e6-4027-b284-9d5d875efd5b/unknown",
+			wantStatus: 404,
+			wantBody:   `{"api_version": "1", "error": "Not found"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T:           t,
+					listBetween: tt.listBetween,
+				},
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_listMessages_stream(t *testing.T) {
+	all := make([]Message, 250)
+	for i := range all {
+		all[i] = Message{ID: strconv.Itoa(i), Text: "hello", UserID: "test", Reactions: []Reaction{}}
+	}
+	var offsets []int
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				offsets = append(offsets, offset)
+				return all[offset:min(offset+limit, len(all))], nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				t.Error("Listed cached messages for a stream")
+				return nil, nil
+			},
+		},
+		Logger: slogt.New(t),
+		Val:    validator.New(),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/messages?stream=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+	if !slices.Contains(resp.TransferEncoding, "chunked") {
+		t.Errorf("Got transfer encoding %v, want chunked", resp.TransferEncoding)
+	}
+
+	var body struct {
+		APIVersion string `json:"api_version"`
+		Data       struct {
+			Messages []Message `json:"messages"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.APIVersion != APIVersion {
+		t.Errorf("Got API version %q, want %q", body.APIVersion, APIVersion)
+	}
+	if len(body.Data.Messages) != len(all) {
+		t.Fatalf("Got %d messages, want %d", len(body.Data.Messages), len(all))
+	}
+	for i, msg := range body.Data.Messages {
+		if msg.ID != all[i].ID {
+			t.Fatalf("Got message %s at %d, want %s", msg.ID, i, all[i].ID)
+		}
+	}
+	if want := []int{0, 100, 200}; !slices.Equal(offsets, want) {
+		t.Errorf("Got pages at offsets %v, want %v", offsets, want)
+	}
+}
+
+func TestAPI_listMessages_hidden(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       string
+		wantHidden bool
+		wantBody   string
+	}{
+		{
+			name:       "User",
+			wantHidden: false,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{"id": "1", "text": "visible", "user_id": "test", "created_at": "2024-01-01T00:00:00Z", "pinned": false, "reactions": [], "reaction_count": 0, "reply_count": 0}
+					]
+				}
+			}`,
+		},
+		{
+			name:       "Moderator",
+			auth:       "Bearer mod",
+			wantHidden: true,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{"id": "1", "text": "visible", "user_id": "test", "created_at": "2024-01-01T00:00:00Z", "pinned": false, "reactions": [], "reaction_count": 0, "reply_count": 0},
+						{"id": "2", "text": "offensive", "user_id": "test", "created_at": "2024-01-01T00:00:00Z", "pinned": false, "hidden": true, "reactions": [], "reaction_count": 0, "reply_count": 0}
+					]
+				}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						if withHidden != tt.wantHidden {
+							t.Errorf("Got withHidden %t, want %t", withHidden, tt.wantHidden)
+						}
+						return nil, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+						return []Message{
+							{ID: "1", Text: "visible", UserID: "test", CreatedAt: createdAt, Reactions: []Reaction{}},
+							{ID: "2", Text: "offensive", UserID: "test", CreatedAt: createdAt, Hidden: true, Reactions: []Reaction{}},
+						}, nil
+					},
+				},
+				Logger:         slogt.New(t),
+				Val:            validator.New(),
+				ModeratorToken: "mod",
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("GET", srv.URL+"/messages", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, 200)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_listMessages_order(t *testing.T) {
+	cached := Message{ID: "cached", Reactions: []Reaction{}}
+	stored := Message{ID: "stored", Reactions: []Reaction{}}
+
+	tests := []struct {
+		name       string
+		query      string
+		total      int
+		wantStatus int
+		wantOrder  Order
+		wantIDs    []string
+	}{
+		{
+			name:       "Default",
+			total:      10,
+			wantStatus: 200,
+			wantOrder:  OrderDesc,
+			wantIDs:    []string{"cached", "stored"},
+		},
+		{
+			name:       "Desc",
+			query:      "?order=desc",
+			total:      10,
+			wantStatus: 200,
+			wantOrder:  OrderDesc,
+			wantIDs:    []string{"cached", "stored"},
+		},
+		{
+			// The cache only holds the latest messages, so the oldest
+			// messages come from the DB.
+			name:       "Asc",
+			query:      "?order=asc",
+			total:      10,
+			wantStatus: 200,
+			wantOrder:  OrderAsc,
+			wantIDs:    []string{"stored"},
+		},
+		{
+			name:       "AscAllCached",
+			query:      "?order=asc",
+			total:      1,
+			wantStatus: 200,
+			wantOrder:  OrderAsc,
+			wantIDs:    []string{"cached"},
+		},
+		{
+			name:       "Invalid",
+			query:      "?order=sideways",
+			wantStatus: 400,
+		},
+		{
+			name:       "Injection",
+			query:      "?order=created_at%3B%20DROP%20TABLE%20messages",
+			wantStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						if order != tt.wantOrder {
+							t.Errorf("Got DB order %q, want %q", order, tt.wantOrder)
+						}
+						if tt.total == 1 {
+							// The cached message is the only one.
+							return nil, nil
+						}
+						return []Message{stored}, nil
+					},
+					countMessages: func(t *testing.T) (int, error) {
+						return tt.total, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						if order != tt.wantOrder {
+							t.Errorf("Got cache order %q, want %q", order, tt.wantOrder)
+						}
+						return []Message{cached}, nil
+					},
+				},
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantStatus != 200 {
+				return
+			}
+
+			var body struct {
+				Data struct {
+					Messages []Message `json:"messages"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, m := range body.Data.Messages {
+				ids = append(ids, m.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("Got messages %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestAPI_listMessages_withReactions(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  ReactionLoad
+	}{
+		{name: "Default", query: "", want: ReactionsCounted},
+		{name: "ExpandReactions", query: "?expand=reactions", want: ReactionsLoaded},
+		{name: "ExpandReactionUsers", query: "?expand=reaction_users", want: ReactionsLoaded},
+		{name: "ExpandOther", query: "?expand=other", want: ReactionsCounted},
+		{name: "Fields", query: "?fields=id,created_at", want: ReactionsOmitted},
+		{name: "FieldsReactionCount", query: "?fields=id,reaction_count", want: ReactionsCounted},
+		{name: "FieldsWithoutReactions", query: "?expand=reactions&fields=id,reaction_count", want: ReactionsCounted},
+		{name: "FieldsWithReactions", query: "?expand=reactions&fields=id,reactions", want: ReactionsLoaded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						if reactions != tt.want {
+							t.Errorf("Got DB reactions %d, want %d", reactions, tt.want)
+						}
+						return nil, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						if want := tt.want == ReactionsLoaded; withReactions != want {
+							t.Errorf("Got cache withReactions %t, want %t", withReactions, want)
+						}
+						return nil, nil
+					},
+				},
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			checkStatus(t, resp.StatusCode, 200)
+		})
+	}
+}
+
+func TestAPI_listMessages_fields(t *testing.T) {
+	msg := Message{
+		ID:            "1",
+		Text:          "hello",
+		UserID:        "test",
+		CreatedAt:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		ReactionCount: 2,
+	}
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "IDs",
+			query:      "?fields=created_at,id",
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {"messages": [{"id": "1", "created_at": "2024-01-01T00:00:00Z"}]}
+			}`,
+		},
+		{
+			name:       "ReactionCount",
+			query:      "?fields=id,reaction_count",
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {"messages": [{"id": "1", "reaction_count": 2}]}
+			}`,
+		},
+		{
+			name:       "UnknownField",
+			query:      "?fields=id,secret",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [{"Field": "fields", "Message": "fields contains unknown field \"secret\""}]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						return []Message{msg}, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						return nil, nil
+					},
+				},
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_listMessages_cancel(t *testing.T) {
+	tests := []struct {
+		name       string
+		db         DB
+		cache      Cache
+		ctx        func() (context.Context, context.CancelFunc)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:  "CacheCanceled",
+			db:    &testdb{},
+			cache: blockingCache{&testcache{}},
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantStatus: 499,
+			wantBody:   `{"api_version": "1", "error": "Client closed request"}`,
+		},
+		{
+			name: "DBCanceled",
+			db:   blockingDB{&testdb{}},
+			cache: &testcache{
+				listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+					return nil, nil
+				},
+			},
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantStatus: 499,
+			wantBody:   `{"api_version": "1", "error": "Client closed request"}`,
+		},
+		{
+			name:  "DeadlineExceeded",
+			db:    &testdb{},
+			cache: blockingCache{&testcache{}},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			wantStatus: 504,
+			wantBody:   `{"api_version": "1", "error": "Request timed out"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			req := httptest.NewRequest(http.MethodGet, "/messages", nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				api.ServeHTTP(rec, req)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Handler did not return after the request context was done")
+			}
+
+			resp := rec.Result()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_listMessages_before(t *testing.T) {
+	before := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBefore time.Time
+	}{
+		{
+			name:       "Explicit",
+			query:      "?before=2024-01-01T12:00:00Z",
+			wantStatus: 200,
+			wantBefore: before,
+		},
+		{
+			name:       "Invalid",
+			query:      "?before=yesterday",
+			wantStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cacheBefore, dbBefore time.Time
+			api := &API{
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						dbBefore = before
+						if limit != 9 {
+							t.Errorf("Got DB limit %d, want 9", limit)
+						}
+						return nil, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						cacheBefore = before
+						if limit != 10 {
+							t.Errorf("Got cache limit %d, want 10", limit)
+						}
+						return []Message{{ID: "1"}}, nil
+					},
+				},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if !cacheBefore.Equal(tt.wantBefore) || !dbBefore.Equal(tt.wantBefore) {
+				t.Errorf("Got bounds cache=%v db=%v, want %v for both", cacheBefore, dbBefore, tt.wantBefore)
+			}
+		})
+	}
+}
+
+func TestAPI_listMessages_fullCachePage(t *testing.T) {
+	api := &API{
+		DB: &testdb{T: t},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				msgs := make([]Message, limit)
+				for i := range msgs {
+					msgs[i] = Message{ID: strconv.Itoa(i), Reactions: []Reaction{}}
+				}
+				return msgs, nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	// The DB fake has no listMessages, so calling it would panic.
+	resp, err := http.Get(srv.URL + "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+}
+
+func TestAPI_listMessages_ifModifiedSince(t *testing.T) {
+	latest := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name            string
+		ifModifiedSince string
+		wantStatus      int
+	}{
+		{
+			name:            "NotModified",
+			ifModifiedSince: latest.Format(http.TimeFormat),
+			wantStatus:      304,
+		},
+		{
+			name:            "Modified",
+			ifModifiedSince: latest.Add(-time.Second).Format(http.TimeFormat),
+			wantStatus:      200,
+		},
+		{
+			name:            "InvalidHeader",
+			ifModifiedSince: "yesterday",
+			wantStatus:      200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					latestMsgTime: func(t *testing.T) (time.Time, error) {
+						return latest.Add(500 * time.Millisecond), nil
+					},
+					listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						return nil, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						return nil, nil
+					},
+				},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("GET", srv.URL+"/messages", nil)
+			req.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if got, want := resp.Header.Get("Last-Modified"), latest.Format(http.TimeFormat); got != want {
+				t.Errorf("Got Last-Modified %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAPI_listMessages_head(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return []Message{{ID: "1", Text: "hello", UserID: "test"}}, nil
+			},
+			latestMsgTime: func(t *testing.T) (time.Time, error) {
+				return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), nil
+			},
+			countMessages: func(t *testing.T) (int, error) {
+				return 1, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	get, err := http.Get(srv.URL + "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	getBody, err := io.ReadAll(get.Body)
+	get.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Head(srv.URL + "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 0 {
+		t.Errorf("Got body %q, want none", body)
+	}
+	if got, want := resp.ContentLength, int64(len(getBody)); got != want {
+		t.Errorf("Got Content-Length %d, want %d", got, want)
+	}
+	for _, h := range []string{"Content-Type", "Last-Modified", "X-Total-Count"} {
+		if got, want := resp.Header.Get(h), get.Header.Get(h); got != want || got == "" {
+			t.Errorf("Got %s %q, want %q", h, got, want)
+		}
+	}
+}
+
+func TestAPI_listMessages_totalCount(t *testing.T) {
+	var (
+		counts int
+		cached *int
+	)
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return nil, nil
+			},
+			countMessages: func(t *testing.T) (int, error) {
+				counts++
+				return 42, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+			getCount: func(t *testing.T) (int, error) {
+				if cached == nil {
+					return 0, ErrNotFound
+				}
+				return *cached, nil
+			},
+			setCount: func(t *testing.T, n int, ttl time.Duration) error {
+				if ttl != 5*time.Second {
+					t.Errorf("Got TTL %s, want 5s", ttl)
+				}
+				cached = &n
+				return nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	for range 3 {
+		resp, err := http.Get(srv.URL + "/messages")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkStatus(t, resp.StatusCode, 200)
+		if got := resp.Header.Get("X-Total-Count"); got != "42" {
+			t.Errorf("Got X-Total-Count %q, want 42", got)
+		}
+	}
+	if counts != 1 {
+		t.Errorf("Counted messages %d times, want 1", counts)
+	}
+}
+
+func TestAPI_listMessages_pinned(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return []Message{
+					{ID: "2", Text: "Pinned", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Pinned: true, Reactions: []Reaction{}},
+				}, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return []Message{
+					{ID: "1", Text: "Latest", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Reactions: []Reaction{}},
+				}, nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, resp.StatusCode, 200)
+	checkBody(t, resp, `{
+		"api_version": "1",
+		"data": {
+			"messages": [
+				{
+					"id": "2",
+					"text": "Pinned",
+					"user_id": "",
+					"created_at": "2024-01-01T00:00:00Z",
+					"pinned": true,
+					"reactions": [],
+					"reaction_count": 0,
+					"reply_count": 0
+				},
+				{
+					"id": "1",
+					"text": "Latest",
+					"user_id": "",
+					"created_at": "2024-01-02T00:00:00Z",
+					"pinned": false,
+					"reactions": [],
+					"reaction_count": 0,
+					"reply_count": 0
+				}
+			]
+		}
+	}`)
+}
+
+func TestAPI_listMessages_expandReactionUsers(t *testing.T) {
+	reaction := func(typ, userID string) Reaction {
+		return Reaction{ID: typ + userID, Type: typ, Score: 1, UserID: userID, CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "Default",
+			query: "",
+			want:  `null`,
+		},
+		{
+			name:  "Expanded",
+			query: "?expand=reaction_users",
+			want: `{
+				"like": ["alice", "bob"],
+				"love": ["alice"]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						return []Message{
+							{
+								ID: "1",
+								Reactions: []Reaction{
+									reaction("like", "alice"),
+									reaction("like", "bob"),
+									reaction("love", "alice"),
+									reaction("like", "alice"),
+								},
+								ReactionCount: 4,
+							},
+						}, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						return nil, nil
+					},
+				},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, 200)
+
+			var body struct {
+				Data struct {
+					Messages []struct {
+						ReactionUsers json.RawMessage `json:"reaction_users"`
+					} `json:"messages"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Data.Messages) != 1 {
+				t.Fatalf("Got %d messages, want 1", len(body.Data.Messages))
+			}
+			got := string(body.Data.Messages[0].ReactionUsers)
+			if got == "" {
+				got = "null"
+			}
+			if normalizeJSON(t, strings.NewReader(got)) != normalizeJSON(t, strings.NewReader(tt.want)) {
+				t.Errorf("Got reaction_users %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPI_pinMessage(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		method     string
+		messageID  string
+		auth       string
+		db         *testdb
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Anonymous",
+			method:     "POST",
+			messageID:  messageID,
+			wantStatus: 401,
+			wantBody:   `{"api_version": "1", "error": "Unauthorized"}`,
+		},
+		{
+			name:       "AnonymousUnpin",
+			method:     "DELETE",
+			messageID:  messageID,
+			wantStatus: 401,
+			wantBody:   `{"api_version": "1", "error": "Unauthorized"}`,
+		},
+		{
+			name:       "User",
+			method:     "POST",
+			messageID:  messageID,
+			auth:       "Bearer alice-token",
+			wantStatus: 403,
+			wantBody:   `{"api_version": "1", "error": "Forbidden"}`,
+		},
+		{
+			name:       "UserUnpin",
+			method:     "DELETE",
+			messageID:  messageID,
+			auth:       "Bearer alice-token",
+			wantStatus: 403,
+			wantBody:   `{"api_version": "1", "error": "Forbidden"}`,
+		},
+		{
+			name:       "InvalidID",
+			method:     "POST",
+			messageID:  "not-a-uuid",
+			auth:       "Bearer mod",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:      "NotFound",
+			method:    "POST",
+			messageID: messageID,
+			auth:      "Bearer mod",
+			db: &testdb{
+				setPinned: func(t *testing.T, id string, pinned bool) (Message, error) {
+					return Message{}, ErrNotFound
+				},
+			},
+			wantStatus: 404,
+			wantBody:   `{"api_version": "1", "error": "Message not found"}`,
+		},
+		{
+			name:      "Pin",
+			method:    "POST",
+			messageID: messageID,
+			auth:      "Bearer mod",
+			db: &testdb{
+				setPinned: func(t *testing.T, id string, pinned bool) (Message, error) {
+					if id != messageID || !pinned {
+						t.Errorf("Got SetMessagePinned(%q, %t), want (%q, true)", id, pinned, messageID)
+					}
+					return Message{
+						ID:        id,
+						Text:      "hello",
+						UserID:    "test",
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						Pinned:    pinned,
+						Reactions: []Reaction{},
+					}, nil
+				},
+			},
+			cache: &testcache{
+				setPinned: func(t *testing.T, msg Message) error {
+					if !msg.Pinned {
+						t.Error("Cached message is not pinned")
+					}
+					return nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z",
+					"pinned": true,
+					"reactions": [],
+					"reaction_count": 0,
+					"reply_count": 0
+				}
+			}`,
+		},
+		{
+			name:      "Unpin",
+			method:    "DELETE",
+			messageID: messageID,
+			auth:      "Bearer mod",
+			db: &testdb{
+				setPinned: func(t *testing.T, id string, pinned bool) (Message, error) {
+					if pinned {
+						t.Error("Got pinned true, want false")
+					}
+					return Message{
+						ID:        id,
+						Text:      "hello",
+						UserID:    "test",
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						Reactions: []Reaction{},
+					}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z",
+					"pinned": false,
+					"reactions": [],
+					"reaction_count": 0,
+					"reply_count": 0
+				}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.db.T = t
+			tt.cache.T = t
+			api := &API{
+				DB:             tt.db,
+				Cache:          tt.cache,
+				Logger:         slogt.New(t),
+				Val:            validator.New(),
+				ModeratorToken: "mod",
+				UserTokens:     map[string]string{"alice-token": "alice"},
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest(tt.method, srv.URL+"/messages/"+tt.messageID+"/pin", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_hideMessage(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		method     string
+		auth       string
+		db         *testdb
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Unauthorized",
+			method:     "POST",
+			wantStatus: 401,
+			wantBody:   `{"api_version": "1", "error": "Unauthorized"}`,
+		},
+		{
+			name:       "InvalidToken",
+			method:     "POST",
+			auth:       "Bearer wrong",
+			wantStatus: 401,
+			wantBody:   `{"api_version": "1", "error": "Unauthorized"}`,
+		},
+		{
+			name:       "NotModerator",
+			method:     "POST",
+			auth:       "Bearer alice-token",
+			wantStatus: 403,
+			wantBody:   `{"api_version": "1", "error": "Forbidden"}`,
+		},
+		{
+			name:   "NotFound",
+			method: "POST",
+			auth:   "Bearer mod",
+			db: &testdb{
+				setHidden: func(t *testing.T, id string, hidden bool) (Message, error) {
+					return Message{}, ErrNotFound
+				},
+			},
+			wantStatus: 404,
+			wantBody:   `{"api_version": "1", "error": "Message not found"}`,
+		},
+		{
+			name:   "Hide",
+			method: "POST",
+			auth:   "Bearer mod",
+			db: &testdb{
+				setHidden: func(t *testing.T, id string, hidden bool) (Message, error) {
+					if id != messageID || !hidden {
+						t.Errorf("Got SetMessageHidden(%q, %t), want (%q, true)", id, hidden, messageID)
+					}
+					return Message{
+						ID:        id,
+						Text:      "hello",
+						UserID:    "test",
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						Hidden:    hidden,
+						Reactions: []Reaction{},
+					}, nil
+				},
+			},
+			cache: &testcache{
+				setHidden: func(t *testing.T, id string, hidden bool) error {
+					if id != messageID || !hidden {
+						t.Errorf("Got cached SetMessageHidden(%q, %t), want (%q, true)", id, hidden, messageID)
+					}
+					return nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z",
+					"pinned": false,
+					"hidden": true,
+					"reactions": [],
+					"reaction_count": 0,
+					"reply_count": 0
+				}
+			}`,
+		},
+		{
+			name:   "Unhide",
+			method: "DELETE",
+			auth:   "Bearer mod",
+			db: &testdb{
+				setHidden: func(t *testing.T, id string, hidden bool) (Message, error) {
+					if hidden {
+						t.Error("Got hidden true, want false")
+					}
+					return Message{
+						ID:        id,
+						Text:      "hello",
+						UserID:    "test",
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						Reactions: []Reaction{},
+					}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z",
+					"pinned": false,
+					"reactions": [],
+					"reaction_count": 0,
+					"reply_count": 0
+				}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.db.T = t
+			tt.cache.T = t
+			api := &API{
+				DB:             tt.db,
+				Cache:          tt.cache,
+				Logger:         slogt.New(t),
+				Val:            validator.New(),
+				ModeratorToken: "mod",
+				UserTokens:     map[string]string{"alice-token": "alice"},
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest(tt.method, srv.URL+"/messages/"+messageID+"/hide", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_updateMessage(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	owned := func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+		return Message{ID: id, Text: "hello", UserID: "alice", CreatedAt: createdAt}, nil
+	}
+
+	tests := []struct {
+		name        string
+		auth        string
+		req         string
+		getMessage  func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error)
+		wantStatus  int
+		wantBody    string
+		wantUpdated bool
+	}{
+		{
+			name:        "Author",
+			auth:        "Bearer alice-token",
+			req:         `{"text": "edited"}`,
+			getMessage:  owned,
+			wantStatus:  200,
+			wantUpdated: true,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+					"text": "edited",
+					"user_id": "alice",
+					"created_at": "2024-01-01T00:00:00Z",
+					"pinned": false,
+					"reactions": [],
+					"reaction_count": 0,
+					"reply_count": 0
+				}
+			}`,
+		},
+		{
+			name:       "NotAuthor",
+			auth:       "Bearer bob-token",
+			req:        `{"text": "edited"}`,
+			getMessage: owned,
+			wantStatus: 403,
+			wantBody:   `{"api_version": "1", "error": "Message belongs to another user"}`,
+		},
+		{
+			// Moderators may edit every message without it being loaded.
+			name:        "Moderator",
+			auth:        "Bearer mod",
+			req:         `{"text": "edited"}`,
+			wantStatus:  200,
+			wantUpdated: true,
+		},
+		{
+			name:       "Anonymous",
+			req:        `{"text": "edited"}`,
+			wantStatus: 401,
+			wantBody:   `{"api_version": "1", "error": "Unauthorized"}`,
+		},
+		{
+			name: "NotFound",
+			auth: "Bearer alice-token",
+			req:  `{"text": "edited"}`,
+			getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+				return Message{}, ErrNotFound
+			},
+			wantStatus: 404,
+			wantBody:   `{"api_version": "1", "error": "Message not found"}`,
+		},
+		{
+			name:       "BlankText",
+			auth:       "Bearer alice-token",
+			req:        `{"text": "  "}`,
+			wantStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dbUpdated, cacheUpdated bool
+			api := &API{
+				DB: &testdb{
+					T:          t,
+					getMessage: tt.getMessage,
+					updateText: func(t *testing.T, id, text string) (Message, error) {
+						if id != messageID || text != "edited" {
+							t.Errorf("Got UpdateMessageText(%q, %q), want (%q, edited)", id, text, messageID)
+						}
+						dbUpdated = true
+						return Message{ID: id, Text: text, UserID: "alice", CreatedAt: createdAt, Reactions: []Reaction{}}, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					setText: func(t *testing.T, id, text string) error {
+						cacheUpdated = true
+						return nil
+					},
+				},
+				Logger:         slogt.New(t),
+				Val:            validator.New(),
+				ModeratorToken: "mod",
+				UserTokens:     map[string]string{"alice-token": "alice", "bob-token": "bob"},
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("PATCH", srv.URL+"/messages/"+messageID, strings.NewReader(tt.req))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+			if dbUpdated != tt.wantUpdated || cacheUpdated != tt.wantUpdated {
+				t.Errorf("Got updated in DB %t and cache %t, want %t", dbUpdated, cacheUpdated, tt.wantUpdated)
+			}
+		})
+	}
+}
+
+func TestAPI_deleteMessage(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	owned := func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+		return Message{ID: id, Text: "hello", UserID: "alice"}, nil
+	}
+
+	tests := []struct {
+		name        string
+		auth        string
+		getMessage  func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error)
+		wantStatus  int
+		wantBody    string
+		wantDeleted bool
+	}{
+		{
+			name:        "Author",
+			auth:        "Bearer alice-token",
+			getMessage:  owned,
+			wantStatus:  204,
+			wantDeleted: true,
+		},
+		{
+			name:       "NotAuthor",
+			auth:       "Bearer bob-token",
+			getMessage: owned,
+			wantStatus: 403,
+			wantBody:   `{"api_version": "1", "error": "Message belongs to another user"}`,
+		},
+		{
+			name:        "Moderator",
+			auth:        "Bearer mod",
+			wantStatus:  204,
+			wantDeleted: true,
+		},
+		{
+			name:       "Anonymous",
+			wantStatus: 401,
+			wantBody:   `{"api_version": "1", "error": "Unauthorized"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dbDeleted, cacheDeleted bool
+			api := &API{
+				DB: &testdb{
+					T:          t,
+					getMessage: tt.getMessage,
+					deleteMessage: func(t *testing.T, id string) error {
+						if id != messageID {
+							t.Errorf("Deleted %q, want %q", id, messageID)
+						}
+						dbDeleted = true
+						return nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					deleteMessage: func(t *testing.T, id string) error {
+						cacheDeleted = true
+						return nil
+					},
+				},
+				Logger:         slogt.New(t),
+				Val:            validator.New(),
+				ModeratorToken: "mod",
+				UserTokens:     map[string]string{"alice-token": "alice", "bob-token": "bob"},
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("DELETE", srv.URL+"/messages/"+messageID, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+			if dbDeleted != tt.wantDeleted || cacheDeleted != tt.wantDeleted {
+				t.Errorf("Got deleted from DB %t and cache %t, want %t", dbDeleted, cacheDeleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestAPI_reactionsNeverNull(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	// The storage layers return messages without reaction lists.
+	msg := Message{ID: messageID, Text: "hello", UserID: "test"}
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, m Message) (Message, error) {
+				return msg, nil
+			},
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return []Message{msg}, nil
+			},
+			getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+				return msg, nil
+			},
+			getThread: func(t *testing.T, id string, maxDepth, limit int, withHidden bool) ([]ThreadMessage, error) {
+				return []ThreadMessage{{Message: msg}}, nil
+			},
+			setPinned: func(t *testing.T, id string, pinned bool) (Message, error) {
+				return msg, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, m Message) error {
+				return nil
+			},
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Logger:         slogt.New(t),
+		Val:            validator.New(),
+		ModeratorToken: "mod",
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/messages", `{"text": "hello", "user_id": "test"}`},
+		{"GET", "/messages", ""},
+		{"GET", "/messages?stream=true", ""},
+		{"GET", "/messages/" + messageID, ""},
+		{"GET", "/messages/" + messageID + "/thread", ""},
+		{"POST", "/messages/" + messageID + "/pin", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+			// Pinning is reserved to moderators.
+			req.Header.Set("Authorization", "Bearer mod")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			var body struct {
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			var msgs []map[string]json.RawMessage
+			var list struct {
+				Messages []map[string]json.RawMessage `json:"messages"`
+			}
+			if err := json.Unmarshal(body.Data, &list); err == nil && list.Messages != nil {
+				msgs = list.Messages
+			} else {
+				var single map[string]json.RawMessage
+				if err := json.Unmarshal(body.Data, &single); err != nil {
+					t.Fatal(err)
+				}
+				msgs = append(msgs, single)
+			}
+			for _, m := range msgs {
+				if got := string(m["reactions"]); got != "[]" {
+					t.Errorf("Got reactions %s, want []", got)
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_getThread(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		messageID  string
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidID",
+			messageID:  "not-a-uuid",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:      "NotFound",
+			messageID: messageID,
+			db: &testdb{
+				getThread: func(t *testing.T, id string, maxDepth, limit int, withHidden bool) ([]ThreadMessage, error) {
+					return nil, ErrNotFound
+				},
+			},
+			wantStatus: 404,
+			wantBody:   `{"api_version": "1", "error": "Message not found"}`,
+		},
+		{
+			name:      "Error",
+			messageID: messageID,
+			db: &testdb{
+				getThread: func(t *testing.T, id string, maxDepth, limit int, withHidden bool) ([]ThreadMessage, error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody:   `{"api_version": "1", "error": "Could not get thread"}`,
+		},
+		{
+			name:      "TwoLevels",
+			messageID: messageID,
+			db: &testdb{
+				getThread: func(t *testing.T, id string, maxDepth, limit int, withHidden bool) ([]ThreadMessage, error) {
+					if id != messageID {
+						t.Errorf("Got message ID %q, want %q", id, messageID)
+					}
+					if maxDepth != maxThreadDepth || limit != maxThreadSize {
+						t.Errorf("Got maxDepth %d and limit %d, want %d and %d", maxDepth, limit, maxThreadDepth, maxThreadSize)
+					}
+					createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return []ThreadMessage{
+						{Message: Message{ID: messageID, Text: "root", UserID: "a", CreatedAt: createdAt, ReplyCount: 1}},
+						{Message: Message{ID: "2", Text: "reply", UserID: "b", ParentID: messageID, CreatedAt: createdAt, ReplyCount: 1}, Depth: 1},
+						{Message: Message{ID: "3", Text: "nested", UserID: "a", ParentID: "2", CreatedAt: createdAt}, Depth: 2},
+					}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{
+							"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+							"text": "root",
+							"user_id": "a",
+							"created_at": "2024-01-01T00:00:00Z",
+							"pinned": false,
+							"reactions": [],
+							"reaction_count": 0,
+							"reply_count": 1,
+							"depth": 0
+						},
+						{
+							"id": "2",
+							"text": "reply",
+							"user_id": "b",
+							"parent_id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+							"created_at": "2024-01-01T00:00:00Z",
+							"pinned": false,
+							"reactions": [],
+							"reaction_count": 0,
+							"reply_count": 1,
+							"depth": 1
+						},
+						{
+							"id": "3",
+							"text": "nested",
+							"user_id": "a",
+							"parent_id": "2",
+							"created_at": "2024-01-01T00:00:00Z",
+							"pinned": false,
+							"reactions": [],
+							"reaction_count": 0,
+							"reply_count": 0,
+							"depth": 2
+						}
+					]
+				}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages/" + tt.messageID + "/thread")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_getThread_hidden(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	api := &API{
+		DB: &testdb{
+			T: t,
+			getThread: func(t *testing.T, id string, maxDepth, limit int, withHidden bool) ([]ThreadMessage, error) {
+				msgs := []ThreadMessage{{Message: Message{ID: messageID, Text: "root", UserID: "a"}}}
+				if withHidden {
+					msgs = append(msgs, ThreadMessage{Message: Message{ID: "2", Text: "secret", UserID: "b", ParentID: messageID, Hidden: true}, Depth: 1})
+				}
+				return msgs, nil
+			},
+		},
+		Cache:          &testcache{T: t},
+		Logger:         slogt.New(t),
+		ModeratorToken: "mod",
+		UserTokens:     map[string]string{"alice-token": "alice"},
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		auth       string
+		wantHidden bool
+	}{
+		{auth: "", wantHidden: false},
+		{auth: "Bearer alice-token", wantHidden: false},
+		{auth: "Bearer mod", wantHidden: true},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+"/messages/"+messageID+"/thread", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkStatus(t, resp.StatusCode, 200)
+		if got := strings.Contains(string(body), "secret"); got != tt.wantHidden {
+			t.Errorf("Auth %q: got the hidden reply %t, want %t", tt.auth, got, tt.wantHidden)
+		}
+	}
+}
+
+func TestAPI_createMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		cache       *testcache
+		db          *testdb
+		req         string
+		wantStatus  int
+		wantBody    string
+		containsLog string
+	}{
+		{
+			name:       "InvalidJSON",
+			req:        `not json`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Request body contains malformed JSON at position 2"
+			}`,
+		},
+		{
+			name: "InvalidUserID",
+			req: `{
+				"text": "hello",
+				"user_id": "not a valid id!"
+			}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "UserID",
+						"Message": "Key: 'request.UserID' Error:Field validation for 'UserID' failed on the 'user_id' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name: "InvalidAttachmentURL",
+			req: `{
+				"text": "hello",
+				"user_id": "test",
+				"attachments": [{"url": "not a url", "type": "image/png"}]
+			}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "URL",
+						"Message": "Key: 'request.Attachments[0].URL' Error:Field validation for 'URL' failed on the 'http_url' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name: "Attachments",
+			req: `{
+				"text": "hello",
+				"user_id": "test",
+				"attachments": [{"url": "https://example.com/cat.png", "type": "image/png", "name": "cat.png", "size": 1024}]
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					want := []Attachment{{URL: "https://example.com/cat.png", Type: "image/png", Name: "cat.png", Size: 1024}}
+					if len(msg.Attachments) != 1 || msg.Attachments[0] != want[0] {
+						t.Errorf("Got Attachments %+v, want %+v", msg.Attachments, want)
+					}
+					msg.ID = "1"
+					msg.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return msg, nil
+				},
+			},
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					if len(msg.Attachments) != 1 {
+						t.Errorf("Got %d cached attachments, want 1", len(msg.Attachments))
+					}
+					return nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+					"attachments": [
+						{
+							"url": "https://example.com/cat.png",
+							"type": "image/png",
+							"name": "cat.png",
+							"size": 1024
+						}
+					],
+					"reactions": []
+				}
+			}`,
+		},
+		{
+			name: "CreatedAtFromDB",
+			req: `{
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					if msg.CreatedAt.IsZero() {
+						t.Error("Got no CreatedAt, want the current time")
+					}
+					msg.ID = "1"
+					msg.CreatedAt = time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+					return msg, nil
+				},
+			},
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					want := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+					if !msg.CreatedAt.Equal(want) {
+						t.Errorf("Got cached CreatedAt %v, want %v", msg.CreatedAt, want)
+					}
+					return nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "Mon, 03 Feb 2020 04:05:06 UTC",
+					"reactions": []
+				}
+			}`,
+		},
+		{
+			name: "InvalidID",
+			req: `{
+				"id": "not-a-uuid",
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "ID",
+						"Message": "Key: 'request.ID' Error:Field validation for 'ID' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name: "ClientID",
+			req: `{
+				"id": "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a",
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					if msg.ID != "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a" {
+						t.Errorf("Got ID %q, want the client's", msg.ID)
+					}
+					msg.CreatedAt = time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+					return msg, nil
+				},
+			},
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					return nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "Mon, 03 Feb 2020 04:05:06 UTC",
+					"reactions": []
+				}
+			}`,
+		},
+		{
+			name: "RepeatedClientID",
+			req: `{
+				"id": "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a",
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{}, ErrDuplicateMessage
+				},
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					return Message{
+						ID:        id,
+						Text:      "hello",
+						UserID:    "test",
+						CreatedAt: time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC),
+					}, nil
+				},
+			},
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					t.Error("Cached a repeated message")
+					return nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "Mon, 03 Feb 2020 04:05:06 UTC",
+					"reactions": []
+				}
+			}`,
+		},
+		{
+			name: "ConflictingClientID",
+			req: `{
+				"id": "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a",
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{}, ErrDuplicateMessage
+				},
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					return Message{ID: id, Text: "something else", UserID: "other"}, nil
+				},
+			},
+			wantStatus: 409,
+			wantBody:   `{"api_version": "1", "error": "Message ID already in use"}`,
+		},
+		{
+			name: "Reply",
+			req: `{
+				"text": "hello",
+				"user_id": "test",
+				"parent_id": "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					if msg.ParentID != "84bd9af7-79e6-4027-b284-9d5d875efd5b" {
+						t.Errorf("Got ParentID %q, want 84bd9af7-79e6-4027-b284-9d5d875efd5b", msg.ParentID)
+					}
+					msg.ID = "1"
+					msg.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return msg, nil
+				},
+			},
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					return nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"text": "hello",
+					"user_id": "test",
+					"parent_id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+					"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+					"reactions": []
+				}
+			}`,
+		},
+		{
+			name: "InvalidParentID",
+			req: `{
+				"text": "hello",
+				"user_id": "test",
+				"parent_id": "not-a-uuid"
+			}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "ParentID",
+						"Message": "Key: 'request.ParentID' Error:Field validation for 'ParentID' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name: "ParentNotFound",
+			req: `{
+				"text": "hello",
+				"user_id": "test",
+				"parent_id": "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{}, ErrNotFound
+				},
+			},
+			wantStatus: 422,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Parent message not found"
+			}`,
+		},
+		{
+			name: "DBError",
+			req: `{
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{}, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Could not insert message"
+			}`,
+		},
+		{
+			name: "CacheError",
+			req: `{
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					return errors.New("something went wrong")
+				},
+			},
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{
+						ID:        "1",
+						Text:      msg.Text,
+						UserID:    msg.UserID,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					}, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+					"reactions": []
+				}
+			}`,
+			containsLog: "Could not cache message",
+		},
+		{
+			name: "OK",
+			req: `{
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					if msg.UserID != "test" {
+						t.Errorf("Got UserID %q, want test", msg.UserID)
+					}
+					if msg.Text != "hello" {
+						t.Errorf("Got Text %q, want test", msg.Text)
+					}
+					return Message{
+						ID:        "1",
+						Text:      msg.Text,
+						UserID:    msg.UserID,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					}, nil
+				},
+			},
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					if msg.UserID != "test" {
+						t.Errorf("Got UserID %q, want test", msg.UserID)
+					}
+					if msg.Text != "hello" {
+						t.Errorf("Got Text %q, want test", msg.Text)
+					}
+					return nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"text": "hello",
+					"user_id": "test",
+					"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+					"reactions": []
+				}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if tt.db != nil {
+				tt.db.T = t
+			}
+			if tt.cache != nil {
+				tt.cache.T = t
+			}
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slog.New(slog.NewTextHandler(buf, nil)),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("POST", srv.URL+"/messages", strings.NewReader(tt.req))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+			checkLog(t, buf, tt.containsLog)
+		})
+	}
+}
+
+func TestAPI_clock(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				msg.ID = "1"
+				return msg, nil
+			},
+			insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+				if !reaction.CreatedAt.Equal(now) {
+					t.Errorf("Got reaction CreatedAt %v, want %v", reaction.CreatedAt, now)
+				}
+				reaction.ID = "1"
+				return reaction, nil
+			},
+		},
+		Cache: &testcache{
+			T:              t,
+			insertMessage:  func(t *testing.T, msg Message) error { return nil },
+			insertReaction: func(t *testing.T, reaction Reaction) error { return nil },
+		},
+		Logger: slogt.New(t),
+		Now:    func() time.Time { return now },
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{"text": "hello", "user_id": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, resp.StatusCode, 201)
+	checkBody(t, resp, `{
+		"api_version": "1",
+		"data": {
+			"id": "1",
+			"text": "hello",
+			"user_id": "test",
+			"created_at": "Mon, 06 May 2024 07:08:09 UTC",
+			"reactions": []
+		}
+	}`)
+
+	resp, err = http.Post(srv.URL+"/messages/84bd9af7-79e6-4027-b284-9d5d875efd5b/reactions", "application/json", strings.NewReader(`{"type": "like", "user_id": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 201)
+}
+
+func TestAPI_createMessage_whitespace(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		collapse bool
+		wantText string
+	}{
+		{name: "Padded", text: "  hello \n", wantText: "hello"},
+		{name: "Internal", text: " hello   big\n\nworld ", wantText: "hello   big\n\nworld"},
+		{name: "Collapsed", text: " hello   big\n\nworld ", collapse: true, wantText: "hello big world"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) (Message, error) {
+						if msg.Text != tt.wantText {
+							t.Errorf("Got text %q, want %q", msg.Text, tt.wantText)
+						}
+						msg.ID = "1"
+						return msg, nil
+					},
+				},
+				Cache: &testcache{
+					T:             t,
+					insertMessage: func(t *testing.T, msg Message) error { return nil },
+				},
+				Logger:             slogt.New(t),
+				Val:                validator.New(),
+				CollapseWhitespace: tt.collapse,
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			body, _ := json.Marshal(map[string]string{"text": tt.text, "user_id": "test"})
+			resp, err := http.Post(srv.URL+"/messages", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			checkStatus(t, resp.StatusCode, 201)
+		})
+	}
+
+	t.Run("Blank", func(t *testing.T) {
+		// The fakes have no functions, reaching the DB or the cache panics.
+		api := &API{
+			DB:     &testdb{T: t},
+			Cache:  &testcache{T: t},
+			Logger: slogt.New(t),
+			Val:    validator.New(),
+		}
+
+		srv := httptest.NewServer(api)
+		defer srv.Close()
+
+		resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{"text": " \t\n ", "user_id": "test"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkStatus(t, resp.StatusCode, 400)
+		checkBody(t, resp, `{
+			"api_version": "1",
+			"kind": "body",
+			"errors": [
+				{
+					"Field": "Text",
+					"Message": "Key: 'request.Text' Error:Field validation for 'Text' failed on the 'required' tag"
+				}
+			]
+		}`)
+	})
+}
+
+func TestAPI_createReaction(t *testing.T) {
+	tests := []struct {
+		name       string
+		db         *testdb
+		messageID  string
+		req        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "OK",
+			req: `{
+				"type": "thumbsup",
+				"user_id": "test"
+			}`,
+			messageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+					if reaction.UserID != "test" {
+						t.Errorf("Got UserID %q, want test", reaction.UserID)
+					}
+					if reaction.Type != "thumbs_up" {
+						t.Errorf("Got Type %q, want thumbs_up", reaction.Type)
+					}
+					return Reaction{
+						ID:        "1",
+						MessageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+						Score:     1,
+						Type:      reaction.Type,
+						UserID:    reaction.UserID,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					}, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"type": "thumbs_up",
+					"score": 1,	
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name: "DefaultScore",
+			req: `{
+				"type": "like",
+				"user_id": "test"
+			}`,
+			messageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+					if reaction.Score != 1 {
+						t.Errorf("Got Score %d, want 1", reaction.Score)
+					}
+					if reaction.CreatedAt.IsZero() {
+						t.Error("Got no CreatedAt, want the current time")
+					}
+					reaction.ID = "1"
+					reaction.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return reaction, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"type": "like",
+					"score": 1,
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name: "ExplicitScore",
+			req: `{
+				"type": "like",
+				"score": 5,
+				"user_id": "test"
+			}`,
+			messageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+					if reaction.Score != 5 {
+						t.Errorf("Got Score %d, want 5", reaction.Score)
+					}
+					reaction.ID = "1"
+					reaction.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return reaction, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"type": "like",
+					"score": 5,
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name: "Emoji",
+			req: `{
+				"type": "party",
+				"emoji": "🎉",
+				"user_id": "test"
+			}`,
+			messageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+					if reaction.Emoji != "🎉" {
+						t.Errorf("Got Emoji %q, want 🎉", reaction.Emoji)
+					}
+					reaction.ID = "1"
+					reaction.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return reaction, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"type": "party",
+					"emoji": "🎉",
+					"score": 1,
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name: "EmptyEmoji",
+			req: `{
+				"type": "like",
+				"emoji": "",
+				"user_id": "test"
+			}`,
+			messageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+					if reaction.Emoji != "" {
+						t.Errorf("Got Emoji %q, want empty", reaction.Emoji)
+					}
+					reaction.ID = "1"
+					reaction.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return reaction, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"type": "like",
+					"score": 1,
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name: "MultipleEmoji",
+			req: `{
+				"type": "party",
+				"emoji": "🎉🎉",
+				"user_id": "test"
+			}`,
+			messageID:  "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Emoji",
+						"Message": "Key: 'request.Emoji' Error:Field validation for 'Emoji' failed on the 'emoji' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:       "MalformedJSON",
+			req:        `{"type": "like",}`,
+			messageID:  "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Request body contains malformed JSON at position 17"
+			}`,
+		},
+		{
+			name:       "TruncatedJSON",
+			req:        `{"type": "like"`,
+			messageID:  "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Request body contains malformed JSON"
+			}`,
+		},
+		{
+			name:       "ScoreWrongType",
+			req:        `{"type": "like", "score": "high", "user_id": "test"}`,
+			messageID:  "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Field \"score\" must be of type integer"
+			}`,
+		},
+		{
+			name:       "NotAnObject",
+			req:        `["like"]`,
+			messageID:  "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Request body must be of type object"
+			}`,
+		},
+		{
+			name:       "EmptyBody",
+			req:        ``,
+			messageID:  "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Type",
+						"Message": "Key: 'request.Type' Error:Field validation for 'Type' failed on the 'required' tag"
+					},
+					{
+						"Field": "UserID",
+						"Message": "Key: 'request.UserID' Error:Field validation for 'UserID' failed on the 'required' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name: "ScoreAboveMax",
+			req: `{
+				"type": "like",
+				"score": 101,
+				"user_id": "test"
+			}`,
+			messageID:  "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Score",
+						"Message": "Score must not be greater than 100"
+					}
+				]
+			}`,
+		},
+		{
+			name: "Duplicate",
+			req: `{
+				"type": "like",
+				"user_id": "test"
+			}`,
+			messageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+					return Reaction{}, ErrDuplicateReaction
+				},
+			},
+			wantStatus: 409,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Reaction already exists"
+			}`,
+		},
+		{
+			name: "UUIDUserID",
+			req: `{
+				"type": "like",
+				"user_id": "0d5a4fa0-7e0b-4f2a-9b1b-4b4a2b5f3c11"
+			}`,
+			messageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+					reaction.ID = "1"
+					reaction.Score = 1
+					reaction.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return reaction, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "1",
+					"type": "like",
+					"score": 1,
+					"user_id": "0d5a4fa0-7e0b-4f2a-9b1b-4b4a2b5f3c11",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name: "InvalidUserID",
+			req: `{
+				"type": "like",
+				"user_id": "<script>"
+			}`,
+			messageID:  "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "UserID",
+						"Message": "Key: 'request.UserID' Error:Field validation for 'UserID' failed on the 'user_id' tag"
+					}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+				Cache:  &testcache{},
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("POST", srv.URL+"/messages/"+tt.messageID+"/reactions", strings.NewReader(tt.req))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_createReaction_maxReactions(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		max        int
+		count      int
+		wantStatus int
+	}{
+		{name: "BelowCap", max: 3, count: 2, wantStatus: 201},
+		{name: "AtCap", max: 3, count: 3, wantStatus: 422},
+		{name: "AboveCap", max: 3, count: 4, wantStatus: 422},
+		{name: "Unlimited", max: 0, count: 1000, wantStatus: 201},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					countReactions: func(t *testing.T, id string) (int, error) {
+						if tt.max == 0 {
+							t.Error("Counted reactions without a cap")
+						}
+						if id != messageID {
+							t.Errorf("Got message ID %q, want %q", id, messageID)
+						}
+						return tt.count, nil
+					},
+					insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+						reaction.ID = "1"
+						return reaction, nil
+					},
+				},
+				Cache:                  &testcache{T: t},
+				Logger:                 slogt.New(t),
+				MaxReactionsPerMessage: tt.max,
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Post(srv.URL+"/messages/"+messageID+"/reactions", "application/json",
+				strings.NewReader(`{"type": "like", "user_id": "test"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantStatus == 422 {
+				checkBody(t, resp, `{
+					"api_version": "1",
+					"error": "Message has reached the maximum number of reactions"
+				}`)
+			}
+		})
+	}
+}
+
+func TestAPI_createReaction_repeated(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	var inserts int
+	cached := make(map[string]Reaction)
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+				inserts++
+				reaction.ID = strconv.Itoa(inserts)
+				reaction.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+				return reaction, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertReaction: func(t *testing.T, reaction Reaction) error {
+				cached[reaction.UserID+":"+reaction.Type] = reaction
+				return nil
+			},
+			findReaction: func(t *testing.T, id, userID, reactionType string) (Reaction, error) {
+				if id != messageID {
+					t.Errorf("Got message ID %q, want %q", id, messageID)
+				}
+				rc, ok := cached[userID+":"+reactionType]
+				if !ok {
+					return Reaction{}, ErrNotFound
+				}
+				return rc, nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	react := func(body string, wantStatus int, wantBody string) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/messages/"+messageID+"/reactions", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		checkStatus(t, resp.StatusCode, wantStatus)
+		checkBody(t, resp, wantBody)
+	}
+
+	like := `{
+		"api_version": "1",
+		"data": {"id": "1", "type": "like", "score": 1, "user_id": "test", "created_at": "2024-01-01T00:00:00Z"}
+	}`
+	react(`{"type": "like", "user_id": "test"}`, 201, like)
+	react(`{"type": "like", "user_id": "test"}`, 200, like)
+	react(`{"type": "love", "user_id": "test"}`, 201, `{
+		"api_version": "1",
+		"data": {"id": "2", "type": "love", "score": 1, "user_id": "test", "created_at": "2024-01-01T00:00:00Z"}
+	}`)
+
+	if inserts != 2 {
+		t.Errorf("Got %d inserted reactions, want 2", inserts)
+	}
+}
+
+func TestAPI_create_location(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	cached := make(map[string]Reaction)
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				msg.ID = messageID
+				return msg, nil
+			},
+			insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+				reaction.ID = "1"
+				return reaction, nil
+			},
+		},
+		Cache: &testcache{
+			T:             t,
+			insertMessage: func(t *testing.T, msg Message) error { return nil },
+			insertReaction: func(t *testing.T, reaction Reaction) error {
+				cached[reaction.UserID+":"+reaction.Type] = reaction
+				return nil
+			},
+			findReaction: func(t *testing.T, id, userID, reactionType string) (Reaction, error) {
+				rc, ok := cached[userID+":"+reactionType]
+				if !ok {
+					return Reaction{}, ErrNotFound
+				}
+				return rc, nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	create := func(path, body string, wantStatus int, wantLocation string) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkStatus(t, resp.StatusCode, wantStatus)
+		if got := resp.Header.Get("Location"); got != wantLocation {
+			t.Errorf("Got Location %q, want %q", got, wantLocation)
+		}
+	}
+
+	create("/messages", `{"text": "hello", "user_id": "test"}`, 201, "/messages/"+messageID)
+	create("/messages/"+messageID+"/reactions", `{"type": "like", "user_id": "test"}`, 201, "/messages/"+messageID+"/reactions/1")
+	// Nothing is created by a repeated reaction.
+	create("/messages/"+messageID+"/reactions", `{"type": "like", "user_id": "test"}`, 200, "")
+}
+
+func TestAPI_createReaction_includeMessage(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	msg := Message{
+		ID:        messageID,
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Reactions: []Reaction{
+			{ID: "1", MessageID: messageID, Type: "love", Score: 1, UserID: "other", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		ReactionCount: 1,
+	}
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+				reaction.ID = "2"
+				reaction.CreatedAt = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+				msg.Reactions = append(msg.Reactions, reaction)
+				msg.ReactionCount++
+				return reaction, nil
+			},
+			getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+				if id != messageID {
+					t.Errorf("Got message ID %q, want %q", id, messageID)
+				}
+				return msg, nil
+			},
+		},
+		Cache:  &testcache{T: t},
+		Logger: slogt.New(t),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/messages/"+messageID+"/reactions?include=message", "application/json", strings.NewReader(`{"type": "like", "user_id": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 201)
+	checkBody(t, resp, `{
+		"api_version": "1",
+		"data": {
+			"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			"text": "hello",
+			"user_id": "test",
+			"created_at": "2024-01-01T00:00:00Z",
+			"pinned": false,
+			"reactions": [
+				{"id": "1", "type": "love", "score": 1, "user_id": "other", "created_at": "2024-01-01T00:00:00Z"},
+				{"id": "2", "type": "like", "score": 1, "user_id": "test", "created_at": "2024-01-02T00:00:00Z"}
+			],
+			"reaction_count": 2,
+			"reply_count": 0
+		}
+	}`)
+
+	resp, err = http.Post(srv.URL+"/messages/"+messageID+"/reactions?include=author", "application/json", strings.NewReader(`{"type": "like", "user_id": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 400)
+}
+
+func TestAPI_createReaction_ifAbsent(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	stored := Reaction{
+		ID:        "1",
+		MessageID: messageID,
+		Type:      "like",
+		Score:     1,
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		stored     bool
+		wantStatus int
+		wantID     string
+		wantInsert bool
+	}{
+		{
+			name:       "Present",
+			query:      "?if_absent=true",
+			stored:     true,
+			wantStatus: 200,
+			wantID:     "1",
+		},
+		{
+			name:       "Absent",
+			query:      "?if_absent=true",
+			wantStatus: 201,
+			wantID:     "2",
+			wantInsert: true,
+		},
+		{
+			// Without if_absent only the cache is checked.
+			name:       "Unconditional",
+			stored:     true,
+			wantStatus: 201,
+			wantID:     "2",
+			wantInsert: true,
+		},
+		{
+			name:       "Invalid",
+			query:      "?if_absent=maybe",
+			wantStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inserted bool
+			api := &API{
+				DB: &testdb{
+					T: t,
+					findReaction: func(t *testing.T, id, userID, reactionType string) (Reaction, error) {
+						if id != messageID || userID != "test" || reactionType != "like" {
+							t.Errorf("Got FindReaction(%q, %q, %q), want (%q, test, like)", id, userID, reactionType, messageID)
+						}
+						if !tt.stored {
+							return Reaction{}, ErrNotFound
+						}
+						return stored, nil
+					},
+					insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+						inserted = true
+						reaction.ID = "2"
+						return reaction, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					insertReaction: func(t *testing.T, reaction Reaction) error {
+						return nil
+					},
+				},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Post(srv.URL+"/messages/"+messageID+"/reactions"+tt.query, "application/json", strings.NewReader(`{"type": "like", "user_id": "test"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if inserted != tt.wantInsert {
+				t.Errorf("Got inserted %t, want %t", inserted, tt.wantInsert)
+			}
+			if tt.wantID == "" {
+				return
+			}
+			var body struct {
+				Data Reaction `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Data.ID != tt.wantID {
+				t.Errorf("Got reaction %q, want %q", body.Data.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAPI_createReaction_counts(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Counts",
+			query:      "?counts=true",
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "4",
+					"type": "like",
+					"score": 1,
+					"user_id": "dave",
+					"created_at": "2024-01-01T00:00:00Z",
+					"message_reaction_count": 4,
+					"type_count": 3
+				}
+			}`,
+		},
+		{
+			name:       "NoCounts",
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "4",
+					"type": "like",
+					"score": 1,
+					"user_id": "dave",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name:       "InvalidCounts",
+			query:      "?counts=maybe",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'boolean' tag"
+					}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := []Reaction{
+				{ID: "1", MessageID: messageID, Type: "like", UserID: "alice"},
+				{ID: "2", MessageID: messageID, Type: "like", UserID: "bob"},
+				{ID: "3", MessageID: messageID, Type: "love", UserID: "carol"},
+			}
+			api := &API{
+				DB: &testdb{
+					T: t,
+					insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+						reaction.ID = strconv.Itoa(len(stored) + 1)
+						reaction.CreatedAt = createdAt
+						stored = append(stored, reaction)
+						return reaction, nil
+					},
+					summary: func(t *testing.T, id string) (ReactionSummary, error) {
+						summary := ReactionSummary{Counts: make(map[string]int)}
+						for _, r := range stored {
+							summary.Counts[r.Type]++
+							summary.Total++
+						}
+						return summary, nil
+					},
+				},
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req := `{"type": "like", "user_id": "dave"}`
+			resp, err := http.Post(srv.URL+"/messages/"+messageID+"/reactions"+tt.query, "application/json", strings.NewReader(req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_createReaction_invalidMessageID(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantBody string
+	}{
+		{
+			name: "Empty",
+			path: "/messages//reactions",
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "messageID",
+						"Message": "messageID must not be empty"
+					}
+				]
+			}`,
+		},
+		{
+			name: "NotAUUID",
+			path: "/messages/not-a-uuid/reactions",
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The fakes have no functions, reaching the DB or the cache panics.
+			api := &API{
+				DB:     &testdb{T: t},
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("POST", srv.URL+tt.path, strings.NewReader(`{"type": "like", "user_id": "test"}`))
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, 400)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_createReaction_unknownMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{
+			name: "One",
+			path: "/reactions",
+			body: `{"type": "like", "user_id": "test"}`,
+		},
+		{
+			name: "Batch",
+			path: "/reactions/batch",
+			body: `{"reactions": [{"type": "like", "user_id": "test"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+						return Reaction{}, ErrNotFound
+					},
+					insertReactions: func(t *testing.T, reactions []Reaction) ([]Reaction, error) {
+						return nil, ErrNotFound
+					},
+				},
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			// No message has this id.
+			path := "/messages/0b7e4c31-5d2f-4f7a-9a63-2e8d1c6f9b40" + tt.path
+			resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, 404)
+			checkBody(t, resp, `{"api_version": "1", "error": "Message not found"}`)
+		})
+	}
+}
+
+func TestAPI_createReaction_scoreRanges(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		req        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "SuperLikeInRange",
+			req:        `{"type": "super_like", "score": 5, "user_id": "test"}`,
+			wantStatus: 201,
+		},
+		{
+			name:       "SuperLikeAboveRange",
+			req:        `{"type": "super_like", "score": 6, "user_id": "test"}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Score",
+						"Message": "Score must be between 1 and 5 for super_like reactions"
+					}
+				]
+			}`,
+		},
+		{
+			name:       "VoteBelowRange",
+			req:        `{"type": "vote", "score": 1, "user_id": "test"}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Score",
+						"Message": "Score must be between 2 and 3 for vote reactions"
+					}
+				]
+			}`,
+		},
+		{
+			name:       "VoteDefaultScore",
+			req:        `{"type": "vote", "user_id": "test"}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Score",
+						"Message": "Score must be between 2 and 3 for vote reactions"
+					}
+				]
+			}`,
+		},
+		{
+			name:       "DownvoteZero",
+			req:        `{"type": "downvote", "score": 0, "user_id": "test"}`,
+			wantStatus: 201,
+		},
+		{
+			name:       "DownvoteNegative",
+			req:        `{"type": "downvote", "score": -1, "user_id": "test"}`,
+			wantStatus: 201,
+		},
+		{
+			name:       "DownvoteBelowRange",
+			req:        `{"type": "downvote", "score": -2, "user_id": "test"}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Score",
+						"Message": "Score must be between -1 and 1 for downvote reactions"
+					}
+				]
+			}`,
+		},
+		{
+			name:       "UnconfiguredType",
+			req:        `{"type": "like", "score": 50, "user_id": "test"}`,
+			wantStatus: 201,
+		},
+		{
+			name:       "UnconfiguredTypeZero",
+			req:        `{"type": "like", "score": 0, "user_id": "test"}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Score",
+						"Message": "Score must be at least 1"
+					}
+				]
+			}`,
+		},
+		{
+			name:       "UnconfiguredTypeAboveMax",
+			req:        `{"type": "like", "score": 101, "user_id": "test"}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Score",
+						"Message": "Score must not be greater than 100"
+					}
+				]
+			}`,
+		},
+		{
+			name:       "BatchMixedTypes",
+			req:        `{"reactions": [{"type": "super_like", "score": 5, "user_id": "test"}, {"type": "vote", "score": 4, "user_id": "test"}]}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Score",
+						"Message": "Reactions[1].Score must be between 2 and 3 for vote reactions"
+					}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+						return reaction, nil
+					},
+				},
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+				Cache:  &testcache{T: t},
+				ReactionScoreRanges: map[string]ScoreRange{
+					"super_like": {Min: 1, Max: 5},
+					"vote":       {Min: 2, Max: 3},
+					"downvote":   {Min: -1, Max: 1},
+				},
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			path := "/messages/" + messageID + "/reactions"
+			if strings.HasPrefix(tt.req, `{"reactions"`) {
+				path += "/batch"
+			}
+			resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(tt.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAPI_createReactions(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		db         *testdb
+		req        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "Three",
+			req: `{"reactions": [
+				{"type": "like", "user_id": "alice"},
+				{"type": "thumbsup", "score": 3, "user_id": "bob"},
+				{"type": "party", "emoji": "🎉", "user_id": "carol"}
+			]}`,
+			db: &testdb{
+				insertReactions: func(t *testing.T, reactions []Reaction) ([]Reaction, error) {
+					if len(reactions) != 3 {
+						t.Fatalf("Got %d reactions, want 3", len(reactions))
+					}
+					for i := range reactions {
+						if reactions[i].MessageID != messageID {
+							t.Errorf("Got MessageID %q, want %q", reactions[i].MessageID, messageID)
+						}
+						reactions[i].ID = strconv.Itoa(i + 1)
+						reactions[i].CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					}
+					return reactions, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"reactions": [
+						{
+							"id": "1",
+							"type": "like",
+							"score": 1,
+							"user_id": "alice",
+							"created_at": "2024-01-01T00:00:00Z"
+						},
+						{
+							"id": "2",
+							"type": "thumbs_up",
+							"score": 3,
+							"user_id": "bob",
+							"created_at": "2024-01-01T00:00:00Z"
+						},
+						{
+							"id": "3",
+							"type": "party",
+							"emoji": "🎉",
+							"score": 1,
+							"user_id": "carol",
+							"created_at": "2024-01-01T00:00:00Z"
+						}
+					]
+				}
+			}`,
+		},
+		{
+			name:       "Empty",
+			req:        `{"reactions": []}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Reactions",
+						"Message": "Key: 'request.Reactions' Error:Field validation for 'Reactions' failed on the 'min' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name: "InvalidElement",
+			req: `{"reactions": [
+				{"type": "like", "user_id": "alice"},
+				{"type": "like"}
+			]}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "UserID",
+						"Message": "Key: 'request.Reactions[1].UserID' Error:Field validation for 'UserID' failed on the 'required' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name: "ScoreAboveMax",
+			req: `{"reactions": [
+				{"type": "like", "user_id": "alice"},
+				{"type": "like", "score": 101, "user_id": "bob"}
+			]}`,
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [
+					{
+						"Field": "Score",
+						"Message": "Reactions[1].Score must not be greater than 100"
+					}
+				]
+			}`,
+		},
+		{
+			name: "Duplicate",
+			req:  `{"reactions": [{"type": "like", "user_id": "alice"}]}`,
+			db: &testdb{
+				insertReactions: func(t *testing.T, reactions []Reaction) ([]Reaction, error) {
+					return nil, ErrDuplicateReaction
+				},
+			},
+			wantStatus: 409,
+			wantBody:   `{"api_version": "1", "error": "Reaction already exists"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Post(srv.URL+"/messages/"+messageID+"/reactions/batch", "application/json", strings.NewReader(tt.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_createReactions_tooMany(t *testing.T) {
+	api := &API{
+		DB:     &testdb{T: t},
+		Cache:  &testcache{T: t},
+		Logger: slogt.New(t),
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	reactions := make([]string, 51)
+	for i := range reactions {
+		reactions[i] = `{"type": "like", "user_id": "user` + strconv.Itoa(i) + `"}`
+	}
+	req := `{"reactions": [` + strings.Join(reactions, ",") + `]}`
+	resp, err := http.Post(srv.URL+"/messages/84bd9af7-79e6-4027-b284-9d5d875efd5b/reactions/batch", "application/json", strings.NewReader(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 400)
+}
+
+func TestAPI_createReactions_partial(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	db := &testdb{
+		T: t,
+		insertReactions: func(t *testing.T, reactions []Reaction) ([]Reaction, error) {
+			if len(reactions) != 2 {
+				t.Fatalf("Got %d reactions, want 2", len(reactions))
+			}
+			if reactions[0].UserID != "alice" || reactions[1].UserID != "dave" {
+				t.Errorf("Got users %q and %q, want alice and dave", reactions[0].UserID, reactions[1].UserID)
+			}
+			for i := range reactions {
+				reactions[i].ID = strconv.Itoa(i + 1)
+			}
+			return reactions, nil
+		},
+	}
+	api := &API{
+		DB:     db,
+		Cache:  &testcache{T: t},
+		Logger: slogt.New(t),
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	req := `{"reactions": [
+		{"type": "like", "user_id": "alice"},
+		{"type": "like"},
+		{"type": "like", "score": 101, "user_id": "carol"},
+		{"type": "party", "user_id": "dave"}
+	]}`
+	resp, err := http.Post(srv.URL+"/messages/"+messageID+"/reactions/batch?partial=true", "application/json", strings.NewReader(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 207)
+	checkBody(t, resp, `{
+		"api_version": "1",
+		"data": {
+			"results": [
+				{"status": 201, "id": "1"},
+				{
+					"status": 400,
+					"errors": [
+						{
+							"Field": "UserID",
+							"Message": "Key: 'reaction.UserID' Error:Field validation for 'UserID' failed on the 'required' tag"
+						}
+					]
+				},
+				{
+					"status": 400,
+					"errors": [
+						{
+							"Field": "Score",
+							"Message": "Score must not be greater than 100"
+						}
+					]
+				},
+				{"status": 201, "id": "2"}
+			]
+		}
+	}`)
+}
+
+func TestAPI_listReactionTypes(t *testing.T) {
+	api := &API{
+		DB:     &testdb{T: t},
+		Cache:  &testcache{T: t},
+		Logger: slogt.New(t),
+		ReactionTypes: []ReactionType{
+			{Name: "like", Emoji: "❤️"},
+			{Name: "star", Emoji: "⭐", DefaultScore: 5},
+		},
+		DefaultReactionScore: 2,
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/reactions/types")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+	checkBody(t, resp, `{
+		"api_version": "1",
+		"data": {
+			"types": [
+				{"name": "like", "emoji": "❤️", "default_score": 2},
+				{"name": "star", "emoji": "⭐", "default_score": 5}
+			]
+		}
+	}`)
+}
+
+func TestAPI_normalizeReactionType(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases map[string]string
+		in      string
+		want    string
+	}{
+		{name: "CamelCase", in: "ThumbsUp", want: "thumbs_up"},
+		{name: "Canonical", in: "thumbs_up", want: "thumbs_up"},
+		{name: "Alias", in: "thumbsup", want: "thumbs_up"},
+		{name: "Padded", in: " Like ", want: "like"},
+		{name: "Unknown", in: "Party", want: "party"},
+		{
+			name:    "CustomAliases",
+			aliases: map[string]string{"heart": "love"},
+			in:      "Heart",
+			want:    "love",
+		},
+		{
+			name:    "CustomAliasesReplaceDefaults",
+			aliases: map[string]string{"heart": "love"},
+			in:      "thumbsup",
+			want:    "thumbsup",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{ReactionAliases: tt.aliases}
+			if got := api.normalizeReactionType(tt.in); got != tt.want {
+				t.Errorf("Got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPI_createReaction_publish(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	hub := NewHub()
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+				reaction.ID = "1"
+				reaction.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+				return reaction, nil
+			},
+		},
+		Cache:  &testcache{T: t},
+		Logger: slogt.New(t),
+		Val:    validator.New(),
+		Hub:    hub,
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	// Subscribe through the event stream like a real-time client would.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events", nil)
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	checkStatus(t, stream.StatusCode, 200)
+
+	resp, err := http.Post(srv.URL+"/messages/"+messageID+"/reactions", "application/json",
+		strings.NewReader(`{"type": "like", "user_id": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 201)
+
+	scanner := bufio.NewScanner(stream.Body)
+	var lines []string
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+	want := []string{
+		"event: reaction",
+		`data: {"message_id":"84bd9af7-79e6-4027-b284-9d5d875efd5b","id":"1","type":"like","score":1,"user_id":"test","created_at":"2024-01-01T00:00:00Z"}`,
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("Got event\n%s\n\nWant\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestAPI_getReaction(t *testing.T) {
+	const (
+		messageID  = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+		reactionID = "0d5a4fa0-7e0b-4f2a-9b1b-4b4a2b5f3c11"
+	)
+	reaction := Reaction{
+		ID:        reactionID,
+		MessageID: messageID,
+		Type:      "like",
+		Score:     1,
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		cache      *testcache
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidReactionID",
+			path:       "/messages/" + messageID + "/reactions/not-a-uuid",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name: "Cache",
+			path: "/messages/" + messageID + "/reactions/" + reactionID,
+			cache: &testcache{
+				getReaction: func(t *testing.T, mID, rID string) (Reaction, error) {
+					return reaction, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "0d5a4fa0-7e0b-4f2a-9b1b-4b4a2b5f3c11",
+					"type": "like",
+					"score": 1,
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name: "DB",
+			path: "/messages/" + messageID + "/reactions/" + reactionID,
+			cache: &testcache{
+				insertReaction: func(t *testing.T, r Reaction) error {
+					if r.ID != reactionID {
+						t.Errorf("Cached reaction %q, want %q", r.ID, reactionID)
+					}
+					return nil
+				},
+			},
+			db: &testdb{
+				getReaction: func(t *testing.T, mID, rID string) (Reaction, error) {
+					if mID != messageID || rID != reactionID {
+						t.Errorf("Got ids %q/%q, want %q/%q", mID, rID, messageID, reactionID)
+					}
+					return reaction, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"id": "0d5a4fa0-7e0b-4f2a-9b1b-4b4a2b5f3c11",
+					"type": "like",
+					"score": 1,
+					"user_id": "test",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name: "NotFound",
+			path: "/messages/" + messageID + "/reactions/" + reactionID,
+			db: &testdb{
+				getReaction: func(t *testing.T, mID, rID string) (Reaction, error) {
+					return Reaction{}, ErrNotFound
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Reaction not found"
+			}`,
+		},
+		{
+			name: "DBError",
+			path: "/messages/" + messageID + "/reactions/" + reactionID,
+			db: &testdb{
+				getReaction: func(t *testing.T, mID, rID string) (Reaction, error) {
+					return Reaction{}, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Could not get reaction"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.db.T = t
+			tt.cache.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_toggleReaction(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	// bob's like stays as alice's like is toggled.
+	likes := []Reaction{{ID: "1", MessageID: messageID, Type: "like", UserID: "bob"}}
+	var cached, uncached []string
+	api := &API{
+		DB: &testdb{
+			T: t,
+			toggleReaction: func(t *testing.T, reaction Reaction) (ReactionToggle, error) {
+				if reaction.MessageID == "0b7e4c31-5d2f-4f7a-9a63-2e8d1c6f9b40" {
+					return ReactionToggle{}, ErrNotFound
+				}
+				if reaction.Type != "like" {
+					t.Errorf("Got type %q, want like", reaction.Type)
+				}
+				for i, rc := range likes {
+					if rc.UserID == reaction.UserID {
+						likes = slices.Delete(likes, i, i+1)
+						return ReactionToggle{Reactions: []Reaction{rc}, Count: len(likes)}, nil
+					}
+				}
+				reaction.ID = "2"
+				likes = append(likes, reaction)
+				return ReactionToggle{Active: true, Reactions: []Reaction{reaction}, Count: len(likes)}, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertReaction: func(t *testing.T, reaction Reaction) error {
+				cached = append(cached, reaction.ID)
+				return nil
+			},
+			deleteReaction: func(t *testing.T, messageID, reactionID string) error {
+				uncached = append(uncached, reactionID)
+				return nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	toggle := func(messageID string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/messages/"+messageID+"/reactions/toggle", "application/json", strings.NewReader(`{"type": "Like", "user_id": "alice"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := toggle(messageID)
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+	checkBody(t, resp, `{"api_version": "1", "data": {"active": true, "count": 2}}`)
+
+	resp = toggle(messageID)
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+	checkBody(t, resp, `{"api_version": "1", "data": {"active": false, "count": 1}}`)
+
+	if !slices.Equal(cached, []string{"2"}) || !slices.Equal(uncached, []string{"2"}) {
+		t.Errorf("Got reactions %v cached and %v uncached, want 2 and 2", cached, uncached)
+	}
+
+	resp = toggle("0b7e4c31-5d2f-4f7a-9a63-2e8d1c6f9b40")
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 404)
+}
+
+func TestAPI_getReactionByID(t *testing.T) {
+	const reactionID = "0b7e4c31-5d2f-4f7a-9a63-2e8d1c6f9b40"
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Found",
+			id:         reactionID,
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"message_id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+					"id": "0b7e4c31-5d2f-4f7a-9a63-2e8d1c6f9b40",
+					"type": "like",
+					"score": 1,
+					"user_id": "alice",
+					"created_at": "2024-01-01T00:00:00Z"
+				}
+			}`,
+		},
+		{
+			name:       "Missing",
+			id:         "6f1d2c3b-4a5e-4f60-8b7c-9d0e1f2a3b4c",
+			wantStatus: 404,
+			wantBody:   `{"api_version": "1", "error": "Reaction not found"}`,
+		},
+		{
+			name:       "InvalidID",
+			id:         "1",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					getReactionByID: func(t *testing.T, id string) (Reaction, error) {
+						if id != reactionID {
+							return Reaction{}, ErrNotFound
+						}
+						return Reaction{
+							ID:        id,
+							MessageID: "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+							Type:      "like",
+							Score:     1,
+							UserID:    "alice",
+							CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						}, nil
+					},
+				},
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+			}
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/reactions/" + tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_deleteReaction(t *testing.T) {
+	const (
+		messageID  = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+		reactionID = "0d5a4fa0-7e0b-4f2a-9b1b-4b4a2b5f3c11"
+	)
+	reaction := Reaction{
+		ID:        reactionID,
+		MessageID: messageID,
+		Type:      "like",
+		Score:     1,
+		UserID:    "alice",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name        string
+		auth        string
+		getReaction func(t *testing.T, mID, rID string) (Reaction, error)
+		wantStatus  int
+		wantBody    string
+		wantDeleted bool
+	}{
+		{
+			name: "Own",
+			auth: "Bearer alice-token",
+			getReaction: func(t *testing.T, mID, rID string) (Reaction, error) {
+				if mID != messageID || rID != reactionID {
+					t.Errorf("Got ids %q/%q, want %q/%q", mID, rID, messageID, reactionID)
+				}
+				return reaction, nil
+			},
+			wantStatus:  204,
+			wantDeleted: true,
+		},
+		{
+			name: "OtherUser",
+			auth: "Bearer bob-token",
+			getReaction: func(t *testing.T, mID, rID string) (Reaction, error) {
+				return reaction, nil
+			},
+			wantStatus: 403,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Reaction belongs to another user"
+			}`,
+		},
+		{
+			name:       "Anonymous",
+			wantStatus: 401,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Unauthorized"
+			}`,
+		},
+		{
+			name: "NotFound",
+			auth: "Bearer alice-token",
+			getReaction: func(t *testing.T, mID, rID string) (Reaction, error) {
+				return Reaction{}, ErrNotFound
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Reaction not found"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dbDeleted, cacheDeleted bool
+			api := &API{
+				DB: &testdb{
+					T:           t,
+					getReaction: tt.getReaction,
+					deleteReaction: func(t *testing.T, mID, rID string) error {
+						if mID != messageID || rID != reactionID {
+							t.Errorf("Deleted %q/%q, want %q/%q", mID, rID, messageID, reactionID)
+						}
+						dbDeleted = true
+						return nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					deleteReaction: func(t *testing.T, mID, rID string) error {
+						cacheDeleted = true
+						return ErrNotFound
+					},
+				},
+				Logger:     slogt.New(t),
+				Val:        validator.New(),
+				UserTokens: map[string]string{"alice-token": "alice", "bob-token": "bob"},
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("DELETE", srv.URL+"/messages/"+messageID+"/reactions/"+reactionID, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+			if dbDeleted != tt.wantDeleted || cacheDeleted != tt.wantDeleted {
+				t.Errorf("Got deleted from DB %t and cache %t, want %t", dbDeleted, cacheDeleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestAPI_getMessage(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	msg := Message{
+		ID:        messageID,
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Reactions: []Reaction{},
+	}
+	const msgBody = `{
+		"api_version": "1",
+		"data": {
+			"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			"text": "hello",
+			"user_id": "test",
+			"created_at": "2024-01-01T00:00:00Z",
+			"pinned": false,
+			"reactions": [],
+			"reaction_count": 0,
+			"reply_count": 0
+		}
+	}`
+
+	tests := []struct {
+		name       string
+		path       string
+		cache      *testcache
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidID",
+			path:       "/messages/not-a-uuid",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:       "InvalidSort",
+			path:       "/messages/" + messageID + "?sort=random",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'oneof' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name: "SortScore",
+			path: "/messages/" + messageID + "?sort=score",
+			cache: &testcache{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					if sort != ReactionSortScore {
+						t.Errorf("Got cache sort %q, want %q", sort, ReactionSortScore)
+					}
+					return Message{}, ErrNotFound
+				},
+			},
+			db: &testdb{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					if sort != ReactionSortScore {
+						t.Errorf("Got DB sort %q, want %q", sort, ReactionSortScore)
+					}
+					return msg, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   msgBody,
+		},
+		{
+			name: "FilterType",
+			path: "/messages/" + messageID + "?type=ThumbsUp",
+			cache: &testcache{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					if reactionType != "thumbs_up" {
+						t.Errorf("Got reaction type %q, want the canonical thumbs_up", reactionType)
+					}
+					// The message has no reactions of the type.
+					return msg, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   msgBody,
+		},
+		{
+			name: "Cache",
+			path: "/messages/" + messageID,
+			cache: &testcache{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					return msg, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   msgBody,
+		},
+		{
+			name: "DB",
+			path: "/messages/" + messageID,
+			cache: &testcache{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					return Message{}, errors.New("something went wrong")
+				},
+			},
+			db: &testdb{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					if id != messageID {
+						t.Errorf("Got id %q, want %q", id, messageID)
+					}
+					return msg, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   msgBody,
+		},
+		{
+			name: "NotFound",
+			path: "/messages/" + messageID,
+			db: &testdb{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					return Message{}, ErrNotFound
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name: "DBError",
+			path: "/messages/" + messageID,
+			db: &testdb{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					return Message{}, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"api_version": "1",
+				"error": "Could not get message"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.db.T = t
+			tt.cache.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_getMessage_reactions(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	msg := Message{
+		ID:        messageID,
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Reactions: []Reaction{
+			{ID: "1", MessageID: messageID, Type: "like", Score: 1, UserID: "alice", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+			{ID: "2", MessageID: messageID, Type: "like", Score: 2, UserID: "bob", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		},
+		ReactionCount: 2,
+	}
+	const wantBody = `{
+		"api_version": "1",
+		"data": {
+			"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+			"text": "hello",
+			"user_id": "test",
+			"created_at": "2024-01-01T00:00:00Z",
+			"pinned": false,
+			"reactions": [
+				{"id": "1", "type": "like", "score": 1, "user_id": "alice", "created_at": "2024-01-02T00:00:00Z"},
+				{"id": "2", "type": "like", "score": 2, "user_id": "bob", "created_at": "2024-01-03T00:00:00Z"}
+			],
+			"reaction_count": 2,
+			"reply_count": 0,
+			"reaction_users": {"like": ["alice", "bob"]}
+		}
+	}`
+
+	tests := []struct {
+		name  string
+		cache *testcache
+		db    *testdb
+	}{
+		{
+			name: "Cache",
+			cache: &testcache{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					return msg, nil
+				},
+			},
+		},
+		{
+			name:  "DB",
+			cache: &testcache{},
+			db: &testdb{
+				getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+					return msg, nil
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.cache.T = t
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages/" + messageID + "?expand=reaction_users")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, 200)
+			checkBody(t, resp, wantBody)
+		})
+	}
+}
+
+func TestAPI_startTyping(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        string
+		cache      *testcache
+		wantStatus int
+		wantEvent  bool
+	}{
+		{
+			name:       "MissingUserID",
+			req:        `{}`,
+			wantStatus: 400,
+		},
+		{
+			name: "CacheError",
+			req:  `{"user_id": "test"}`,
+			cache: &testcache{
+				setTyping: func(t *testing.T, userID string, ttl time.Duration) error {
+					return errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+		},
+		{
+			name: "OK",
+			req:  `{"user_id": "test"}`,
+			cache: &testcache{
+				setTyping: func(t *testing.T, userID string, ttl time.Duration) error {
+					if userID != "test" {
+						t.Errorf("Got UserID %q, want test", userID)
+					}
+					if ttl != 5*time.Second {
+						t.Errorf("Got TTL %v, want 5s", ttl)
+					}
+					return nil
+				},
+			},
+			wantStatus: 204,
+			wantEvent:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.cache.T = t
+			hub := NewHub()
+			events, unsubscribe := hub.Subscribe()
+			defer unsubscribe()
+			api := &API{
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+				Val:    validator.New(),
+				Hub:    hub,
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Post(srv.URL+"/messages/typing", "application/json", strings.NewReader(tt.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+
+			select {
+			case e := <-events:
+				if !tt.wantEvent {
+					t.Errorf("Got unexpected event %+v", e)
+				} else if e.Type != EventTyping || e.Data != (TypingEvent{UserID: "test"}) {
+					t.Errorf("Got event %+v, want typing event for test", e)
+				}
+			default:
+				if tt.wantEvent {
+					t.Error("No typing event published")
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_listTyping(t *testing.T) {
+	tests := []struct {
+		name       string
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "Empty",
+			cache: &testcache{
+				listTyping: func(t *testing.T) ([]string, error) {
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"user_ids": []}}`,
+		},
+		{
+			name: "Typing",
+			cache: &testcache{
+				listTyping: func(t *testing.T) ([]string, error) {
+					return []string{"alice", "bob"}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"user_ids": ["alice", "bob"]}}`,
+		},
+		{
+			name: "CacheError",
+			cache: &testcache{
+				listTyping: func(t *testing.T) ([]string, error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody:   `{"api_version": "1", "error": "Could not list typing users"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cache.T = t
+			api := &API{
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages/typing")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_defaults(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return nil, errors.New("something went wrong")
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+		},
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	// Both the success path and the error path log.
+	resp, err := http.Get(srv.URL + "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 500)
+
+	resp, err = http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 400)
+}
+
+func TestAPI_reactionSummary(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		messageID  string
+		db         *testdb
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidID",
+			messageID:  "not-a-uuid",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:      "NoReactions",
+			messageID: messageID,
+			db: &testdb{
+				summary: func(t *testing.T, id string) (ReactionSummary, error) {
+					return ReactionSummary{}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"counts": {}, "total": 0, "score": 0}}`,
+		},
+		{
+			name:      "DB",
+			messageID: messageID,
+			db: &testdb{
+				summary: func(t *testing.T, id string) (ReactionSummary, error) {
+					if id != messageID {
+						t.Errorf("Got message ID %q, want %q", id, messageID)
+					}
+					return ReactionSummary{Counts: map[string]int{"like": 3, "love": 2}, Total: 5, Score: 7}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"counts": {"like": 3, "love": 2}, "total": 5, "score": 7}}`,
+		},
+		{
+			name:      "CacheFallback",
+			messageID: messageID,
+			db: &testdb{
+				summary: func(t *testing.T, id string) (ReactionSummary, error) {
+					return ReactionSummary{}, errors.New("something went wrong")
+				},
+			},
+			cache: &testcache{
+				summary: func(t *testing.T, id string) (ReactionSummary, error) {
+					return ReactionSummary{Counts: map[string]int{"like": 1}, Total: 1, Score: 1}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"counts": {"like": 1}, "total": 1, "score": 1}}`,
+		},
+		{
+			name:      "Error",
+			messageID: messageID,
+			db: &testdb{
+				summary: func(t *testing.T, id string) (ReactionSummary, error) {
+					return ReactionSummary{}, errors.New("something went wrong")
+				},
+			},
+			cache: &testcache{
+				summary: func(t *testing.T, id string) (ReactionSummary, error) {
+					return ReactionSummary{}, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody:   `{"api_version": "1", "error": "Could not summarize reactions"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.db.T = t
+			tt.cache.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages/" + tt.messageID + "/reactions/summary")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_emojiCounts(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		messageID  string
+		db         *testdb
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidID",
+			messageID:  "not-a-uuid",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:      "NoReactions",
+			messageID: messageID,
+			db: &testdb{
+				emojiCounts: func(t *testing.T, id string) (map[string]int, error) {
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {}}`,
+		},
+		{
+			name:      "DB",
+			messageID: messageID,
+			db: &testdb{
+				emojiCounts: func(t *testing.T, id string) (map[string]int, error) {
+					if id != messageID {
+						t.Errorf("Got message ID %q, want %q", id, messageID)
+					}
+					return map[string]int{"👍": 3, "🎉": 1}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"🎉": 1, "👍": 3}}`,
+		},
+		{
+			name:      "CacheFallback",
+			messageID: messageID,
+			db: &testdb{
+				emojiCounts: func(t *testing.T, id string) (map[string]int, error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+			cache: &testcache{
+				emojiCounts: func(t *testing.T, id string) (map[string]int, error) {
+					return map[string]int{"👍": 1}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"👍": 1}}`,
+		},
+		{
+			name:      "Error",
+			messageID: messageID,
+			db: &testdb{
+				emojiCounts: func(t *testing.T, id string) (map[string]int, error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+			cache: &testcache{
+				emojiCounts: func(t *testing.T, id string) (map[string]int, error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody:   `{"api_version": "1", "error": "Could not count emojis"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.db.T = t
+			tt.cache.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages/" + tt.messageID + "/reactions/emojis")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_scoreHistogram(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	tests := []struct {
+		name       string
+		messageID  string
+		histogram  func(t *testing.T, messageID string) (map[int]int, error)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidID",
+			messageID:  "not-a-uuid",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:      "NoReactions",
+			messageID: messageID,
+			histogram: func(t *testing.T, id string) (map[int]int, error) {
+				return nil, nil
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {}}`,
+		},
+		{
+			name:      "Scores",
+			messageID: messageID,
+			histogram: func(t *testing.T, id string) (map[int]int, error) {
+				if id != messageID {
+					t.Errorf("Got message ID %q, want %q", id, messageID)
+				}
+				return map[int]int{1: 2, 3: 1}, nil
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"1": 2, "3": 1}}`,
+		},
+		{
+			name:      "Error",
+			messageID: messageID,
+			histogram: func(t *testing.T, id string) (map[int]int, error) {
+				return nil, errors.New("something went wrong")
+			},
+			wantStatus: 500,
+			wantBody:   `{"api_version": "1", "error": "Could not count scores"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB:     &testdb{T: t, scoreHistogram: tt.histogram},
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages/" + tt.messageID + "/reactions/histogram")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_searchMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		search     func(t *testing.T, query string, limit, offset int, reactions ReactionLoad, withHidden bool) ([]SearchResult, error)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:  "OK",
+			query: "?q=+hello+&limit=5",
+			search: func(t *testing.T, query string, limit, offset int, reactions ReactionLoad, withHidden bool) ([]SearchResult, error) {
+				if query != "hello" || limit != 5 || offset != 0 {
+					t.Errorf("Got SearchMessages(%q, %d, %d), want (hello, 5, 0)", query, limit, offset)
+				}
+				return []SearchResult{{
+					Message: Message{
+						ID:        "1",
+						Text:      "hello <world>",
+						UserID:    "test",
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					},
+					Highlight: "<mark>hello</mark> &lt;world&gt;",
+				}}, nil
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{
+							"id": "1",
+							"text": "hello \u003cworld\u003e",
+							"user_id": "test",
+							"created_at": "2024-01-01T00:00:00Z",
+							"pinned": false,
+							"reactions": [],
+							"reaction_count": 0,
+							"reply_count": 0,
+							"highlight": "\u003cmark\u003ehello\u003c/mark\u003e \u0026lt;world\u0026gt;"
+						}
+					]
+				}
+			}`,
+		},
+		{
+			name:       "MissingQuery",
+			query:      "?q=+",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'required' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:  "Error",
+			query: "?q=hello",
+			search: func(t *testing.T, query string, limit, offset int, reactions ReactionLoad, withHidden bool) ([]SearchResult, error) {
+				return nil, errors.New("something went wrong")
+			},
+			wantStatus: 500,
+			wantBody:   `{"api_version": "1", "error": "Could not search messages"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB:     &testdb{T: t, search: tt.search},
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages/search" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_userReactions(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     string
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidUserID",
+			userID:     "not%20valid",
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [
+					{
+						"Field": "",
+						"Message": "Key: '' Error:Field validation for '' failed on the 'user_id' tag"
+					}
+				]
+			}`,
+		},
+		{
+			name:   "Error",
+			userID: "test",
+			db: &testdb{
+				userReactions: func(t *testing.T, userID string) ([]Reaction, error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody:   `{"api_version": "1", "error": "Could not list reactions"}`,
+		},
+		{
+			name:   "UnknownUser",
+			userID: "nobody",
+			db: &testdb{
+				userReactions: func(t *testing.T, userID string) ([]Reaction, error) {
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"user_id": "nobody", "types": []}}`,
+		},
+		{
+			name:   "SeveralMessages",
+			userID: "test",
+			db: &testdb{
+				userReactions: func(t *testing.T, userID string) ([]Reaction, error) {
+					if userID != "test" {
+						t.Errorf("Got user ID %q, want test", userID)
+					}
+					return []Reaction{
+						{ID: "1", MessageID: "m3", Type: "love", UserID: "test"},
+						{ID: "2", MessageID: "m3", Type: "like", UserID: "test"},
+						{ID: "3", MessageID: "m2", Type: "like", UserID: "test"},
+						{ID: "4", MessageID: "m1", Type: "laugh", UserID: "test"},
+					}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"user_id": "test",
+					"types": [
+						{"type": "like", "count": 2, "message_ids": ["m3", "m2"]},
+						{"type": "laugh", "count": 1, "message_ids": ["m1"]},
+						{"type": "love", "count": 1, "message_ids": ["m3"]}
+					]
+				}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/users/" + tt.userID + "/reactions")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_envelope(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	msg := Message{ID: messageID, Text: "Hello", UserID: "testuser", Pinned: true}
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return []Message{msg}, nil
+			},
+			setPinned: func(t *testing.T, id string, pinned bool) (Message, error) {
+				return msg, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+			setPinned: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Logger:         slogt.New(t),
+		ModeratorToken: "mod",
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		wantData string
+	}{
+		{
+			name:     "Messages",
+			method:   "GET",
+			path:     "/messages",
+			wantData: `{"messages": [`,
+		},
+		{
+			name:     "Message",
+			method:   "POST",
+			path:     "/messages/" + messageID + "/pin",
+			wantData: `{"id": "` + messageID + `"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Pinning is reserved to moderators.
+			req.Header.Set("Authorization", "Bearer mod")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, 200)
+
+			var body struct {
+				APIVersion string          `json:"api_version"`
+				Data       json.RawMessage `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.APIVersion != APIVersion {
+				t.Errorf("Got api_version %q, want %q", body.APIVersion, APIVersion)
+			}
+			if got, want := string(body.Data), strings.ReplaceAll(tt.wantData, " ", ""); !strings.HasPrefix(got, want) {
+				t.Errorf("Got data %s, want prefix %s", got, want)
+			}
+		})
+	}
+}
+
+func TestAPI_flushCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       string
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "NoToken",
+			wantStatus: 401,
+			wantBody:   `{"api_version": "1", "error": "Unauthorized"}`,
+		},
+		{
+			name:       "WrongToken",
+			auth:       "Bearer wrong",
+			wantStatus: 401,
+			wantBody:   `{"api_version": "1", "error": "Unauthorized"}`,
+		},
+		{
+			name: "Error",
+			auth: "Bearer secret",
+			cache: &testcache{
+				flush: func(t *testing.T) (int, error) {
+					return 0, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody:   `{"api_version": "1", "error": "Could not flush cache"}`,
+		},
+		{
+			name: "OK",
+			auth: "Bearer secret",
+			cache: &testcache{
+				flush: func(t *testing.T) (int, error) {
+					return 12, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody:   `{"api_version": "1", "data": {"deleted": 12}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.cache.T = t
+			api := &API{
+				DB:         &testdb{T: t},
+				Cache:      tt.cache,
+				Logger:     slogt.New(t),
+				AdminToken: "secret",
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("POST", srv.URL+"/admin/cache/flush", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_flushCache_disabled(t *testing.T) {
+	api := &API{
+		DB:     &testdb{T: t},
+		Cache:  &testcache{T: t},
+		Logger: slogt.New(t),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("POST", srv.URL+"/admin/cache/flush", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 404)
+}
+
+func TestAPI_setLogLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	level := new(slog.LevelVar)
+	api := &API{
+		DB:         &testdb{T: t},
+		Cache:      &testcache{T: t},
+		Logger:     slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: level})),
+		LogLevel:   level,
+		AdminToken: "secret",
+	}
+
+	setLevel := func(body string, wantStatus int, wantBody string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/admin/loglevel", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		resp := rec.Result()
+		checkStatus(t, resp.StatusCode, wantStatus)
+		checkBody(t, resp, wantBody)
+	}
+
+	api.Logger.Debug("Hidden")
+	if strings.Contains(buf.String(), "Hidden") {
+		t.Errorf("Got a debug log at the info level")
+	}
+
+	setLevel(`{"level": "debug"}`, 200, `{"api_version": "1", "data": {"level": "debug"}}`)
+	if got := level.Level(); got != slog.LevelDebug {
+		t.Errorf("Got level %v, want %v", got, slog.LevelDebug)
+	}
+	api.Logger.Debug("Shown")
+	checkLog(t, buf, "level=DEBUG msg=Shown")
+
+	setLevel(`{"level": "verbose"}`, 400, `{
+		"api_version": "1",
+		"kind": "body",
+		"errors": [
+			{
+				"Field": "Level",
+				"Message": "Key: 'request.Level' Error:Field validation for 'Level' failed on the 'oneof' tag"
+			}
+		]
+	}`)
+	if got := level.Level(); got != slog.LevelDebug {
+		t.Errorf("Got level %v after an invalid change, want %v", got, slog.LevelDebug)
+	}
+}
+
+type testdb struct {
+	T               *testing.T
+	listMessages    func(t *testing.T, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error)
+	insertMessage   func(t *testing.T, msg Message) (Message, error)
+	insertReaction  func(t *testing.T, reaction Reaction) (Reaction, error)
+	getMessage      func(t *testing.T, messageID string, sort ReactionSort, reactionType string) (Message, error)
+	getReaction     func(t *testing.T, messageID, reactionID string) (Reaction, error)
+	getReactionByID func(t *testing.T, reactionID string) (Reaction, error)
+	findReaction    func(t *testing.T, messageID, userID, reactionType string) (Reaction, error)
+	deleteReaction  func(t *testing.T, messageID, reactionID string) error
+	toggleReaction  func(t *testing.T, reaction Reaction) (ReactionToggle, error)
+	latestMsgTime   func(t *testing.T) (time.Time, error)
+	countMessages   func(t *testing.T) (int, error)
+	countReactions  func(t *testing.T, messageID string) (int, error)
+	insertReactions func(t *testing.T, reactions []Reaction) ([]Reaction, error)
+	userReactions   func(t *testing.T, userID string) ([]Reaction, error)
+	getThread       func(t *testing.T, messageID string, maxDepth, limit int, withHidden bool) ([]ThreadMessage, error)
+	setPinned       func(t *testing.T, messageID string, pinned bool) (Message, error)
+	summary         func(t *testing.T, messageID string) (ReactionSummary, error)
+	emojiCounts     func(t *testing.T, messageID string) (map[string]int, error)
+	scoreHistogram  func(t *testing.T, messageID string) (map[int]int, error)
+	setHidden       func(t *testing.T, messageID string, hidden bool) (Message, error)
+	updateText      func(t *testing.T, messageID, text string) (Message, error)
+	deleteMessage   func(t *testing.T, messageID string) error
+	listAfter       func(t *testing.T, afterID string, limit int, reactions ReactionLoad, withHidden bool) ([]Message, error)
+	search          func(t *testing.T, query string, limit, offset int, reactions ReactionLoad, withHidden bool) ([]SearchResult, error)
+	listBetween     func(t *testing.T, from, to time.Time, limit, offset int, reactions ReactionLoad, withHidden bool) ([]Message, error)
+}
+
+func (db *testdb) InsertReactions(_ context.Context, reactions []Reaction) ([]Reaction, error) {
+	return db.insertReactions(db.T, reactions)
+}
+
+func (db *testdb) ListReactionsByUser(_ context.Context, userID string) ([]Reaction, error) {
+	return db.userReactions(db.T, userID)
+}
+
+func (db *testdb) GetThread(_ context.Context, messageID string, maxDepth, limit int, withHidden bool) ([]ThreadMessage, error) {
+	return db.getThread(db.T, messageID, maxDepth, limit, withHidden)
+}
+
+func (db *testdb) CountReactions(_ context.Context, messageID string) (int, error) {
+	return db.countReactions(db.T, messageID)
+}
+
+func (db *testdb) CountMessages(_ context.Context) (int, error) {
+	if db.countMessages == nil {
+		return 0, nil
+	}
+	return db.countMessages(db.T)
+}
+
+func (db *testdb) ListMessages(_ context.Context, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+	return db.listMessages(db.T, before, order, limit, offset, reactions, withHidden, excludeMsgIDs...)
+}
+
+func (db *testdb) ListMessagesAfter(_ context.Context, afterID string, limit int, reactions ReactionLoad, withHidden bool) ([]Message, error) {
+	return db.listAfter(db.T, afterID, limit, reactions, withHidden)
+}
+
+func (db *testdb) ListMessagesBetween(_ context.Context, from, to time.Time, limit, offset int, reactions ReactionLoad, withHidden bool) ([]Message, error) {
+	return db.listBetween(db.T, from, to, limit, offset, reactions, withHidden)
+}
+
+func (db *testdb) InsertMessage(_ context.Context, msg Message) (Message, error) {
+	return db.insertMessage(db.T, msg)
+}
+
+func (db *testdb) InsertReaction(_ context.Context, reaction Reaction) (Reaction, error) {
 	return db.insertReaction(db.T, reaction)
 }
 
+func (db *testdb) LatestMessageTime(_ context.Context) (time.Time, error) {
+	if db.latestMsgTime == nil {
+		return time.Time{}, nil
+	}
+	return db.latestMsgTime(db.T)
+}
+
+func (db *testdb) SetMessagePinned(_ context.Context, messageID string, pinned bool) (Message, error) {
+	return db.setPinned(db.T, messageID, pinned)
+}
+
+func (db *testdb) SetMessageHidden(_ context.Context, messageID string, hidden bool) (Message, error) {
+	return db.setHidden(db.T, messageID, hidden)
+}
+
+func (db *testdb) ReactionSummary(_ context.Context, messageID string) (ReactionSummary, error) {
+	return db.summary(db.T, messageID)
+}
+
+func (db *testdb) EmojiCounts(_ context.Context, messageID string) (map[string]int, error) {
+	return db.emojiCounts(db.T, messageID)
+}
+
+func (db *testdb) ScoreHistogram(_ context.Context, messageID string) (map[int]int, error) {
+	return db.scoreHistogram(db.T, messageID)
+}
+
+func (db *testdb) GetMessage(_ context.Context, messageID string, sort ReactionSort, reactionType string) (Message, error) {
+	return db.getMessage(db.T, messageID, sort, reactionType)
+}
+
+func (db *testdb) GetReaction(_ context.Context, messageID, reactionID string) (Reaction, error) {
+	return db.getReaction(db.T, messageID, reactionID)
+}
+
+func (db *testdb) GetReactionByID(_ context.Context, reactionID string) (Reaction, error) {
+	return db.getReactionByID(db.T, reactionID)
+}
+
+func (db *testdb) UpdateMessageText(_ context.Context, messageID, text string) (Message, error) {
+	return db.updateText(db.T, messageID, text)
+}
+
+func (db *testdb) DeleteMessage(_ context.Context, messageID string) error {
+	return db.deleteMessage(db.T, messageID)
+}
+
+func (db *testdb) SearchMessages(_ context.Context, query string, limit, offset int, reactions ReactionLoad, withHidden bool) ([]SearchResult, error) {
+	return db.search(db.T, query, limit, offset, reactions, withHidden)
+}
+
+func (db *testdb) FindReaction(_ context.Context, messageID, userID, reactionType string) (Reaction, error) {
+	return db.findReaction(db.T, messageID, userID, reactionType)
+}
+
+func (db *testdb) DeleteReaction(_ context.Context, messageID, reactionID string) error {
+	return db.deleteReaction(db.T, messageID, reactionID)
+}
+
+func (db *testdb) ToggleReaction(_ context.Context, reaction Reaction) (ReactionToggle, error) {
+	return db.toggleReaction(db.T, reaction)
+}
+
+// blockingDB lists messages only once the context is done, like a DB
+// honoring cancellation would.
+type blockingDB struct {
+	*testdb
+}
+
+func (db blockingDB) ListMessages(ctx context.Context, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
 type testcache struct {
 	T              *testing.T
-	listMessages   func(t *testing.T) ([]Message, error)
+	listMessages   func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error)
 	insertMessage  func(t *testing.T, msg Message) error
 	insertReaction func(t *testing.T, reaction Reaction) error
 	listReactions  func(t *testing.T, messageID string) ([]Reaction, error)
+	getMessage     func(t *testing.T, messageID string, sort ReactionSort, reactionType string) (Message, error)
+	getReaction    func(t *testing.T, messageID, reactionID string) (Reaction, error)
+	findReaction   func(t *testing.T, messageID, userID, reactionType string) (Reaction, error)
+	deleteReaction func(t *testing.T, messageID, reactionID string) error
+	setTyping      func(t *testing.T, userID string, ttl time.Duration) error
+	listTyping     func(t *testing.T) ([]string, error)
+	setPinned      func(t *testing.T, msg Message) error
+	setHidden      func(t *testing.T, messageID string, hidden bool) error
+	setText        func(t *testing.T, messageID, text string) error
+	deleteMessage  func(t *testing.T, messageID string) error
+	summary        func(t *testing.T, messageID string) (ReactionSummary, error)
+	emojiCounts    func(t *testing.T, messageID string) (map[string]int, error)
+	getCount       func(t *testing.T) (int, error)
+	setCount       func(t *testing.T, n int, ttl time.Duration) error
+	flush          func(t *testing.T) (int, error)
 }
 
-func (c *testcache) ListMessages(_ context.Context) ([]Message, error) {
-	return c.listMessages(c.T)
+func (c *testcache) ListMessages(_ context.Context, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+	return c.listMessages(c.T, before, order, limit, withReactions)
 }
 
 func (c *testcache) InsertMessage(_ context.Context, msg Message) error {
@@ -516,10 +6532,102 @@ func (c *testcache) InsertReaction(_ context.Context, messageID string, reaction
 	return c.insertReaction(c.T, reaction)
 }
 
+func (c *testcache) GetMessage(_ context.Context, messageID string, sort ReactionSort, reactionType string) (Message, error) {
+	if c.getMessage == nil {
+		return Message{}, ErrNotFound
+	}
+	return c.getMessage(c.T, messageID, sort, reactionType)
+}
+
+func (c *testcache) GetReaction(_ context.Context, messageID, reactionID string) (Reaction, error) {
+	if c.getReaction == nil {
+		return Reaction{}, ErrNotFound
+	}
+	return c.getReaction(c.T, messageID, reactionID)
+}
+
+func (c *testcache) FindReaction(_ context.Context, messageID, userID, reactionType string) (Reaction, error) {
+	if c.findReaction == nil {
+		return Reaction{}, ErrNotFound
+	}
+	return c.findReaction(c.T, messageID, userID, reactionType)
+}
+
+func (c *testcache) SetMessageText(_ context.Context, messageID, text string) error {
+	return c.setText(c.T, messageID, text)
+}
+
+func (c *testcache) DeleteMessage(_ context.Context, messageID string) error {
+	return c.deleteMessage(c.T, messageID)
+}
+
+func (c *testcache) DeleteReaction(_ context.Context, messageID, reactionID string) error {
+	return c.deleteReaction(c.T, messageID, reactionID)
+}
+
+func (c *testcache) SetTyping(_ context.Context, userID string, ttl time.Duration) error {
+	return c.setTyping(c.T, userID, ttl)
+}
+
+func (c *testcache) ListTyping(_ context.Context) ([]string, error) {
+	return c.listTyping(c.T)
+}
+
+func (c *testcache) SetMessagePinned(_ context.Context, msg Message) error {
+	if c.setPinned == nil {
+		return nil
+	}
+	return c.setPinned(c.T, msg)
+}
+
+func (c *testcache) SetMessageHidden(_ context.Context, messageID string, hidden bool) error {
+	if c.setHidden == nil {
+		return nil
+	}
+	return c.setHidden(c.T, messageID, hidden)
+}
+
+func (c *testcache) ReactionSummary(_ context.Context, messageID string) (ReactionSummary, error) {
+	return c.summary(c.T, messageID)
+}
+
+func (c *testcache) EmojiCounts(_ context.Context, messageID string) (map[string]int, error) {
+	return c.emojiCounts(c.T, messageID)
+}
+
+func (c *testcache) Flush(_ context.Context) (int, error) {
+	return c.flush(c.T)
+}
+
+func (c *testcache) GetMessageCount(_ context.Context) (int, error) {
+	if c.getCount == nil {
+		return 0, ErrNotFound
+	}
+	return c.getCount(c.T)
+}
+
+func (c *testcache) SetMessageCount(_ context.Context, n int, ttl time.Duration) error {
+	if c.setCount == nil {
+		return nil
+	}
+	return c.setCount(c.T, n, ttl)
+}
+
 func (c *testcache) ListReactions(_ context.Context, messageID string) ([]Reaction, error) {
 	return c.listReactions(c.T, messageID)
 }
 
+// blockingCache lists messages only once the context is done, like a cache
+// honoring cancellation would.
+type blockingCache struct {
+	*testcache
+}
+
+func (c blockingCache) ListMessages(ctx context.Context, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
 func checkStatus(t *testing.T, got, want int) {
 	t.Helper()
 	if got != want {
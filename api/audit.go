@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// An AuditAction names a mutation recorded in the audit trail.
+type AuditAction string
+
+const (
+	AuditMessageCreate  AuditAction = "message.create"
+	AuditMessagePin     AuditAction = "message.pin"
+	AuditMessageUnpin   AuditAction = "message.unpin"
+	AuditMessageHide    AuditAction = "message.hide"
+	AuditMessageUnhide  AuditAction = "message.unhide"
+	AuditMessageUpdate  AuditAction = "message.update"
+	AuditMessageDelete  AuditAction = "message.delete"
+	AuditReactionCreate AuditAction = "reaction.create"
+	AuditReactionDelete AuditAction = "reaction.delete"
+	AuditCacheFlush     AuditAction = "cache.flush"
+	AuditLogLevel       AuditAction = "log.level"
+)
+
+// An AuditEvent records who changed what and when.
+type AuditEvent struct {
+	// Actor is the user that made the change. Requests not made on behalf of
+	// a user are attributed to the role of the client, or else to its IP
+	// address.
+	Actor    string      `json:"actor"`
+	Action   AuditAction `json:"action"`
+	TargetID string      `json:"target_id,omitempty"`
+	Time     time.Time   `json:"time"`
+}
+
+// An Auditor records the audit trail of mutations.
+type Auditor interface {
+	Record(ctx context.Context, event AuditEvent) error
+}
+
+// LogAuditor is an Auditor writing each event as a JSON line through Logger.
+type LogAuditor struct {
+	Logger *slog.Logger
+}
+
+// Record logs event.
+func (l *LogAuditor) Record(ctx context.Context, event AuditEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	l.Logger.InfoContext(ctx, "Audit", "event", string(b))
+	return nil
+}
+
+// audit records a mutation of targetID by actor. The mutation has already
+// happened, so failures are logged instead of failing the request.
+func (a *API) audit(r *http.Request, action AuditAction, actor, targetID string) {
+	if actor == "" {
+		actor = a.ClientIP(r)
+		if roleFrom(r.Context()) == RoleModerator {
+			actor = "moderator"
+		}
+	}
+	err := a.Auditor.Record(r.Context(), AuditEvent{
+		Actor:    actor,
+		Action:   action,
+		TargetID: targetID,
+		Time:     a.now().UTC(),
+	})
+	if err != nil {
+		a.Logger.Error("Could not record audit event", "action", string(action), "error", err.Error())
+	}
+}
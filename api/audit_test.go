@@ -0,0 +1,240 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api/validator"
+)
+
+type testauditor struct {
+	events []AuditEvent
+	err    error
+}
+
+func (a *testauditor) Record(_ context.Context, event AuditEvent) error {
+	a.events = append(a.events, event)
+	return a.err
+}
+
+func TestAPI_audit(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	message := func(t *testing.T, id string, _ bool) (Message, error) {
+		return Message{ID: id, Text: "hello", UserID: "test"}, nil
+	}
+	db := &testdb{
+		insertMessage: func(t *testing.T, msg Message) (Message, error) {
+			msg.ID = messageID
+			return msg, nil
+		},
+		insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+			reaction.ID = "1"
+			return reaction, nil
+		},
+		insertReactions: func(t *testing.T, reactions []Reaction) ([]Reaction, error) {
+			for i := range reactions {
+				reactions[i].ID = strings.Repeat("2", i+1)
+			}
+			return reactions, nil
+		},
+		setPinned: message,
+		setHidden: message,
+	}
+	cache := &testcache{
+		insertMessage: func(t *testing.T, msg Message) error { return nil },
+		flush:         func(t *testing.T) (int, error) { return 0, nil },
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		auth       string
+		wantStatus int
+		want       []AuditEvent
+	}{
+		{
+			name:       "CreateMessage",
+			method:     "POST",
+			path:       "/messages",
+			body:       `{"text": "hello", "user_id": "test"}`,
+			wantStatus: 201,
+			want:       []AuditEvent{{Actor: "test", Action: AuditMessageCreate, TargetID: messageID}},
+		},
+		{
+			name:       "CreateReaction",
+			method:     "POST",
+			path:       "/messages/" + messageID + "/reactions",
+			body:       `{"type": "like", "user_id": "test"}`,
+			wantStatus: 201,
+			want:       []AuditEvent{{Actor: "test", Action: AuditReactionCreate, TargetID: "1"}},
+		},
+		{
+			name:       "CreateReactions",
+			method:     "POST",
+			path:       "/messages/" + messageID + "/reactions/batch",
+			body:       `{"reactions": [{"type": "like", "user_id": "a"}, {"type": "love", "user_id": "b"}]}`,
+			wantStatus: 201,
+			want: []AuditEvent{
+				{Actor: "a", Action: AuditReactionCreate, TargetID: "2"},
+				{Actor: "b", Action: AuditReactionCreate, TargetID: "22"},
+			},
+		},
+		{
+			name:       "Pin",
+			method:     "POST",
+			path:       "/messages/" + messageID + "/pin",
+			auth:       "Bearer mod",
+			wantStatus: 200,
+			want:       []AuditEvent{{Actor: "moderator", Action: AuditMessagePin, TargetID: messageID}},
+		},
+		{
+			name:       "Unpin",
+			method:     "DELETE",
+			path:       "/messages/" + messageID + "/pin",
+			auth:       "Bearer mod",
+			wantStatus: 200,
+			want:       []AuditEvent{{Actor: "moderator", Action: AuditMessageUnpin, TargetID: messageID}},
+		},
+		{
+			name:       "Hide",
+			method:     "POST",
+			path:       "/messages/" + messageID + "/hide",
+			auth:       "Bearer mod",
+			wantStatus: 200,
+			want:       []AuditEvent{{Actor: "moderator", Action: AuditMessageHide, TargetID: messageID}},
+		},
+		{
+			name:       "Unhide",
+			method:     "DELETE",
+			path:       "/messages/" + messageID + "/hide",
+			auth:       "Bearer mod",
+			wantStatus: 200,
+			want:       []AuditEvent{{Actor: "moderator", Action: AuditMessageUnhide, TargetID: messageID}},
+		},
+		{
+			name:       "FlushCache",
+			method:     "POST",
+			path:       "/admin/cache/flush",
+			auth:       "Bearer admin",
+			wantStatus: 200,
+			want:       []AuditEvent{{Actor: "admin", Action: AuditCacheFlush}},
+		},
+		{
+			name:       "Invalid",
+			method:     "POST",
+			path:       "/messages",
+			body:       `{"text": "hello"}`,
+			wantStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db.T = t
+			cache.T = t
+			auditor := &testauditor{}
+			api := &API{
+				DB:             db,
+				Cache:          cache,
+				Logger:         slogt.New(t),
+				Val:            validator.New(),
+				Auditor:        auditor,
+				AdminToken:     "admin",
+				ModeratorToken: "mod",
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			start := time.Now()
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+
+			if len(auditor.events) != len(tt.want) {
+				t.Fatalf("Got %d audit events, want %d: %+v", len(auditor.events), len(tt.want), auditor.events)
+			}
+			for i, got := range auditor.events {
+				if got.Time.Before(start.Add(-time.Second)) || got.Time.After(time.Now()) {
+					t.Errorf("Got event time %v, want the time of the request", got.Time)
+				}
+				got.Time = time.Time{}
+				if got != tt.want[i] {
+					t.Errorf("Got audit event %+v, want %+v", got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_audit_error(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			setPinned: func(t *testing.T, id string, _ bool) (Message, error) {
+				return Message{ID: id}, nil
+			},
+		},
+		Cache:          &testcache{},
+		Logger:         slogt.New(t),
+		Auditor:        &testauditor{err: errors.New("disk full")},
+		ModeratorToken: "mod",
+	}
+	api.DB.(*testdb).T = t
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("POST", srv.URL+"/messages/84bd9af7-79e6-4027-b284-9d5d875efd5b/pin", nil)
+	req.Header.Set("Authorization", "Bearer mod")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+}
+
+func TestLogAuditor(t *testing.T) {
+	var buf bytes.Buffer
+	auditor := &LogAuditor{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+
+	event := AuditEvent{
+		Actor:    "test",
+		Action:   AuditMessageCreate,
+		TargetID: "1",
+		Time:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if err := auditor.Record(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	var line struct {
+		Event string `json:"event"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"actor":"test","action":"message.create","target_id":"1","time":"2024-01-01T00:00:00Z"}`
+	if line.Event != want {
+		t.Errorf("Got event %s, want %s", line.Event, want)
+	}
+}
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCacheUnavailable is returned by a BreakerCache for calls it skips that
+// have no sensible empty result.
+var ErrCacheUnavailable = errors.New("cache unavailable")
+
+// A BreakerState is the state of the circuit breaker of a BreakerCache.
+type BreakerState int
+
+const (
+	// BreakerClosed passes all calls to the cache.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen skips all calls to the cache until the cooldown has
+	// passed.
+	BreakerOpen
+	// BreakerHalfOpen passes a single call to the cache to probe whether it
+	// recovered, skipping all others.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerCache is a Cache guarded by a circuit breaker, so that requests don't
+// all wait for a cache that is down. After Threshold consecutive failures the
+// breaker opens and calls are skipped for Cooldown: reads behave like cache
+// misses and writes like no-ops. Then a single call probes the cache, closing
+// the breaker again if it succeeds.
+type BreakerCache struct {
+	Cache
+
+	// Threshold is the number of consecutive failures opening the breaker.
+	// Defaults to 5.
+	Threshold int
+	// Cooldown is how long calls are skipped once the breaker opened.
+	// Defaults to 30 seconds.
+	Cooldown time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// State returns the current state of the breaker.
+func (b *BreakerCache) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// ListMessages lists the cached messages, or none while the breaker is open.
+func (b *BreakerCache) ListMessages(ctx context.Context, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+	return guard(b, []Message{}, nil, func() ([]Message, error) {
+		return b.Cache.ListMessages(ctx, before, order, limit, withReactions)
+	})
+}
+
+// InsertMessage caches msg unless the breaker is open.
+func (b *BreakerCache) InsertMessage(ctx context.Context, msg Message) error {
+	return guardErr(b, func() error {
+		return b.Cache.InsertMessage(ctx, msg)
+	})
+}
+
+// InsertReaction caches the reaction unless the breaker is open.
+func (b *BreakerCache) InsertReaction(ctx context.Context, msgID string, reaction Reaction) error {
+	return guardErr(b, func() error {
+		return b.Cache.InsertReaction(ctx, msgID, reaction)
+	})
+}
+
+// GetMessage returns a cached message. ErrNotFound is returned while the
+// breaker is open.
+func (b *BreakerCache) GetMessage(ctx context.Context, messageID string, sort ReactionSort, reactionType string) (Message, error) {
+	return guard(b, Message{}, ErrNotFound, func() (Message, error) {
+		return b.Cache.GetMessage(ctx, messageID, sort, reactionType)
+	})
+}
+
+// GetReaction returns a cached reaction. ErrNotFound is returned while the
+// breaker is open.
+func (b *BreakerCache) GetReaction(ctx context.Context, messageID, reactionID string) (Reaction, error) {
+	return guard(b, Reaction{}, ErrNotFound, func() (Reaction, error) {
+		return b.Cache.GetReaction(ctx, messageID, reactionID)
+	})
+}
+
+// FindReaction returns the cached reaction of a user with the given type.
+// ErrNotFound is returned while the breaker is open.
+func (b *BreakerCache) FindReaction(ctx context.Context, messageID, userID, reactionType string) (Reaction, error) {
+	return guard(b, Reaction{}, ErrNotFound, func() (Reaction, error) {
+		return b.Cache.FindReaction(ctx, messageID, userID, reactionType)
+	})
+}
+
+// SetTyping marks the user as typing unless the breaker is open.
+func (b *BreakerCache) SetTyping(ctx context.Context, userID string, ttl time.Duration) error {
+	return guardErr(b, func() error {
+		return b.Cache.SetTyping(ctx, userID, ttl)
+	})
+}
+
+// ListTyping lists the typing users, or none while the breaker is open.
+func (b *BreakerCache) ListTyping(ctx context.Context) ([]string, error) {
+	return guard(b, nil, nil, func() ([]string, error) {
+		return b.Cache.ListTyping(ctx)
+	})
+}
+
+// SetMessagePinned updates the pinned state of a cached message unless the
+// breaker is open.
+func (b *BreakerCache) SetMessagePinned(ctx context.Context, msg Message) error {
+	return guardErr(b, func() error {
+		return b.Cache.SetMessagePinned(ctx, msg)
+	})
+}
+
+// SetMessageHidden updates the hidden state of a cached message unless the
+// breaker is open.
+func (b *BreakerCache) SetMessageHidden(ctx context.Context, messageID string, hidden bool) error {
+	return guardErr(b, func() error {
+		return b.Cache.SetMessageHidden(ctx, messageID, hidden)
+	})
+}
+
+// SetMessageText updates the text of a cached message unless the breaker is
+// open.
+func (b *BreakerCache) SetMessageText(ctx context.Context, messageID, text string) error {
+	return guardErr(b, func() error {
+		return b.Cache.SetMessageText(ctx, messageID, text)
+	})
+}
+
+// DeleteMessage removes a cached message unless the breaker is open.
+func (b *BreakerCache) DeleteMessage(ctx context.Context, messageID string) error {
+	return guardErr(b, func() error {
+		return b.Cache.DeleteMessage(ctx, messageID)
+	})
+}
+
+// DeleteReaction removes a cached reaction unless the breaker is open.
+func (b *BreakerCache) DeleteReaction(ctx context.Context, messageID, reactionID string) error {
+	return guardErr(b, func() error {
+		return b.Cache.DeleteReaction(ctx, messageID, reactionID)
+	})
+}
+
+// ReactionSummary summarizes the cached reactions of a message.
+// ErrCacheUnavailable is returned while the breaker is open.
+func (b *BreakerCache) ReactionSummary(ctx context.Context, messageID string) (ReactionSummary, error) {
+	return guard(b, ReactionSummary{}, ErrCacheUnavailable, func() (ReactionSummary, error) {
+		return b.Cache.ReactionSummary(ctx, messageID)
+	})
+}
+
+// EmojiCounts counts the cached reactions of a message per emoji.
+// ErrCacheUnavailable is returned while the breaker is open.
+func (b *BreakerCache) EmojiCounts(ctx context.Context, messageID string) (map[string]int, error) {
+	return guard(b, nil, ErrCacheUnavailable, func() (map[string]int, error) {
+		return b.Cache.EmojiCounts(ctx, messageID)
+	})
+}
+
+// GetMessageCount returns the cached number of messages. ErrNotFound is
+// returned while the breaker is open.
+func (b *BreakerCache) GetMessageCount(ctx context.Context) (int, error) {
+	return guard(b, 0, ErrNotFound, func() (int, error) {
+		return b.Cache.GetMessageCount(ctx)
+	})
+}
+
+// SetMessageCount caches the number of messages unless the breaker is open.
+func (b *BreakerCache) SetMessageCount(ctx context.Context, n int, ttl time.Duration) error {
+	return guardErr(b, func() error {
+		return b.Cache.SetMessageCount(ctx, n, ttl)
+	})
+}
+
+// Flush removes all cached messages. ErrCacheUnavailable is returned while the
+// breaker is open.
+func (b *BreakerCache) Flush(ctx context.Context) (int, error) {
+	return guard(b, 0, ErrCacheUnavailable, func() (int, error) {
+		return b.Cache.Flush(ctx)
+	})
+}
+
+// guard calls fn if the breaker allows it and records the outcome. Otherwise
+// skipped and skippedErr are returned.
+func guard[T any](b *BreakerCache, skipped T, skippedErr error, fn func() (T, error)) (T, error) {
+	if !b.allow() {
+		return skipped, skippedErr
+	}
+	res, err := fn()
+	b.record(err)
+	return res, err
+}
+
+// guardErr is guard for calls returning only an error.
+func guardErr(b *BreakerCache, fn func() error) error {
+	_, err := guard(b, struct{}{}, nil, func() (struct{}, error) {
+		return struct{}{}, fn()
+	})
+	return err
+}
+
+// allow reports whether a call may go through to the cache.
+func (b *BreakerCache) allow() bool {
+	cooldown := b.Cooldown
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if time.Since(b.openedAt) < cooldown {
+			return false
+		}
+		// This call probes the cache.
+		b.state = BreakerHalfOpen
+		return true
+	default:
+		// A probe is in flight.
+		return false
+	}
+}
+
+// record updates the breaker with the outcome of a call. Misses and calls
+// cancelled by the client are not failures of the cache.
+func (b *BreakerCache) record(err error) {
+	threshold := b.Threshold
+	if threshold < 1 {
+		threshold = defaultBreakerThreshold
+	}
+	failed := err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, context.Canceled)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.state == BreakerOpen:
+		// The call started before the breaker opened.
+	case !failed:
+		b.state = BreakerClosed
+		b.failures = 0
+	case b.state == BreakerHalfOpen:
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	default:
+		b.failures++
+		if b.failures >= threshold {
+			b.state = BreakerOpen
+			b.openedAt = time.Now()
+		}
+	}
+}
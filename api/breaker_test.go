@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerCache(t *testing.T) {
+	ctx := context.Background()
+	errDown := errors.New("connection refused")
+
+	var calls int
+	cacheErr := errDown
+	b := &BreakerCache{
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				calls++
+				return []Message{{ID: "1"}}, cacheErr
+			},
+			insertMessage: func(t *testing.T, msg Message) error {
+				calls++
+				return cacheErr
+			},
+		},
+		Threshold: 3,
+		Cooldown:  50 * time.Millisecond,
+	}
+
+	for range 3 {
+		if _, err := b.ListMessages(ctx, time.Now(), OrderDesc, 10, false); !errors.Is(err, errDown) {
+			t.Fatalf("Got error %v, want %v", err, errDown)
+		}
+	}
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("Got state %s after %d failures, want %s", got, calls, BreakerOpen)
+	}
+
+	// The cache is skipped during the cooldown.
+	msgs, err := b.ListMessages(ctx, time.Now(), OrderDesc, 10, false)
+	if err != nil || len(msgs) != 0 {
+		t.Errorf("Got %v, %v while open, want no messages and no error", msgs, err)
+	}
+	if err := b.InsertMessage(ctx, Message{}); err != nil {
+		t.Errorf("Got error %v while open, want none", err)
+	}
+	if calls != 3 {
+		t.Errorf("Got %d cache calls, want 3", calls)
+	}
+
+	// A failing probe after the cooldown opens the breaker again.
+	time.Sleep(b.Cooldown)
+	if err := b.InsertMessage(ctx, Message{}); !errors.Is(err, errDown) {
+		t.Errorf("Got error %v, want %v", err, errDown)
+	}
+	if got := b.State(); got != BreakerOpen {
+		t.Errorf("Got state %s after failing probe, want %s", got, BreakerOpen)
+	}
+
+	// A succeeding probe closes it.
+	time.Sleep(b.Cooldown)
+	cacheErr = nil
+	msgs, err = b.ListMessages(ctx, time.Now(), OrderDesc, 10, false)
+	if err != nil || len(msgs) != 1 {
+		t.Errorf("Got %v, %v from probe, want the cached message", msgs, err)
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Errorf("Got state %s after succeeding probe, want %s", got, BreakerClosed)
+	}
+	if calls != 5 {
+		t.Errorf("Got %d cache calls, want 5", calls)
+	}
+}
+
+func TestBreakerCache_notFound(t *testing.T) {
+	b := &BreakerCache{
+		Cache: &testcache{
+			T: t,
+			getMessage: func(t *testing.T, messageID string, sort ReactionSort, reactionType string) (Message, error) {
+				return Message{}, ErrNotFound
+			},
+		},
+		Threshold: 1,
+	}
+	for range 3 {
+		if _, err := b.GetMessage(context.Background(), "1", ReactionSortCreated, ""); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Got error %v, want %v", err, ErrNotFound)
+		}
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Errorf("Got state %s after misses, want %s", got, BreakerClosed)
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// errInvalidCursor is returned for cursors that are malformed or were not
+// signed with the cursor key.
+var errInvalidCursor = errors.New("invalid cursor")
+
+// A cursor points at a page of a message listing. It is handed to clients as
+// an opaque, signed token, so that they can't page from arbitrary timestamps
+// or offsets.
+type cursor struct {
+	// Before is the bound the listing was started with, so that messages
+	// created while paging don't shift the pages.
+	Before time.Time `json:"before"`
+	Page   int       `json:"page"`
+}
+
+// encodeCursor encodes c as a token signed with key.
+func encodeCursor(key []byte, c cursor) string {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		// A cursor only holds a time and an int, which always marshal.
+		panic(err)
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(signCursor(key, payload))
+}
+
+// decodeCursor decodes a token encoded by encodeCursor. errInvalidCursor is
+// returned if the token is malformed or its signature does not match key.
+func decodeCursor(key []byte, token string) (cursor, error) {
+	enc := base64.RawURLEncoding
+	p, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return cursor{}, errInvalidCursor
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return cursor{}, errInvalidCursor
+	}
+	sig, err := enc.DecodeString(s)
+	if err != nil {
+		return cursor{}, errInvalidCursor
+	}
+	if !hmac.Equal(sig, signCursor(key, payload)) {
+		return cursor{}, errInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(payload, &c); err != nil || c.Page < 1 {
+		return cursor{}, errInvalidCursor
+	}
+	return c, nil
+}
+
+// nextLink returns the value of the Link header pointing at nextURL.
+func nextLink(r *http.Request, cursor string) string {
+	return fmt.Sprintf(`<%s>; rel="next"`, nextURL(r, cursor))
+}
+
+// nextURL returns the URL of the page after the one requested by r, continued
+// with the token cursor. The URL keeps the parameters of r, except the ones
+// the cursor takes precedence over.
+func nextURL(r *http.Request, cursor string) string {
+	q := r.URL.Query()
+	q.Del("page")
+	q.Del("before")
+	q.Set("cursor", cursor)
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	return u.String()
+}
+
+func signCursor(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
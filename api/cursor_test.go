@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	key := []byte("secret")
+	want := cursor{Before: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Page: 3}
+	token := encodeCursor(key, want)
+
+	tampered := func() string {
+		forged := encodeCursor(key, cursor{Before: want.Before, Page: 1000})
+		payload, _, _ := strings.Cut(forged, ".")
+		_, sig, _ := strings.Cut(token, ".")
+		return payload + "." + sig
+	}()
+
+	tests := []struct {
+		name    string
+		key     []byte
+		token   string
+		wantErr error
+	}{
+		{name: "Valid", key: key, token: token},
+		{name: "Tampered", key: key, token: tampered, wantErr: errInvalidCursor},
+		{name: "WrongKey", key: []byte("other"), token: token, wantErr: errInvalidCursor},
+		{name: "Malformed", key: key, token: "not a cursor", wantErr: errInvalidCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.key, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (!got.Before.Equal(want.Before) || got.Page != want.Page) {
+				t.Errorf("Got cursor %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+	"fmt"
+)
+
+// A MessageEnricher enriches messages before they are stored, for example by
+// detecting their language or unfurling their links.
+type MessageEnricher interface {
+	Enrich(ctx context.Context, msg Message) (Message, error)
+}
+
+// enrich passes msg through the Enrichers in order. A failing enricher fails
+// the enrichment, unless SkipEnricherErrors is set: the message is then passed
+// on without the changes of the failing enricher.
+func (a *API) enrich(ctx context.Context, msg Message) (Message, error) {
+	for i, e := range a.Enrichers {
+		enriched, err := e.Enrich(ctx, msg)
+		if err != nil {
+			if !a.SkipEnricherErrors {
+				return Message{}, fmt.Errorf("enricher %d: %w", i, err)
+			}
+			a.Logger.Error("Could not enrich message", "enricher", i, "error", err.Error())
+			continue
+		}
+		msg = enriched
+	}
+	return msg, nil
+}
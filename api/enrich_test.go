@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/neilotoole/slogt"
+)
+
+// linkEnricher attaches the links of messages.
+type linkEnricher struct{}
+
+var linkRe = regexp.MustCompile(`https?://\S+`)
+
+func (linkEnricher) Enrich(_ context.Context, msg Message) (Message, error) {
+	for _, link := range linkRe.FindAllString(msg.Text, -1) {
+		msg.Attachments = append(msg.Attachments, Attachment{URL: link, Type: "link"})
+	}
+	return msg, nil
+}
+
+// shoutEnricher rewrites the text of messages in upper case.
+type shoutEnricher struct{}
+
+func (shoutEnricher) Enrich(_ context.Context, msg Message) (Message, error) {
+	msg.Text = strings.ToUpper(msg.Text)
+	return msg, nil
+}
+
+// failingEnricher fails every enrichment.
+type failingEnricher struct{}
+
+func (failingEnricher) Enrich(context.Context, Message) (Message, error) {
+	return Message{}, errors.New("service unavailable")
+}
+
+func TestAPI_createMessage_enrichers(t *testing.T) {
+	tests := []struct {
+		name            string
+		enrichers       []MessageEnricher
+		skipErrors      bool
+		wantStatus      int
+		wantAttachments int
+	}{
+		{
+			name:            "Link",
+			enrichers:       []MessageEnricher{linkEnricher{}},
+			wantStatus:      201,
+			wantAttachments: 1,
+		},
+		{
+			name:       "Fatal",
+			enrichers:  []MessageEnricher{linkEnricher{}, failingEnricher{}},
+			wantStatus: 500,
+		},
+		{
+			name:            "Skipped",
+			enrichers:       []MessageEnricher{failingEnricher{}, linkEnricher{}},
+			skipErrors:      true,
+			wantStatus:      201,
+			wantAttachments: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inserted []Message
+			api := &API{
+				DB: &testdb{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) (Message, error) {
+						inserted = append(inserted, msg)
+						msg.ID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+						return msg, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) error {
+						return nil
+					},
+				},
+				Logger:             slogt.New(t),
+				Enrichers:          tt.enrichers,
+				SkipEnricherErrors: tt.skipErrors,
+			}
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{"text": "see https://example.com", "user_id": "test"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+
+			if tt.wantStatus != 201 {
+				if len(inserted) != 0 {
+					t.Errorf("Got %d inserted messages, want none", len(inserted))
+				}
+				return
+			}
+			if len(inserted) != 1 {
+				t.Fatalf("Got %d inserted messages, want 1", len(inserted))
+			}
+			got := inserted[0].Attachments
+			if len(got) != tt.wantAttachments || got[0].URL != "https://example.com" || got[0].Type != "link" {
+				t.Errorf("Got attachments %+v, want the link", got)
+			}
+		})
+	}
+}
+
+func TestAPI_createMessage_enrichedRetry(t *testing.T) {
+	const id = "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a"
+	stored := make(map[string]Message)
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				if _, ok := stored[msg.ID]; ok {
+					return Message{}, ErrDuplicateMessage
+				}
+				stored[msg.ID] = msg
+				return msg, nil
+			},
+			getMessage: func(t *testing.T, id string, sort ReactionSort, reactionType string) (Message, error) {
+				return stored[id], nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Logger:    slogt.New(t),
+		Enrichers: []MessageEnricher{shoutEnricher{}, linkEnricher{}},
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	post := func(req string) int {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	req := `{"id": "` + id + `", "text": "see https://example.com", "user_id": "test"}`
+	checkStatus(t, post(req), 201)
+
+	// The stored message differs from the request, but a retry is enriched
+	// the same way.
+	checkStatus(t, post(req), 200)
+
+	// Attachments are part of the message too.
+	checkStatus(t, post(`{
+		"id": "`+id+`",
+		"text": "see https://example.com",
+		"user_id": "test",
+		"attachments": [{"url": "https://example.com/a.png", "type": "image"}]
+	}`), 409)
+}
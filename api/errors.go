@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// An APIError is an error together with the response it is served as.
+type APIError struct {
+	// Status is the HTTP status of the response.
+	Status int
+	// Code identifies the kind of error in the logs, such as "not_found".
+	// Defaults to a code derived from Status.
+	Code string
+	// Message is the error message shown to the client.
+	Message string
+	// Err is the cause of the error. It is logged but not shown to the
+	// client.
+	Err error
+}
+
+// apiError returns an *APIError responding with status and msg, caused by err.
+func apiError(status int, err error, msg string) *APIError {
+	return &APIError{Status: status, Message: msg, Err: err}
+}
+
+func (e *APIError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// statusCodes are the default codes of APIErrors by status.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusConflict:            "conflict",
+	http.StatusUnprocessableEntity: "unprocessable",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "internal",
+	http.StatusGatewayTimeout:      "timeout",
+	statusClientClosedRequest:      "client_closed_request",
+}
+
+// A handlerFunc is an HTTP handler that returns its errors instead of
+// responding with them.
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// handle adapts h to an http.HandlerFunc responding with the errors h returns.
+func (a *API) handle(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			a.handleError(w, err)
+		}
+	}
+}
+
+// handleError responds with err. Invalid parameters and bodies are served as
+// validation errors, an *APIError as itself, and the sentinel errors with their
+// matching status. Any other error is an internal server error.
+func (a *API) handleError(w http.ResponseWriter, err error) {
+	var (
+		perr   *paramError
+		berr   *bodyError
+		apiErr *APIError
+	)
+	switch {
+	case errors.As(err, &perr):
+		a.respondInvalid(w, "param", perr.Errors)
+		return
+	case errors.As(err, &berr):
+		a.respondInvalid(w, "body", berr.Errors)
+		return
+	case errors.As(err, &apiErr):
+		e := *apiErr
+		apiErr = &e
+	case errors.Is(err, ErrNotFound):
+		apiErr = apiError(http.StatusNotFound, err, "Not found")
+	case errors.Is(err, ErrDuplicateReaction):
+		apiErr = apiError(http.StatusConflict, err, "Reaction already exists")
+	case errors.Is(err, ErrDuplicateMessage):
+		apiErr = apiError(http.StatusConflict, err, "Message ID already in use")
+	default:
+		apiErr = apiError(http.StatusInternalServerError, err, "Internal server error")
+	}
+
+	if apiErr.Status == http.StatusInternalServerError {
+		// The storage layers fail with the error of the request context once
+		// the client went away or the request timed out, which is not a
+		// server error.
+		switch {
+		case errors.Is(err, context.Canceled):
+			apiErr.Status, apiErr.Code, apiErr.Message = statusClientClosedRequest, "", "Client closed request"
+		case errors.Is(err, context.DeadlineExceeded):
+			apiErr.Status, apiErr.Code, apiErr.Message = http.StatusGatewayTimeout, "", "Request timed out"
+		}
+	}
+	if apiErr.Code == "" {
+		apiErr.Code = statusCodes[apiErr.Status]
+	}
+
+	type response struct {
+		APIVersion string `json:"api_version"`
+		Error      string `json:"error"`
+	}
+	a.Logger.Error("Error", "code", apiErr.Code, "error", err.Error())
+	a.writeJSON(w, apiErr.Status, response{APIVersion: APIVersion, Error: apiErr.Message})
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neilotoole/slogt"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api/validator"
+)
+
+func TestAPI_handleError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "NotFound",
+			err:        fmt.Errorf("get message: %w", ErrNotFound),
+			wantStatus: 404,
+			wantBody:   `{"api_version": "1", "error": "Not found"}`,
+		},
+		{
+			name:       "DuplicateReaction",
+			err:        fmt.Errorf("insert: %w", ErrDuplicateReaction),
+			wantStatus: 409,
+			wantBody:   `{"api_version": "1", "error": "Reaction already exists"}`,
+		},
+		{
+			name:       "DuplicateMessage",
+			err:        fmt.Errorf("insert: %w", ErrDuplicateMessage),
+			wantStatus: 409,
+			wantBody:   `{"api_version": "1", "error": "Message ID already in use"}`,
+		},
+		{
+			name:       "Param",
+			err:        &paramError{Errors: []validator.ValidationError{{Field: "Limit", Message: "Limit must be at most 100"}}},
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "param",
+				"errors": [{"Field": "Limit", "Message": "Limit must be at most 100"}]
+			}`,
+		},
+		{
+			name:       "Body",
+			err:        &bodyError{Errors: []validator.ValidationError{{Field: "Text", Message: "Text is required"}}},
+			wantStatus: 400,
+			wantBody: `{
+				"api_version": "1",
+				"kind": "body",
+				"errors": [{"Field": "Text", "Message": "Text is required"}]
+			}`,
+		},
+		{
+			name:       "APIError",
+			err:        apiError(http.StatusUnprocessableEntity, ErrNotFound, "Parent message not found"),
+			wantStatus: 422,
+			wantBody:   `{"api_version": "1", "error": "Parent message not found"}`,
+		},
+		{
+			name:       "WrappedAPIError",
+			err:        fmt.Errorf("create: %w", apiError(http.StatusConflict, nil, "Already exists")),
+			wantStatus: 409,
+			wantBody:   `{"api_version": "1", "error": "Already exists"}`,
+		},
+		{
+			name:       "Canceled",
+			err:        apiError(http.StatusInternalServerError, context.Canceled, "Could not list messages"),
+			wantStatus: 499,
+			wantBody:   `{"api_version": "1", "error": "Client closed request"}`,
+		},
+		{
+			name:       "DeadlineExceeded",
+			err:        fmt.Errorf("scan: %w", context.DeadlineExceeded),
+			wantStatus: 504,
+			wantBody:   `{"api_version": "1", "error": "Request timed out"}`,
+		},
+		{
+			name:       "Unknown",
+			err:        errors.New("something went wrong"),
+			wantStatus: 500,
+			wantBody:   `{"api_version": "1", "error": "Internal server error"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{Logger: slogt.New(t)}
+			h := api.handle(func(w http.ResponseWriter, r *http.Request) error {
+				return tt.err
+			})
+
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest("GET", "/", nil))
+
+			checkStatus(t, rec.Code, tt.wantStatus)
+			checkBody(t, rec.Result(), tt.wantBody)
+		})
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	err := apiError(http.StatusNotFound, ErrNotFound, "Message not found")
+	if got, want := err.Error(), "Message not found: not found"; got != want {
+		t.Errorf("Got error %q, want %q", got, want)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("APIError does not wrap its cause")
+	}
+}
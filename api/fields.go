@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api/validator"
+)
+
+// messageFields are the JSON fields of a message, in the order they are
+// encoded.
+var messageFields = []string{
+	"id",
+	"text",
+	"user_id",
+	"parent_id",
+	"created_at",
+	"pinned",
+	"hidden",
+	"attachments",
+	"reactions",
+	"reaction_count",
+	"reply_count",
+	"reaction_users",
+}
+
+// fieldsParam parses the fields query parameter, the comma-separated fields
+// listed messages are trimmed to. It returns nil if the parameter is absent,
+// in which case messages are listed whole. A *paramError is returned for
+// unknown fields.
+func fieldsParam(r *http.Request) ([]string, error) {
+	if !r.URL.Query().Has("fields") {
+		return nil, nil
+	}
+
+	fields := make([]string, 0)
+	var errs []validator.ValidationError
+	for _, f := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		f = strings.TrimSpace(f)
+		if !slices.Contains(messageFields, f) {
+			errs = append(errs, validator.ValidationError{
+				Field:   "fields",
+				Message: fmt.Sprintf("fields contains unknown field %q", f),
+			})
+			continue
+		}
+		fields = append(fields, f)
+	}
+	if errs != nil {
+		return nil, &paramError{Errors: errs}
+	}
+	return fields, nil
+}
+
+// reactionLoad returns how much of the reactions of listed messages must be
+// loaded. Feeds only need the reaction counts, loading the reactions
+// themselves is opt-in, and messages trimmed to other fields need neither.
+func reactionLoad(r *http.Request, fields []string) ReactionLoad {
+	selected := func(field string) bool {
+		return fields == nil || slices.Contains(fields, field)
+	}
+	switch {
+	case expands(r, "reactions") && selected("reactions"),
+		expands(r, "reaction_users") && selected("reaction_users"):
+		return ReactionsLoaded
+	case selected("reaction_count"):
+		return ReactionsCounted
+	default:
+		return ReactionsOmitted
+	}
+}
+
+// trimMessages encodes msgs with only the given fields.
+func trimMessages(msgs []Message, fields []string) ([]json.RawMessage, error) {
+	out := make([]json.RawMessage, len(msgs))
+	for i, m := range msgs {
+		b, err := trimFields(m, fields)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+// trimFields encodes v, a message or its JSON:API attributes, with only the
+// given fields, in the order of messageFields.
+func trimFields(v any, fields []string) (json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, f := range messageFields {
+		v, ok := all[f]
+		if !ok || !slices.Contains(fields, f) {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:%s", f, v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// respondMessageList responds with a listing of msgs, trimmed to the given
+// fields unless they are nil, and the cursor of the next page unless it is
+// empty. JSON:API documents link to the next page instead.
+func (a *API) respondMessageList(w http.ResponseWriter, r *http.Request, msgs []Message, fields []string, nextCursor string) error {
+	type response struct {
+		Messages   any    `json:"messages"`
+		NextCursor string `json:"next_cursor,omitempty"`
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if acceptsJSONAPI(r) {
+		doc, err := messagesDocument(msgs, false, fields)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, err, "Could not encode messages")
+		}
+		if nextCursor != "" {
+			doc.Links = map[string]string{"next": nextURL(r, nextCursor)}
+		}
+		a.writeJSONAPI(w, http.StatusOK, doc)
+		return nil
+	}
+
+	res := response{Messages: msgs, NextCursor: nextCursor}
+	if fields != nil {
+		trimmed, err := trimMessages(msgs, fields)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, err, "Could not encode messages")
+		}
+		res.Messages = trimmed
+	}
+	a.respond(w, http.StatusOK, res)
+	return nil
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"sync"
+)
+
+// Event types published to the Hub.
+const (
+	EventReaction = "reaction"
+	EventTyping   = "typing"
+)
+
+// An Event is a real-time notification delivered to Hub subscribers.
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// subscriberBuffer is the number of events buffered per subscriber before new
+// events are dropped for it.
+const subscriberBuffer = 16
+
+// A Hub fans out events to live subscribers, such as clients connected to the
+// event stream. Publishing never blocks: events are dropped for subscribers
+// that do not keep up.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewHub returns an empty Hub.
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber. The returned function unsubscribes
+// and must be called once the subscriber is done.
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, ch)
+			h.mu.Unlock()
+		})
+	}
+}
+
+// Publish delivers the event to all current subscribers.
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHub_Publish(t *testing.T) {
+	h := NewHub()
+
+	// Publishing without subscribers must not block.
+	h.Publish(Event{Type: EventReaction})
+
+	events, unsubscribe := h.Subscribe()
+	h.Publish(Event{Type: EventReaction, Data: "1"})
+
+	select {
+	case e := <-events:
+		if e.Type != EventReaction || e.Data != "1" {
+			t.Errorf("Got event %+v, want reaction event with data 1", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No event received")
+	}
+
+	unsubscribe()
+	h.Publish(Event{Type: EventReaction, Data: "2"})
+	select {
+	case e := <-events:
+		t.Errorf("Got event %+v after unsubscribing", e)
+	default:
+	}
+}
+
+func TestHub_Publish_slowSubscriber(t *testing.T) {
+	h := NewHub()
+	_, unsubscribe := h.Subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriberBuffer*2; i++ {
+			h.Publish(Event{Type: EventReaction})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a subscriber that does not read")
+	}
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jsonAPIMediaType is the media type of JSON:API documents. Clients ask for
+// JSON:API output by accepting it.
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// A jsonAPIDocument is the top-level object of a JSON:API response. Data is a
+// single jsonAPIResource or a slice of them.
+type jsonAPIDocument struct {
+	Data     any               `json:"data"`
+	Included []jsonAPIResource `json:"included,omitempty"`
+	Meta     map[string]any    `json:"meta,omitempty"`
+	Links    map[string]string `json:"links,omitempty"`
+}
+
+// A jsonAPIResource is a JSON:API resource object.
+type jsonAPIResource struct {
+	Type          string                         `json:"type"`
+	ID            string                         `json:"id"`
+	Attributes    any                            `json:"attributes"`
+	Relationships map[string]jsonAPIRelationship `json:"relationships,omitempty"`
+}
+
+// A jsonAPIRelationship links a resource to other resources by their
+// identifiers.
+type jsonAPIRelationship struct {
+	Data []jsonAPIIdentifier `json:"data"`
+}
+
+// A jsonAPIIdentifier identifies a resource.
+type jsonAPIIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type messageAttributes struct {
+	Text          string              `json:"text"`
+	UserID        string              `json:"user_id"`
+	ParentID      string              `json:"parent_id,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	Pinned        bool                `json:"pinned"`
+	Hidden        bool                `json:"hidden,omitempty"`
+	Attachments   []Attachment        `json:"attachments,omitempty"`
+	ReactionCount int                 `json:"reaction_count"`
+	ReplyCount    int                 `json:"reply_count"`
+	ReactionUsers map[string][]string `json:"reaction_users,omitempty"`
+}
+
+type reactionAttributes struct {
+	Type      string    `json:"type"`
+	Emoji     string    `json:"emoji,omitempty"`
+	Score     int       `json:"score"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// acceptsJSONAPI reports whether the client asked for JSON:API output in the
+// Accept header.
+func acceptsJSONAPI(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mt := range strings.Split(accept, ",") {
+			mt, _, err := mime.ParseMediaType(strings.TrimSpace(mt))
+			if err == nil && mt == jsonAPIMediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// respondMessages writes msgs as a JSON:API document if the client accepts
+// JSON:API, or body in the response envelope otherwise. A single message is
+// written as a single resource, other slices as a collection.
+func (a *API) respondMessages(w http.ResponseWriter, r *http.Request, status int, body any, msgs []Message, single bool) {
+	w.Header().Add("Vary", "Accept")
+	if !acceptsJSONAPI(r) {
+		a.respond(w, status, body)
+		return
+	}
+
+	doc, err := messagesDocument(msgs, single, nil)
+	if err != nil {
+		a.handleError(w, apiError(http.StatusInternalServerError, err, "Could not encode messages"))
+		return
+	}
+	a.writeJSONAPI(w, status, doc)
+}
+
+// writeJSONAPI writes doc as a JSON:API document.
+func (a *API) writeJSONAPI(w http.ResponseWriter, status int, doc jsonAPIDocument) {
+	w.Header().Set("Content-Type", jsonAPIMediaType)
+	a.writeJSON(w, status, doc)
+}
+
+// messagesDocument returns the JSON:API document of msgs, with the attributes
+// trimmed to the given fields unless they are nil. The reactions are only
+// related and included if the fields select them.
+func messagesDocument(msgs []Message, single bool, fields []string) (jsonAPIDocument, error) {
+	doc := jsonAPIDocument{
+		Included: make([]jsonAPIResource, 0),
+		Meta:     map[string]any{"api_version": APIVersion},
+	}
+	withReactions := fields == nil || slices.Contains(fields, "reactions")
+	resources := make([]jsonAPIResource, len(msgs))
+	for i, m := range msgs {
+		res, err := messageResource(m, fields)
+		if err != nil {
+			return jsonAPIDocument{}, err
+		}
+		resources[i] = res
+		if !withReactions {
+			continue
+		}
+		for _, rc := range m.Reactions {
+			doc.Included = append(doc.Included, reactionResource(rc))
+		}
+	}
+	doc.Data = resources
+	if single && len(resources) == 1 {
+		doc.Data = resources[0]
+	}
+	return doc, nil
+}
+
+// messageResource returns the JSON:API resource of m, with the attributes
+// trimmed to the given fields unless they are nil.
+func messageResource(m Message, fields []string) (jsonAPIResource, error) {
+	attrs := messageAttributes{
+		Text:          m.Text,
+		UserID:        m.UserID,
+		ParentID:      m.ParentID,
+		CreatedAt:     m.CreatedAt,
+		Pinned:        m.Pinned,
+		Hidden:        m.Hidden,
+		Attachments:   m.Attachments,
+		ReactionCount: m.ReactionCount,
+		ReplyCount:    m.ReplyCount,
+		ReactionUsers: m.ReactionUsers,
+	}
+	res := jsonAPIResource{Type: "messages", ID: m.ID, Attributes: attrs}
+	if fields != nil {
+		trimmed, err := trimFields(attrs, fields)
+		if err != nil {
+			return jsonAPIResource{}, err
+		}
+		res.Attributes = trimmed
+	}
+	if fields != nil && !slices.Contains(fields, "reactions") {
+		return res, nil
+	}
+
+	reactions := make([]jsonAPIIdentifier, len(m.Reactions))
+	for i, rc := range m.Reactions {
+		reactions[i] = jsonAPIIdentifier{Type: "reactions", ID: rc.ID}
+	}
+	res.Relationships = map[string]jsonAPIRelationship{
+		"reactions": {Data: reactions},
+	}
+	return res, nil
+}
+
+func reactionResource(rc Reaction) jsonAPIResource {
+	return jsonAPIResource{
+		Type: "reactions",
+		ID:   rc.ID,
+		Attributes: reactionAttributes{
+			Type:      rc.Type,
+			Emoji:     rc.Emoji,
+			Score:     rc.Score,
+			UserID:    rc.UserID,
+			CreatedAt: rc.CreatedAt,
+		},
+	}
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_jsonAPI(t *testing.T) {
+	const messageID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	msg := Message{
+		ID:        messageID,
+		Text:      "hello",
+		UserID:    "testuser",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Reactions: []Reaction{{
+			ID:        "1",
+			MessageID: messageID,
+			Type:      "like",
+			Score:     1,
+			UserID:    "testuser2",
+			CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		ReactionCount: 1,
+	}
+
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		accept          string
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "PlainList",
+			method:          "GET",
+			path:            "/messages",
+			accept:          "application/json",
+			wantContentType: "application/json; charset=utf-8",
+			wantBody: `{
+				"api_version": "1",
+				"data": {
+					"messages": [
+						{
+							"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+							"text": "hello",
+							"user_id": "testuser",
+							"created_at": "2024-01-01T00:00:00Z",
+							"pinned": false,
+							"reactions": [
+								{
+									"id": "1",
+									"type": "like",
+									"score": 1,
+									"user_id": "testuser2",
+									"created_at": "2024-01-01T00:00:00Z"
+								}
+							],
+							"reaction_count": 1,
+							"reply_count": 0
+						}
+					]
+				}
+			}`,
+		},
+		{
+			name:            "JSONAPIList",
+			method:          "GET",
+			path:            "/messages",
+			accept:          "application/vnd.api+json",
+			wantContentType: "application/vnd.api+json",
+			wantBody: `{
+				"data": [
+					{
+						"type": "messages",
+						"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+						"attributes": {
+							"text": "hello",
+							"user_id": "testuser",
+							"created_at": "2024-01-01T00:00:00Z",
+							"pinned": false,
+							"reaction_count": 1,
+							"reply_count": 0
+						},
+						"relationships": {
+							"reactions": {
+								"data": [{"type": "reactions", "id": "1"}]
+							}
+						}
+					}
+				],
+				"included": [
+					{
+						"type": "reactions",
+						"id": "1",
+						"attributes": {
+							"type": "like",
+							"score": 1,
+							"user_id": "testuser2",
+							"created_at": "2024-01-01T00:00:00Z"
+						}
+					}
+				],
+				"meta": {"api_version": "1"}
+			}`,
+		},
+		{
+			name:            "JSONAPIMessage",
+			method:          "POST",
+			path:            "/messages/" + messageID + "/pin",
+			accept:          "text/html, application/vnd.api+json;q=0.9",
+			wantContentType: "application/vnd.api+json",
+			wantBody: `{
+				"data": {
+					"type": "messages",
+					"id": "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+					"attributes": {
+						"text": "hello",
+						"user_id": "testuser",
+						"created_at": "2024-01-01T00:00:00Z",
+						"pinned": false,
+						"reaction_count": 1,
+						"reply_count": 0
+					},
+					"relationships": {
+						"reactions": {
+							"data": [{"type": "reactions", "id": "1"}]
+						}
+					}
+				},
+				"included": [
+					{
+						"type": "reactions",
+						"id": "1",
+						"attributes": {
+							"type": "like",
+							"score": 1,
+							"user_id": "testuser2",
+							"created_at": "2024-01-01T00:00:00Z"
+						}
+					}
+				],
+				"meta": {"api_version": "1"}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						return []Message{msg}, nil
+					},
+					setPinned: func(t *testing.T, id string, pinned bool) (Message, error) {
+						return msg, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+						return nil, nil
+					},
+					setPinned: func(t *testing.T, msg Message) error {
+						return nil
+					},
+				},
+				Logger:         slogt.New(t),
+				ModeratorToken: "mod",
+			}
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept", tt.accept)
+			// Pinning is reserved to moderators.
+			req.Header.Set("Authorization", "Bearer mod")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, 200)
+			if got := resp.Header.Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Got Content-Type %q, want %q", got, tt.wantContentType)
+			}
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_jsonAPI_fieldsAndCursor(t *testing.T) {
+	msg := Message{
+		ID:        "84bd9af7-79e6-4027-b284-9d5d875efd5b",
+		Text:      "hello",
+		UserID:    "testuser",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Reactions: []Reaction{{ID: "1", Type: "like", Score: 1, UserID: "testuser2"}},
+	}
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return []Message{msg}, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/messages?limit=1&fields=text,reply_count", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", jsonAPIMediaType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+
+	var doc struct {
+		Data []struct {
+			ID            string         `json:"id"`
+			Attributes    map[string]any `json:"attributes"`
+			Relationships map[string]any `json:"relationships"`
+		} `json:"data"`
+		Included []any             `json:"included"`
+		Links    map[string]string `json:"links"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Data) != 1 {
+		t.Fatalf("Got %d resources, want 1", len(doc.Data))
+	}
+	wantAttrs := map[string]any{"text": "hello", "reply_count": float64(0)}
+	if diff := cmp.Diff(doc.Data[0].Attributes, wantAttrs); diff != "" {
+		t.Errorf("Attributes diff (-got +want)\n%s", diff)
+	}
+	if doc.Data[0].Relationships != nil || len(doc.Included) != 0 {
+		t.Errorf("Got relationships %v and included %v, want none as reactions are not selected", doc.Data[0].Relationships, doc.Included)
+	}
+
+	// The cursor of the next page is linked like in the Link header.
+	next := doc.Links["next"]
+	if !strings.HasPrefix(next, "/messages?") || !strings.Contains(next, "cursor="+resp.Header.Get("X-Next-Cursor")) {
+		t.Errorf("Got next link %q, want the messages with cursor %q", next, resp.Header.Get("X-Next-Cursor"))
+	}
+}
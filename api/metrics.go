@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// CacheHits returns the number of requests served from the cache alone.
+func (a *API) CacheHits() int64 {
+	return a.cacheHits.Load()
+}
+
+// CacheMisses returns the number of requests that looked up the cache but
+// had to consult the DB.
+func (a *API) CacheMisses() int64 {
+	return a.cacheMisses.Load()
+}
+
+// countCacheLookup counts a request that looked up the cache as a hit, if
+// the cache served it alone, or as a miss.
+func (a *API) countCacheLookup(hit bool) {
+	if hit {
+		a.cacheHits.Add(1)
+	} else {
+		a.cacheMisses.Add(1)
+	}
+}
+
+// CacheStatsLoop logs the number of cache hits and misses and the hit ratio
+// every interval until ctx is done, to help tuning the cache size.
+func (a *API) CacheStatsLoop(ctx context.Context, interval time.Duration) {
+	a.once.Do(a.setupRoutes)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hits, misses := a.CacheHits(), a.CacheMisses()
+			var ratio float64
+			if hits+misses > 0 {
+				ratio = float64(hits) / float64(hits+misses)
+			}
+			a.Logger.Info("Cache stats", "hits", hits, "misses", misses, "hit_ratio", ratio)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_cacheMisses(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return []Message{{ID: "1", Text: "hello"}}, nil
+			},
+			getMessage: func(t *testing.T, messageID string, sort ReactionSort, reactionType string) (Message, error) {
+				return Message{ID: messageID, Text: "hello"}, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	for _, path := range []string{"/messages", "/messages/84bd9af7-79e6-4027-b284-9d5d875efd5b"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkStatus(t, resp.StatusCode, 200)
+	}
+
+	if got := api.CacheMisses(); got != 2 {
+		t.Errorf("Got %d misses, want 2", got)
+	}
+	if got := api.CacheHits(); got != 0 {
+		t.Errorf("Got %d hits, want 0", got)
+	}
+}
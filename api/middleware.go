@@ -0,0 +1,339 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api/validator"
+)
+
+// statusRecorder wraps an http.ResponseWriter and records the status code
+// written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter so http.ResponseController
+// can reach optional interfaces such as http.Flusher.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequests logs every request before it is dispatched and logs the
+// response status and latency after the handler returns.
+func (a *API) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		a.Logger.Info("Request received", "method", r.Method, "path", r.URL.Path, "client_ip", a.ClientIP(r))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		a.Logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// rateLimit limits the number of requests per client IP to RateLimit per
+// RateLimitWindow. The limit, the remaining requests and the time the window
+// resets are reported in the X-RateLimit headers of every response, so that
+// clients can throttle themselves before they are limited.
+func (a *API) rateLimit(next http.Handler) http.Handler {
+	if a.RateLimiter == nil {
+		return next
+	}
+	limit := a.RateLimit
+	if limit <= 0 {
+		limit = defaultRateLimit
+	}
+	window := a.RateLimitWindow
+	if window <= 0 {
+		window = defaultRateLimitWindow
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := a.ClientIP(r)
+		count, reset, err := a.RateLimiter.Hit(r.Context(), key, window)
+		if err != nil {
+			// Fail open, an unavailable limiter should not take the API down.
+			a.Logger.Error("Could not check rate limit", "error", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(max(limit-count, 0)))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if count > limit {
+			retryAfter := max(int(math.Ceil(time.Until(reset).Seconds())), 1)
+			h.Set("Retry-After", strconv.Itoa(retryAfter))
+			a.handleError(w, apiError(http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded for %s", key), "Too many requests"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the IP address of the client that made r. Behind one of the
+// TrustedProxies the address is read from the X-Forwarded-For header, skipping
+// the trusted proxies from the right since the left-most entries can be forged
+// by the client, or else from the X-Real-IP header. Otherwise it is the address
+// of the peer.
+func (a *API) ClientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !a.trustedProxy(peer) {
+		return peer
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				// The chain is malformed from here on, it can't be trusted.
+				break
+			}
+			if i == 0 || !a.trustedProxy(hop) {
+				return hop
+			}
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		if _, err := netip.ParseAddr(ip); err == nil {
+			return ip
+		}
+	}
+	return peer
+}
+
+// trustedProxy reports whether ip is in one of the TrustedProxies.
+func (a *API) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range a.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// prettyWriter marks a response whose JSON body is indented for readability.
+type prettyWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap returns the underlying http.ResponseWriter so http.ResponseController
+// can reach optional interfaces such as http.Flusher.
+func (pw prettyWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// prettyPrint indents the JSON responses, including errors, to requests with
+// pretty=true, which is handy when debugging. Responses are compact by
+// default.
+func (a *API) prettyPrint(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Query().Get("pretty")
+		if p == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := a.validateParam(p, "boolean"); err != nil {
+			a.handleError(w, err)
+			return
+		}
+		if pretty, _ := strconv.ParseBool(p); pretty {
+			w = prettyWriter{w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectEmptySegments responds with a param validation error to requests with
+// an empty path segment, such as POST /messages//reactions. The mux would
+// redirect them to the cleaned path, which belongs to a different route. The
+// errors name the wildcards of the route that are empty.
+func (a *API) rejectEmptySegments(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "//") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// Look up the route with the empty segments filled in.
+		segments := strings.Split(r.URL.Path, "/")
+		filled := slices.Clone(segments)
+		for i := 1; i < len(filled); i++ {
+			if filled[i] == "" {
+				filled[i] = "_"
+			}
+		}
+		lookup := r.Clone(r.Context())
+		lookup.URL.Path = strings.Join(filled, "/")
+		_, pattern := mux.Handler(lookup)
+		_, path, _ := strings.Cut(pattern, " ")
+		if path == "" {
+			path = pattern
+		}
+		wildcards := strings.Split(path, "/")
+
+		// A path with "//" always has an empty segment before its last one.
+		var errs []validator.ValidationError
+		for i := 1; i < len(segments)-1; i++ {
+			if segments[i] != "" {
+				continue
+			}
+			msg := fmt.Sprintf("Path segment %d must not be empty", i)
+			var name string
+			if i < len(wildcards) && strings.HasPrefix(wildcards[i], "{") {
+				name = strings.Trim(wildcards[i], "{.}")
+				msg = name + " must not be empty"
+			}
+			errs = append(errs, validator.ValidationError{Field: name, Message: msg})
+		}
+		a.handleError(w, &paramError{Errors: errs})
+	})
+}
+
+// requireAdmin only passes requests authenticated with the AdminToken as a
+// bearer token to next.
+func (a *API) requireAdmin(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			a.handleError(w, apiError(http.StatusUnauthorized, errors.New("invalid admin token"), "Unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// A Role determines what a client may do.
+type Role int
+
+const (
+	// RoleUser is the role of all clients by default.
+	RoleUser Role = iota
+	// RoleModerator may pin and hide messages and is shown hidden messages.
+	RoleModerator
+)
+
+type (
+	roleKey struct{}
+	userKey struct{}
+)
+
+// roleFrom returns the role authenticate stored in ctx.
+func roleFrom(ctx context.Context) Role {
+	role, _ := ctx.Value(roleKey{}).(Role)
+	return role
+}
+
+// userFrom returns the id of the user authenticate stored in ctx, or "" for
+// anonymous requests.
+func userFrom(ctx context.Context) string {
+	userID, _ := ctx.Value(userKey{}).(string)
+	return userID
+}
+
+// authenticate stores the role of the client and the id of the user it
+// authenticated as in the request context. Clients authenticated with the
+// ModeratorToken or the AdminToken as a bearer token are moderators, all
+// others are users. Clients authenticated with one of the UserTokens act as
+// its user.
+func (a *API) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := RoleUser
+		var userID string
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			for _, t := range []string{a.ModeratorToken, a.AdminToken} {
+				if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+					role = RoleModerator
+				}
+			}
+			for t, id := range a.UserTokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+					userID = id
+				}
+			}
+		}
+		ctx := context.WithValue(r.Context(), roleKey{}, role)
+		ctx = context.WithValue(ctx, userKey{}, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireModerator only passes requests of moderators to next. Anonymous
+// requests are unauthorized, authenticated users are forbidden.
+func (a *API) requireModerator(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if roleFrom(r.Context()) == RoleModerator {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if userFrom(r.Context()) != "" {
+			a.handleError(w, apiError(http.StatusForbidden, errors.New("not a moderator"), "Forbidden"))
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		a.handleError(w, apiError(http.StatusUnauthorized, errors.New("not a moderator"), "Unauthorized"))
+	})
+}
+
+// bodyDiscarder is an http.ResponseWriter that drops the response body.
+type bodyDiscarder struct {
+	http.ResponseWriter
+}
+
+func (d bodyDiscarder) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// withoutBody serves HEAD requests with next, keeping the headers next writes,
+// including Content-Length, but dropping the body.
+func withoutBody(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(bodyDiscarder{w}, r)
+	})
+}
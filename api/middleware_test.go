@@ -0,0 +1,366 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_logRequests(t *testing.T) {
+	buf := &bytes.Buffer{}
+	api := &API{
+		DB: &testdb{
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Cache: &testcache{
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Logger: slog.New(slog.NewTextHandler(buf, nil)),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+	checkLog(t, buf, "Request completed")
+	checkLog(t, buf, "status=200")
+	checkLog(t, buf, "duration=")
+}
+
+func TestAPI_rateLimit(t *testing.T) {
+	reset := time.Now().Add(time.Minute).Truncate(time.Second)
+	limiter := &testlimiter{reset: reset}
+	api := &API{
+		DB: &testdb{
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				msg.ID = "1"
+				return msg, nil
+			},
+		},
+		Cache: &testcache{
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Logger:      slogt.New(t),
+		RateLimiter: limiter,
+		RateLimit:   2,
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	for i, want := range []struct {
+		status    int
+		remaining string
+	}{
+		{201, "1"},
+		{201, "0"},
+		{429, "0"},
+	} {
+		resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{"text": "hello", "user_id": "test"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkStatus(t, resp.StatusCode, want.status)
+		if got := resp.Header.Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("Request %d: got X-RateLimit-Limit %q, want 2", i, got)
+		}
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got != want.remaining {
+			t.Errorf("Request %d: got X-RateLimit-Remaining %q, want %s", i, got, want.remaining)
+		}
+		if got, want := resp.Header.Get("X-RateLimit-Reset"), strconv.FormatInt(reset.Unix(), 10); got != want {
+			t.Errorf("Request %d: got X-RateLimit-Reset %q, want %s", i, got, want)
+		}
+	}
+	if limiter.window != time.Minute {
+		t.Errorf("Got window %s, want 1m", limiter.window)
+	}
+}
+
+func TestAPI_rateLimit_moderation(t *testing.T) {
+	api := &API{
+		DB:          &testdb{},
+		Cache:       &testcache{},
+		Logger:      slogt.New(t),
+		RateLimiter: &testlimiter{reset: time.Now().Add(time.Minute)},
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/pin"},
+		{http.MethodDelete, "/pin"},
+		{http.MethodPost, "/hide"},
+		{http.MethodDelete, "/hide"},
+	} {
+		req, err := http.NewRequest(route.method, srv.URL+"/messages/84bd9af7-79e6-4027-b284-9d5d875efd5b"+route.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got == "" {
+			t.Errorf("%s %s: got no X-RateLimit-Remaining header, want one", route.method, route.path)
+		}
+	}
+}
+
+func TestAPI_rateLimit_getNotLimited(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Cache: &testcache{
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Logger:      slogt.New(t),
+		RateLimiter: &testlimiter{},
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "" {
+		t.Errorf("Got X-RateLimit-Remaining %q, want none", got)
+	}
+}
+
+func TestAPI_ClientIP(t *testing.T) {
+	api := &API{
+		TrustedProxies: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("fd00::/8"),
+		},
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{
+			name:       "NoProxy",
+			remoteAddr: "203.0.113.7:1234",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "UntrustedPeer",
+			remoteAddr: "203.0.113.7:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.2"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "TrustedPeer",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "TrustedPeerIPv6",
+			remoteAddr: "[fd00::1]:1234",
+			header:     http.Header{"X-Forwarded-For": {"2001:db8::1"}},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "ProxyChain",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"192.0.2.9, 198.51.100.1", "10.0.0.2"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "AllTrusted",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "RealIP",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Real-Ip": {"198.51.100.2"}},
+			want:       "198.51.100.2",
+		},
+		{
+			name:       "InvalidHeaders",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"unknown"}, "X-Real-Ip": {"not-an-ip"}},
+			want:       "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/messages", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			if got := api.ClientIP(r); got != tt.want {
+				t.Errorf("Got client IP %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPI_rateLimit_trustedProxy(t *testing.T) {
+	limiter := &testlimiter{}
+	api := &API{
+		DB: &testdb{
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				msg.ID = "1"
+				return msg, nil
+			},
+		},
+		Cache: &testcache{
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Logger:         slogt.New(t),
+		RateLimiter:    limiter,
+		RateLimit:      1,
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	// Clients behind the proxy are limited separately.
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		req, _ := http.NewRequest("POST", srv.URL+"/messages", strings.NewReader(`{"text": "hello", "user_id": "test"}`))
+		req.Header.Set("X-Forwarded-For", client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkStatus(t, resp.StatusCode, 201)
+	}
+}
+
+func TestAPI_prettyPrint(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Cache: &testcache{
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, withReactions bool) ([]Message, error) {
+				return nil, nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{
+			name: "Compact",
+			path: "/messages",
+			want: `{"api_version":"1","data":{"messages":[]}}` + "\n",
+		},
+		{
+			name: "Pretty",
+			path: "/messages?pretty=true",
+			want: `{
+  "api_version": "1",
+  "data": {
+    "messages": []
+  }
+}
+`,
+		},
+		{
+			name: "PrettyError",
+			path: "/messages/not-a-uuid/thread?pretty=true",
+			want: `{
+  "api_version": "1",
+  "kind": "param",
+  "errors": [
+    {
+      "Field": "",
+      "Message": "Key: '' Error:Field validation for '' failed on the 'uuid' tag"
+    }
+  ]
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Got body\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// testlimiter is an in-memory RateLimiter with a single window.
+type testlimiter struct {
+	mu     sync.Mutex
+	counts map[string]int
+	reset  time.Time
+	window time.Duration
+}
+
+func (l *testlimiter) Hit(_ context.Context, key string, window time.Duration) (int, time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts == nil {
+		l.counts = make(map[string]int)
+	}
+	l.counts[key]++
+	l.window = window
+	return l.counts[key], l.reset, nil
+}
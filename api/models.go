@@ -1,23 +1,168 @@
 package api
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // A Message represents a persisted message.
 type Message struct {
-	ID            string     `json:"id"`
-	Text          string     `json:"text"`
-	UserID        string     `json:"user_id"`
-	CreatedAt     time.Time  `json:"created_at"`
-	Reactions     []Reaction `json:"reactions"`
-	ReactionCount int        `json:"reaction_count"`
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	UserID    string    `json:"user_id"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Pinned    bool      `json:"pinned"`
+	// Hidden is set for messages hidden by a moderator, which are only
+	// listed for moderators.
+	Hidden        bool         `json:"hidden,omitempty"`
+	Attachments   []Attachment `json:"attachments,omitempty"`
+	Reactions     []Reaction   `json:"reactions"`
+	ReactionCount int          `json:"reaction_count"`
+	ReplyCount    int          `json:"reply_count"`
+	// ReactionUsers maps each reaction type to the users that reacted with
+	// it. Only set when requested with expand=reaction_users.
+	ReactionUsers map[string][]string `json:"reaction_users,omitempty"`
+}
+
+// messageJSON is a Message without its MarshalJSON method.
+type messageJSON Message
+
+// MarshalJSON encodes m with nil Reactions as an empty list, so that clients
+// always get a list whichever code path built the message.
+func (m Message) MarshalJSON() ([]byte, error) {
+	if m.Reactions == nil {
+		m.Reactions = []Reaction{}
+	}
+	return json.Marshal(messageJSON(m))
+}
+
+// A SearchResult is a message matching a search query.
+type SearchResult struct {
+	Message
+	// Highlight is the text of the message with the matches of the query
+	// wrapped in <mark> tags. The rest of the text is HTML-escaped.
+	Highlight string
+}
+
+// An Order is the order messages are listed in by creation time.
+type Order string
+
+const (
+	// OrderDesc lists the newest messages first.
+	OrderDesc Order = "desc"
+	// OrderAsc lists the oldest messages first.
+	OrderAsc Order = "asc"
+)
+
+// A ReactionLoad is how much of the reactions of listed messages is loaded.
+type ReactionLoad int
+
+const (
+	// ReactionsCounted only loads the number of reactions of each message.
+	ReactionsCounted ReactionLoad = iota
+	// ReactionsLoaded loads the reactions of each message.
+	ReactionsLoaded
+	// ReactionsOmitted loads neither the reactions nor their number, which is
+	// left at zero.
+	ReactionsOmitted
+)
+
+// A ReactionSort is the order the reactions of a message are listed in.
+type ReactionSort string
+
+const (
+	// ReactionSortCreated lists the oldest reactions first.
+	ReactionSortCreated ReactionSort = "created"
+	// ReactionSortScore lists the highest-scored reactions first, the newest
+	// first among equal scores.
+	ReactionSortScore ReactionSort = "score"
+)
+
+// A ThreadMessage is a message in a thread. Depth is the number of replies
+// between the message and the root of the thread, which has depth 0.
+type ThreadMessage struct {
+	Message
+	Depth int `json:"depth"`
+}
+
+// MarshalJSON encodes m like a Message followed by its depth. It is needed
+// since the promoted Message.MarshalJSON would leave out the depth.
+func (m ThreadMessage) MarshalJSON() ([]byte, error) {
+	if m.Reactions == nil {
+		m.Reactions = []Reaction{}
+	}
+	return json.Marshal(struct {
+		messageJSON
+		Depth int `json:"depth"`
+	}{messageJSON(m.Message), m.Depth})
+}
+
+// An Attachment references a file attached to a message, such as an image.
+type Attachment struct {
+	URL  string `json:"url"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
 }
 
 // A Reaction represents a reaction to a message such as a like.
 type Reaction struct {
-	ID        string    `json:"id"`
-	MessageID string    `json:"-"`
-	Type      string    `json:"type"`
+	ID        string `json:"id"`
+	MessageID string `json:"-"`
+	Type      string `json:"type"`
+	// Emoji is an optional single unicode emoji shown for the reaction.
+	Emoji     string    `json:"emoji,omitempty"`
 	Score     int       `json:"score"`
 	UserID    string    `json:"user_id"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// A ReactionToggle is the outcome of toggling a user's reaction to a message.
+type ReactionToggle struct {
+	// Active reports whether the user reacts with the type after the
+	// toggle.
+	Active bool
+	// Reactions holds the created reaction if Active, the deleted ones
+	// otherwise.
+	Reactions []Reaction
+	// Count is the number of reactions of the type the message has after
+	// the toggle.
+	Count int
+}
+
+// A ReactionType describes how clients display a reaction type, such as in a
+// reaction picker.
+type ReactionType struct {
+	// Name is the canonical name of the type.
+	Name  string `json:"name"`
+	Emoji string `json:"emoji,omitempty"`
+	// DefaultScore is the score of reactions of the type created without
+	// one. Zero means API.DefaultReactionScore.
+	DefaultScore int `json:"default_score"`
+}
+
+// A ScoreRange bounds the score of a reaction, both ends inclusive.
+type ScoreRange struct {
+	Min int
+	Max int
+}
+
+// A ReactionEvent is published to the Hub when a reaction is created.
+type ReactionEvent struct {
+	MessageID string `json:"message_id"`
+	Reaction
+}
+
+// A TypingEvent is published to the Hub when a user starts typing.
+type TypingEvent struct {
+	UserID string `json:"user_id"`
+}
+
+// A ReactionSummary aggregates the reactions of a message.
+type ReactionSummary struct {
+	// Counts holds the number of reactions per reaction type.
+	Counts map[string]int `json:"counts"`
+	Total  int            `json:"total"`
+	Score  int            `json:"score"`
+}
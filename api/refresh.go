@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RefreshLoop reloads the latest messages from the DB into the cache every
+// interval until ctx is done, so that the cache stays fresh even without
+// writes going through the API. Failed reloads are logged and retried on the
+// next tick.
+func (a *API) RefreshLoop(ctx context.Context, interval time.Duration) {
+	// The loop may start before the first request, default the optional
+	// dependencies the same way.
+	a.once.Do(a.setupRoutes)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := a.refreshCache(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					a.Logger.Error("Could not refresh cache", "error", err.Error())
+				}
+				continue
+			}
+			a.Logger.Debug("Refreshed cache", "count", n)
+		}
+	}
+}
+
+// refreshCache loads the latest messages and their reactions from the DB into
+// the cache and returns the number of messages loaded. Hidden messages are
+// loaded too, the cache holds them flagged.
+func (a *API) refreshCache(ctx context.Context) (int, error) {
+	size := a.RefreshSize
+	if size <= 0 {
+		size = defaultRefreshSize
+	}
+
+	msgs, err := a.DB.ListMessages(ctx, a.now(), OrderDesc, size, 0, ReactionsLoaded, true)
+	if err != nil {
+		return 0, fmt.Errorf("list messages: %w", err)
+	}
+
+	for _, msg := range msgs {
+		if err := a.Cache.InsertMessage(ctx, msg); err != nil {
+			return 0, fmt.Errorf("cache message %s: %w", msg.ID, err)
+		}
+		for _, reaction := range msg.Reactions {
+			if err := a.Cache.InsertReaction(ctx, msg.ID, reaction); err != nil {
+				return 0, fmt.Errorf("cache reaction %s: %w", reaction.ID, err)
+			}
+		}
+	}
+	return len(msgs), nil
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_RefreshLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	msgs := []Message{
+		{ID: "2", Text: "world", Reactions: []Reaction{{ID: "r1", MessageID: "2", Type: "like"}}},
+		{ID: "1", Text: "hello", Hidden: true, Reactions: []Reaction{}},
+	}
+	// Later reloads repeat the inserts, the test only looks at the first ones.
+	cached := make(chan string, len(msgs)+1)
+	record := func(s string) {
+		select {
+		case cached <- s:
+		default:
+		}
+	}
+	fail := true
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				if limit != 5 || offset != 0 || reactions != ReactionsLoaded || !withHidden {
+					t.Errorf("Got limit %d, offset %d, reactions %d and withHidden %t, want 5, 0, %d and true", limit, offset, reactions, withHidden, ReactionsLoaded)
+				}
+				// The first reload fails, the loop keeps going.
+				if fail {
+					fail = false
+					return nil, errors.New("connection refused")
+				}
+				return msgs, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				record("message " + msg.ID)
+				return nil
+			},
+			insertReaction: func(t *testing.T, reaction Reaction) error {
+				record("reaction " + reaction.ID)
+				return nil
+			},
+		},
+		Logger:      slogt.New(t),
+		RefreshSize: 5,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		api.RefreshLoop(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	want := []string{"message 2", "reaction r1", "message 1"}
+	for _, w := range want {
+		select {
+		case got := <-cached:
+			if got != w {
+				t.Fatalf("Got %s cached, want %s", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s to be cached", w)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RefreshLoop did not return after cancellation")
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	defaultRetryAttempts  = 3
+	defaultRetryBaseDelay = 50 * time.Millisecond
+	defaultRetryMaxDelay  = time.Second
+)
+
+// RetryDB is a DB that retries read operations failing with a transient error,
+// waiting with exponential backoff between attempts. Writes are passed through
+// without retrying, since a write that failed mid-flight may already have been
+// applied and retrying it could create duplicates.
+type RetryDB struct {
+	DB
+
+	// IsTransient reports whether an error is worth retrying. Required;
+	// without it no errors are retried.
+	IsTransient func(error) bool
+	// Attempts is the maximum number of attempts, including the first one.
+	// Defaults to 3.
+	Attempts int
+	// BaseDelay is the wait before the first retry, doubled for every
+	// subsequent one. Defaults to 50ms.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts. Defaults to 1s.
+	MaxDelay time.Duration
+}
+
+// ListMessages calls the underlying DB's ListMessages, retrying on transient
+// errors.
+func (r *RetryDB) ListMessages(ctx context.Context, before time.Time, order Order, limit, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+	return retry(ctx, r, func() ([]Message, error) {
+		return r.DB.ListMessages(ctx, before, order, limit, offset, reactions, withHidden, excludeMsgIDs...)
+	})
+}
+
+// GetMessage calls the underlying DB's GetMessage, retrying on transient
+// errors.
+func (r *RetryDB) GetMessage(ctx context.Context, messageID string, sort ReactionSort, reactionType string) (Message, error) {
+	return retry(ctx, r, func() (Message, error) {
+		return r.DB.GetMessage(ctx, messageID, sort, reactionType)
+	})
+}
+
+// GetReaction calls the underlying DB's GetReaction, retrying on transient
+// errors.
+func (r *RetryDB) GetReaction(ctx context.Context, messageID, reactionID string) (Reaction, error) {
+	return retry(ctx, r, func() (Reaction, error) {
+		return r.DB.GetReaction(ctx, messageID, reactionID)
+	})
+}
+
+// GetReactionByID calls the underlying DB's GetReactionByID, retrying on
+// transient errors.
+func (r *RetryDB) GetReactionByID(ctx context.Context, reactionID string) (Reaction, error) {
+	return retry(ctx, r, func() (Reaction, error) {
+		return r.DB.GetReactionByID(ctx, reactionID)
+	})
+}
+
+// SearchMessages calls the underlying DB's SearchMessages, retrying on
+// transient errors.
+func (r *RetryDB) SearchMessages(ctx context.Context, query string, limit, offset int, reactions ReactionLoad, withHidden bool) ([]SearchResult, error) {
+	return retry(ctx, r, func() ([]SearchResult, error) {
+		return r.DB.SearchMessages(ctx, query, limit, offset, reactions, withHidden)
+	})
+}
+
+// FindReaction calls the underlying DB's FindReaction, retrying on transient
+// errors.
+func (r *RetryDB) FindReaction(ctx context.Context, messageID, userID, reactionType string) (Reaction, error) {
+	return retry(ctx, r, func() (Reaction, error) {
+		return r.DB.FindReaction(ctx, messageID, userID, reactionType)
+	})
+}
+
+// ListReactionsByUser calls the underlying DB's ListReactionsByUser, retrying
+// on transient errors.
+func (r *RetryDB) ListReactionsByUser(ctx context.Context, userID string) ([]Reaction, error) {
+	return retry(ctx, r, func() ([]Reaction, error) {
+		return r.DB.ListReactionsByUser(ctx, userID)
+	})
+}
+
+// LatestMessageTime calls the underlying DB's LatestMessageTime, retrying on
+// transient errors.
+func (r *RetryDB) LatestMessageTime(ctx context.Context) (time.Time, error) {
+	return retry(ctx, r, func() (time.Time, error) {
+		return r.DB.LatestMessageTime(ctx)
+	})
+}
+
+// CountMessages calls the underlying DB's CountMessages, retrying on
+// transient errors.
+func (r *RetryDB) CountMessages(ctx context.Context) (int, error) {
+	return retry(ctx, r, func() (int, error) {
+		return r.DB.CountMessages(ctx)
+	})
+}
+
+// CountReactions calls the underlying DB's CountReactions, retrying on
+// transient errors.
+func (r *RetryDB) CountReactions(ctx context.Context, messageID string) (int, error) {
+	return retry(ctx, r, func() (int, error) {
+		return r.DB.CountReactions(ctx, messageID)
+	})
+}
+
+// GetThread calls the underlying DB's GetThread, retrying on transient
+// errors.
+func (r *RetryDB) GetThread(ctx context.Context, messageID string, maxDepth, limit int, withHidden bool) ([]ThreadMessage, error) {
+	return retry(ctx, r, func() ([]ThreadMessage, error) {
+		return r.DB.GetThread(ctx, messageID, maxDepth, limit, withHidden)
+	})
+}
+
+// ReactionSummary calls the underlying DB's ReactionSummary, retrying on
+// transient errors.
+func (r *RetryDB) ReactionSummary(ctx context.Context, messageID string) (ReactionSummary, error) {
+	return retry(ctx, r, func() (ReactionSummary, error) {
+		return r.DB.ReactionSummary(ctx, messageID)
+	})
+}
+
+// ListMessagesAfter calls the underlying DB's ListMessagesAfter, retrying on
+// transient errors.
+func (r *RetryDB) ListMessagesAfter(ctx context.Context, afterID string, limit int, reactions ReactionLoad, withHidden bool) ([]Message, error) {
+	return retry(ctx, r, func() ([]Message, error) {
+		return r.DB.ListMessagesAfter(ctx, afterID, limit, reactions, withHidden)
+	})
+}
+
+// ListMessagesBetween calls the underlying DB's ListMessagesBetween, retrying
+// on transient errors.
+func (r *RetryDB) ListMessagesBetween(ctx context.Context, from, to time.Time, limit, offset int, reactions ReactionLoad, withHidden bool) ([]Message, error) {
+	return retry(ctx, r, func() ([]Message, error) {
+		return r.DB.ListMessagesBetween(ctx, from, to, limit, offset, reactions, withHidden)
+	})
+}
+
+// ScoreHistogram calls the underlying DB's ScoreHistogram, retrying on
+// transient errors.
+func (r *RetryDB) ScoreHistogram(ctx context.Context, messageID string) (map[int]int, error) {
+	return retry(ctx, r, func() (map[int]int, error) {
+		return r.DB.ScoreHistogram(ctx, messageID)
+	})
+}
+
+// EmojiCounts calls the underlying DB's EmojiCounts, retrying on transient
+// errors.
+func (r *RetryDB) EmojiCounts(ctx context.Context, messageID string) (map[string]int, error) {
+	return retry(ctx, r, func() (map[string]int, error) {
+		return r.DB.EmojiCounts(ctx, messageID)
+	})
+}
+
+func retry[T any](ctx context.Context, r *RetryDB, fn func() (T, error)) (T, error) {
+	attempts := r.Attempts
+	if attempts < 1 {
+		attempts = defaultRetryAttempts
+	}
+	delay := r.BaseDelay
+	if delay <= 0 {
+		delay = defaultRetryBaseDelay
+	}
+	maxDelay := r.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := fn()
+		if err == nil || attempt >= attempts || r.IsTransient == nil || !r.IsTransient(err) {
+			return res, err
+		}
+
+		timer := time.NewTimer(min(delay, maxDelay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("connection reset")
+
+func isTestTransient(err error) bool { return errors.Is(err, errTransient) }
+
+func TestRetryDB_ListMessages(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		wantAttempts int
+	}{
+		{
+			name:         "SucceedsOnSecondTry",
+			failures:     1,
+			err:          errTransient,
+			wantAttempts: 2,
+		},
+		{
+			name:         "GivesUpAfterAttempts",
+			failures:     5,
+			err:          errTransient,
+			wantAttempts: 3,
+		},
+		{
+			name:         "PermanentError",
+			failures:     5,
+			err:          errors.New("syntax error"),
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			db := &RetryDB{
+				DB: &testdb{
+					T: t,
+					listMessages: func(t *testing.T, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+						attempts++
+						if attempts <= tt.failures {
+							return nil, tt.err
+						}
+						return []Message{{ID: "1"}}, nil
+					},
+				},
+				IsTransient: isTestTransient,
+				BaseDelay:   time.Millisecond,
+			}
+
+			msgs, err := db.ListMessages(context.Background(), time.Now(), OrderDesc, 10, 0, ReactionsCounted, false)
+			if attempts != tt.wantAttempts {
+				t.Errorf("ListMessages() made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if attempts > tt.failures {
+				if err != nil {
+					t.Fatalf("ListMessages() error = %v", err)
+				}
+				if len(msgs) != 1 {
+					t.Errorf("ListMessages() returned %d messages, want 1", len(msgs))
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ListMessages() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRetryDB_contextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	db := &RetryDB{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, before time.Time, order Order, limit int, offset int, reactions ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]Message, error) {
+				attempts++
+				cancel()
+				return nil, errTransient
+			},
+		},
+		IsTransient: isTestTransient,
+		BaseDelay:   time.Hour,
+	}
+
+	if _, err := db.ListMessages(ctx, time.Now(), OrderDesc, 10, 0, ReactionsCounted, false); !errors.Is(err, errTransient) {
+		t.Errorf("ListMessages() error = %v, want %v", err, errTransient)
+	}
+	if attempts != 1 {
+		t.Errorf("ListMessages() made %d attempts, want 1", attempts)
+	}
+}
+
+func TestRetryDB_writesNotRetried(t *testing.T) {
+	attempts := 0
+	db := &RetryDB{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				attempts++
+				return Message{}, errTransient
+			},
+		},
+		IsTransient: isTestTransient,
+		BaseDelay:   time.Millisecond,
+	}
+
+	if _, err := db.InsertMessage(context.Background(), Message{}); !errors.Is(err, errTransient) {
+		t.Errorf("InsertMessage() error = %v, want %v", err, errTransient)
+	}
+	if attempts != 1 {
+		t.Errorf("InsertMessage() made %d attempts, want 1", attempts)
+	}
+}
@@ -0,0 +1,92 @@
+package validator
+
+import "unicode/utf8"
+
+const (
+	zeroWidthJoiner   = '\u200d'
+	variationSelector = '\ufe0f'
+	combiningKeycap   = '\u20e3'
+)
+
+// maxEmojiLength is the number of characters the emoji column of the reactions
+// table holds.
+const maxEmojiLength = 64
+
+// isEmoji reports whether s consists of exactly one emoji, including
+// multi-codepoint emoji such as flags, keycaps, skin tone variants and
+// sequences joined with a zero width joiner. Sequences longer than the emoji
+// column are rejected.
+func isEmoji(s string) bool {
+	if s == "" || utf8.RuneCountInString(s) > maxEmojiLength {
+		return false
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	s = s[size:]
+
+	switch {
+	case isRegionalIndicator(r):
+		// Flags are exactly two regional indicators.
+		r2, size := utf8.DecodeRuneInString(s)
+		return isRegionalIndicator(r2) && len(s) == size
+	case isKeycapBase(r):
+		// Keycaps are a digit, # or *, optionally followed by a variation
+		// selector, followed by the combining keycap.
+		if r, size := utf8.DecodeRuneInString(s); r == variationSelector {
+			s = s[size:]
+		}
+		return s == string(combiningKeycap)
+	case !isPictographic(r):
+		return false
+	}
+
+	for s != "" {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		switch {
+		case r == variationSelector, isSkinTone(r), isTag(r):
+		case r == zeroWidthJoiner:
+			next, size := utf8.DecodeRuneInString(s)
+			if !isPictographic(next) {
+				return false
+			}
+			s = s[size:]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1f1e6 && r <= 0x1f1ff }
+
+func isKeycapBase(r rune) bool { return r >= '0' && r <= '9' || r == '#' || r == '*' }
+
+func isSkinTone(r rune) bool { return r >= 0x1f3fb && r <= 0x1f3ff }
+
+// isTag reports whether r is a tag character, used in subdivision flags.
+func isTag(r rune) bool { return r >= 0xe0020 && r <= 0xe007f }
+
+// isPictographic approximates the Extended_Pictographic Unicode property,
+// which the standard library does not expose.
+func isPictographic(r rune) bool {
+	switch {
+	case r == 0xa9, r == 0xae, r == 0x203c, r == 0x2049, r == 0x2122, r == 0x2139:
+		return true
+	case r >= 0x2194 && r <= 0x21aa:
+		return true
+	case r >= 0x231a && r <= 0x23ff:
+		return true
+	case r >= 0x24c2 && r <= 0x27bf:
+		return true
+	case r >= 0x2934 && r <= 0x2935, r >= 0x2b05 && r <= 0x2b55:
+		return true
+	case r == 0x3030, r == 0x303d, r == 0x3297, r == 0x3299:
+		return true
+	case r >= 0x1f000 && r <= 0x1faff:
+		// Mahjong tiles through Symbols and Pictographs Extended-A, minus the
+		// modifiers and regional indicators handled separately.
+		return !isRegionalIndicator(r) && !isSkinTone(r)
+	default:
+		return false
+	}
+}
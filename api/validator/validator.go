@@ -1,9 +1,16 @@
 package validator
 
 import (
+	"regexp"
+
 	"github.com/go-playground/validator/v10"
 )
 
+// DefaultUserIDPattern is the pattern user IDs are validated against unless
+// configured otherwise with WithUserIDPattern. User IDs are free-form, but
+// limited to a safe set of characters and the size of the user_id columns.
+var DefaultUserIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,255}$`)
+
 // Validator is a struct that provides methods for struct validation using the underlying validator library.
 type Validator struct {
 	cli *validator.Validate
@@ -46,9 +53,39 @@ func (v *Validator) Validate(value interface{}, tag string) []ValidationError {
 	return nil
 }
 
+// An Option configures a Validator.
+type Option func(*options)
+
+type options struct {
+	userIDPattern *regexp.Regexp
+}
+
+// WithUserIDPattern sets the pattern used by the user_id validation tag.
+func WithUserIDPattern(re *regexp.Regexp) Option {
+	return func(o *options) {
+		o.userIDPattern = re
+	}
+}
+
 // New initializes and returns a new instance of the Validator
-func New() *Validator {
+func New(opts ...Option) *Validator {
+	o := options{
+		userIDPattern: DefaultUserIDPattern,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cli := validator.New(validator.WithRequiredStructEnabled())
+	// The tag name is static and the function is valid, so this never fails.
+	_ = cli.RegisterValidation("user_id", func(fl validator.FieldLevel) bool {
+		return o.userIDPattern.MatchString(fl.Field().String())
+	})
+	_ = cli.RegisterValidation("emoji", func(fl validator.FieldLevel) bool {
+		return isEmoji(fl.Field().String())
+	})
+
 	return &Validator{
-		cli: validator.New(validator.WithRequiredStructEnabled()),
+		cli: cli,
 	}
 }
@@ -1,6 +1,8 @@
 package validator
 
 import (
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -147,6 +149,103 @@ func TestValidator_Validate(t *testing.T) {
 	}
 }
 
+// TestValidator_UserID tests the user_id validation tag with the default and a custom pattern.
+func TestValidator_UserID(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		value   string
+		wantErr bool
+	}{
+		{
+			name:    "Default valid",
+			value:   "test.user-1",
+			wantErr: false,
+		},
+		{
+			name:    "Default UUID",
+			value:   "0d5a4fa0-7e0b-4f2a-9b1b-4b4a2b5f3c11",
+			wantErr: false,
+		},
+		{
+			name:    "Default invalid characters",
+			value:   "test user; DROP TABLE",
+			wantErr: true,
+		},
+		{
+			name:    "Default empty",
+			value:   "",
+			wantErr: true,
+		},
+		{
+			name:    "Custom valid",
+			opts:    []Option{WithUserIDPattern(regexp.MustCompile(`^u[0-9]+$`))},
+			value:   "u42",
+			wantErr: false,
+		},
+		{
+			name:    "Custom invalid",
+			opts:    []Option{WithUserIDPattern(regexp.MustCompile(`^u[0-9]+$`))},
+			value:   "testuser",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New(tt.opts...)
+			errors := v.Validate(tt.value, "user_id")
+
+			if tt.wantErr && len(errors) == 0 {
+				t.Error("Validate() expected errors but got none")
+			}
+
+			if !tt.wantErr && len(errors) > 0 {
+				t.Errorf("Validate() got unexpected errors: %v", errors)
+			}
+		})
+	}
+}
+
+func TestValidator_Emoji(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "Single", value: "\U0001F389"},
+		{name: "VariationSelector", value: "\u2764\ufe0f"},
+		{name: "SkinTone", value: "\U0001F44D\U0001F3FD"},
+		{name: "ZWJSequence", value: "\U0001F468\u200d\U0001F469\u200d\U0001F467"},
+		{name: "Flag", value: "\U0001F1F3\U0001F1F1"},
+		{name: "Keycap", value: "1\ufe0f\u20e3"},
+		{name: "Empty", value: "", wantErr: true},
+		{name: "Multiple", value: "\U0001F389\U0001F389", wantErr: true},
+		{name: "Text", value: "party", wantErr: true},
+		{name: "EmojiAndText", value: "\U0001F389!", wantErr: true},
+		{name: "Digit", value: "1", wantErr: true},
+		{name: "LoneSkinTone", value: "\U0001F3FD", wantErr: true},
+		{name: "DanglingJoiner", value: "\U0001F468\u200d", wantErr: true},
+		{name: "LongSequence", value: "\U0001F468" + strings.Repeat("\u200d\U0001F468", 31)},
+		{name: "TooLong", value: "\U0001F468" + strings.Repeat("\u200d\U0001F468", 32), wantErr: true},
+	}
+
+	v := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errors := v.Validate(tt.value, "emoji")
+
+			if tt.wantErr && len(errors) == 0 {
+				t.Error("Validate() expected errors but got none")
+			}
+
+			if !tt.wantErr && len(errors) > 0 {
+				t.Errorf("Validate() got unexpected errors: %v", errors)
+			}
+		})
+	}
+}
+
 func TestNew(t *testing.T) {
 	v := New()
 	if v == nil || v.cli == nil {
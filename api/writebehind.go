@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// enqueueMessage queues msg to be persisted by the next Flush and returns it
+// with its id, generated if the client did not provide one.
+func (a *API) enqueueMessage(msg Message) Message {
+	if msg.ID == "" {
+		msg.ID = newID()
+	}
+
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	a.pending = append(a.pending, msg)
+	return msg
+}
+
+// isPending reports whether the message identified by id waits to be flushed.
+func (a *API) isPending(id string) bool {
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	return slices.ContainsFunc(a.pending, func(msg Message) bool {
+		return msg.ID == id
+	})
+}
+
+// flushed makes h flush the pending messages first if the message of the
+// request is one of them, so that h finds the message in the DB. All pending
+// messages are flushed, as the message may reply to another pending one.
+func (a *API) flushed(h handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if a.isPending(r.PathValue("messageID")) {
+			if err := a.Flush(r.Context()); err != nil {
+				return apiError(http.StatusInternalServerError, err, "Could not persist message")
+			}
+		}
+		return h(w, r)
+	}
+}
+
+// Flush persists the messages created in write-behind mode to the DB, in the
+// order they were created. Messages the DB rejects because their parent does
+// not exist are dropped, from the cache too. A message whose id is in use is
+// taken for a retry and dropped from the cache as well, so that the stored
+// message is read from the DB rather than the rejected one from the cache. If
+// the DB fails, the messages not persisted yet stay pending for the next flush
+// and the error is returned.
+func (a *API) Flush(ctx context.Context) error {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	a.pendingMu.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.pendingMu.Unlock()
+
+	for i, msg := range pending {
+		_, err := a.DB.InsertMessage(ctx, msg)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrDuplicateMessage):
+			a.Logger.Info("Dropping message whose id is in use", "id", msg.ID)
+			if err := a.Cache.DeleteMessage(ctx, msg.ID); err != nil {
+				a.Logger.Error("Could not delete cached message", "error", err.Error())
+			}
+		case errors.Is(err, ErrNotFound):
+			a.Logger.Error("Dropping reply to missing message", "id", msg.ID, "parent_id", msg.ParentID)
+			if err := a.Cache.DeleteMessage(ctx, msg.ID); err != nil {
+				a.Logger.Error("Could not delete cached message", "error", err.Error())
+			}
+		default:
+			a.pendingMu.Lock()
+			a.pending = slices.Concat(pending[i:], a.pending)
+			a.pendingMu.Unlock()
+			return fmt.Errorf("insert message %s: %w", msg.ID, err)
+		}
+	}
+	return nil
+}
+
+// FlushLoop flushes the messages created in write-behind mode every interval
+// until ctx is done. Failed flushes are logged and retried on the next tick.
+// Messages still pending once ctx is done are not flushed, callers should
+// call Flush one last time after the server shut down.
+func (a *API) FlushLoop(ctx context.Context, interval time.Duration) {
+	a.once.Do(a.setupRoutes)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Flush(ctx); err != nil && ctx.Err() == nil {
+				a.Logger.Error("Could not flush messages", "error", err.Error())
+			}
+		}
+	}
+}
+
+// newID returns a random version 4 UUID.
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("read random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_writeBehind(t *testing.T) {
+	var cached, persisted []Message
+	fail := true
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				if fail {
+					return Message{}, errors.New("connection refused")
+				}
+				persisted = append(persisted, msg)
+				return msg, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				cached = append(cached, msg)
+				return nil
+			},
+		},
+		Logger:      slogt.New(t),
+		WriteBehind: true,
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	for _, text := range []string{"hello", "world"} {
+		resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{"text": "`+text+`", "user_id": "test"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkStatus(t, resp.StatusCode, 201)
+	}
+
+	if len(cached) != 2 {
+		t.Fatalf("Got %d cached messages, want 2", len(cached))
+	}
+	if cached[0].ID == "" || cached[0].ID == cached[1].ID {
+		t.Errorf("Got ids %q and %q, want distinct ids", cached[0].ID, cached[1].ID)
+	}
+	if len(persisted) != 0 {
+		t.Fatalf("Got %d persisted messages before flushing, want 0", len(persisted))
+	}
+
+	// The messages stay pending while the DB fails.
+	if err := api.Flush(context.Background()); err == nil {
+		t.Fatal("Got no error, want the DB error")
+	}
+	fail = false
+	if err := api.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted) != 2 {
+		t.Fatalf("Got %d persisted messages, want 2", len(persisted))
+	}
+	for i := range persisted {
+		if persisted[i].ID != cached[i].ID || persisted[i].Text != cached[i].Text {
+			t.Errorf("Got persisted message %+v, want %+v", persisted[i], cached[i])
+		}
+	}
+
+	if err := api.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted) != 2 {
+		t.Errorf("Got %d persisted messages after flushing again, want 2", len(persisted))
+	}
+}
+
+func TestAPI_Flush_missingParent(t *testing.T) {
+	const parentID = "84bd9af7-79e6-4027-b284-9d5d875efd5b"
+	var deleted []string
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				return Message{}, ErrNotFound
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+			deleteMessage: func(t *testing.T, id string) error {
+				deleted = append(deleted, id)
+				return nil
+			},
+		},
+		Logger:      slogt.New(t),
+		WriteBehind: true,
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{"text": "hi", "user_id": "test", "parent_id": "`+parentID+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 201)
+
+	// The reply is dropped rather than retried forever.
+	for range 2 {
+		if err := api.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(deleted) != 1 {
+		t.Errorf("Got %d messages deleted from the cache, want 1", len(deleted))
+	}
+}
+
+func TestAPI_Flush_duplicate(t *testing.T) {
+	var deleted []string
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				return Message{}, ErrDuplicateMessage
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+			deleteMessage: func(t *testing.T, id string) error {
+				deleted = append(deleted, id)
+				return nil
+			},
+		},
+		Logger:      slogt.New(t),
+		WriteBehind: true,
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{"text": "hi", "user_id": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 201)
+
+	// The cache must not keep serving the rejected message in place of the
+	// stored one.
+	if err := api.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 {
+		t.Errorf("Got %d messages deleted from the cache, want 1", len(deleted))
+	}
+}
+
+func TestAPI_writeBehind_reactToPending(t *testing.T) {
+	persisted := make(map[string]bool)
+	fail := true
+	var reactions int
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				if fail {
+					return Message{}, errors.New("connection refused")
+				}
+				persisted[msg.ID] = true
+				return msg, nil
+			},
+			insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
+				// The reaction references the message row.
+				if !persisted[reaction.MessageID] {
+					return Reaction{}, ErrNotFound
+				}
+				reactions++
+				reaction.ID = "1"
+				return reaction, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Logger:      slogt.New(t),
+		WriteBehind: true,
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{"text": "hello", "user_id": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	react := func(wantStatus int) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/messages/"+created.Data.ID+"/reactions", "application/json", strings.NewReader(`{"type": "like", "user_id": "test"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkStatus(t, resp.StatusCode, wantStatus)
+	}
+
+	// The message can't be persisted yet, neither can the reaction.
+	react(500)
+	if reactions != 0 {
+		t.Errorf("Got %d reactions, want none", reactions)
+	}
+
+	fail = false
+	react(201)
+	if !persisted[created.Data.ID] || reactions != 1 {
+		t.Errorf("Got message persisted %t and %d reactions, want the message persisted first and 1 reaction", persisted[created.Data.ID], reactions)
+	}
+}
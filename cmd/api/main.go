@@ -7,11 +7,18 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/GetStream/stream-backend-homework-assignment/memory"
 	"github.com/GetStream/stream-backend-homework-assignment/postgres"
 	"github.com/GetStream/stream-backend-homework-assignment/redis"
 )
@@ -27,23 +34,148 @@ func main() {
 
 	addr := flag.String("addr", "localhost:8080", "HTTP network address")
 	connStr := flag.String("connection-string", connStr, "Postgres connection string")
+	replicaConnStr := flag.String("replica-connection-string", "", "Connection string of a Postgres read replica serving the message listings, the primary serves them when empty")
+	migrate := flag.Bool("migrate", false, "Update a PostgreSQL schema created from an older schema.sql on startup")
+	migrateDedupe := flag.Bool("migrate-dedupe", false, "Delete all but the oldest of the duplicate reactions of a user when migrating, instead of failing")
+	verifySchema := flag.Bool("verify-schema", false, "Check on startup that the PostgreSQL tables and columns the queries rely on exist")
+	aggregateReactions := flag.Bool("aggregate-reactions", false, "Aggregate the reactions of listed messages into the message rows with json_agg instead of loading them in a second query")
 	redisAddr := flag.String("redis-address", "localhost:6379", "Redis endpoint")
+	cacheSize := flag.Int("cache-size", 10, "Number of latest messages kept in the Redis cache")
+	cacheRefresh := flag.Duration("cache-refresh-interval", time.Minute, "Interval at which the latest messages are reloaded into the cache, 0 disables refreshing")
+	writeBehind := flag.Duration("write-behind-interval", 0, "Interval at which created messages are persisted to PostgreSQL after being cached, 0 persists them before responding. Messages not persisted yet are lost on a crash")
+	cacheStats := flag.Duration("cache-stats-interval", time.Minute, "Interval at which the cache hit ratio is logged, 0 disables logging")
+	cacheEviction := flag.String("cache-eviction", "fifo", "Redis cache eviction policy, either fifo (oldest messages) or lru (least recently used messages)")
+	cacheReactionLimit := flag.Int("cache-reaction-limit", 0, "Number of most recent reactions loaded per message when listing messages from the Redis cache, 0 loads all of them")
+	cacheKeyPrefix := flag.String("cache-key-prefix", "", "Prefix of the Redis keys, isolating environments that share a Redis instance")
+	cacheFormat := flag.String("cache-format", "hash", "Format of the messages cached in Redis, either hash (a hash per message and reaction) or json (a JSON string per message including its reactions)")
+	userIDPattern := flag.String("user-id-pattern", validator.DefaultUserIDPattern.String(), "Regular expression user IDs are validated against")
+	collapseWhitespace := flag.Bool("collapse-whitespace", false, "Collapse runs of whitespace within message texts to single spaces")
+	maxReactions := flag.Int("max-reactions-per-message", 0, "Maximum number of reactions per message, 0 means unlimited")
+	maxOffset := flag.Int("max-offset", 10000, "Number of messages a page may skip at most, deeper pages are rejected")
+	rateLimit := flag.Int("rate-limit", 60, "Number of POST requests per minute allowed per client, 0 disables rate limiting")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "Bearer token for the admin endpoints, which are disabled when empty")
+	moderatorToken := flag.String("moderator-token", os.Getenv("MODERATOR_TOKEN"), "Bearer token of moderators, who can hide messages")
+	userTokens := flag.String("user-tokens", os.Getenv("USER_TOKENS"), "Comma-separated token:user_id pairs of bearer tokens authenticating users, who can edit and delete their messages and delete their reactions")
+	cursorSecret := flag.String("cursor-secret", os.Getenv("SECRET"), "Key signing pagination cursors, a random key is used when empty")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated CIDRs of proxies whose X-Forwarded-For and X-Real-IP headers are trusted")
+	connectAttempts := flag.Int("connect-attempts", 5, "Number of times PostgreSQL and Redis are pinged on startup before giving up")
+	connectDelay := flag.Duration("connect-retry-delay", time.Second, "Delay before retrying to connect to PostgreSQL and Redis, doubled after each retry")
+	inMemory := flag.Bool("memory", false, "Store messages in memory instead of PostgreSQL and Redis, data is lost on exit")
+	debug := flag.Bool("debug", false, "Enable debug logging, including SQL queries")
 	flag.Parse()
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	logLevel := new(slog.LevelVar)
+	if *debug {
+		logLevel.Set(slog.LevelDebug)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
 
-	pg, err := postgres.Connect(ctx, *connStr)
+	userIDRe, err := regexp.Compile(*userIDPattern)
 	if err != nil {
-		logger.Error("Could not connect to PostgreSQL", "error", err.Error())
+		logger.Error("Invalid user ID pattern", "error", err.Error())
 		os.Exit(1)
 	}
 
-	r, err := redis.Connect(ctx, *redisAddr)
-	if err != nil {
-		logger.Error("Could not connect to Redis", "error", err.Error())
+	var proxies []netip.Prefix
+	for _, cidr := range strings.Split(*trustedProxies, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			logger.Error("Invalid trusted proxy", "error", err.Error())
+			os.Exit(1)
+		}
+		proxies = append(proxies, p)
+	}
+
+	users := make(map[string]string)
+	for _, pair := range strings.Split(*userTokens, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		token, userID, ok := strings.Cut(pair, ":")
+		if !ok || token == "" || userID == "" {
+			logger.Error("Invalid user token, expected token:user_id")
+			os.Exit(1)
+		}
+		users[token] = userID
+	}
+
+	var evictionPolicy redis.EvictionPolicy
+	switch *cacheEviction {
+	case "fifo":
+		evictionPolicy = redis.EvictFIFO
+	case "lru":
+		evictionPolicy = redis.EvictLRU
+	default:
+		logger.Error("Invalid cache eviction policy", "policy", *cacheEviction)
 		os.Exit(1)
 	}
 
+	var format redis.Format
+	switch *cacheFormat {
+	case "hash":
+		format = redis.FormatHash
+	case "json":
+		format = redis.FormatJSON
+	default:
+		logger.Error("Invalid cache format", "format", *cacheFormat)
+		os.Exit(1)
+	}
+
+	var (
+		db      api.DB
+		cache   api.Cache
+		limiter api.RateLimiter
+	)
+	if *inMemory {
+		c := memory.NewCache(*cacheSize)
+		db, cache, limiter = memory.NewDB(), c, c
+	} else {
+		pg, err := postgres.Connect(ctx, *connStr,
+			postgres.WithDebug(*debug),
+			postgres.WithLogger(logger),
+			postgres.WithReplica(*replicaConnStr),
+			postgres.WithConnectRetry(*connectAttempts, *connectDelay),
+			postgres.WithAggregatedReactions(*aggregateReactions),
+			postgres.WithMigrate(*migrate, *migrateDedupe),
+			postgres.WithVerifySchema(*verifySchema),
+		)
+		if err != nil {
+			logger.Error("Could not connect to PostgreSQL", "error", err.Error())
+			os.Exit(1)
+		}
+
+		r, err := redis.Connect(ctx, *redisAddr,
+			redis.WithMaxSize(*cacheSize),
+			redis.WithEvictionPolicy(evictionPolicy),
+			redis.WithFormat(format),
+			redis.WithKeyPrefix(*cacheKeyPrefix),
+			redis.WithReactionLimit(*cacheReactionLimit),
+			redis.WithConnectRetry(*connectAttempts, *connectDelay),
+		)
+		if err != nil {
+			logger.Error("Could not connect to Redis", "error", err.Error())
+			os.Exit(1)
+		}
+		breaker := &api.BreakerCache{Cache: r}
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cache_breaker_state",
+			Help: "State of the cache circuit breaker: 0 closed, 1 open, 2 half-open.",
+		}, func() float64 {
+			return float64(breaker.State())
+		}))
+
+		db = &api.RetryDB{DB: pg, IsTransient: postgres.IsTransient}
+		cache, limiter = breaker, r
+	}
+
+	var cursorKey []byte
+	if *cursorSecret != "" {
+		cursorKey = []byte(*cursorSecret)
+	}
+
 	lis, err := net.Listen("tcp", *addr)
 	if err != nil {
 		logger.Error("Could not listen", "error", err)
@@ -51,24 +183,77 @@ func main() {
 	}
 
 	api := &api.API{
-		Logger: logger,
-		DB:     pg,
-		Cache:  r,
-		Val:    validator.New(),
+		Logger:   logger,
+		DB:       db,
+		Cache:    cache,
+		Val:      validator.New(validator.WithUserIDPattern(userIDRe)),
+		Hub:      api.NewHub(),
+		LogLevel: logLevel,
+
+		AdminToken:             *adminToken,
+		ModeratorToken:         *moderatorToken,
+		UserTokens:             users,
+		CursorKey:              cursorKey,
+		MaxReactionsPerMessage: *maxReactions,
+		CollapseWhitespace:     *collapseWhitespace,
+		MaxOffset:              *maxOffset,
+		RefreshSize:            *cacheSize,
+		TrustedProxies:         proxies,
+	}
+	if *rateLimit > 0 {
+		api.RateLimiter = limiter
+		api.RateLimit = *rateLimit
 	}
 
+	if *cacheRefresh > 0 {
+		go api.RefreshLoop(ctx, *cacheRefresh)
+	}
+	if *writeBehind > 0 {
+		api.WriteBehind = true
+		go api.FlushLoop(ctx, *writeBehind)
+	}
+	if *cacheStats > 0 {
+		go api.CacheStatsLoop(ctx, *cacheStats)
+	}
+
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "cache_hits_total",
+		Help: "Number of message lookups served from the cache alone.",
+	}, func() float64 {
+		return float64(api.CacheHits())
+	}))
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "cache_misses_total",
+		Help: "Number of message lookups that consulted the DB after the cache.",
+	}, func() float64 {
+		return float64(api.CacheMisses())
+	}))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.Handle("/", api)
+
 	srv := &http.Server{
-		Handler: api,
+		Handler: mux,
 	}
 
+	shutdown := make(chan struct{})
 	go func() {
+		defer close(shutdown)
 		<-ctx.Done()
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Could not shut down server", "error", err.Error())
+		}
 
-		err := srv.Shutdown(ctx)
-		if err != nil {
-			return
+		// The messages created by the last requests are still pending, flush
+		// them even if requests are still running. Flushing gets its own
+		// deadline, shutting down may have used up the first one.
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := api.Flush(flushCtx); err != nil {
+			logger.Error("Could not flush messages", "error", err.Error())
 		}
 	}()
 
@@ -77,4 +262,5 @@ func main() {
 		logger.Error("Could not start server", "error", err)
 		os.Exit(1)
 	}
+	<-shutdown
 }
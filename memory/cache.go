@@ -0,0 +1,437 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+)
+
+const defaultMaxSize = 10
+
+// Cache caches the latest messages in memory. It implements api.Cache and
+// api.RateLimiter and mirrors the behavior of the Redis cache: only the
+// latest messages and pinned messages are kept.
+type Cache struct {
+	// maxSize is the number of latest messages kept in the cache.
+	maxSize int
+
+	mu       sync.Mutex
+	messages map[string]cachedMessage
+	// reactions are keyed by message id. Like in Redis, reactions are only
+	// cached for cached messages.
+	reactions map[string][]api.Reaction
+	typing    map[string]time.Time
+	hits      map[string]hitWindow
+
+	count        int
+	countExpires time.Time
+}
+
+// A cachedMessage is a message without its reactions.
+type cachedMessage struct {
+	api.Message
+	// latest reports whether the message is within the window of latest
+	// messages. Pinned messages are cached outside of the window too.
+	latest bool
+}
+
+// A hitWindow counts the requests in a rate limit window.
+type hitWindow struct {
+	start time.Time
+	n     int
+}
+
+// NewCache returns an empty cache keeping the maxSize latest messages. A
+// maxSize of 0 or less keeps 10.
+func NewCache(maxSize int) *Cache {
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	return &Cache{
+		maxSize:   maxSize,
+		messages:  make(map[string]cachedMessage),
+		reactions: make(map[string][]api.Reaction),
+		typing:    make(map[string]time.Time),
+		hits:      make(map[string]hitWindow),
+	}
+}
+
+// ListMessages returns up to limit messages created before the given time.
+// Pinned messages come first, then the messages are sorted by creation time in
+// the given order. The reactions are left out unless withReactions is set.
+func (c *Cache) ListMessages(_ context.Context, before time.Time, order api.Order, limit int, withReactions bool) ([]api.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	limit = min(limit, c.maxSize)
+	var pinned, latest []api.Message
+	for _, m := range c.messages {
+		if !m.CreatedAt.Before(before) {
+			continue
+		}
+		if m.Pinned {
+			pinned = append(pinned, m.Message)
+		} else if m.latest {
+			latest = append(latest, m.Message)
+		}
+	}
+	sortMessages(pinned, order)
+	sortMessages(latest, order)
+
+	msgs := append(pinned[:min(limit, len(pinned))], latest...)
+	out := make([]api.Message, 0, limit)
+	for _, m := range msgs[:min(limit, len(msgs))] {
+		m = c.message(m)
+		if !withReactions {
+			m.Reactions = []api.Reaction{}
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// GetMessage returns the message identified by messageID. api.ErrNotFound is
+// returned if the message is not cached. The reactions are listed in the given
+// sort order, only those of reactionType unless it is empty.
+func (c *Cache) GetMessage(_ context.Context, messageID string, sort api.ReactionSort, reactionType string) (api.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, ok := c.messages[messageID]
+	if !ok {
+		return api.Message{}, api.ErrNotFound
+	}
+	msg := c.message(m.Message)
+	if reactionType != "" {
+		msg.Reactions = filterReactions(msg.Reactions, reactionType)
+		msg.ReactionCount = len(msg.Reactions)
+	}
+	if sort == api.ReactionSortScore {
+		sortReactionsByScore(msg.Reactions)
+	}
+	return msg, nil
+}
+
+// InsertMessage adds a message to the cache and evicts the oldest messages
+// exceeding the cache size.
+func (c *Cache) InsertMessage(_ context.Context, msg api.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg.CreatedAt = msg.CreatedAt.UTC()
+	msg.Attachments = slices.Clone(msg.Attachments)
+	msg.Reactions = nil
+	// Refreshing the cache inserts the cached messages again, they are only
+	// counted as replies once.
+	_, cached := c.messages[msg.ID]
+	c.messages[msg.ID] = cachedMessage{Message: msg, latest: true}
+	if parent, ok := c.messages[msg.ParentID]; ok && !cached {
+		parent.ReplyCount++
+		c.messages[msg.ParentID] = parent
+	}
+	// The cached total is stale now, the next list request recounts.
+	c.countExpires = time.Time{}
+
+	c.evictOldest()
+	return nil
+}
+
+// InsertReaction adds a reaction to the message identified by msgID. Nothing
+// is stored if the message is not cached.
+func (c *Cache) InsertReaction(_ context.Context, msgID string, reaction api.Reaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.messages[msgID]; !ok {
+		return nil
+	}
+
+	rs := slices.DeleteFunc(c.reactions[msgID], func(r api.Reaction) bool {
+		return r.ID == reaction.ID
+	})
+	reaction.CreatedAt = reaction.CreatedAt.UTC()
+	rs = append(rs, reaction)
+	sortReactions(rs)
+	c.reactions[msgID] = rs
+	return nil
+}
+
+// GetReaction returns a single cached reaction of the message identified by
+// messageID. api.ErrNotFound is returned if the reaction is not cached.
+func (c *Cache) GetReaction(_ context.Context, messageID, reactionID string) (api.Reaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, r := range c.reactions[messageID] {
+		if r.ID == reactionID {
+			return r, nil
+		}
+	}
+	return api.Reaction{}, api.ErrNotFound
+}
+
+// FindReaction returns the cached reaction of the user identified by userID
+// with the given type to the message identified by messageID.
+// api.ErrNotFound is returned if there is none.
+func (c *Cache) FindReaction(_ context.Context, messageID, userID, reactionType string) (api.Reaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, r := range c.reactions[messageID] {
+		if r.UserID == userID && r.Type == reactionType {
+			return r, nil
+		}
+	}
+	return api.Reaction{}, api.ErrNotFound
+}
+
+// DeleteReaction removes a cached reaction of the message identified by
+// messageID. api.ErrNotFound is returned if the reaction is not cached.
+func (c *Cache) DeleteReaction(_ context.Context, messageID, reactionID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rs := c.reactions[messageID]
+	i := slices.IndexFunc(rs, func(r api.Reaction) bool {
+		return r.ID == reactionID
+	})
+	if i < 0 {
+		return api.ErrNotFound
+	}
+	c.reactions[messageID] = slices.Delete(rs, i, i+1)
+	return nil
+}
+
+// ReactionSummary aggregates the cached reactions of a message.
+func (c *Cache) ReactionSummary(_ context.Context, messageID string) (api.ReactionSummary, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return summarize(c.reactions[messageID]), nil
+}
+
+// EmojiCounts counts the cached reactions of a message per emoji.
+func (c *Cache) EmojiCounts(_ context.Context, messageID string) (map[string]int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return countEmojis(c.reactions[messageID]), nil
+}
+
+// SetMessagePinned updates the pinned state of a message. Pinned messages are
+// added to the cache, unpinned messages are dropped from it unless they are
+// still among the latest messages.
+func (c *Cache) SetMessagePinned(_ context.Context, msg api.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cm, ok := c.messages[msg.ID]
+	if msg.Pinned {
+		if !ok {
+			msg.CreatedAt = msg.CreatedAt.UTC()
+			msg.Attachments = slices.Clone(msg.Attachments)
+			msg.Reactions = nil
+			cm = cachedMessage{Message: msg}
+		}
+		cm.Pinned = true
+		c.messages[msg.ID] = cm
+		return nil
+	}
+
+	if !ok {
+		return nil
+	}
+	if !cm.latest {
+		// Only cached because it was pinned.
+		delete(c.messages, msg.ID)
+		delete(c.reactions, msg.ID)
+		return nil
+	}
+	cm.Pinned = false
+	c.messages[msg.ID] = cm
+	return nil
+}
+
+// SetMessageHidden updates the hidden state of a message if it is cached.
+func (c *Cache) SetMessageHidden(_ context.Context, messageID string, hidden bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cm, ok := c.messages[messageID]; ok {
+		cm.Hidden = hidden
+		c.messages[messageID] = cm
+	}
+	return nil
+}
+
+// SetMessageText updates the text of a message if it is cached.
+func (c *Cache) SetMessageText(_ context.Context, messageID, text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cm, ok := c.messages[messageID]; ok {
+		cm.Text = text
+		c.messages[messageID] = cm
+	}
+	return nil
+}
+
+// DeleteMessage removes a message, its reactions and its cached replies from
+// the cache, like the DB deletes the replies of a deleted message. The reply
+// count of the cached parent of the message is decremented.
+func (c *Cache) DeleteMessage(_ context.Context, messageID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m, ok := c.messages[messageID]; ok {
+		if parent, ok := c.messages[m.ParentID]; ok {
+			parent.ReplyCount--
+			c.messages[m.ParentID] = parent
+		}
+	}
+	c.deleteMessage(messageID)
+	// The cached total is stale now, the next list request recounts.
+	c.countExpires = time.Time{}
+	return nil
+}
+
+// deleteMessage removes a message, its reactions and its replies, recursively.
+// c.mu must be held.
+func (c *Cache) deleteMessage(messageID string) {
+	delete(c.messages, messageID)
+	delete(c.reactions, messageID)
+	for id, m := range c.messages {
+		if m.ParentID == messageID {
+			c.deleteMessage(id)
+		}
+	}
+}
+
+// GetMessageCount returns the cached total number of messages.
+// api.ErrNotFound is returned if no count is cached.
+func (c *Cache) GetMessageCount(_ context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !time.Now().Before(c.countExpires) {
+		return 0, api.ErrNotFound
+	}
+	return c.count, nil
+}
+
+// SetMessageCount caches the total number of messages. The count expires
+// after ttl.
+func (c *Cache) SetMessageCount(_ context.Context, n int, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.count = n
+	c.countExpires = time.Now().Add(ttl)
+	return nil
+}
+
+// Flush removes all cached messages, including their reactions, and the
+// cached message count. It returns the number of removed messages and
+// reactions.
+func (c *Cache) Flush(_ context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted := len(c.messages)
+	for _, rs := range c.reactions {
+		deleted += len(rs)
+	}
+	clear(c.messages)
+	clear(c.reactions)
+	c.countExpires = time.Time{}
+	return deleted, nil
+}
+
+// SetTyping marks the user as typing. The marker expires after ttl.
+func (c *Cache) SetTyping(_ context.Context, userID string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.typing[userID] = time.Now().Add(ttl)
+	return nil
+}
+
+// ListTyping returns the IDs of the users with an unexpired typing marker,
+// sorted alphabetically.
+func (c *Cache) ListTyping(_ context.Context) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var userIDs []string
+	for userID, expires := range c.typing {
+		if !now.Before(expires) {
+			delete(c.typing, userID)
+			continue
+		}
+		userIDs = append(userIDs, userID)
+	}
+	slices.Sort(userIDs)
+	return userIDs, nil
+}
+
+// Hit records a request for key in the current fixed window of the given
+// length. It returns the number of requests recorded in the window and when
+// the window ends.
+func (c *Cache) Hit(_ context.Context, key string, window time.Duration) (int, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := time.Now().Truncate(window)
+	h := c.hits[key]
+	if !h.start.Equal(start) {
+		h = hitWindow{start: start}
+	}
+	h.n++
+	c.hits[key] = h
+	return h.n, start.Add(window), nil
+}
+
+// message returns m joined with its cached reactions. c.mu must be held.
+func (c *Cache) message(m api.Message) api.Message {
+	m.Attachments = slices.Clone(m.Attachments)
+	m.Reactions = slices.Clone(c.reactions[m.ID])
+	if m.Reactions == nil {
+		m.Reactions = []api.Reaction{}
+	}
+	m.ReactionCount = len(m.Reactions)
+	return m
+}
+
+// evictOldest drops the messages exceeding the cache size from the window of
+// latest messages. Pinned messages stay cached. c.mu must be held.
+func (c *Cache) evictOldest() {
+	var latest []api.Message
+	for _, m := range c.messages {
+		if m.latest {
+			latest = append(latest, m.Message)
+		}
+	}
+	if len(latest) <= c.maxSize {
+		return
+	}
+	// Sort by creation time only, the window ignores pinning.
+	for i := range latest {
+		latest[i].Pinned = false
+	}
+	sortMessages(latest, api.OrderDesc)
+
+	for _, m := range latest[c.maxSize:] {
+		cm := c.messages[m.ID]
+		if cm.Pinned {
+			cm.latest = false
+			c.messages[m.ID] = cm
+			continue
+		}
+		delete(c.messages, m.ID)
+		delete(c.reactions, m.ID)
+	}
+}
@@ -0,0 +1,344 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/google/go-cmp/cmp"
+)
+
+// insert caches a message created on each of the given days of January 2024.
+// The ids are "message-<day>".
+func insert(t *testing.T, c *Cache, days ...int) {
+	t.Helper()
+	for _, i := range days {
+		err := c.InsertMessage(context.Background(), api.Message{
+			ID:        fmt.Sprintf("message-%d", i),
+			Text:      fmt.Sprintf("Message %d", i),
+			UserID:    "test",
+			CreatedAt: time.Date(2024, 1, i, 0, 0, 0, 0, time.UTC),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCache_ListMessages(t *testing.T) {
+	tests := []struct {
+		name   string
+		before time.Time
+		order  api.Order
+		limit  int
+		pinned string
+		want   []string
+	}{
+		{
+			name:  "Desc",
+			order: api.OrderDesc,
+			limit: 2,
+			want:  []string{"message-5", "message-4"},
+		},
+		{
+			name:  "Asc",
+			order: api.OrderAsc,
+			limit: 2,
+			want:  []string{"message-1", "message-2"},
+		},
+		{
+			name:  "LimitAboveSize",
+			order: api.OrderDesc,
+			limit: 100,
+			want:  []string{"message-5", "message-4", "message-3", "message-2", "message-1"},
+		},
+		{
+			name:   "Before",
+			before: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+			order:  api.OrderDesc,
+			limit:  10,
+			want:   []string{"message-2", "message-1"},
+		},
+		{
+			name:   "PinnedFirst",
+			order:  api.OrderDesc,
+			limit:  3,
+			pinned: "message-2",
+			want:   []string{"message-2", "message-5", "message-4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(10)
+			insert(t, c, 1, 2, 3, 4, 5)
+			if tt.pinned != "" {
+				msg, err := c.GetMessage(context.Background(), tt.pinned, api.ReactionSortCreated, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				msg.Pinned = true
+				if err := c.SetMessagePinned(context.Background(), msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.before.IsZero() {
+				tt.before = time.Now()
+			}
+
+			got, err := c.ListMessages(context.Background(), tt.before, tt.order, tt.limit, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(ids(got), tt.want); diff != "" {
+				t.Errorf("Diff (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCache_InsertMessage_evict(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(2)
+	insert(t, c, 1)
+	if err := c.SetMessagePinned(ctx, api.Message{ID: "message-1", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Pinned: true}); err != nil {
+		t.Fatal(err)
+	}
+	insert(t, c, 2, 3, 4)
+
+	got, err := c.ListMessages(ctx, time.Now(), api.OrderDesc, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pinned message survives eviction, message-2 and message-3 don't.
+	if diff := cmp.Diff(ids(got), []string{"message-1", "message-4"}); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+	if _, err := c.GetMessage(ctx, "message-2", api.ReactionSortCreated, ""); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for evicted message, want %v", err, api.ErrNotFound)
+	}
+
+	// Once unpinned, a message outside of the window is dropped.
+	if err := c.SetMessagePinned(ctx, api.Message{ID: "message-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetMessage(ctx, "message-1", api.ReactionSortCreated, ""); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for unpinned message, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestCache_InsertMessage_replyCount(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(10)
+	parent := api.Message{ID: "parent", Text: "hello", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Pinned: true}
+	if err := c.SetMessagePinned(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every refresh inserts the reply again.
+	reply := api.Message{ID: "reply", Text: "hi", UserID: "test", ParentID: parent.ID, CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+	for range 3 {
+		if err := c.InsertMessage(ctx, reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := c.GetMessage(ctx, parent.ID, api.ReactionSortCreated, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReplyCount != 1 {
+		t.Errorf("Got reply count %d, want 1", got.ReplyCount)
+	}
+}
+
+func TestCache_DeleteMessage_replies(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(10)
+	msgs := []api.Message{
+		{ID: "parent", Text: "hello", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "reply", Text: "hi", UserID: "test", ParentID: "parent", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: "nested", Text: "hey", UserID: "test", ParentID: "reply", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, m := range msgs {
+		if err := c.InsertMessage(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deleting a reply decrements the reply count of its parent.
+	if err := c.DeleteMessage(ctx, "nested"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetMessage(ctx, "reply", api.ReactionSortCreated, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReplyCount != 0 {
+		t.Errorf("Got reply count %d, want 0", got.ReplyCount)
+	}
+
+	// Deleting a parent deletes its replies, like the DB does.
+	if err := c.DeleteMessage(ctx, "parent"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetMessage(ctx, "reply", api.ReactionSortCreated, ""); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for the reply, want %v", err, api.ErrNotFound)
+	}
+	listed, err := c.ListMessages(ctx, time.Now(), api.OrderDesc, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("Got %d listed messages, want none", len(listed))
+	}
+}
+
+func TestCache_MessageCount(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(10)
+
+	if _, err := c.GetMessageCount(ctx); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+	}
+	if err := c.SetMessageCount(ctx, 42, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.GetMessageCount(ctx); err != nil || n != 42 {
+		t.Errorf("Got count %d and error %v, want 42", n, err)
+	}
+
+	// Inserting a message invalidates the count.
+	insert(t, c, 1)
+	if _, err := c.GetMessageCount(ctx); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v after insert, want %v", err, api.ErrNotFound)
+	}
+
+	// Deleting a message invalidates the count too.
+	if err := c.SetMessageCount(ctx, 42, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteMessage(ctx, "message-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetMessageCount(ctx); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v after delete, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestCache_FindReaction(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(10)
+	insert(t, c, 1)
+
+	want := api.Reaction{ID: "1", MessageID: "message-1", UserID: "test", Type: "like", Score: 1}
+	if err := c.InsertReaction(ctx, "message-1", want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.FindReaction(ctx, "message-1", "test", "like")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+	if _, err := c.FindReaction(ctx, "message-1", "test", "love"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for another type, want %v", err, api.ErrNotFound)
+	}
+	if _, err := c.FindReaction(ctx, "message-1", "other", "like"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for another user, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestCache_InsertReaction_uncached(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(10)
+
+	r := api.Reaction{ID: "1", MessageID: "message-1", UserID: "test", Type: "like", Score: 1}
+	if err := c.InsertReaction(ctx, "message-1", r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetReaction(ctx, "message-1", "1"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+	}
+	if len(c.reactions) != 0 {
+		t.Errorf("Got %d cached reaction lists, want none", len(c.reactions))
+	}
+}
+
+func TestCache_DeleteReaction(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(10)
+	insert(t, c, 1)
+
+	r := api.Reaction{ID: "1", MessageID: "message-1", UserID: "test", Type: "like", Score: 1}
+	if err := c.InsertReaction(ctx, "message-1", r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DeleteReaction(ctx, "message-1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.FindReaction(ctx, "message-1", "test", "like"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v finding the deleted reaction, want %v", err, api.ErrNotFound)
+	}
+	if err := c.DeleteReaction(ctx, "message-1", "1"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v deleting twice, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestCache_Hit(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(10)
+
+	for want := 1; want <= 3; want++ {
+		n, reset, err := c.Hit(ctx, "client", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("Got %d hits, want %d", n, want)
+		}
+		if !reset.After(time.Now()) {
+			t.Errorf("Got reset %v in the past", reset)
+		}
+	}
+	if n, _, _ := c.Hit(ctx, "other", time.Hour); n != 1 {
+		t.Errorf("Got %d hits for another key, want 1", n)
+	}
+}
+
+func TestCache_utc(t *testing.T) {
+	// Pretend the server runs in another zone than UTC.
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	ctx := context.Background()
+	c := NewCache(10)
+	now := time.Now()
+	if err := c.InsertMessage(ctx, api.Message{ID: "1", Text: "hello", UserID: "test", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InsertReaction(ctx, "1", api.Reaction{ID: "2", MessageID: "1", UserID: "test", Type: "like", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := c.ListMessages(ctx, now.Add(time.Second), api.OrderDesc, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || len(msgs[0].Reactions) != 1 {
+		t.Fatalf("Got %+v, want a message with a reaction", msgs)
+	}
+	if loc := msgs[0].CreatedAt.Location(); loc != time.UTC {
+		t.Errorf("Got message created in %s, want UTC", loc)
+	}
+	if loc := msgs[0].Reactions[0].CreatedAt.Location(); loc != time.UTC {
+		t.Errorf("Got reaction created in %s, want UTC", loc)
+	}
+}
@@ -0,0 +1,700 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+)
+
+// DB stores messages and reactions in memory. It implements api.DB and is
+// meant for local development and tests, all data is lost when the process
+// exits.
+type DB struct {
+	mu sync.Mutex
+	// messages are stored without their reactions, which are joined on read.
+	messages  map[string]api.Message
+	reactions map[string]api.Reaction
+}
+
+// NewDB returns an empty DB.
+func NewDB() *DB {
+	return &DB{
+		messages:  make(map[string]api.Message),
+		reactions: make(map[string]api.Reaction),
+	}
+}
+
+// ListMessages returns a page of the messages created before the given time
+// in the given order, pinned messages first, with as much of their reactions
+// as reactions asks for. Hidden messages are left out unless withHidden is
+// set.
+func (db *DB) ListMessages(_ context.Context, before time.Time, order api.Order, limit, offset int, reactions api.ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var msgs []api.Message
+	for _, m := range db.messages {
+		if m.CreatedAt.Before(before) && (withHidden || !m.Hidden) && !slices.Contains(excludeMsgIDs, m.ID) {
+			msgs = append(msgs, m)
+		}
+	}
+	sortMessages(msgs, order)
+
+	out := make([]api.Message, 0, limit)
+	for _, m := range msgs[min(offset, len(msgs)):min(offset+limit, len(msgs))] {
+		m = db.message(m)
+		out = append(out, withReactions(m, reactions))
+	}
+	return out, nil
+}
+
+// ListMessagesAfter returns up to limit messages created after the message
+// identified by afterID, oldest first. Messages created at the same time are
+// ordered by id. Hidden messages are left out unless withHidden is set.
+// api.ErrNotFound is returned if the message does not exist.
+func (db *DB) ListMessagesAfter(_ context.Context, afterID string, limit int, reactions api.ReactionLoad, withHidden bool) ([]api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	after, ok := db.messages[afterID]
+	if !ok {
+		return nil, api.ErrNotFound
+	}
+	var msgs []api.Message
+	for _, m := range db.messages {
+		if m.Hidden && !withHidden {
+			continue
+		}
+		if c := m.CreatedAt.Compare(after.CreatedAt); c > 0 || c == 0 && m.ID > afterID {
+			// Pinning does not affect the order of a sync.
+			m.Pinned = false
+			msgs = append(msgs, m)
+		}
+	}
+	sortMessages(msgs, api.OrderAsc)
+
+	out := make([]api.Message, 0, min(limit, len(msgs)))
+	for _, m := range msgs[:min(limit, len(msgs))] {
+		m = db.message(db.messages[m.ID])
+		out = append(out, withReactions(m, reactions))
+	}
+	return out, nil
+}
+
+// ListMessagesBetween returns a page of the messages created from the time
+// from up to, but not including, the time to, oldest first. Hidden messages
+// are left out unless withHidden is set.
+func (db *DB) ListMessagesBetween(_ context.Context, from, to time.Time, limit, offset int, reactions api.ReactionLoad, withHidden bool) ([]api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var msgs []api.Message
+	for _, m := range db.messages {
+		if m.Hidden && !withHidden {
+			continue
+		}
+		if !m.CreatedAt.Before(from) && m.CreatedAt.Before(to) {
+			// Pinning does not affect the order within a time range.
+			m.Pinned = false
+			msgs = append(msgs, m)
+		}
+	}
+	sortMessages(msgs, api.OrderAsc)
+
+	out := make([]api.Message, 0)
+	for _, m := range msgs[min(offset, len(msgs)):min(offset+limit, len(msgs))] {
+		m = db.message(db.messages[m.ID])
+		out = append(out, withReactions(m, reactions))
+	}
+	return out, nil
+}
+
+// SearchMessages returns a page of the messages whose text contains all words
+// of query, ignoring case, newest first.
+func (db *DB) SearchMessages(_ context.Context, query string, limit, offset int, reactions api.ReactionLoad, withHidden bool) ([]api.SearchResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	words := strings.Fields(query)
+	patterns := make([]string, len(words))
+	for i, w := range words {
+		patterns[i] = regexp.QuoteMeta(w)
+	}
+	re, err := regexp.Compile("(?i)" + strings.Join(patterns, "|"))
+	if err != nil {
+		return nil, fmt.Errorf("compile query: %w", err)
+	}
+
+	var msgs []api.Message
+	for _, m := range db.messages {
+		if m.Hidden && !withHidden {
+			continue
+		}
+		if containsAll(m.Text, words) {
+			m.Pinned = false
+			msgs = append(msgs, m)
+		}
+	}
+	sortMessages(msgs, api.OrderDesc)
+
+	out := make([]api.SearchResult, 0)
+	for _, m := range msgs[min(offset, len(msgs)):min(offset+limit, len(msgs))] {
+		m = withReactions(db.message(db.messages[m.ID]), reactions)
+		out = append(out, api.SearchResult{Message: m, Highlight: highlight(m.Text, re)})
+	}
+	return out, nil
+}
+
+// containsAll reports whether text contains all words, ignoring case.
+func containsAll(text string, words []string) bool {
+	text = strings.ToLower(text)
+	for _, w := range words {
+		if !strings.Contains(text, strings.ToLower(w)) {
+			return false
+		}
+	}
+	return true
+}
+
+// highlight escapes text and wraps the matches of re in <mark> tags.
+func highlight(text string, re *regexp.Regexp) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[loc[0]:loc[1]]))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// GetMessage returns the message identified by messageID, its reactions in the
+// given sort order and only those of reactionType unless it is empty.
+// api.ErrNotFound is returned if the message does not exist.
+func (db *DB) GetMessage(_ context.Context, messageID string, sort api.ReactionSort, reactionType string) (api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageID]
+	if !ok {
+		return api.Message{}, api.ErrNotFound
+	}
+	m = db.message(m)
+	if reactionType != "" {
+		m.Reactions = filterReactions(m.Reactions, reactionType)
+		m.ReactionCount = len(m.Reactions)
+	}
+	if sort == api.ReactionSortScore {
+		sortReactionsByScore(m.Reactions)
+	}
+	return m, nil
+}
+
+// GetThread returns the message identified by messageID followed by its
+// replies, up to maxDepth levels deep and limit messages in total. Replies
+// directly follow the message they reply to, oldest first. Hidden messages
+// and their replies are left out unless withHidden is set. api.ErrNotFound is
+// returned if the message does not exist or is left out.
+func (db *DB) GetThread(_ context.Context, messageID string, maxDepth, limit int, withHidden bool) ([]api.ThreadMessage, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	root, ok := db.messages[messageID]
+	if !ok || root.Hidden && !withHidden {
+		return nil, api.ErrNotFound
+	}
+	replies := make(map[string][]api.Message)
+	for _, m := range db.messages {
+		if m.ParentID != "" && (withHidden || !m.Hidden) {
+			replies[m.ParentID] = append(replies[m.ParentID], m)
+		}
+	}
+
+	var out []api.ThreadMessage
+	var walk func(m api.Message, depth int)
+	walk = func(m api.Message, depth int) {
+		if len(out) == limit {
+			return
+		}
+		out = append(out, api.ThreadMessage{Message: db.message(m), Depth: depth})
+		if depth == maxDepth {
+			return
+		}
+		children := replies[m.ID]
+		sortMessages(children, api.OrderAsc)
+		for _, c := range children {
+			walk(c, depth+1)
+		}
+	}
+	walk(root, 0)
+	return out, nil
+}
+
+// SetMessagePinned pins or unpins a message and returns the updated message.
+// api.ErrNotFound is returned if the message does not exist.
+func (db *DB) SetMessagePinned(_ context.Context, messageID string, pinned bool) (api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageID]
+	if !ok {
+		return api.Message{}, api.ErrNotFound
+	}
+	m.Pinned = pinned
+	db.messages[messageID] = m
+	return db.message(m), nil
+}
+
+// SetMessageHidden hides or shows a message and returns the updated message.
+// api.ErrNotFound is returned if the message does not exist.
+func (db *DB) SetMessageHidden(_ context.Context, messageID string, hidden bool) (api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageID]
+	if !ok {
+		return api.Message{}, api.ErrNotFound
+	}
+	m.Hidden = hidden
+	db.messages[messageID] = m
+	return db.message(m), nil
+}
+
+// UpdateMessageText replaces the text of a message and returns the updated
+// message. api.ErrNotFound is returned if the message does not exist.
+func (db *DB) UpdateMessageText(_ context.Context, messageID, text string) (api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageID]
+	if !ok {
+		return api.Message{}, api.ErrNotFound
+	}
+	m.Text = text
+	db.messages[messageID] = m
+	return db.message(m), nil
+}
+
+// DeleteMessage deletes a message with its replies and reactions.
+// api.ErrNotFound is returned if the message does not exist.
+func (db *DB) DeleteMessage(_ context.Context, messageID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.messages[messageID]; !ok {
+		return api.ErrNotFound
+	}
+	// Like the cascading foreign keys of PostgreSQL, delete the whole
+	// thread below the message.
+	deleted := map[string]bool{messageID: true}
+	for queue := []string{messageID}; len(queue) > 0; queue = queue[1:] {
+		for id, m := range db.messages {
+			if m.ParentID == queue[0] && !deleted[id] {
+				deleted[id] = true
+				queue = append(queue, id)
+			}
+		}
+	}
+	for id := range deleted {
+		delete(db.messages, id)
+	}
+	for id, r := range db.reactions {
+		if deleted[r.MessageID] {
+			delete(db.reactions, id)
+		}
+	}
+	return nil
+}
+
+// LatestMessageTime returns the creation time of the most recent message, or
+// the zero time if there are no messages.
+func (db *DB) LatestMessageTime(_ context.Context) (time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var latest time.Time
+	for _, m := range db.messages {
+		if m.CreatedAt.After(latest) {
+			latest = m.CreatedAt
+		}
+	}
+	return latest, nil
+}
+
+// CountMessages returns the total number of messages.
+func (db *DB) CountMessages(_ context.Context) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return len(db.messages), nil
+}
+
+// CountReactions returns the number of reactions to the message identified by
+// messageID.
+func (db *DB) CountReactions(_ context.Context, messageID string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return len(db.messageReactions(messageID)), nil
+}
+
+// InsertMessage stores a message. The returned message holds the creation time
+// and the id, which is generated unless set. api.ErrNotFound is returned if
+// the message replies to a message that does not exist, and
+// api.ErrDuplicateMessage if the id is taken.
+func (db *DB) InsertMessage(_ context.Context, msg api.Message) (api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if msg.ParentID != "" {
+		if _, ok := db.messages[msg.ParentID]; !ok {
+			return api.Message{}, api.ErrNotFound
+		}
+	}
+
+	id := msg.ID
+	if id == "" {
+		id = newID()
+	} else if _, ok := db.messages[id]; ok {
+		return api.Message{}, api.ErrDuplicateMessage
+	}
+
+	m := api.Message{
+		ID:          id,
+		Text:        msg.Text,
+		UserID:      msg.UserID,
+		ParentID:    msg.ParentID,
+		CreatedAt:   orNow(msg.CreatedAt),
+		Attachments: slices.Clone(msg.Attachments),
+	}
+	db.messages[m.ID] = m
+	return db.message(m), nil
+}
+
+// InsertReaction stores a message reaction.
+func (db *DB) InsertReaction(_ context.Context, r api.Reaction) (api.Reaction, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.messages[r.MessageID]; !ok {
+		return api.Reaction{}, api.ErrNotFound
+	}
+	if db.hasReaction(r) {
+		return api.Reaction{}, api.ErrDuplicateReaction
+	}
+	return db.insertReaction(r), nil
+}
+
+// InsertReactions stores several message reactions, either all or none of
+// them.
+func (db *DB) InsertReactions(_ context.Context, rs []api.Reaction) ([]api.Reaction, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, r := range rs {
+		if _, ok := db.messages[r.MessageID]; !ok {
+			return nil, api.ErrNotFound
+		}
+	}
+	seen := make(map[[3]string]bool, len(rs))
+	for _, r := range rs {
+		key := [3]string{r.MessageID, r.UserID, r.Type}
+		if seen[key] || db.hasReaction(r) {
+			return nil, api.ErrDuplicateReaction
+		}
+		seen[key] = true
+	}
+	out := make([]api.Reaction, len(rs))
+	for i, r := range rs {
+		out[i] = db.insertReaction(r)
+	}
+	return out, nil
+}
+
+// ListReactionsByUser returns the reactions given by the user identified by
+// userID, newest first.
+func (db *DB) ListReactionsByUser(_ context.Context, userID string) ([]api.Reaction, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	out := make([]api.Reaction, 0)
+	for _, r := range db.reactions {
+		if r.UserID == userID {
+			out = append(out, r)
+		}
+	}
+	slices.SortFunc(out, func(a, b api.Reaction) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return out, nil
+}
+
+// GetReaction returns a single reaction of the message identified by
+// messageID. api.ErrNotFound is returned if no such reaction exists.
+func (db *DB) GetReaction(_ context.Context, messageID, reactionID string) (api.Reaction, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	r, ok := db.reactions[reactionID]
+	if !ok || r.MessageID != messageID {
+		return api.Reaction{}, api.ErrNotFound
+	}
+	return r, nil
+}
+
+// GetReactionByID returns the reaction identified by reactionID, whichever
+// message it belongs to. api.ErrNotFound is returned if no such reaction
+// exists.
+func (db *DB) GetReactionByID(_ context.Context, reactionID string) (api.Reaction, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	r, ok := db.reactions[reactionID]
+	if !ok {
+		return api.Reaction{}, api.ErrNotFound
+	}
+	return r, nil
+}
+
+// FindReaction returns the reaction of the user identified by userID with the
+// given type to the message identified by messageID. api.ErrNotFound is
+// returned if no such reaction exists.
+func (db *DB) FindReaction(_ context.Context, messageID, userID, reactionType string) (api.Reaction, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, r := range db.reactions {
+		if r.MessageID == messageID && r.UserID == userID && r.Type == reactionType {
+			return r, nil
+		}
+	}
+	return api.Reaction{}, api.ErrNotFound
+}
+
+// DeleteReaction removes a reaction of the message identified by messageID.
+// api.ErrNotFound is returned if no such reaction exists.
+func (db *DB) DeleteReaction(_ context.Context, messageID, reactionID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	r, ok := db.reactions[reactionID]
+	if !ok || r.MessageID != messageID {
+		return api.ErrNotFound
+	}
+	delete(db.reactions, reactionID)
+	return nil
+}
+
+// ToggleReaction deletes the reactions of the user with the type of r to its
+// message, or stores r if there are none. api.ErrNotFound is returned if the
+// message does not exist.
+func (db *DB) ToggleReaction(_ context.Context, r api.Reaction) (api.ReactionToggle, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.messages[r.MessageID]; !ok {
+		return api.ReactionToggle{}, api.ErrNotFound
+	}
+
+	var t api.ReactionToggle
+	for id, rc := range db.reactions {
+		if rc.MessageID == r.MessageID && rc.UserID == r.UserID && rc.Type == r.Type {
+			t.Reactions = append(t.Reactions, rc)
+			delete(db.reactions, id)
+		}
+	}
+	if t.Reactions == nil {
+		t.Active = true
+		t.Reactions = []api.Reaction{db.insertReaction(r)}
+	}
+	for _, rc := range db.messageReactions(r.MessageID) {
+		if rc.Type == r.Type {
+			t.Count++
+		}
+	}
+	return t, nil
+}
+
+// ReactionSummary returns the number of reactions per type and the total
+// score of the reactions of a message.
+func (db *DB) ReactionSummary(_ context.Context, messageID string) (api.ReactionSummary, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return summarize(db.messageReactions(messageID)), nil
+}
+
+// EmojiCounts returns the number of reactions per emoji of a message.
+// Reactions without an emoji are not counted.
+func (db *DB) EmojiCounts(_ context.Context, messageID string) (map[string]int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return countEmojis(db.messageReactions(messageID)), nil
+}
+
+// ScoreHistogram returns the number of reactions per score of a message.
+func (db *DB) ScoreHistogram(_ context.Context, messageID string) (map[int]int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	histogram := make(map[int]int)
+	for _, r := range db.messageReactions(messageID) {
+		histogram[r.Score]++
+	}
+	return histogram, nil
+}
+
+// insertReaction stores r with a generated id, and the current time unless it
+// has a creation time. db.mu must be held.
+// hasReaction reports whether the user already reacted to the message with the
+// type of r, mirroring the unique index of the reactions table. db.mu must be
+// held.
+func (db *DB) hasReaction(r api.Reaction) bool {
+	for _, rc := range db.reactions {
+		if rc.MessageID == r.MessageID && rc.UserID == r.UserID && rc.Type == r.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) insertReaction(r api.Reaction) api.Reaction {
+	r.ID = newID()
+	r.CreatedAt = orNow(r.CreatedAt)
+	db.reactions[r.ID] = r
+	return r
+}
+
+// orNow returns t in UTC, or the current time if t is zero.
+func orNow(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now().UTC()
+	}
+	return t.UTC()
+}
+
+// message returns m joined with its reactions and the number of its replies.
+// db.mu must be held.
+func (db *DB) message(m api.Message) api.Message {
+	m.Attachments = slices.Clone(m.Attachments)
+	m.Reactions = db.messageReactions(m.ID)
+	m.ReactionCount = len(m.Reactions)
+	m.ReplyCount = 0
+	for _, reply := range db.messages {
+		if reply.ParentID == m.ID {
+			m.ReplyCount++
+		}
+	}
+	return m
+}
+
+// withReactions returns m with as much of its reactions as reactions asks for.
+func withReactions(m api.Message, reactions api.ReactionLoad) api.Message {
+	if reactions != api.ReactionsLoaded {
+		m.Reactions = []api.Reaction{}
+	}
+	if reactions == api.ReactionsOmitted {
+		m.ReactionCount = 0
+	}
+	return m
+}
+
+// messageReactions returns the reactions of the message identified by
+// messageID, oldest first. db.mu must be held.
+func (db *DB) messageReactions(messageID string) []api.Reaction {
+	out := make([]api.Reaction, 0)
+	for _, r := range db.reactions {
+		if r.MessageID == messageID {
+			out = append(out, r)
+		}
+	}
+	sortReactions(out)
+	return out
+}
+
+// sortMessages sorts pinned messages first, then by creation time in the
+// given order. Messages created at the same time are sorted by id so that
+// pages are stable.
+func sortMessages(msgs []api.Message, order api.Order) {
+	slices.SortFunc(msgs, func(a, b api.Message) int {
+		if a.Pinned != b.Pinned {
+			if a.Pinned {
+				return -1
+			}
+			return 1
+		}
+		c := a.CreatedAt.Compare(b.CreatedAt)
+		if order != api.OrderAsc {
+			c = -c
+		}
+		return cmp.Or(c, cmp.Compare(a.ID, b.ID))
+	})
+}
+
+// sortReactions sorts reactions oldest first.
+func sortReactions(rs []api.Reaction) {
+	slices.SortFunc(rs, func(a, b api.Reaction) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+}
+
+// sortReactionsByScore sorts reactions highest score first, newest first among
+// equal scores.
+func sortReactionsByScore(rs []api.Reaction) {
+	slices.SortFunc(rs, func(a, b api.Reaction) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+}
+
+// filterReactions keeps the reactions of the given type.
+func filterReactions(rs []api.Reaction, typ string) []api.Reaction {
+	return slices.DeleteFunc(rs, func(r api.Reaction) bool {
+		return r.Type != typ
+	})
+}
+
+// summarize aggregates reactions into a summary.
+func summarize(rs []api.Reaction) api.ReactionSummary {
+	summary := api.ReactionSummary{Counts: make(map[string]int)}
+	for _, r := range rs {
+		summary.Counts[r.Type]++
+		summary.Total++
+		summary.Score += r.Score
+	}
+	return summary
+}
+
+// countEmojis counts reactions per emoji, leaving out reactions without one.
+func countEmojis(rs []api.Reaction) map[string]int {
+	counts := make(map[string]int)
+	for _, r := range rs {
+		if r.Emoji != "" {
+			counts[r.Emoji]++
+		}
+	}
+	return counts
+}
+
+// newID returns a random version 4 UUID.
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("read random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
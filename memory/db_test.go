@@ -0,0 +1,591 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/google/go-cmp/cmp"
+)
+
+// seed stores n messages created a day apart, starting Jan 1st 2024. The ids
+// are "message-1" to "message-n".
+func seed(db *DB, n int) {
+	for i := 1; i <= n; i++ {
+		id := fmt.Sprintf("message-%d", i)
+		db.messages[id] = api.Message{
+			ID:        id,
+			Text:      fmt.Sprintf("Message %d", i),
+			UserID:    "test",
+			CreatedAt: time.Date(2024, 1, i, 0, 0, 0, 0, time.UTC),
+		}
+	}
+}
+
+func ids(msgs []api.Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.ID
+	}
+	return out
+}
+
+func TestDB_ListMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  time.Time
+		order   api.Order
+		limit   int
+		offset  int
+		exclude []string
+		pinned  string
+		want    []string
+	}{
+		{
+			name:  "Desc",
+			order: api.OrderDesc,
+			limit: 2,
+			want:  []string{"message-5", "message-4"},
+		},
+		{
+			name:  "Asc",
+			order: api.OrderAsc,
+			limit: 2,
+			want:  []string{"message-1", "message-2"},
+		},
+		{
+			name:   "Offset",
+			order:  api.OrderDesc,
+			limit:  2,
+			offset: 2,
+			want:   []string{"message-3", "message-2"},
+		},
+		{
+			name:   "OffsetPastEnd",
+			order:  api.OrderDesc,
+			limit:  2,
+			offset: 10,
+			want:   []string{},
+		},
+		{
+			name:   "Before",
+			before: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+			order:  api.OrderDesc,
+			limit:  10,
+			want:   []string{"message-2", "message-1"},
+		},
+		{
+			name:    "Exclude",
+			order:   api.OrderDesc,
+			limit:   2,
+			exclude: []string{"message-5"},
+			want:    []string{"message-4", "message-3"},
+		},
+		{
+			name:   "PinnedFirst",
+			order:  api.OrderAsc,
+			limit:  3,
+			pinned: "message-4",
+			want:   []string{"message-4", "message-1", "message-2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDB()
+			seed(db, 5)
+			if tt.pinned != "" {
+				if _, err := db.SetMessagePinned(context.Background(), tt.pinned, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.before.IsZero() {
+				tt.before = time.Now()
+			}
+
+			got, err := db.ListMessages(context.Background(), tt.before, tt.order, tt.limit, tt.offset, api.ReactionsCounted, false, tt.exclude...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(ids(got), tt.want); diff != "" {
+				t.Errorf("Diff (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDB_ListMessagesAfter(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	seed(db, 5)
+	if _, err := db.SetMessagePinned(ctx, "message-5", true); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.ListMessagesAfter(ctx, "message-2", 2, api.ReactionsCounted, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ids(got), []string{"message-3", "message-4"}); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+
+	// Syncing from the last listed message continues where the page ended.
+	got, err = db.ListMessagesAfter(ctx, got[len(got)-1].ID, 2, api.ReactionsCounted, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ids(got), []string{"message-5"}); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+
+	if _, err := db.ListMessagesAfter(ctx, newID(), 2, api.ReactionsCounted, false); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestDB_ListMessagesBetween(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	seed(db, 5)
+	if _, err := db.SetMessagePinned(ctx, "message-4", true); err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	got, err := db.ListMessagesBetween(ctx, from, to, 2, 0, api.ReactionsCounted, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ids(got), []string{"message-2", "message-3"}); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+
+	got, err = db.ListMessagesBetween(ctx, from, to, 2, 2, api.ReactionsCounted, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ids(got), []string{"message-4"}); diff != "" {
+		t.Errorf("Diff of the second page (-got +want)\n%s", diff)
+	}
+
+	got, err = db.ListMessagesBetween(ctx, to.AddDate(0, 1, 0), to.AddDate(0, 1, 1), 2, 0, api.ReactionsCounted, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Got %d messages on an empty day, want 0", len(got))
+	}
+}
+
+func TestDB_InsertMessage(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	parent, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.ID == "" || parent.CreatedAt.IsZero() {
+		t.Errorf("Got message %+v, want generated id and creation time", parent)
+	}
+
+	if _, err := db.InsertMessage(ctx, api.Message{Text: "hi", UserID: "test", ParentID: parent.ID}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetMessage(ctx, parent.ID, api.ReactionSortCreated, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReplyCount != 1 {
+		t.Errorf("Got reply count %d, want 1", got.ReplyCount)
+	}
+
+	_, err = db.InsertMessage(ctx, api.Message{Text: "hi", UserID: "test", ParentID: newID()})
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestDB_InsertMessage_clientID(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	msg := api.Message{ID: newID(), Text: "hello", UserID: "test"}
+	got, err := db.InsertMessage(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != msg.ID {
+		t.Errorf("Got id %q, want %q", got.ID, msg.ID)
+	}
+
+	_, err = db.InsertMessage(ctx, msg)
+	if !errors.Is(err, api.ErrDuplicateMessage) {
+		t.Errorf("Got error %v, want %v", err, api.ErrDuplicateMessage)
+	}
+	if n, _ := db.CountMessages(ctx); n != 1 {
+		t.Errorf("Got %d messages, want 1", n)
+	}
+}
+
+func TestDB_Reactions(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertReactions(ctx, []api.Reaction{
+		{MessageID: msg.ID, UserID: "test", Type: "like", Score: 1},
+		{MessageID: msg.ID, UserID: "test", Type: "love", Score: 2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetMessage(ctx, msg.ID, api.ReactionSortCreated, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReactionCount != 2 {
+		t.Errorf("Got reaction count %d, want 2", got.ReactionCount)
+	}
+
+	summary, err := db.ReactionSummary(ctx, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := api.ReactionSummary{Counts: map[string]int{"like": 1, "love": 1}, Total: 2, Score: 3}
+	if diff := cmp.Diff(summary, want); diff != "" {
+		t.Errorf("Summary diff (-got +want)\n%s", diff)
+	}
+
+	// Either all or none of the reactions are inserted.
+	_, err = db.InsertReactions(ctx, []api.Reaction{
+		{MessageID: msg.ID, UserID: "test", Type: "like", Score: 1},
+		{MessageID: newID(), UserID: "test", Type: "like", Score: 1},
+	})
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v inserting a reaction to an unknown message, want %v", err, api.ErrNotFound)
+	}
+	if n, _ := db.CountReactions(ctx, msg.ID); n != 2 {
+		t.Errorf("Got %d reactions, want 2", n)
+	}
+}
+
+func TestDB_InsertReaction_duplicate(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	like := api.Reaction{MessageID: msg.ID, UserID: "test", Type: "like", Score: 1}
+	if _, err := db.InsertReaction(ctx, like); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.InsertReaction(ctx, like); !errors.Is(err, api.ErrDuplicateReaction) {
+		t.Errorf("Got error %v inserting the reaction again, want %v", err, api.ErrDuplicateReaction)
+	}
+	love := api.Reaction{MessageID: msg.ID, UserID: "test", Type: "love", Score: 1}
+	if _, err := db.InsertReactions(ctx, []api.Reaction{love, love}); !errors.Is(err, api.ErrDuplicateReaction) {
+		t.Errorf("Got error %v inserting a batch with a repeated reaction, want %v", err, api.ErrDuplicateReaction)
+	}
+	if n, _ := db.CountReactions(ctx, msg.ID); n != 1 {
+		t.Errorf("Got %d reactions, want 1", n)
+	}
+
+	// Other users may react with the same type.
+	if _, err := db.InsertReaction(ctx, api.Reaction{MessageID: msg.ID, UserID: "other", Type: "like", Score: 1}); err != nil {
+		t.Errorf("Got error %v reacting as another user, want none", err)
+	}
+}
+
+func TestDB_GetReactionByID(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := db.InsertReaction(ctx, api.Reaction{MessageID: msg.ID, UserID: "test", Type: "like", Score: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetReactionByID(ctx, want.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+	if _, err := db.GetReactionByID(ctx, newID()); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for an unknown reaction, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestDB_DeleteReaction(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.InsertReaction(ctx, api.Reaction{MessageID: msg.ID, UserID: "test", Type: "like", Score: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteReaction(ctx, "other", r.ID); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v deleting from another message, want %v", err, api.ErrNotFound)
+	}
+	if err := db.DeleteReaction(ctx, msg.ID, r.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetReaction(ctx, msg.ID, r.ID); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v getting the deleted reaction, want %v", err, api.ErrNotFound)
+	}
+	if err := db.DeleteReaction(ctx, msg.ID, r.ID); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v deleting twice, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestDB_ToggleReaction(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertReaction(ctx, api.Reaction{MessageID: msg.ID, UserID: "other", Type: "like", Score: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	like := api.Reaction{MessageID: msg.ID, UserID: "test", Type: "like", Score: 1}
+	for _, want := range []struct {
+		active bool
+		count  int
+	}{{true, 2}, {false, 1}, {true, 2}} {
+		got, err := db.ToggleReaction(ctx, like)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Active != want.active || got.Count != want.count || len(got.Reactions) != 1 {
+			t.Errorf("Got active %t, count %d and %d reactions, want %t, %d and 1", got.Active, got.Count, len(got.Reactions), want.active, want.count)
+		}
+	}
+
+	if _, err := db.ToggleReaction(ctx, api.Reaction{MessageID: "other", UserID: "test", Type: "like"}); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for an unknown message, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestDB_SearchMessages(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	seed(db, 3)
+	db.messages["message-2"] = api.Message{
+		ID:        "message-2",
+		Text:      "Say <b>Hello</b> to the world",
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	got, err := db.SearchMessages(ctx, "hello world", 10, 0, api.ReactionsCounted, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != "message-2" {
+		t.Fatalf("Got %+v, want only message-2", got)
+	}
+	want := "Say &lt;b&gt;<mark>Hello</mark>&lt;/b&gt; to the <mark>world</mark>"
+	if got[0].Highlight != want {
+		t.Errorf("Got highlight %q, want %q", got[0].Highlight, want)
+	}
+}
+
+func TestDB_FindReaction(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := db.InsertReaction(ctx, api.Reaction{MessageID: msg.ID, UserID: "test", Type: "like", Score: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.FindReaction(ctx, msg.ID, "test", "like")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != want.ID {
+		t.Errorf("Got reaction %q, want %q", got.ID, want.ID)
+	}
+	if _, err := db.FindReaction(ctx, msg.ID, "test", "love"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for another type, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestDB_DeleteMessage(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	parent, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := db.InsertMessage(ctx, api.Message{Text: "hi", UserID: "test", ParentID: parent.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.InsertReaction(ctx, api.Reaction{MessageID: reply.ID, UserID: "test", Type: "like", Score: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteMessage(ctx, parent.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The replies and their reactions are deleted too.
+	if _, err := db.GetMessage(ctx, reply.ID, api.ReactionSortCreated, ""); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v getting the reply, want %v", err, api.ErrNotFound)
+	}
+	if _, err := db.GetReaction(ctx, reply.ID, r.ID); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v getting the reaction, want %v", err, api.ErrNotFound)
+	}
+	if err := db.DeleteMessage(ctx, parent.ID); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v deleting twice, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestDB_GetMessage_sortScore(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, score := range []int{1, 3, 2} {
+		r := api.Reaction{MessageID: msg.ID, UserID: fmt.Sprintf("user-%d", i), Type: "like", Score: score}
+		if _, err := db.InsertReaction(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := db.GetMessage(ctx, msg.ID, api.ReactionSortScore, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scores []int
+	for _, r := range got.Reactions {
+		scores = append(scores, r.Score)
+	}
+	if diff := cmp.Diff(scores, []int{3, 2, 1}); diff != "" {
+		t.Errorf("Scores diff (-got +want)\n%s", diff)
+	}
+}
+
+func TestDB_GetMessage_filterType(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertReactions(ctx, []api.Reaction{
+		{MessageID: msg.ID, UserID: "alice", Type: "like", Score: 1},
+		{MessageID: msg.ID, UserID: "bob", Type: "love", Score: 1},
+		{MessageID: msg.ID, UserID: "carol", Type: "like", Score: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for typ, want := range map[string]int{"like": 2, "love": 1, "wow": 0} {
+		got, err := db.GetMessage(ctx, msg.ID, api.ReactionSortCreated, typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Reactions) != want || got.ReactionCount != want {
+			t.Errorf("Got %d reactions counted as %d for %s, want %d", len(got.Reactions), got.ReactionCount, typ, want)
+		}
+		for _, r := range got.Reactions {
+			if r.Type != typ {
+				t.Errorf("Got a %s reaction filtering by %s", r.Type, typ)
+			}
+		}
+		if got.Reactions == nil {
+			t.Errorf("Got nil reactions for %s, want an empty list", typ)
+		}
+	}
+}
+
+func TestDB_GetThread(t *testing.T) {
+	db := NewDB()
+	add := func(id, parentID string, day int, hidden bool) {
+		db.messages[id] = api.Message{
+			ID:        id,
+			ParentID:  parentID,
+			CreatedAt: time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC),
+			Hidden:    hidden,
+		}
+	}
+	add("root", "", 1, false)
+	add("b", "root", 3, false)
+	add("a", "root", 2, false)
+	add("a1", "a", 4, false)
+	add("a1x", "a1", 5, false)
+	add("c", "root", 6, true)
+	add("c1", "c", 7, false)
+
+	tests := []struct {
+		name       string
+		maxDepth   int
+		limit      int
+		withHidden bool
+		want       []string
+	}{
+		{name: "All", maxDepth: 10, limit: 10, want: []string{"root", "a", "a1", "a1x", "b"}},
+		{name: "MaxDepth", maxDepth: 1, limit: 10, want: []string{"root", "a", "b"}},
+		{name: "Limit", maxDepth: 10, limit: 3, want: []string{"root", "a", "a1"}},
+		{name: "WithHidden", maxDepth: 10, limit: 10, withHidden: true, want: []string{"root", "a", "a1", "a1x", "b", "c", "c1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.GetThread(context.Background(), "root", tt.maxDepth, tt.limit, tt.withHidden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotIDs []string
+			for _, m := range got {
+				gotIDs = append(gotIDs, m.ID)
+			}
+			if diff := cmp.Diff(gotIDs, tt.want); diff != "" {
+				t.Errorf("Diff (-got +want)\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := db.GetThread(context.Background(), "unknown", 10, 10, false); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+	}
+	// A hidden message is not found unless hidden messages are included.
+	if _, err := db.GetThread(context.Background(), "c", 10, 10, false); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for a hidden message, want %v", err, api.ErrNotFound)
+	}
+}
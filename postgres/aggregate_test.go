@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+)
+
+func TestPostgres_aggregatedReactions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name          string
+		aggregate     bool
+		reactions     api.ReactionLoad
+		wantAggregate bool
+	}{
+		{name: "Aggregated", aggregate: true, reactions: api.ReactionsLoaded, wantAggregate: true},
+		{name: "Relation", reactions: api.ReactionsLoaded},
+		{name: "Counted", aggregate: true, reactions: api.ReactionsCounted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, queries := unreachableDB(t)
+			pg := &Postgres{bun: db, aggregate: tt.aggregate}
+			pg.ListMessages(ctx, time.Now(), api.OrderDesc, 10, 0, tt.reactions, false)
+
+			if len(queries.queries) != 1 {
+				t.Fatalf("Got %d queries, want 1", len(queries.queries))
+			}
+			if got := strings.Contains(queries.queries[0], "json_agg"); got != tt.wantAggregate {
+				t.Errorf("Got query %s, want aggregated reactions %t", queries.queries[0], tt.wantAggregate)
+			}
+		})
+	}
+}
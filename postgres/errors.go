@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// Postgres error codes for integrity constraint violations.
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+func isUniqueViolation(err error) bool {
+	var pgErr pgdriver.Error
+	return errors.As(err, &pgErr) && pgErr.Field('C') == uniqueViolation
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr pgdriver.Error
+	return errors.As(err, &pgErr) && pgErr.Field('C') == foreignKeyViolation
+}
+
+// IsTransient reports whether err is a transient error after which the
+// operation may succeed when retried, such as a dropped connection or a
+// serialization failure.
+func IsTransient(err error) bool {
+	var pgErr pgdriver.Error
+	if errors.As(err, &pgErr) {
+		code := pgErr.Field('C')
+		switch {
+		case strings.HasPrefix(code, "08"): // connection_exception
+			return true
+		case code == "40001", // serialization_failure
+			code == "40P01", // deadlock_detected
+			code == "57P01", // admin_shutdown
+			code == "57P03": // cannot_connect_now
+			return true
+		default:
+			return false
+		}
+	}
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
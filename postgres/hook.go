@@ -0,0 +1,32 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// queryLogger is a bun.QueryHook that logs every executed query and its
+// duration. The logged SQL includes the query arguments, so it must only be
+// installed in debug mode.
+type queryLogger struct {
+	logger *slog.Logger
+}
+
+func (h *queryLogger) BeforeQuery(ctx context.Context, _ *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *queryLogger) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	attrs := []any{
+		"operation", event.Operation(),
+		"query", event.Query,
+		"duration", time.Since(event.StartTime),
+	}
+	if event.Err != nil {
+		attrs = append(attrs, "error", event.Err.Error())
+	}
+	h.logger.DebugContext(ctx, "Query executed", attrs...)
+}
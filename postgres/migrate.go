@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+// ErrDuplicateReactions is returned by Connect with WithMigrate if the
+// reactions table holds several reactions of a user of the same type to the
+// same message, which prevents the creation of the unique index.
+var ErrDuplicateReactions = errors.New("duplicate reactions")
+
+// migrate brings a database created from an older schema.sql up to date. It
+// is idempotent, so it may run on every startup. Existing duplicate reactions
+// fail the migration unless dedupe is set, in which case the oldest reaction
+// of each duplicate is kept and the others are deleted.
+func migrate(ctx context.Context, db bun.IDB, dedupe bool) error {
+	duplicates, err := db.NewSelect().
+		TableExpr("reactions").
+		Group("message_id", "user_id", "type").
+		Having("count(*) > 1").
+		Count(ctx)
+	if err != nil {
+		return fmt.Errorf("count duplicate reactions: %w", err)
+	}
+	if duplicates > 0 && !dedupe {
+		return fmt.Errorf("%w: %d users reacted more than once with the same type to a message, dedupe them to migrate", ErrDuplicateReactions, duplicates)
+	}
+	if duplicates > 0 {
+		_, err := db.NewDelete().
+			TableExpr("reactions").
+			Where(`id IN (
+				SELECT id FROM (
+					SELECT id, row_number() OVER (PARTITION BY message_id, user_id, type ORDER BY created_at, id) AS n
+					FROM reactions
+				) AS ranked WHERE n > 1
+			)`).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete duplicate reactions: %w", err)
+		}
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS idx_reactions_user_type
+ON reactions(message_id, user_id, type)`)
+	if err != nil {
+		return fmt.Errorf("create index: %w", err)
+	}
+	return nil
+}
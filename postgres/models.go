@@ -8,36 +8,78 @@ import (
 
 // A message represents a message in the database.
 type message struct {
-	ID          string     `bun:",pk,type:uuid,default:uuid_generate_v4()"`
-	MessageText string     `bun:"message_text,notnull"`
-	UserID      string     `bun:",notnull"`
-	CreatedAt   time.Time  `bun:",nullzero,default:now()"`
-	Reactions   []reaction `bun:"rel:has-many,join:id=message_id"`
+	ID          string       `bun:",pk,type:uuid,default:uuid_generate_v4()"`
+	MessageText string       `bun:"message_text,notnull"`
+	UserID      string       `bun:",notnull"`
+	ParentID    string       `bun:",nullzero,type:uuid"`
+	CreatedAt   time.Time    `bun:",nullzero,default:now()"`
+	Pinned      bool         `bun:",notnull,default:false"`
+	Hidden      bool         `bun:",notnull,default:false"`
+	Attachments []attachment `bun:",type:jsonb,default:'[]'"`
+	Reactions   []reaction   `bun:"rel:has-many,join:id=message_id"`
+	ReplyCount  int          `bun:",scanonly"`
+	// ReactionCount is only set when the reactions are counted rather than
+	// loaded.
+	ReactionCount int `bun:",scanonly"`
+	// AggregatedReactions is only set when the reactions are aggregated into
+	// the message row rather than loaded as a relation.
+	AggregatedReactions []reaction `bun:",scanonly"`
+	// Highlight is only set by searches.
+	Highlight string `bun:",scanonly"`
 }
 
+// An attachment is stored as an element of the message's attachments JSONB
+// column.
+type attachment struct {
+	URL  string `json:"url"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// The JSON tags of a reaction match the keys of aggregatedReactionsColumn.
 type reaction struct {
-	ID        string    `bun:",pk,type:uuid,default:uuid_generate_v4()"`
-	MessageID string    `bun:",notnull"`
-	UserID    string    `bun:",notnull"`
-	Type      string    `bun:",notnull"`
-	Score     int       `bun:",notnull,default:1"`
-	CreatedAt time.Time `bun:",nullzero,default:now()"`
-	Message   message   `bun:"rel:belongs-to,join:id=id"`
+	ID        string    `bun:",pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	MessageID string    `bun:",notnull" json:"message_id"`
+	UserID    string    `bun:",notnull" json:"user_id"`
+	Type      string    `bun:",notnull" json:"type"`
+	Emoji     string    `bun:",notnull,default:''" json:"emoji"`
+	Score     int       `bun:",notnull" json:"score"`
+	CreatedAt time.Time `bun:",nullzero,default:now()" json:"created_at"`
+	Message   message   `bun:"rel:belongs-to,join:id=id" json:"-"`
 }
 
 func (m message) APIMessage() api.Message {
+	if m.AggregatedReactions != nil {
+		m.Reactions = m.AggregatedReactions
+	}
 	reactions := make([]api.Reaction, len(m.Reactions))
 	for i, r := range m.Reactions {
 		reactions[i] = r.APIReaction()
 	}
 
+	var attachments []api.Attachment
+	for _, a := range m.Attachments {
+		attachments = append(attachments, api.Attachment(a))
+	}
+
+	reactionCount := m.ReactionCount
+	if reactionCount == 0 {
+		reactionCount = len(m.Reactions)
+	}
+
 	return api.Message{
 		ID:            m.ID,
 		Text:          m.MessageText,
 		UserID:        m.UserID,
-		CreatedAt:     m.CreatedAt,
+		ParentID:      m.ParentID,
+		CreatedAt:     m.CreatedAt.UTC(),
+		Pinned:        m.Pinned,
+		Hidden:        m.Hidden,
+		Attachments:   attachments,
 		Reactions:     reactions,
-		ReactionCount: len(m.Reactions),
+		ReactionCount: reactionCount,
+		ReplyCount:    m.ReplyCount,
 	}
 }
 
@@ -47,7 +89,8 @@ func (r reaction) APIReaction() api.Reaction {
 		MessageID: r.MessageID,
 		UserID:    r.UserID,
 		Type:      r.Type,
+		Emoji:     r.Emoji,
 		Score:     r.Score,
-		CreatedAt: r.CreatedAt,
+		CreatedAt: r.CreatedAt.UTC(),
 	}
 }
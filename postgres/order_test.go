@@ -0,0 +1,28 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+)
+
+func TestPostgres_unknownOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	db, queries := unreachableDB(t)
+	pg := &Postgres{bun: db}
+
+	const injection = "created_at; DROP TABLE messages"
+	if _, err := pg.ListMessages(ctx, time.Now(), api.Order(injection), 10, 0, api.ReactionsCounted, false); err == nil {
+		t.Error("Got no error listing messages, want an unknown order error")
+	}
+	if _, err := pg.GetMessage(ctx, "1", api.ReactionSort(injection), ""); err == nil {
+		t.Error("Got no error getting a message, want an unknown sort error")
+	}
+	if queries.n != 0 {
+		t.Errorf("Got queries %q, want none", queries.queries)
+	}
+}
@@ -3,7 +3,12 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/GetStream/stream-backend-homework-assignment/api"
 	"github.com/uptrace/bun"
@@ -14,33 +19,299 @@ import (
 // Postgres provides storage in PostgreSQL.
 type Postgres struct {
 	bun *bun.DB
+	// replica serves the list queries when set, bun serves everything else.
+	replica *bun.DB
+	// aggregate makes the list queries aggregate the reactions of each
+	// message into its row instead of loading them in a second query.
+	aggregate bool
+}
+
+// An Option configures the connection created by Connect.
+type Option func(*config)
+
+type config struct {
+	debug      bool
+	logger     *slog.Logger
+	replicaDSN string
+	attempts   int
+	delay      time.Duration
+	aggregate  bool
+	verify     bool
+	migrate    bool
+	dedupe     bool
+}
+
+// WithDebug enables logging of every executed SQL query. Logged queries
+// include their arguments, so this is off by default and should not be
+// enabled in production.
+func WithDebug(debug bool) Option {
+	return func(c *config) {
+		c.debug = debug
+	}
+}
+
+// WithLogger sets the logger used for debug query logging. Defaults to
+// slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithReplica routes the list queries to the read replica at connStr, while
+// everything else, including reads that must see the latest writes, uses the
+// primary. By default all queries use the primary.
+func WithReplica(connStr string) Option {
+	return func(c *config) {
+		c.replicaDSN = connStr
+	}
+}
+
+// WithConnectRetry makes Connect ping the database up to attempts times, for
+// databases that are still starting up. Connect waits delay before the first
+// retry and twice as long before each following one. Defaults to a single
+// attempt.
+func WithConnectRetry(attempts int, delay time.Duration) Option {
+	return func(c *config) {
+		c.attempts = attempts
+		c.delay = delay
+	}
+}
+
+// WithAggregatedReactions makes the list queries load the reactions of the
+// listed messages with json_agg in the same query, rather than with a second
+// query for the reactions of all listed messages. Defaults to false.
+func WithAggregatedReactions(aggregate bool) Option {
+	return func(c *config) {
+		c.aggregate = aggregate
+	}
+}
+
+// WithVerifySchema makes Connect check that the tables and columns the
+// queries rely on exist, so that a missing or outdated schema is reported on
+// startup rather than by the first failing query. Connect then returns an
+// error wrapping ErrSchema. Defaults to false.
+func WithVerifySchema(verify bool) Option {
+	return func(c *config) {
+		c.verify = verify
+	}
+}
+
+// WithMigrate makes Connect update a database created from an older
+// schema.sql, such as by adding the unique index on the reactions of a user.
+// Connect returns an error wrapping ErrDuplicateReactions if the reactions
+// table holds duplicates, unless dedupe is set: the oldest of the duplicate
+// reactions are then kept and the others deleted. Defaults to false.
+func WithMigrate(migrate, dedupe bool) Option {
+	return func(c *config) {
+		c.migrate = migrate
+		c.dedupe = dedupe
+	}
 }
 
 // Connect connects to the database and ping the DB to ensure the connection is
 // working.
-func Connect(ctx context.Context, connStr string) (*Postgres, error) {
+func Connect(ctx context.Context, connStr string, opts ...Option) (*Postgres, error) {
+	cfg := config{
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	sqlDB := sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(connStr)))
-	if err := sqlDB.PingContext(ctx); err != nil {
+	if err := pingWithRetry(ctx, cfg.attempts, cfg.delay, sqlDB.PingContext); err != nil {
 		return nil, fmt.Errorf("ping database: %w", err)
 	}
+	pg := &Postgres{
+		bun:       newBunDB(sqlDB, cfg),
+		aggregate: cfg.aggregate,
+	}
+
+	if cfg.replicaDSN != "" {
+		replicaDB := sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(cfg.replicaDSN)))
+		if err := pingWithRetry(ctx, cfg.attempts, cfg.delay, replicaDB.PingContext); err != nil {
+			sqlDB.Close()
+			return nil, fmt.Errorf("ping replica: %w", err)
+		}
+		pg.replica = newBunDB(replicaDB, cfg)
+	}
+
+	if cfg.migrate {
+		err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			return migrate(ctx, tx, cfg.dedupe)
+		})
+		if err != nil {
+			pg.bun.Close()
+			if pg.replica != nil {
+				pg.replica.Close()
+			}
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
+	}
+
+	if cfg.verify {
+		if err := verifySchema(ctx, pg.bun, expectedSchema); err != nil {
+			pg.bun.Close()
+			if pg.replica != nil {
+				pg.replica.Close()
+			}
+			return nil, err
+		}
+	}
+	return pg, nil
+}
+
+// pingWithRetry calls ping until it succeeds, it was called attempts times or
+// ctx is done, doubling the delay between the calls after each retry. The last
+// error of ping is returned.
+func pingWithRetry(ctx context.Context, attempts int, delay time.Duration, ping func(context.Context) error) error {
+	err := ping(ctx)
+	for i := 1; i < attempts && err != nil; i++ {
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-t.C:
+		}
+		delay *= 2
+		err = ping(ctx)
+	}
+	return err
+}
+
+// reader returns the DB serving the list queries: the replica if there is one,
+// the primary otherwise.
+func (pg *Postgres) reader() *bun.DB {
+	if pg.replica != nil {
+		return pg.replica
+	}
+	return pg.bun
+}
+
+func newBunDB(sqlDB *sql.DB, cfg config) *bun.DB {
 	db := bun.NewDB(sqlDB, pgdialect.New())
-	return &Postgres{
-		bun: db,
-	}, nil
+	if cfg.debug {
+		db.AddQueryHook(&queryLogger{logger: cfg.logger})
+	}
+	return db
 }
 
-// ListMessages returns all messages in the database.
-func (pg *Postgres) ListMessages(ctx context.Context, limit, offset int, excludeMsgIDs ...string) ([]api.Message, error) {
+// replyCountColumn selects the number of direct replies of each message.
+const replyCountColumn = "(SELECT COUNT(*) FROM messages AS reply WHERE reply.parent_id = message.id) AS reply_count"
+
+// messageOrders and reactionSorts map the orders the API asks for to fixed
+// SQL. Request values never make it into queries as SQL: they are either
+// looked up here or passed as placeholder arguments.
+var (
+	messageOrders = map[api.Order]string{
+		api.OrderDesc: "message.created_at DESC",
+		api.OrderAsc:  "message.created_at ASC",
+	}
+	reactionSorts = map[api.ReactionSort][]string{
+		api.ReactionSortCreated: {"created_at ASC"},
+		api.ReactionSortScore:   {"score DESC", "created_at DESC"},
+	}
+)
+
+// ListMessages returns a page of the messages created before the given time
+// in the given order, pinned messages first. The messages include the number
+// of their direct replies and as much of their reactions as reactions asks
+// for. Hidden messages are left out unless withHidden is set.
+func (pg *Postgres) ListMessages(ctx context.Context, before time.Time, order api.Order, limit, offset int, reactions api.ReactionLoad, withHidden bool, excludeMsgIDs ...string) ([]api.Message, error) {
+	createdAt, ok := messageOrders[order]
+	if !ok {
+		return nil, fmt.Errorf("unknown order %q", order)
+	}
+
 	var msgs []message
-	q := pg.bun.NewSelect().
+	q := pg.reader().NewSelect().
 		Model(&msgs).
-		Relation("Reactions").
-		Order("created_at DESC").
+		ColumnExpr("message.*").
+		ColumnExpr(replyCountColumn).
+		Where("message.created_at < ?", before.UTC()).
+		Order("message.pinned DESC", createdAt).
 		Limit(limit).
 		Offset(offset)
-
+	q = pg.selectReactions(q, reactions)
+	if !withHidden {
+		q = q.Where("NOT message.hidden")
+	}
 	if len(excludeMsgIDs) > 0 {
-		q = q.Where("id NOT IN (?)", bun.In(excludeMsgIDs))
+		q = q.Where("message.id NOT IN (?)", bun.In(excludeMsgIDs))
+	}
+
+	if err := q.Scan(ctx); err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+	out := make([]api.Message, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.APIMessage()
+	}
+
+	return out, nil
+}
+
+// ListMessagesAfter returns up to limit messages created after the message
+// identified by afterID, oldest first. Messages created at the same time are
+// ordered by id, so that paging by the id of the last message neither skips
+// nor repeats messages. Hidden messages are left out unless withHidden is set.
+// api.ErrNotFound is returned if the message does not exist.
+func (pg *Postgres) ListMessagesAfter(ctx context.Context, afterID string, limit int, reactions api.ReactionLoad, withHidden bool) ([]api.Message, error) {
+	var after message
+	err := pg.reader().NewSelect().
+		Model(&after).
+		Column("created_at").
+		Where("id = ?", afterID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, api.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan after: %w", err)
+	}
+
+	var msgs []message
+	q := pg.reader().NewSelect().
+		Model(&msgs).
+		ColumnExpr("message.*").
+		ColumnExpr(replyCountColumn).
+		Where("(message.created_at, message.id) > (?, ?)", after.CreatedAt, afterID).
+		Order("message.created_at ASC", "message.id ASC").
+		Limit(limit)
+	q = pg.selectReactions(q, reactions)
+	if !withHidden {
+		q = q.Where("NOT message.hidden")
+	}
+
+	if err := q.Scan(ctx); err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+	out := make([]api.Message, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.APIMessage()
+	}
+	return out, nil
+}
+
+// ListMessagesBetween returns a page of the messages created from the time
+// from up to, but not including, the time to, oldest first. Hidden messages
+// are left out unless withHidden is set.
+func (pg *Postgres) ListMessagesBetween(ctx context.Context, from, to time.Time, limit, offset int, reactions api.ReactionLoad, withHidden bool) ([]api.Message, error) {
+	var msgs []message
+	q := pg.reader().NewSelect().
+		Model(&msgs).
+		ColumnExpr("message.*").
+		ColumnExpr(replyCountColumn).
+		Where("message.created_at >= ?", from.UTC()).
+		Where("message.created_at < ?", to.UTC()).
+		Order("message.created_at ASC", "message.id ASC").
+		Limit(limit).
+		Offset(offset)
+	q = pg.selectReactions(q, reactions)
+	if !withHidden {
+		q = q.Where("NOT message.hidden")
 	}
 
 	if err := q.Scan(ctx); err != nil {
@@ -50,33 +321,620 @@ func (pg *Postgres) ListMessages(ctx context.Context, limit, offset int, exclude
 	for i, m := range msgs {
 		out[i] = m.APIMessage()
 	}
+	return out, nil
+}
+
+// searchConfig is the text search configuration messages are searched with.
+const searchConfig = "english"
+
+// highlightStart and highlightStop delimit the matches in the headlines of
+// ts_headline. Being private use characters, which html.EscapeString keeps,
+// they are replaced with <mark> tags once the text is escaped.
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+var highlightTags = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// highlight escapes a headline of ts_headline and marks its matches.
+func highlight(headline string) string {
+	return highlightTags.Replace(html.EscapeString(headline))
+}
+
+// SearchMessages returns a page of the messages whose text matches the words
+// of query, best matches first, using full-text search.
+func (pg *Postgres) SearchMessages(ctx context.Context, query string, limit, offset int, reactions api.ReactionLoad, withHidden bool) ([]api.SearchResult, error) {
+	var msgs []message
+	q := pg.reader().NewSelect().
+		Model(&msgs).
+		ColumnExpr("message.*").
+		ColumnExpr(replyCountColumn).
+		ColumnExpr("ts_headline(?, message.message_text, plainto_tsquery(?, ?), ?) AS highlight",
+			searchConfig, searchConfig, query,
+			"HighlightAll=true, StartSel="+highlightStart+", StopSel="+highlightStop).
+		Where("to_tsvector(?, message.message_text) @@ plainto_tsquery(?, ?)", searchConfig, searchConfig, query).
+		OrderExpr("ts_rank(to_tsvector(?, message.message_text), plainto_tsquery(?, ?)) DESC", searchConfig, searchConfig, query).
+		Order("message.created_at DESC", "message.id DESC").
+		Limit(limit).
+		Offset(offset)
+	q = pg.selectReactions(q, reactions)
+	if !withHidden {
+		q = q.Where("NOT message.hidden")
+	}
 
+	if err := q.Scan(ctx); err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+	out := make([]api.SearchResult, len(msgs))
+	for i, m := range msgs {
+		out[i] = api.SearchResult{Message: m.APIMessage(), Highlight: highlight(m.Highlight)}
+	}
 	return out, nil
 }
 
+// aggregatedReactionsColumn selects the reactions of each message as a JSON
+// array, oldest first. created_at is stored without a time zone, in UTC, and
+// formatted as such.
+const aggregatedReactionsColumn = `(
+	SELECT COALESCE(json_agg(json_build_object(
+		'id', r.id,
+		'message_id', r.message_id,
+		'user_id', r.user_id,
+		'type', r.type,
+		'emoji', r.emoji,
+		'score', r.score,
+		'created_at', to_char(r.created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+	) ORDER BY r.created_at, r.id), '[]')
+	FROM reactions AS r
+	WHERE r.message_id = message.id
+) AS aggregated_reactions`
+
+// selectReactions makes q load the reactions of the selected messages, only
+// count them in the same query, or leave them out, as reactions asks for.
+// Loaded reactions are aggregated into the message rows if pg.aggregate is
+// set.
+func (pg *Postgres) selectReactions(q *bun.SelectQuery, reactions api.ReactionLoad) *bun.SelectQuery {
+	switch reactions {
+	case api.ReactionsLoaded:
+		if pg.aggregate {
+			return q.ColumnExpr(aggregatedReactionsColumn)
+		}
+		return q.Relation("Reactions")
+	case api.ReactionsOmitted:
+		return q
+	}
+	return q.ColumnExpr("COUNT(reaction.id) AS reaction_count").
+		Join("LEFT JOIN reactions AS reaction ON reaction.message_id = message.id").
+		Group("message.id")
+}
+
+// GetMessage returns the message identified by messageID along with its
+// reactions in the given sort order, only those of reactionType unless it is
+// empty. api.ErrNotFound is returned if the message does not exist.
+func (pg *Postgres) GetMessage(ctx context.Context, messageID string, sort api.ReactionSort, reactionType string) (api.Message, error) {
+	reactionOrder, ok := reactionSorts[sort]
+	if !ok {
+		return api.Message{}, fmt.Errorf("unknown reaction sort %q", sort)
+	}
+
+	var m message
+	err := pg.bun.NewSelect().
+		Model(&m).
+		ColumnExpr("message.*").
+		ColumnExpr(replyCountColumn).
+		Relation("Reactions", func(q *bun.SelectQuery) *bun.SelectQuery {
+			if reactionType != "" {
+				q = q.Where("type = ?", reactionType)
+			}
+			return q.Order(reactionOrder...)
+		}).
+		Where("id = ?", messageID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.Message{}, api.ErrNotFound
+	}
+	if err != nil {
+		return api.Message{}, fmt.Errorf("scan: %w", err)
+	}
+	return m.APIMessage(), nil
+}
+
+// GetThread returns the message identified by messageID followed by its
+// replies, up to maxDepth levels deep and limit messages in total. Replies
+// directly follow the message they reply to, oldest first with ties broken by
+// ID. Hidden messages
+// and their replies are left out unless withHidden is set. api.ErrNotFound is
+// returned if the message does not exist or is left out.
+func (pg *Postgres) GetThread(ctx context.Context, messageID string, maxDepth, limit int, withHidden bool) ([]api.ThreadMessage, error) {
+	var nodes []struct {
+		ID    string
+		Depth int
+	}
+	err := pg.bun.NewRaw(`
+		WITH RECURSIVE thread AS (
+			SELECT id, 0 AS depth, ARRAY[ROW(created_at, id)] AS path
+			FROM messages
+			WHERE id = ? AND (? OR NOT hidden)
+			UNION ALL
+			SELECT m.id, t.depth + 1, t.path || ROW(m.created_at, m.id)
+			FROM messages AS m
+			JOIN thread AS t ON m.parent_id = t.id
+			WHERE t.depth < ? AND (? OR NOT m.hidden)
+		)
+		SELECT id, depth FROM thread ORDER BY path LIMIT ?`,
+		messageID, withHidden, maxDepth, withHidden, limit,
+	).Scan(ctx, &nodes)
+	if err != nil {
+		return nil, fmt.Errorf("scan thread: %w", err)
+	}
+	if len(nodes) == 0 {
+		return nil, api.ErrNotFound
+	}
+
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.ID
+	}
+	var msgs []message
+	err = pg.bun.NewSelect().
+		Model(&msgs).
+		ColumnExpr("message.*").
+		ColumnExpr(replyCountColumn).
+		Relation("Reactions").
+		Where("id IN (?)", bun.In(ids)).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("scan messages: %w", err)
+	}
+	byID := make(map[string]message, len(msgs))
+	for _, m := range msgs {
+		byID[m.ID] = m
+	}
+
+	out := make([]api.ThreadMessage, 0, len(nodes))
+	for _, n := range nodes {
+		m, ok := byID[n.ID]
+		if !ok {
+			// Deleted between the queries.
+			continue
+		}
+		out = append(out, api.ThreadMessage{Message: m.APIMessage(), Depth: n.Depth})
+	}
+	return out, nil
+}
+
+// SetMessagePinned pins or unpins a message and returns the updated message.
+// api.ErrNotFound is returned if the message does not exist.
+func (pg *Postgres) SetMessagePinned(ctx context.Context, messageID string, pinned bool) (api.Message, error) {
+	m := &message{ID: messageID, Pinned: pinned}
+	res, err := pg.bun.NewUpdate().Model(m).Column("pinned").WherePK().Exec(ctx)
+	if err != nil {
+		return api.Message{}, fmt.Errorf("update: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return api.Message{}, fmt.Errorf("rows affected: %w", err)
+	} else if n == 0 {
+		return api.Message{}, api.ErrNotFound
+	}
+
+	// Reselect the message like GetMessage does, with its reply count.
+	return pg.GetMessage(ctx, messageID, api.ReactionSortCreated, "")
+}
+
+// SetMessageHidden hides or shows a message and returns the updated message.
+// api.ErrNotFound is returned if the message does not exist.
+func (pg *Postgres) SetMessageHidden(ctx context.Context, messageID string, hidden bool) (api.Message, error) {
+	m := &message{ID: messageID, Hidden: hidden}
+	res, err := pg.bun.NewUpdate().Model(m).Column("hidden").WherePK().Exec(ctx)
+	if err != nil {
+		return api.Message{}, fmt.Errorf("update: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return api.Message{}, fmt.Errorf("rows affected: %w", err)
+	} else if n == 0 {
+		return api.Message{}, api.ErrNotFound
+	}
+
+	// Reselect the message like GetMessage does, with its reply count.
+	return pg.GetMessage(ctx, messageID, api.ReactionSortCreated, "")
+}
+
+// UpdateMessageText replaces the text of a message and returns the updated
+// message. api.ErrNotFound is returned if the message does not exist.
+func (pg *Postgres) UpdateMessageText(ctx context.Context, messageID, text string) (api.Message, error) {
+	m := &message{ID: messageID, MessageText: text}
+	res, err := pg.bun.NewUpdate().Model(m).Column("message_text").WherePK().Exec(ctx)
+	if err != nil {
+		return api.Message{}, fmt.Errorf("update: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return api.Message{}, fmt.Errorf("rows affected: %w", err)
+	} else if n == 0 {
+		return api.Message{}, api.ErrNotFound
+	}
+
+	// Reselect the message like GetMessage does, with its reply count.
+	return pg.GetMessage(ctx, messageID, api.ReactionSortCreated, "")
+}
+
+// DeleteMessage deletes a message. Its replies and reactions are deleted by
+// the ON DELETE CASCADE of their foreign keys. api.ErrNotFound is returned if
+// the message does not exist.
+func (pg *Postgres) DeleteMessage(ctx context.Context, messageID string) error {
+	res, err := pg.bun.NewDelete().Model(&message{ID: messageID}).WherePK().Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if n == 0 {
+		return api.ErrNotFound
+	}
+	return nil
+}
+
+// LatestMessageTime returns the creation time of the most recent message, or
+// the zero time if there are no messages.
+func (pg *Postgres) LatestMessageTime(ctx context.Context) (time.Time, error) {
+	var latest bun.NullTime
+	err := pg.bun.NewSelect().
+		Model((*message)(nil)).
+		ColumnExpr("MAX(created_at)").
+		Scan(ctx, &latest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("scan: %w", err)
+	}
+	return latest.Time, nil
+}
+
+// CountMessages returns the total number of messages.
+func (pg *Postgres) CountMessages(ctx context.Context) (int, error) {
+	n, err := pg.reader().NewSelect().Model((*message)(nil)).Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("count: %w", err)
+	}
+	return n, nil
+}
+
+// CountReactions returns the number of reactions to the message identified by
+// messageID.
+func (pg *Postgres) CountReactions(ctx context.Context, messageID string) (int, error) {
+	n, err := pg.bun.NewSelect().
+		Model((*reaction)(nil)).
+		Where("message_id = ?", messageID).
+		Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("count: %w", err)
+	}
+	return n, nil
+}
+
 // InsertMessage inserts a message into the database. The returned message
-// holds auto generated fields, such as the message id.
+// holds the stored values, including the creation time and the id, which is
+// generated unless set. api.ErrDuplicateMessage is returned if the id is
+// taken.
 func (pg *Postgres) InsertMessage(ctx context.Context, msg api.Message) (api.Message, error) {
 	m := &message{
+		ID:          msg.ID,
 		MessageText: msg.Text,
 		UserID:      msg.UserID,
+		ParentID:    msg.ParentID,
+		CreatedAt:   msg.CreatedAt.UTC(),
 	}
-	if _, err := pg.bun.NewInsert().Model(m).Exec(ctx); err != nil {
+	for _, a := range msg.Attachments {
+		m.Attachments = append(m.Attachments, attachment(a))
+	}
+	res, err := pg.bun.NewInsert().
+		Model(m).
+		On("CONFLICT (id) DO NOTHING").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			// The parent message does not exist.
+			return api.Message{}, api.ErrNotFound
+		}
 		return api.Message{}, fmt.Errorf("insert: %w", err)
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// The id is taken, the insert did nothing.
+		return api.Message{}, api.ErrDuplicateMessage
+	}
 	return m.APIMessage(), nil
 }
 
-// InsertReaction inserts a message reaction into the database.
+// InsertReaction inserts a message reaction into the database. The returned
+// reaction holds the stored values, the score is stored as given, zero
+// included; the API applies the default score. api.ErrDuplicateReaction is
+// returned if the user already reacted to the message with the same type.
 func (pg *Postgres) InsertReaction(ctx context.Context, r api.Reaction) (api.Reaction, error) {
 	rm := &reaction{
 		MessageID: r.MessageID,
 		UserID:    r.UserID,
 		Type:      r.Type,
+		Emoji:     r.Emoji,
 		Score:     r.Score,
+		CreatedAt: r.CreatedAt.UTC(),
 	}
-	if _, err := pg.bun.NewInsert().Model(rm).Exec(ctx); err != nil {
+	if _, err := pg.bun.NewInsert().Model(rm).Returning("*").Exec(ctx); err != nil {
+		if isForeignKeyViolation(err) {
+			// The message does not exist.
+			return api.Reaction{}, api.ErrNotFound
+		}
+		if isUniqueViolation(err) {
+			return api.Reaction{}, api.ErrDuplicateReaction
+		}
 		return api.Reaction{}, fmt.Errorf("insert: %w", err)
 	}
 	return rm.APIReaction(), nil
 }
+
+// ListReactionsByUser returns the reactions given by the user identified by
+// userID, newest first.
+func (pg *Postgres) ListReactionsByUser(ctx context.Context, userID string) ([]api.Reaction, error) {
+	var rms []reaction
+	err := pg.bun.NewSelect().
+		Model(&rms).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+
+	out := make([]api.Reaction, len(rms))
+	for i, rm := range rms {
+		out[i] = rm.APIReaction()
+	}
+	return out, nil
+}
+
+// InsertReactions inserts several message reactions into the database in a
+// single statement, so that either all or none are inserted.
+func (pg *Postgres) InsertReactions(ctx context.Context, rs []api.Reaction) ([]api.Reaction, error) {
+	rms := make([]reaction, len(rs))
+	for i, r := range rs {
+		rms[i] = reaction{
+			MessageID: r.MessageID,
+			UserID:    r.UserID,
+			Type:      r.Type,
+			Emoji:     r.Emoji,
+			Score:     r.Score,
+			CreatedAt: r.CreatedAt.UTC(),
+		}
+	}
+	if _, err := pg.bun.NewInsert().Model(&rms).Returning("*").Exec(ctx); err != nil {
+		if isForeignKeyViolation(err) {
+			// The message does not exist.
+			return nil, api.ErrNotFound
+		}
+		if isUniqueViolation(err) {
+			return nil, api.ErrDuplicateReaction
+		}
+		return nil, fmt.Errorf("insert: %w", err)
+	}
+
+	out := make([]api.Reaction, len(rms))
+	for i, rm := range rms {
+		out[i] = rm.APIReaction()
+	}
+	return out, nil
+}
+
+// GetReaction returns a single reaction of the message identified by
+// messageID. api.ErrNotFound is returned if no such reaction exists.
+func (pg *Postgres) GetReaction(ctx context.Context, messageID, reactionID string) (api.Reaction, error) {
+	var rm reaction
+	err := pg.bun.NewSelect().
+		Model(&rm).
+		Where("id = ? AND message_id = ?", reactionID, messageID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.Reaction{}, api.ErrNotFound
+	}
+	if err != nil {
+		return api.Reaction{}, fmt.Errorf("scan: %w", err)
+	}
+	return rm.APIReaction(), nil
+}
+
+// GetReactionByID returns the reaction identified by reactionID, whichever
+// message it belongs to. api.ErrNotFound is returned if no such reaction
+// exists.
+func (pg *Postgres) GetReactionByID(ctx context.Context, reactionID string) (api.Reaction, error) {
+	var rm reaction
+	err := pg.bun.NewSelect().
+		Model(&rm).
+		Where("id = ?", reactionID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.Reaction{}, api.ErrNotFound
+	}
+	if err != nil {
+		return api.Reaction{}, fmt.Errorf("scan: %w", err)
+	}
+	return rm.APIReaction(), nil
+}
+
+// FindReaction returns the reaction of the user identified by userID with the
+// given type to the message identified by messageID. api.ErrNotFound is
+// returned if no such reaction exists.
+func (pg *Postgres) FindReaction(ctx context.Context, messageID, userID, reactionType string) (api.Reaction, error) {
+	var rm reaction
+	err := pg.bun.NewSelect().
+		Model(&rm).
+		Where("message_id = ? AND user_id = ? AND type = ?", messageID, userID, reactionType).
+		Order("created_at").
+		Limit(1).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.Reaction{}, api.ErrNotFound
+	}
+	if err != nil {
+		return api.Reaction{}, fmt.Errorf("scan: %w", err)
+	}
+	return rm.APIReaction(), nil
+}
+
+// DeleteReaction deletes a reaction of the message identified by messageID.
+// api.ErrNotFound is returned if no such reaction exists.
+func (pg *Postgres) DeleteReaction(ctx context.Context, messageID, reactionID string) error {
+	res, err := pg.bun.NewDelete().
+		Model((*reaction)(nil)).
+		Where("id = ? AND message_id = ?", reactionID, messageID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if n == 0 {
+		return api.ErrNotFound
+	}
+	return nil
+}
+
+// ToggleReaction deletes the reactions of the user with the type of r to its
+// message, or inserts r if there are none, in a transaction. The message is
+// locked meanwhile, so that concurrent toggles don't both insert.
+// api.ErrNotFound is returned if the message does not exist.
+func (pg *Postgres) ToggleReaction(ctx context.Context, r api.Reaction) (api.ReactionToggle, error) {
+	var t api.ReactionToggle
+	err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var id string
+		err := tx.NewSelect().
+			Model((*message)(nil)).
+			Column("id").
+			Where("id = ?", r.MessageID).
+			For("UPDATE").
+			Scan(ctx, &id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return api.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("lock message: %w", err)
+		}
+
+		var deleted []reaction
+		_, err = tx.NewDelete().
+			Model(&deleted).
+			Where("message_id = ? AND user_id = ? AND type = ?", r.MessageID, r.UserID, r.Type).
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		for _, rm := range deleted {
+			t.Reactions = append(t.Reactions, rm.APIReaction())
+		}
+
+		if len(deleted) == 0 {
+			rm := &reaction{
+				MessageID: r.MessageID,
+				UserID:    r.UserID,
+				Type:      r.Type,
+				Emoji:     r.Emoji,
+				Score:     r.Score,
+				CreatedAt: r.CreatedAt.UTC(),
+			}
+			if _, err := tx.NewInsert().Model(rm).Returning("*").Exec(ctx); err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+			t.Active = true
+			t.Reactions = []api.Reaction{rm.APIReaction()}
+		}
+
+		t.Count, err = tx.NewSelect().
+			Model((*reaction)(nil)).
+			Where("message_id = ? AND type = ?", r.MessageID, r.Type).
+			Count(ctx)
+		if err != nil {
+			return fmt.Errorf("count: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return api.ReactionToggle{}, err
+	}
+	return t, nil
+}
+
+// ReactionSummary returns the number of reactions per type and the total
+// score of the reactions of a message.
+func (pg *Postgres) ReactionSummary(ctx context.Context, messageID string) (api.ReactionSummary, error) {
+	var rows []struct {
+		Type  string
+		Count int
+		Score int
+	}
+	err := pg.bun.NewSelect().
+		Model((*reaction)(nil)).
+		Column("type").
+		ColumnExpr("COUNT(*) AS count").
+		ColumnExpr("COALESCE(SUM(score), 0) AS score").
+		Where("message_id = ?", messageID).
+		Group("type").
+		Scan(ctx, &rows)
+	if err != nil {
+		return api.ReactionSummary{}, fmt.Errorf("scan: %w", err)
+	}
+
+	summary := api.ReactionSummary{Counts: make(map[string]int, len(rows))}
+	for _, row := range rows {
+		summary.Counts[row.Type] = row.Count
+		summary.Total += row.Count
+		summary.Score += row.Score
+	}
+	return summary, nil
+}
+
+// EmojiCounts returns the number of reactions per emoji of a message.
+// Reactions without an emoji are not counted.
+func (pg *Postgres) EmojiCounts(ctx context.Context, messageID string) (map[string]int, error) {
+	var rows []struct {
+		Emoji string
+		Count int
+	}
+	err := pg.bun.NewSelect().
+		Model((*reaction)(nil)).
+		Column("emoji").
+		ColumnExpr("COUNT(*) AS count").
+		Where("message_id = ?", messageID).
+		Where("emoji <> ''").
+		Group("emoji").
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Emoji] = row.Count
+	}
+	return counts, nil
+}
+
+// ScoreHistogram returns the number of reactions per score of a message.
+func (pg *Postgres) ScoreHistogram(ctx context.Context, messageID string) (map[int]int, error) {
+	var rows []struct {
+		Score int
+		Count int
+	}
+	err := pg.bun.NewSelect().
+		Model((*reaction)(nil)).
+		Column("score").
+		ColumnExpr("COUNT(*) AS count").
+		Where("message_id = ?", messageID).
+		Group("score").
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+
+	histogram := make(map[int]int, len(rows))
+	for _, row := range rows {
+		histogram[row.Score] = row.Count
+	}
+	return histogram, nil
+}
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/uptrace/bun"
+)
+
+// ErrSchema is returned by Connect with WithVerifySchema if tables or columns
+// the queries rely on are missing.
+var ErrSchema = errors.New("missing or outdated schema")
+
+// expectedSchema lists the columns of each table the queries rely on. Keep it
+// in sync with schema.sql.
+var expectedSchema = map[string][]string{
+	"messages":  {"id", "message_text", "user_id", "parent_id", "created_at", "attachments", "pinned", "hidden"},
+	"reactions": {"id", "user_id", "message_id", "type", "emoji", "score", "created_at"},
+}
+
+// verifySchema checks that the tables and columns of want exist in the current
+// schema of db. The returned error wraps ErrSchema and names everything that
+// is missing.
+func verifySchema(ctx context.Context, db bun.IDB, want map[string][]string) error {
+	tables := make([]string, 0, len(want))
+	for table := range want {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+
+	var columns []struct {
+		TableName  string `bun:"table_name"`
+		ColumnName string `bun:"column_name"`
+	}
+	err := db.NewSelect().
+		TableExpr("information_schema.columns").
+		Column("table_name", "column_name").
+		Where("table_schema = current_schema()").
+		Where("table_name IN (?)", bun.In(tables)).
+		Scan(ctx, &columns)
+	if err != nil {
+		return fmt.Errorf("list columns: %w", err)
+	}
+
+	have := make(map[string]bool)
+	for _, c := range columns {
+		have[c.TableName] = true
+		have[c.TableName+"."+c.ColumnName] = true
+	}
+	var missing []string
+	for _, table := range tables {
+		if !have[table] {
+			missing = append(missing, "table "+table)
+			continue
+		}
+		for _, column := range want[table] {
+			if !have[table+"."+column] {
+				missing = append(missing, "column "+table+"."+column)
+			}
+		}
+	}
+	if missing != nil {
+		return fmt.Errorf("%w: missing %s, apply schema.sql", ErrSchema, strings.Join(missing, ", "))
+	}
+	return nil
+}
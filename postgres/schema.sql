@@ -5,7 +5,11 @@ CREATE TABLE IF NOT EXISTS messages (
   id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
   message_text TEXT NOT NULL,
   user_id VARCHAR(255) NOT NULL,
-  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+  parent_id uuid REFERENCES messages(id) ON DELETE CASCADE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  attachments JSONB NOT NULL DEFAULT '[]',
+  pinned BOOLEAN NOT NULL DEFAULT FALSE,
+  hidden BOOLEAN NOT NULL DEFAULT FALSE
 );
 
 -- Reactions
@@ -14,6 +18,7 @@ CREATE TABLE IF NOT EXISTS reactions (
   user_id VARCHAR(255) NOT NULL,
   message_id uuid NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
   type VARCHAR(64) NOT NULL,
+  emoji VARCHAR(64) NOT NULL DEFAULT '',
   score INTEGER DEFAULT 1,
   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -21,3 +26,15 @@ CREATE TABLE IF NOT EXISTS reactions (
 -- indexes
 CREATE INDEX IF NOT EXISTS idx_message_id
 ON reactions(message_id);
+
+-- A user reacts at most once with each type to a message. Databases created
+-- before this index are migrated with the -migrate flag.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reactions_user_type
+ON reactions(message_id, user_id, type);
+
+CREATE INDEX IF NOT EXISTS idx_parent_id
+ON messages(parent_id);
+
+-- The configuration must match searchConfig for searches to use the index.
+CREATE INDEX IF NOT EXISTS idx_message_text_search
+ON messages USING GIN (to_tsvector('english', message_text));
@@ -0,0 +1,11 @@
+package postgres
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	headline := "Say <b>" + highlightStart + "Hello" + highlightStop + "</b> & goodbye"
+	want := "Say &lt;b&gt;<mark>Hello</mark>&lt;/b&gt; &amp; goodbye"
+	if got := highlight(headline); got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPingWithRetry(t *testing.T) {
+	errDown := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		attempts  int
+		upAfter   int
+		wantPings int
+		wantErr   bool
+	}{
+		{name: "Up", attempts: 3, upAfter: 0, wantPings: 1},
+		{name: "UpAfterFirstPing", attempts: 3, upAfter: 1, wantPings: 2},
+		{name: "Down", attempts: 3, upAfter: 5, wantPings: 3, wantErr: true},
+		{name: "NoRetry", attempts: 0, upAfter: 1, wantPings: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pings := 0
+			ping := func(context.Context) error {
+				pings++
+				if pings <= tt.upAfter {
+					return errDown
+				}
+				return nil
+			}
+
+			err := pingWithRetry(context.Background(), tt.attempts, time.Millisecond, ping)
+			if tt.wantErr && !errors.Is(err, errDown) {
+				t.Errorf("Got error %v, want %v", err, errDown)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Got error %v", err)
+			}
+			if pings != tt.wantPings {
+				t.Errorf("Got %d pings, want %d", pings, tt.wantPings)
+			}
+		})
+	}
+}
+
+func TestPingWithRetry_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errDown := errors.New("connection refused")
+	pings := 0
+	ping := func(context.Context) error {
+		pings++
+		cancel()
+		return errDown
+	}
+
+	err := pingWithRetry(ctx, 5, time.Hour, ping)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, errDown) {
+		t.Errorf("Got error %v, want the cancellation and the last ping error", err)
+	}
+	if pings != 1 {
+		t.Errorf("Got %d pings, want 1", pings)
+	}
+}
@@ -0,0 +1,234 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/redis/go-redis/v9"
+)
+
+// maxUpdateAttempts bounds the number of times updateJSONMessage retries an
+// update that conflicted with a concurrent one.
+const maxUpdateAttempts = 10
+
+// getJSONMessages reads the JSON messages at keys in a single round trip.
+// Their reactions are only kept if withReactions is set, otherwise they are
+// only counted. Only the reactionLimit most recent reactions are kept if
+// reactionLimit is positive. api.ErrNotFound is returned if one of the messages is not
+// cached.
+func (r *Redis) getJSONMessages(ctx context.Context, keys []string, withReactions bool, reactionLimit int) ([]message, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	vals, err := r.cli.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("mget: %w", err)
+	}
+
+	out := make([]message, len(vals))
+	for i, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			return nil, api.ErrNotFound
+		}
+		if err := json.Unmarshal([]byte(s), &out[i]); err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+		if !withReactions {
+			out[i].ReactionCount = len(out[i].Reactions)
+			out[i].Reactions = nil
+		} else if reactionLimit > 0 {
+			out[i].ReactionCount = len(out[i].Reactions)
+			out[i].Reactions = lastReactions(out[i].Reactions, reactionLimit)
+		}
+	}
+	return out, nil
+}
+
+// readJSONMessage reads the JSON message at key. ok is false if the message
+// is not cached.
+func readJSONMessage(ctx context.Context, c redis.Cmdable, key string) (m message, ok bool, err error) {
+	s, err := c.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return message{}, false, nil
+	}
+	if err != nil {
+		return message{}, false, fmt.Errorf("get: %w", err)
+	}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return message{}, false, fmt.Errorf("decode: %w", err)
+	}
+	return m, true, nil
+}
+
+// updateJSONMessage applies update to the JSON message at key and stores the
+// result. update may queue more commands on pipe, which runs them in the same
+// transaction. The update is retried if the message was modified
+// concurrently. api.ErrNotFound is returned if the message is not cached.
+func (r *Redis) updateJSONMessage(ctx context.Context, key string, update func(m *message, pipe redis.Pipeliner) error) error {
+	for range maxUpdateAttempts {
+		err := r.cli.Watch(ctx, func(tx *redis.Tx) error {
+			m, ok, err := readJSONMessage(ctx, tx, key)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return api.ErrNotFound
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if err := update(&m, pipe); err != nil {
+					return err
+				}
+				b, err := json.Marshal(m)
+				if err != nil {
+					return fmt.Errorf("encode: %w", err)
+				}
+				pipe.Set(ctx, key, b, 0)
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return redis.TxFailedErr
+}
+
+// encodeInsert encodes m, keeping the reactions of the message if it is
+// already cached. If parentCached is set, the parent at parentKey is encoded
+// too, with one more reply.
+func encodeInsert(ctx context.Context, tx *redis.Tx, key string, m message, parentKey string, parentCached bool) (msgJSON, parentJSON []byte, err error) {
+	cached, ok, err := readJSONMessage(ctx, tx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
+		m.Reactions = cached.Reactions
+	}
+	if msgJSON, err = json.Marshal(m); err != nil {
+		return nil, nil, fmt.Errorf("encode: %w", err)
+	}
+	if !parentCached {
+		return msgJSON, nil, nil
+	}
+
+	parent, ok, err := readJSONMessage(ctx, tx, parentKey)
+	if err != nil || !ok {
+		return msgJSON, nil, err
+	}
+	parent.ReplyCount++
+	if parentJSON, err = json.Marshal(parent); err != nil {
+		return nil, nil, fmt.Errorf("encode parent: %w", err)
+	}
+	return msgJSON, parentJSON, nil
+}
+
+// insertJSONReaction adds rc to the JSON message identified by messageID,
+// replacing the reaction with the same id if there is one. Nothing is stored
+// if the message is not cached.
+func (r *Redis) insertJSONReaction(ctx context.Context, messageID string, rc reaction) error {
+	key := r.messageKey(messageID)
+	err := r.updateJSONMessage(ctx, key, func(m *message, pipe redis.Pipeliner) error {
+		m.Reactions = slices.DeleteFunc(m.Reactions, func(cached reaction) bool {
+			return cached.ID == rc.ID
+		})
+		m.Reactions = append(m.Reactions, rc)
+		// Like the sorted sets of FormatHash, keep the reactions in
+		// creation order.
+		slices.SortStableFunc(m.Reactions, func(a, b reaction) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+		pipe.HSet(ctx, reactorsKey(key), reactorField(rc.UserID, rc.Type), rc.ID)
+		return nil
+	})
+	if errors.Is(err, api.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// deleteJSONReaction removes the reaction identified by reactionID from the
+// JSON message identified by messageID. api.ErrNotFound is returned if the
+// reaction is not cached.
+func (r *Redis) deleteJSONReaction(ctx context.Context, messageID, reactionID string) error {
+	key := r.messageKey(messageID)
+	return r.updateJSONMessage(ctx, key, func(m *message, pipe redis.Pipeliner) error {
+		i := slices.IndexFunc(m.Reactions, func(rc reaction) bool {
+			return rc.ID == reactionID
+		})
+		if i < 0 {
+			return api.ErrNotFound
+		}
+		rc := m.Reactions[i]
+		m.Reactions = slices.Delete(m.Reactions, i, i+1)
+		pipe.HDel(ctx, reactorsKey(key), reactorField(rc.UserID, rc.Type))
+		return nil
+	})
+}
+
+// listJSONReactions returns the reactions of the JSON message identified by
+// messageID, none if it is not cached.
+func (r *Redis) listJSONReactions(ctx context.Context, messageID string) ([]reaction, error) {
+	m, ok, err := readJSONMessage(ctx, r.cli, r.messageKey(messageID))
+	if err != nil {
+		return nil, err
+	}
+	if !ok || m.Reactions == nil {
+		return []reaction{}, nil
+	}
+	return m.Reactions, nil
+}
+
+// lastReactions returns the limit most recent of the reactions rs, which are
+// sorted oldest first, or all of them if limit is not positive.
+func lastReactions(rs []reaction, limit int) []reaction {
+	if limit > 0 && len(rs) > limit {
+		return rs[len(rs)-limit:]
+	}
+	return rs
+}
+
+// setJSONField updates a field of the JSON message at key with set, if the
+// message is cached.
+func (r *Redis) setJSONField(ctx context.Context, key string, set func(m *message)) error {
+	err := r.updateJSONMessage(ctx, key, func(m *message, _ redis.Pipeliner) error {
+		set(m)
+		return nil
+	})
+	if err != nil && !errors.Is(err, api.ErrNotFound) {
+		return fmt.Errorf("update: %w", err)
+	}
+	return nil
+}
+
+// pinJSONMessage stores m as a pinned JSON message at key. The replies and
+// reactions of the message are kept if it is already cached.
+func (r *Redis) pinJSONMessage(ctx context.Context, key string, m message) error {
+	return r.cli.Watch(ctx, func(tx *redis.Tx) error {
+		cached, ok, err := readJSONMessage(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.ParentID, m.ReplyCount, m.Reactions = cached.ParentID, cached.ReplyCount, cached.Reactions
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, b, 0)
+			pipe.ZAdd(ctx, r.pinnedKey, redis.Z{
+				Score:  float64(m.CreatedAt.UnixNano()),
+				Member: key,
+			})
+			return nil
+		})
+		return err
+	}, key)
+}
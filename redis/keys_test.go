@@ -0,0 +1,28 @@
+package redis
+
+import "testing"
+
+func TestKeys(t *testing.T) {
+	k := newKeys("env:")
+	msgKey := k.messageKey("m1")
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "Message", got: msgKey, want: "env:messages:m1"},
+		{name: "Reactions", got: reactionsKey(msgKey), want: "env:messages:m1:reactions"},
+		{name: "Reaction", got: reactionKey(msgKey, "r1"), want: "env:messages:m1:reactions:r1"},
+		{name: "Reactors", got: reactorsKey(msgKey), want: "env:messages:m1:reactors"},
+		{name: "ReactionCounts", got: reactionCountsKey(msgKey), want: "env:messages:m1:reaction_counts"},
+		{name: "Pinned", got: k.pinnedKey, want: "env:messages:pinned"},
+		{name: "Typing", got: k.typingPrefix, want: "env:typing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("Got key %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
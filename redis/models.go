@@ -1,28 +1,51 @@
 package redis
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/GetStream/stream-backend-homework-assignment/api"
 )
 
-// A message represents a message in the database.
+// A message represents a message in the database. It is stored as a hash
+// with FormatHash, and as a JSON string including its reactions with
+// FormatJSON.
 type message struct {
-	ID        string    `redis:"id"`
-	Text      string    `redis:"text"`
-	UserID    string    `redis:"user_id"`
-	CreatedAt time.Time `redis:"created_at"`
-	Reactions []reaction
+	ID          string      `redis:"id" json:"id"`
+	Text        string      `redis:"text" json:"text"`
+	UserID      string      `redis:"user_id" json:"user_id"`
+	ParentID    string      `redis:"parent_id" json:"parent_id,omitempty"`
+	CreatedAt   time.Time   `redis:"created_at" json:"created_at"`
+	Pinned      bool        `redis:"pinned" json:"pinned"`
+	Hidden      bool        `redis:"hidden" json:"hidden"`
+	Attachments attachments `redis:"attachments" json:"attachments,omitempty"`
+	ReplyCount  int         `redis:"reply_count" json:"reply_count"`
+	Reactions   []reaction  `json:"reactions"`
+	// ReactionCount is only set when the reactions are counted rather than
+	// loaded.
+	ReactionCount int `json:"-"`
+}
+
+// attachments are stored in the message hash as a single JSON string.
+type attachments []api.Attachment
+
+func (a attachments) MarshalBinary() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+func (a *attachments) ScanRedis(s string) error {
+	return json.Unmarshal([]byte(s), a)
 }
 
 // reaction represents a reaction to a message, stored in the database.
 type reaction struct {
-	ID        string    `redis:"id"`
-	MessageID string    `redis:"message_id"`
-	UserID    string    `redis:"user_id"`
-	Type      string    `redis:"type"`
-	Score     int       `redis:"score"`
-	CreatedAt time.Time `redis:"created_at"`
+	ID        string    `redis:"id" json:"id"`
+	MessageID string    `redis:"message_id" json:"message_id"`
+	UserID    string    `redis:"user_id" json:"user_id"`
+	Type      string    `redis:"type" json:"type"`
+	Emoji     string    `redis:"emoji" json:"emoji,omitempty"`
+	Score     int       `redis:"score" json:"score"`
+	CreatedAt time.Time `redis:"created_at" json:"created_at"`
 }
 
 func (m message) APIMessage() api.Message {
@@ -31,13 +54,23 @@ func (m message) APIMessage() api.Message {
 		rcs[i] = r.APIReaction()
 	}
 
+	reactionCount := m.ReactionCount
+	if reactionCount == 0 {
+		reactionCount = len(m.Reactions)
+	}
+
 	apiMsg := api.Message{
 		ID:            m.ID,
 		Text:          m.Text,
 		UserID:        m.UserID,
-		CreatedAt:     m.CreatedAt,
+		ParentID:      m.ParentID,
+		CreatedAt:     m.CreatedAt.UTC(),
+		Pinned:        m.Pinned,
+		Hidden:        m.Hidden,
+		Attachments:   m.Attachments,
 		Reactions:     rcs,
-		ReactionCount: len(m.Reactions),
+		ReactionCount: reactionCount,
+		ReplyCount:    m.ReplyCount,
 	}
 	return apiMsg
 }
@@ -48,6 +81,8 @@ func (r reaction) APIReaction() api.Reaction {
 		MessageID: r.MessageID,
 		UserID:    r.UserID,
 		Type:      r.Type,
+		Emoji:     r.Emoji,
 		Score:     r.Score,
+		CreatedAt: r.CreatedAt.UTC(),
 	}
 }
@@ -0,0 +1,108 @@
+package redis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAttachments_roundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   attachments
+		want string
+	}{
+		{
+			name: "None",
+			in:   nil,
+			want: `null`,
+		},
+		{
+			name: "One",
+			in: attachments{
+				{URL: "https://example.com/cat.png", Type: "image/png", Name: "cat.png", Size: 1024},
+			},
+			want: `[{"url":"https://example.com/cat.png","type":"image/png","name":"cat.png","size":1024}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.in.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("Got %s, want %s", b, tt.want)
+			}
+
+			var got attachments
+			if err := got.ScanRedis(string(b)); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.in); diff != "" {
+				t.Errorf("Diff (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMessage_APIMessage_attachments(t *testing.T) {
+	m := message{
+		ID:          "1",
+		Attachments: attachments{{URL: "https://example.com/a.pdf", Type: "application/pdf"}},
+	}
+	want := []api.Attachment{{URL: "https://example.com/a.pdf", Type: "application/pdf"}}
+	if diff := cmp.Diff(m.APIMessage().Attachments, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+}
+
+func TestMessage_APIMessage_utc(t *testing.T) {
+	// Times are read back in the server's zone, which need not be UTC.
+	local := time.FixedZone("UTC+2", 2*60*60)
+	m := message{
+		ID:        "1",
+		CreatedAt: time.Date(2024, 1, 1, 2, 0, 0, 0, local),
+		Reactions: []reaction{{ID: "2", MessageID: "1", CreatedAt: time.Date(2024, 1, 1, 3, 0, 0, 0, local)}},
+	}
+	got := m.APIMessage()
+	if want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); got.CreatedAt != want {
+		t.Errorf("Got message created at %v, want %v", got.CreatedAt, want)
+	}
+	if want := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC); got.Reactions[0].CreatedAt != want {
+		t.Errorf("Got reaction created at %v, want %v", got.Reactions[0].CreatedAt, want)
+	}
+}
+
+func TestMessage_json(t *testing.T) {
+	m := message{
+		ID:          "1",
+		Text:        "hello",
+		UserID:      "test",
+		CreatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Attachments: attachments{{URL: "https://example.com/a.pdf", Type: "application/pdf"}},
+		ReplyCount:  2,
+		Reactions: []reaction{
+			{ID: "2", MessageID: "1", UserID: "test", Type: "like", Score: 1, CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		ReactionCount: 1,
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got message
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	// The number of reactions is not stored, it is derived from them.
+	m.ReactionCount = 0
+	if diff := cmp.Diff(got, m); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+}
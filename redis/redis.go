@@ -1,8 +1,13 @@
 package redis
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/GetStream/stream-backend-homework-assignment/api"
@@ -11,144 +16,766 @@ import (
 
 // Redis provides caching in Redis.
 type Redis struct {
+	keys
 	cli *redis.Client
+	// maxSize is the number of latest messages kept in the cache.
+	maxSize int
+	policy  EvictionPolicy
+	format  Format
+	// reactionLimit is the number of most recent reactions loaded per
+	// listed message, zero loads all of them.
+	reactionLimit int
+}
+
+// A Format is the way messages are stored in Redis.
+type Format int
+
+const (
+	// FormatHash stores each message as a hash, and each of its reactions
+	// as a hash of its own.
+	FormatHash Format = iota
+	// FormatJSON stores each message along with its reactions as a single
+	// JSON string, so that it is read in one round trip.
+	FormatJSON
+)
+
+// An EvictionPolicy decides which messages are evicted once the cache holds
+// more than its maximum number of messages.
+type EvictionPolicy int
+
+const (
+	// EvictFIFO evicts the oldest messages by creation time.
+	EvictFIFO EvictionPolicy = iota
+	// EvictLRU evicts the least recently listed or fetched messages.
+	EvictLRU
+)
+
+// An Option configures the cache created by Connect.
+type Option func(*config)
+
+type config struct {
+	maxSize  int
+	policy   EvictionPolicy
+	format   Format
+	attempts int
+	delay    time.Duration
+	prefix   string
+	// reactionLimit is the number of reactions loaded per listed message.
+	reactionLimit int
+}
+
+// WithKeyPrefix namespaces the keys of the cache, so that several
+// environments can share a Redis instance. The keys start with prefix and a
+// colon, messages are listed at "env:messages" with the prefix "env". Defaults
+// to no prefix.
+func WithKeyPrefix(prefix string) Option {
+	return func(c *config) {
+		if prefix != "" {
+			c.prefix = prefix + ":"
+		}
+	}
+}
+
+// WithReactionLimit caps the reactions ListMessages loads per message to the
+// n most recent ones, matching a DB that caps the reactions it loads. The
+// messages still count all of their reactions. GetMessage loads all
+// reactions regardless. Defaults to no limit.
+func WithReactionLimit(n int) Option {
+	return func(c *config) {
+		c.reactionLimit = n
+	}
+}
+
+// WithMaxSize sets the number of latest messages kept in the cache. Older
+// messages are evicted. Defaults to 10.
+func WithMaxSize(n int) Option {
+	return func(c *config) {
+		c.maxSize = n
+	}
+}
+
+// WithEvictionPolicy sets the policy used to evict messages when the cache is
+// full. Defaults to EvictFIFO.
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(c *config) {
+		c.policy = p
+	}
+}
+
+// WithFormat sets the format messages are stored in. Defaults to FormatHash.
+func WithFormat(f Format) Option {
+	return func(c *config) {
+		c.format = f
+	}
+}
+
+// WithConnectRetry makes Connect ping the server up to attempts times, for
+// servers that are still starting up. Connect waits delay before the first
+// retry and twice as long before each following one. Defaults to a single
+// attempt.
+func WithConnectRetry(attempts int, delay time.Duration) Option {
+	return func(c *config) {
+		c.attempts = attempts
+		c.delay = delay
+	}
 }
 
 // Connect connects to the Redis server and pings the server to ensure the
 // connection is working.
-func Connect(ctx context.Context, addr string) (*Redis, error) {
+func Connect(ctx context.Context, addr string, opts ...Option) (*Redis, error) {
+	cfg := config{
+		maxSize: defaultMaxSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	cli := redis.NewClient(&redis.Options{
 		Addr: addr,
 	})
-	if err := cli.Ping(ctx).Err(); err != nil {
+	ping := func(ctx context.Context) error {
+		return cli.Ping(ctx).Err()
+	}
+	if err := pingWithRetry(ctx, cfg.attempts, cfg.delay, ping); err != nil {
+		cli.Close()
 		return nil, fmt.Errorf("ping redis: %w", err)
 	}
 	return &Redis{
-		cli: cli,
+		keys:          newKeys(cfg.prefix),
+		cli:           cli,
+		maxSize:       cfg.maxSize,
+		policy:        cfg.policy,
+		format:        cfg.format,
+		reactionLimit: cfg.reactionLimit,
 	}, nil
 }
 
-const (
-	messagePrefix = "messages"
-	maxSize       = 10
-)
+// pingWithRetry calls ping until it succeeds, it was called attempts times or
+// ctx is done, doubling the delay between the calls after each retry. The last
+// error of ping is returned.
+func pingWithRetry(ctx context.Context, attempts int, delay time.Duration, ping func(context.Context) error) error {
+	err := ping(ctx)
+	for i := 1; i < attempts && err != nil; i++ {
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-t.C:
+		}
+		delay *= 2
+		err = ping(ctx)
+	}
+	return err
+}
+
+const defaultMaxSize = 10
+
+// keys are the names of the keys of a cache. They all start with the prefix
+// set with WithKeyPrefix.
+//
+// With FormatHash a message and its reactions are cached as
+//
+//	messages:MSGID                  hash of the message
+//	messages:MSGID:reactions        sorted set of the keys of its reactions,
+//	                                scored by their creation time
+//	messages:MSGID:reactions:RID    hash of a reaction
+//	messages:MSGID:reactors         hash of the reaction ids by reactorField
+//	messages:MSGID:reaction_counts  hash of the number of its reactions by
+//	                                type
+//
+// With FormatJSON the message key holds a JSON string including the
+// reactions, and only the reactors hash is kept besides it. The keys of a
+// message are built with messageKey, reactionsKey, reactionKey, reactorsKey
+// and reactionCountsKey, and deleted together with deleteMessageKeys.
+type keys struct {
+	messagePrefix   string
+	typingPrefix    string
+	rateLimitPrefix string
+	// pinnedKey is the sorted set of pinned messages. Pinned messages are
+	// kept in the cache even when they are evicted from the window of latest
+	// messages.
+	pinnedKey string
+	// countKey holds the cached total number of messages.
+	countKey string
+	// accessKey is the sorted set of the messages in the window of latest
+	// messages, scored by the time they were first inserted, or last listed
+	// or fetched. Only maintained with EvictLRU.
+	accessKey string
+	// evictedKey holds the creation time of the newest evicted message. With
+	// EvictLRU the cache may miss messages older than that, so they are not
+	// listed. Only maintained with EvictLRU.
+	evictedKey string
+}
+
+// messageKey is the key of the message identified by messageID.
+func (k keys) messageKey(messageID string) string {
+	return k.messagePrefix + ":" + messageID
+}
+
+// reactionsKey is the sorted set listing the reaction keys of the message at
+// messageKey.
+func reactionsKey(messageKey string) string {
+	return messageKey + ":reactions"
+}
 
-// ListMessages returns a list of message from Redis. The messages are sorted
-// by the timestamp in descending order.
-func (r *Redis) ListMessages(ctx context.Context) ([]api.Message, error) {
-	vals, err := r.cli.ZRevRangeByScore(ctx, messagePrefix, &redis.ZRangeBy{
-		Min: "-inf",
-		Max: fmt.Sprintf("%d", time.Now().UnixNano()),
-	}).Result()
+// reactionKey is the key of a reaction of the message at messageKey.
+func reactionKey(messageKey, reactionID string) string {
+	return reactionsKey(messageKey) + ":" + reactionID
+}
+
+// reactorsKey is the hash mapping the reactorField of each cached reaction of
+// the message at messageKey to the reaction's id, so that repeated reactions
+// can be found.
+func reactorsKey(messageKey string) string {
+	return messageKey + ":reactors"
+}
+
+// reactionCountsKey is the hash counting the cached reactions of the message
+// at messageKey by type, so that they are counted without listing them. It is
+// kept in step with the reactionsKey sorted set by InsertReaction and
+// DeleteReaction, and rebuilt by rebuildReactionCounts for reactions cached
+// before the hash was introduced.
+func reactionCountsKey(messageKey string) string {
+	return messageKey + ":reaction_counts"
+}
+
+// deleteMessageKeys deletes the message at key along with the keys of all of
+// its reactions.
+func deleteMessageKeys(ctx context.Context, c redis.Cmdable, key string) error {
+	reactionKeys, err := c.ZRange(ctx, reactionsKey(key), 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("zrange: %w", err)
+	}
+	del := append(reactionKeys, key, reactionsKey(key), reactorsKey(key), reactionCountsKey(key))
+	if err := c.Del(ctx, del...).Err(); err != nil {
+		return fmt.Errorf("del: %w", err)
+	}
+	return nil
+}
+
+// newKeys returns the keys of a cache whose keys start with prefix.
+func newKeys(prefix string) keys {
+	messagePrefix := prefix + "messages"
+	return keys{
+		messagePrefix:   messagePrefix,
+		typingPrefix:    prefix + "typing",
+		rateLimitPrefix: prefix + "ratelimit",
+		pinnedKey:       messagePrefix + ":pinned",
+		countKey:        messagePrefix + ":count",
+		accessKey:       messagePrefix + ":access",
+		evictedKey:      messagePrefix + ":evicted",
+	}
+}
+
+// ListMessages returns up to limit messages created before the given time
+// from Redis. Pinned messages come first, then the messages are sorted by the
+// timestamp in the given order. The reactions are only loaded if
+// withReactions is set, otherwise they are only counted.
+func (r *Redis) ListMessages(ctx context.Context, before time.Time, order api.Order, limit int, withReactions bool) ([]api.Message, error) {
+	limit = min(limit, r.maxSize)
+	rng := &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   fmt.Sprintf("(%d", before.UnixNano()),
+		Count: int64(limit),
+	}
+	zrange := r.cli.ZRevRangeByScore
+	if order == api.OrderAsc {
+		zrange = r.cli.ZRangeByScore
+	}
+	pinned, err := zrange(ctx, r.pinnedKey, rng).Result()
+	if err != nil {
+		return nil, fmt.Errorf("zrange pinned: %w", err)
+	}
+
+	if r.policy == EvictLRU {
+		// Only list the messages newer than every evicted message, the
+		// cache holds all of those.
+		evicted, err := r.cli.Get(ctx, r.evictedKey).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("get evicted: %w", err)
+		}
+		if err == nil {
+			rng.Min = "(" + evicted
+		}
+	}
+	latest, err := zrange(ctx, r.messagePrefix, rng).Result()
 	if err != nil {
 		return nil, fmt.Errorf("zrange: %w", err)
 	}
 
+	vals := pinned
+	for _, key := range latest {
+		if len(vals) == limit {
+			break
+		}
+		if !slices.Contains(pinned, key) {
+			vals = append(vals, key)
+		}
+	}
+
 	out := make([]api.Message, len(vals))
-	for i, key := range vals {
-		var msg message
-		err = r.cli.HGetAll(ctx, key).Scan(&msg)
+	if r.format == FormatJSON {
+		msgs, err := r.getJSONMessages(ctx, vals, withReactions, r.reactionLimit)
 		if err != nil {
-			return nil, fmt.Errorf("hgetall: %w", err)
+			return nil, err
+		}
+		for i, msg := range msgs {
+			out[i] = msg.APIMessage()
 		}
+	} else {
+		for i, key := range vals {
+			msg, err := r.getMessage(ctx, key, withReactions, r.reactionLimit)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = msg.APIMessage()
+		}
+	}
+
+	if err := r.touch(ctx, vals...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetMessage returns the message identified by messageID, its reactions in the
+// given sort order and only those of reactionType unless it is empty.
+// api.ErrNotFound is returned if the message is not cached.
+func (r *Redis) GetMessage(ctx context.Context, messageID string, sort api.ReactionSort, reactionType string) (api.Message, error) {
+	key := r.messageKey(messageID)
+	msg, err := r.getMessage(ctx, key, true, 0)
+	if err != nil {
+		return api.Message{}, err
+	}
+	if err := r.touch(ctx, key); err != nil {
+		return api.Message{}, err
+	}
+
+	out := msg.APIMessage()
+	if reactionType != "" {
+		// The reactions of a message are not indexed by type, they are
+		// filtered once loaded.
+		out.Reactions = slices.DeleteFunc(out.Reactions, func(rc api.Reaction) bool {
+			return rc.Type != reactionType
+		})
+		out.ReactionCount = len(out.Reactions)
+	}
+	if sort == api.ReactionSortScore {
+		// The reactions are stored by creation time, they can only be
+		// sorted by score once loaded.
+		slices.SortStableFunc(out.Reactions, func(a, b api.Reaction) int {
+			return cmp.Or(cmp.Compare(b.Score, a.Score), b.CreatedAt.Compare(a.CreatedAt))
+		})
+	}
+	return out, nil
+}
 
-		reactions, err := r.ListReactions(ctx, msg.ID)
+// getMessage reads the message at key along with its reactions, or only
+// their number unless withReactions is set. Only the reactionLimit most recent
+// reactions are read if reactionLimit is positive. The message hash and the
+// ids of its reactions are read in a single transaction, so that they are
+// consistent.
+func (r *Redis) getMessage(ctx context.Context, key string, withReactions bool, reactionLimit int) (message, error) {
+	if r.format == FormatJSON {
+		msgs, err := r.getJSONMessages(ctx, []string{key}, withReactions, reactionLimit)
 		if err != nil {
-			return nil, fmt.Errorf("list reactions: %w", err)
+			return message{}, err
 		}
+		return msgs[0], nil
+	}
+
+	var (
+		msgCmd    *redis.MapStringStringCmd
+		countsCmd *redis.MapStringStringCmd
+		idsCmd    *redis.StringSliceCmd
+	)
+	now := fmt.Sprintf("%d", time.Now().UnixNano())
+	_, err := r.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		msgCmd = pipe.HGetAll(ctx, key)
+		if withReactions {
+			idsCmd = zrangeReactions(ctx, pipe, key, now, reactionLimit)
+		}
+		if !withReactions || reactionLimit > 0 {
+			countsCmd = pipe.HGetAll(ctx, reactionCountsKey(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return message{}, fmt.Errorf("get message: %w", err)
+	}
+	if len(msgCmd.Val()) == 0 {
+		return message{}, api.ErrNotFound
+	}
 
-		msg.Reactions = reactions
-		out[i] = msg.APIMessage()
+	var msg message
+	if err := msgCmd.Scan(&msg); err != nil {
+		return message{}, fmt.Errorf("scan: %w", err)
 	}
 
-	return out, nil
+	if countsCmd != nil {
+		counts := countsCmd.Val()
+		if len(counts) == 0 {
+			if counts, err = r.rebuildReactionCounts(ctx, key); err != nil {
+				return message{}, err
+			}
+		}
+		msg.ReactionCount, err = totalCount(counts)
+		if err != nil {
+			return message{}, err
+		}
+	}
+	if !withReactions {
+		return msg, nil
+	}
+
+	reactions, err := r.getReactions(ctx, reactionKeys(idsCmd, reactionLimit))
+	if err != nil {
+		return message{}, fmt.Errorf("get reactions: %w", err)
+	}
+	msg.Reactions = reactions
+	return msg, nil
+}
+
+// rebuildReactionCounts counts the cached reactions of the message at key into
+// its reactionCountsKey hash if the hash is missing, and returns the hash.
+// Nothing is stored for messages without cached reactions.
+func (r *Redis) rebuildReactionCounts(ctx context.Context, key string) (map[string]string, error) {
+	countsKey := reactionCountsKey(key)
+	var counts map[string]string
+	err := r.cli.Watch(ctx, func(tx *redis.Tx) error {
+		var err error
+		counts, err = tx.HGetAll(ctx, countsKey).Result()
+		if err != nil || len(counts) > 0 {
+			return err
+		}
+		keys, err := tx.ZRange(ctx, reactionsKey(key), 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("zrange: %w", err)
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		cmds := make([]*redis.StringCmd, len(keys))
+		_, err = tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, k := range keys {
+				cmds[i] = pipe.HGet(ctx, k, "type")
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("hget: %w", err)
+		}
+		byType := make(map[string]int)
+		for _, cmd := range cmds {
+			if cmd.Err() == nil {
+				byType[cmd.Val()]++
+			}
+		}
+		if len(byType) == 0 {
+			return nil
+		}
+		counts = make(map[string]string, len(byType))
+		for typ, n := range byType {
+			counts[typ] = strconv.Itoa(n)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, countsKey, counts)
+			return nil
+		})
+		return err
+	}, countsKey, reactionsKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("rebuild reaction counts: %w", err)
+	}
+	return counts, nil
+}
+
+// totalCount sums the counts of a reactionCountsKey hash.
+func totalCount(counts map[string]string) (int, error) {
+	var total int
+	for typ, v := range counts {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("parse count of %q: %w", typ, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// zrangeReactions lists the keys of the reactions to the message at key
+// created until now, oldest first. If limit is positive, only the limit most
+// recent reactions are listed and newest first; reactionKeys restores the
+// order.
+func zrangeReactions(ctx context.Context, c redis.Cmdable, key, now string, limit int) *redis.StringSliceCmd {
+	rng := &redis.ZRangeBy{Min: "-inf", Max: now}
+	if limit <= 0 {
+		return c.ZRangeByScore(ctx, reactionsKey(key), rng)
+	}
+	rng.Count = int64(limit)
+	return c.ZRevRangeByScore(ctx, reactionsKey(key), rng)
+}
+
+// reactionKeys returns the reaction keys listed by cmd, a zrangeReactions
+// command with the given limit, oldest first.
+func reactionKeys(cmd *redis.StringSliceCmd, limit int) []string {
+	keys := cmd.Val()
+	if limit > 0 {
+		slices.Reverse(keys)
+	}
+	return keys
 }
 
 // InsertMessage adds the message to Redis with the message:MESSAGE_ID as the key and adds the key to a sorted set.
 func (r *Redis) InsertMessage(ctx context.Context, msg api.Message) error {
 	m := &message{
-		ID:        msg.ID,
-		Text:      msg.Text,
-		UserID:    msg.UserID,
-		CreatedAt: msg.CreatedAt,
+		ID:          msg.ID,
+		Text:        msg.Text,
+		UserID:      msg.UserID,
+		ParentID:    msg.ParentID,
+		CreatedAt:   msg.CreatedAt.UTC(),
+		Pinned:      msg.Pinned,
+		Hidden:      msg.Hidden,
+		Attachments: msg.Attachments,
+		ReplyCount:  msg.ReplyCount,
+	}
+	key := r.messageKey(m.ID)
+	watched := []string{key}
+	parentKey := r.messageKey(msg.ParentID)
+	if msg.ParentID != "" {
+		watched = append(watched, parentKey)
 	}
 
 	err := r.cli.Watch(ctx, func(tx *redis.Tx) error {
-		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-			key := fmt.Sprintf("%s:%s", messagePrefix, m.ID)
-			pipe.HSet(ctx, key, m)
-			pipe.ZAdd(ctx, messagePrefix, redis.Z{
+		n, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("exists: %w", err)
+		}
+		cached := n == 1
+
+		// Only count the reply if it is not cached yet, refreshing the cache
+		// inserts the cached messages again, and if the parent is cached,
+		// HINCRBY would otherwise create a hash holding nothing but the
+		// count.
+		var countReply bool
+		if msg.ParentID != "" && !cached {
+			n, err = tx.Exists(ctx, parentKey).Result()
+			if err != nil {
+				return fmt.Errorf("exists parent: %w", err)
+			}
+			countReply = n == 1
+		}
+
+		var msgJSON, parentJSON []byte
+		if r.format == FormatJSON {
+			msgJSON, parentJSON, err = encodeInsert(ctx, tx, key, *m, parentKey, countReply)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if r.format == FormatJSON {
+				pipe.Set(ctx, key, msgJSON, 0)
+			} else {
+				pipe.HSet(ctx, key, m)
+			}
+			pipe.ZAdd(ctx, r.messagePrefix, redis.Z{
 				Score:  float64(msg.CreatedAt.UnixNano()),
 				Member: key,
 			})
+			if r.policy == EvictLRU {
+				// NX keeps the access time of cached messages, refreshing
+				// the cache inserts them again without using them.
+				pipe.ZAddNX(ctx, r.accessKey, redis.Z{
+					Score:  float64(time.Now().UnixNano()),
+					Member: key,
+				})
+			}
+			// The cached total is stale now, the next list request recounts.
+			pipe.Del(ctx, r.countKey)
+			switch {
+			case parentJSON != nil:
+				pipe.Set(ctx, parentKey, parentJSON, 0)
+			case countReply && r.format == FormatHash:
+				pipe.HIncrBy(ctx, parentKey, "reply_count", 1)
+			}
 
 			return nil
 		})
+		if err != nil && !errors.Is(err, redis.TxFailedErr) && !cached {
+			// Redis does not roll back a transaction when one of its
+			// commands fails. A hash without a sorted set entry is never
+			// listed nor evicted, and vice versa, so remove whatever was
+			// written. A message that was already cached is left alone, its
+			// copy is still valid.
+			if cerr := r.cli.Del(ctx, key).Err(); cerr != nil {
+				err = errors.Join(err, fmt.Errorf("clean up hash: %w", cerr))
+			}
+			if cerr := r.cli.ZRem(ctx, r.messagePrefix, key).Err(); cerr != nil {
+				err = errors.Join(err, fmt.Errorf("clean up sorted set: %w", cerr))
+			}
+		}
 		return err
-	}, m.ID)
+	}, watched...)
 
 	if err != nil {
 		return fmt.Errorf("redis insert message: %w", err)
 	}
 
-	// Simulate an eviction strategy by removing the oldest key in case the max cache size is exceeded.
-	err = r.evictOldest(ctx)
+	// Simulate an eviction strategy by removing messages in case the max cache size is exceeded.
+	if r.policy == EvictLRU {
+		err = r.evictLeastRecentlyUsed(ctx)
+	} else {
+		err = r.evictOldest(ctx)
+	}
 	if err != nil {
-		return fmt.Errorf("evict oldest: %w", err)
+		return fmt.Errorf("evict: %w", err)
 	}
 	return nil
 }
 
-// ListReactions fetches all reactions associated with a given message ID.
-func (r *Redis) ListReactions(ctx context.Context, msgId string) ([]reaction, error) {
-	key := fmt.Sprintf("%s:%s:reactions", messagePrefix, msgId)
-	vals, err := r.cli.ZRangeByScore(ctx, key, &redis.ZRangeBy{
-		Min: "-inf",
-		Max: fmt.Sprintf("%d", time.Now().UnixNano()),
-	}).Result()
-
-	if err != nil {
+// ListReactions fetches the reactions associated with a given message ID,
+// oldest first. Only the limit most recent reactions are fetched if limit is
+// positive.
+func (r *Redis) ListReactions(ctx context.Context, msgId string, limit int) ([]reaction, error) {
+	if r.format == FormatJSON {
+		rs, err := r.listJSONReactions(ctx, msgId)
+		if err != nil {
+			return nil, err
+		}
+		return lastReactions(rs, limit), nil
+	}
+	now := fmt.Sprintf("%d", time.Now().UnixNano())
+	cmd := zrangeReactions(ctx, r.cli, r.messageKey(msgId), now, limit)
+	if err := cmd.Err(); err != nil {
 		return nil, fmt.Errorf("zrange: %w", err)
 	}
-	out := make([]reaction, len(vals))
-	for i, key := range vals {
-		var rc reaction
-		err := r.cli.HGetAll(ctx, key).Scan(&rc)
+	return r.getReactions(ctx, reactionKeys(cmd, limit))
+}
+
+// getReactions reads the reaction hashes at keys in a single round trip.
+// Reactions deleted since their keys were listed are skipped.
+func (r *Redis) getReactions(ctx context.Context, keys []string) ([]reaction, error) {
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	if len(keys) > 0 {
+		_, err := r.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				cmds[i] = pipe.HGetAll(ctx, key)
+			}
+			return nil
+		})
 		if err != nil {
 			return nil, fmt.Errorf("hgetall: %w", err)
 		}
-
-		out[i] = rc
 	}
 
+	out := make([]reaction, 0, len(keys))
+	for _, cmd := range cmds {
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+		var rc reaction
+		if err := cmd.Scan(&rc); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		out = append(out, rc)
+	}
 	return out, nil
 }
 
+// ReactionSummary aggregates the cached reactions of a message.
+func (r *Redis) ReactionSummary(ctx context.Context, msgId string) (api.ReactionSummary, error) {
+	reactions, err := r.ListReactions(ctx, msgId, 0)
+	if err != nil {
+		return api.ReactionSummary{}, fmt.Errorf("list reactions: %w", err)
+	}
+
+	summary := api.ReactionSummary{Counts: make(map[string]int)}
+	for _, rc := range reactions {
+		summary.Counts[rc.Type]++
+		summary.Total++
+		summary.Score += rc.Score
+	}
+	return summary, nil
+}
+
+// EmojiCounts counts the cached reactions of a message per emoji. Reactions
+// without an emoji are not counted.
+func (r *Redis) EmojiCounts(ctx context.Context, msgId string) (map[string]int, error) {
+	reactions, err := r.ListReactions(ctx, msgId, 0)
+	if err != nil {
+		return nil, fmt.Errorf("list reactions: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for _, rc := range reactions {
+		if rc.Emoji != "" {
+			counts[rc.Emoji]++
+		}
+	}
+	return counts, nil
+}
+
 // InsertReaction adds a reaction to the specified message in Redis identified by msgId.
+// Nothing is stored if the message is not cached, since its reactions would
+// never be evicted along with it.
 func (r *Redis) InsertReaction(ctx context.Context, msgId string, mr api.Reaction) error {
 	reaction_ := &reaction{
 		ID:        mr.ID,
 		MessageID: mr.MessageID,
 		UserID:    mr.UserID,
 		Type:      mr.Type,
+		Emoji:     mr.Emoji,
 		Score:     mr.Score,
+		CreatedAt: mr.CreatedAt.UTC(),
+	}
+	if r.format == FormatJSON {
+		if err := r.insertJSONReaction(ctx, msgId, *reaction_); err != nil {
+			return fmt.Errorf("could not insert reaction: %w", err)
+		}
+		return nil
 	}
 
+	msgKey := r.messageKey(msgId)
+	key := reactionKey(msgKey, mr.ID)
+	// Incrementing a missing hash would count this reaction alone.
+	if _, err := r.rebuildReactionCounts(ctx, msgKey); err != nil {
+		return fmt.Errorf("could not insert reaction: %w", err)
+	}
 	err := r.cli.Watch(ctx, func(tx *redis.Tx) error {
-		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-			keyPrefix := fmt.Sprintf("%s:%s:reactions", messagePrefix, msgId)
-			key := fmt.Sprintf("%s:%s", keyPrefix, mr.ID)
+		cached, err := tx.Exists(ctx, msgKey).Result()
+		if err != nil {
+			return fmt.Errorf("exists: %w", err)
+		}
+		if cached == 0 {
+			return nil
+		}
+		// Cached reactions are inserted again when the cache is refreshed,
+		// they are only counted once.
+		n, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("exists: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
 			pipe.HSet(ctx, key, reaction_)
 
-			pipe.ZAdd(ctx, keyPrefix, redis.Z{
+			pipe.ZAdd(ctx, reactionsKey(msgKey), redis.Z{
 				Score:  float64(mr.CreatedAt.UnixNano()),
 				Member: key,
 			})
+			pipe.HSet(ctx, reactorsKey(msgKey), reactorField(mr.UserID, mr.Type), mr.ID)
+			if n == 0 {
+				pipe.HIncrBy(ctx, reactionCountsKey(msgKey), mr.Type, 1)
+			}
 			return nil
 		})
 
 		return err
-	}, mr.ID)
+	}, msgKey, key)
 
 	if err != nil {
 		return fmt.Errorf("could not insert reaction: %w", err)
@@ -157,17 +784,491 @@ func (r *Redis) InsertReaction(ctx context.Context, msgId string, mr api.Reactio
 	return nil
 }
 
+// SetMessagePinned updates the pinned state of a message. Pinned messages are
+// added to the cache, unpinned messages are dropped from it unless they are
+// still among the latest messages.
+func (r *Redis) SetMessagePinned(ctx context.Context, msg api.Message) error {
+	key := r.messageKey(msg.ID)
+	if msg.Pinned {
+		m := &message{
+			ID:          msg.ID,
+			Text:        msg.Text,
+			UserID:      msg.UserID,
+			CreatedAt:   msg.CreatedAt.UTC(),
+			Pinned:      true,
+			Hidden:      msg.Hidden,
+			Attachments: msg.Attachments,
+		}
+		if r.format == FormatJSON {
+			if err := r.pinJSONMessage(ctx, key, *m); err != nil {
+				return fmt.Errorf("pin: %w", err)
+			}
+			return nil
+		}
+		_, err := r.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, m)
+			pipe.ZAdd(ctx, r.pinnedKey, redis.Z{
+				Score:  float64(msg.CreatedAt.UnixNano()),
+				Member: key,
+			})
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("pin: %w", err)
+		}
+		return nil
+	}
+
+	if err := r.cli.ZRem(ctx, r.pinnedKey, key).Err(); err != nil {
+		return fmt.Errorf("zrem: %w", err)
+	}
+	err := r.cli.ZScore(ctx, r.messagePrefix, key).Err()
+	if errors.Is(err, redis.Nil) {
+		// Only cached because it was pinned.
+		return deleteMessageKeys(ctx, r.cli, key)
+	}
+	if err != nil {
+		return fmt.Errorf("zscore: %w", err)
+	}
+	if r.format == FormatJSON {
+		return r.setJSONField(ctx, key, func(m *message) { m.Pinned = false })
+	}
+	if err := r.cli.HSet(ctx, key, "pinned", false).Err(); err != nil {
+		return fmt.Errorf("hset: %w", err)
+	}
+	return nil
+}
+
+// SetMessageHidden updates the hidden state of a message if it is cached.
+func (r *Redis) SetMessageHidden(ctx context.Context, messageID string, hidden bool) error {
+	key := r.messageKey(messageID)
+	if r.format == FormatJSON {
+		return r.setJSONField(ctx, key, func(m *message) { m.Hidden = hidden })
+	}
+	if err := r.setHashField(ctx, key, "hidden", hidden); err != nil {
+		return fmt.Errorf("hide: %w", err)
+	}
+	return nil
+}
+
+// SetMessageText updates the text of a message if it is cached.
+func (r *Redis) SetMessageText(ctx context.Context, messageID, text string) error {
+	key := r.messageKey(messageID)
+	if r.format == FormatJSON {
+		return r.setJSONField(ctx, key, func(m *message) { m.Text = text })
+	}
+	if err := r.setHashField(ctx, key, "text", text); err != nil {
+		return fmt.Errorf("set text: %w", err)
+	}
+	return nil
+}
+
+// setHashField sets a field of the message hash at key, if the message is
+// cached.
+func (r *Redis) setHashField(ctx context.Context, key, field string, value any) error {
+	// HSET would create the hash of a message that is not cached, only update
+	// existing hashes.
+	return r.cli.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, key).Result()
+		if err != nil || n == 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, field, value)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// DeleteMessage removes a message, its reactions and its cached replies from
+// the cache, like the DB deletes the replies of a deleted message. The reply
+// count of the cached parent of the message is decremented.
+func (r *Redis) DeleteMessage(ctx context.Context, messageID string) error {
+	key := r.messageKey(messageID)
+	parentID, err := r.cachedParentID(ctx, key)
+	if err != nil {
+		return fmt.Errorf("get parent: %w", err)
+	}
+	replies, err := r.cachedReplies(ctx, key)
+	if err != nil {
+		return fmt.Errorf("find replies: %w", err)
+	}
+
+	keys := append(replies, key)
+	_, err = r.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, k := range keys {
+			pipe.ZRem(ctx, r.messagePrefix, k)
+			pipe.ZRem(ctx, r.pinnedKey, k)
+			pipe.ZRem(ctx, r.accessKey, k)
+		}
+		// The cached total is stale now, the next list request recounts.
+		pipe.Del(ctx, r.countKey)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("zrem: %w", err)
+	}
+	for _, k := range keys {
+		if err := deleteMessageKeys(ctx, r.cli, k); err != nil {
+			return err
+		}
+	}
+
+	if parentID == "" {
+		return nil
+	}
+	if err := r.decrementReplyCount(ctx, r.messageKey(parentID)); err != nil {
+		return fmt.Errorf("decrement reply count: %w", err)
+	}
+	return nil
+}
+
+// cachedParentID returns the id of the parent of the message at key. It is
+// empty if the message is not a reply or not cached.
+func (r *Redis) cachedParentID(ctx context.Context, key string) (string, error) {
+	if r.format == FormatJSON {
+		m, _, err := readJSONMessage(ctx, r.cli, key)
+		return m.ParentID, err
+	}
+	id, err := r.cli.HGet(ctx, key, "parent_id").Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("hget: %w", err)
+	}
+	return id, nil
+}
+
+// cachedReplies returns the keys of the cached replies to the message at key,
+// and of their replies in turn. Replies are not indexed by parent, so every
+// cached message is read; those are at most the window of latest messages and
+// the pinned ones.
+func (r *Redis) cachedReplies(ctx context.Context, key string) ([]string, error) {
+	latest, err := r.cli.ZRange(ctx, r.messagePrefix, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("zrange: %w", err)
+	}
+	pinned, err := r.cli.ZRange(ctx, r.pinnedKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("zrange pinned: %w", err)
+	}
+	cached := append(latest, pinned...)
+	slices.Sort(cached)
+
+	children := make(map[string][]string)
+	for _, k := range slices.Compact(cached) {
+		parentID, err := r.cachedParentID(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		if parentID != "" {
+			parentKey := r.messageKey(parentID)
+			children[parentKey] = append(children[parentKey], k)
+		}
+	}
+
+	var replies []string
+	queue := []string{key}
+	for len(queue) > 0 {
+		next := children[queue[0]]
+		queue = append(queue[1:], next...)
+		replies = append(replies, next...)
+	}
+	return replies, nil
+}
+
+// decrementReplyCount decrements the reply count of the message at key, if it
+// is cached.
+func (r *Redis) decrementReplyCount(ctx context.Context, key string) error {
+	if r.format == FormatJSON {
+		return r.setJSONField(ctx, key, func(m *message) { m.ReplyCount-- })
+	}
+	// Like setHashField, only update existing hashes.
+	return r.cli.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, key).Result()
+		if err != nil || n == 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HIncrBy(ctx, key, "reply_count", -1)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// GetReaction returns a single reaction of the message identified by
+// messageID. api.ErrNotFound is returned if the reaction is not cached.
+func (r *Redis) GetReaction(ctx context.Context, messageID, reactionID string) (api.Reaction, error) {
+	if r.format == FormatJSON {
+		reactions, err := r.listJSONReactions(ctx, messageID)
+		if err != nil {
+			return api.Reaction{}, err
+		}
+		i := slices.IndexFunc(reactions, func(rc reaction) bool {
+			return rc.ID == reactionID
+		})
+		if i < 0 {
+			return api.Reaction{}, api.ErrNotFound
+		}
+		return reactions[i].APIReaction(), nil
+	}
+
+	cmd := r.cli.HGetAll(ctx, reactionKey(r.messageKey(messageID), reactionID))
+	vals, err := cmd.Result()
+	if err != nil {
+		return api.Reaction{}, fmt.Errorf("hgetall: %w", err)
+	}
+	if len(vals) == 0 {
+		return api.Reaction{}, api.ErrNotFound
+	}
+
+	var rc reaction
+	if err := cmd.Scan(&rc); err != nil {
+		return api.Reaction{}, fmt.Errorf("scan: %w", err)
+	}
+	return rc.APIReaction(), nil
+}
+
+// FindReaction returns the reaction of the user identified by userID with the
+// given type to the message identified by messageID. api.ErrNotFound is
+// returned if no such reaction is cached.
+func (r *Redis) FindReaction(ctx context.Context, messageID, userID, reactionType string) (api.Reaction, error) {
+	id, err := r.cli.HGet(ctx, reactorsKey(r.messageKey(messageID)), reactorField(userID, reactionType)).Result()
+	if errors.Is(err, redis.Nil) {
+		return api.Reaction{}, api.ErrNotFound
+	}
+	if err != nil {
+		return api.Reaction{}, fmt.Errorf("hget: %w", err)
+	}
+	return r.GetReaction(ctx, messageID, id)
+}
+
+// reactorField identifies the reactions of a user with a type.
+func reactorField(userID, reactionType string) string {
+	return userID + ":" + reactionType
+}
+
+// DeleteReaction removes a reaction from the message identified by messageID.
+// api.ErrNotFound is returned if the reaction is not cached.
+func (r *Redis) DeleteReaction(ctx context.Context, messageID, reactionID string) error {
+	if r.format == FormatJSON {
+		err := r.deleteJSONReaction(ctx, messageID, reactionID)
+		if err != nil && !errors.Is(err, api.ErrNotFound) {
+			return fmt.Errorf("could not delete reaction: %w", err)
+		}
+		return err
+	}
+	msgKey := r.messageKey(messageID)
+	key := reactionKey(msgKey, reactionID)
+	// Decrementing a missing hash would count this reaction as -1.
+	if _, err := r.rebuildReactionCounts(ctx, msgKey); err != nil {
+		return fmt.Errorf("could not delete reaction: %w", err)
+	}
+
+	var del *redis.IntCmd
+	err := r.cli.Watch(ctx, func(tx *redis.Tx) error {
+		// The reaction is watched so that a concurrent delete can't
+		// decrement its count twice.
+		owner, err := tx.HMGet(ctx, key, "user_id", "type").Result()
+		if err != nil {
+			return fmt.Errorf("hmget: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			del = pipe.Del(ctx, key)
+			pipe.ZRem(ctx, reactionsKey(msgKey), key)
+			if userID, ok := owner[0].(string); ok {
+				typ, _ := owner[1].(string)
+				pipe.HDel(ctx, reactorsKey(msgKey), reactorField(userID, typ))
+				pipe.HIncrBy(ctx, reactionCountsKey(msgKey), typ, -1)
+			}
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return fmt.Errorf("could not delete reaction: %w", err)
+	}
+	if del.Val() == 0 {
+		return api.ErrNotFound
+	}
+	return nil
+}
+
+// GetMessageCount returns the cached total number of messages.
+// api.ErrNotFound is returned if the count is not cached.
+func (r *Redis) GetMessageCount(ctx context.Context) (int, error) {
+	n, err := r.cli.Get(ctx, r.countKey).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, api.ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get: %w", err)
+	}
+	return n, nil
+}
+
+// SetMessageCount caches the total number of messages. The count expires
+// after ttl.
+func (r *Redis) SetMessageCount(ctx context.Context, n int, ttl time.Duration) error {
+	if err := r.cli.Set(ctx, r.countKey, n, ttl).Err(); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	return nil
+}
+
+// Hit records a request for key in the current fixed window of the given
+// length. It returns the number of requests recorded in the window and when
+// the window ends.
+func (r *Redis) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+	start := now.Truncate(window)
+	reset := start.Add(window)
+	k := fmt.Sprintf("%s:%s:%d", r.rateLimitPrefix, key, start.Unix())
+
+	var incr *redis.IntCmd
+	_, err := r.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, k)
+		pipe.ExpireAt(ctx, k, reset)
+		return nil
+	})
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("incr: %w", err)
+	}
+	return int(incr.Val()), reset, nil
+}
+
+// Flush removes all cached messages, including their reactions, pinned
+// messages and the cached message count. Keys are found with SCAN and deleted
+// in batches to avoid blocking Redis. It returns the number of deleted keys.
+func (r *Redis) Flush(ctx context.Context) (int, error) {
+	var (
+		cursor  uint64
+		deleted int
+	)
+	for {
+		keys, next, err := r.cli.Scan(ctx, cursor, r.messagePrefix+"*", 100).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("scan: %w", err)
+		}
+		if len(keys) > 0 {
+			// UNLINK frees the memory in the background.
+			n, err := r.cli.Unlink(ctx, keys...).Result()
+			if err != nil {
+				return deleted, fmt.Errorf("unlink: %w", err)
+			}
+			deleted += int(n)
+		}
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
+
+// SetTyping marks the user as typing. The marker expires after ttl.
+func (r *Redis) SetTyping(ctx context.Context, userID string, ttl time.Duration) error {
+	key := fmt.Sprintf("%s:%s", r.typingPrefix, userID)
+	if err := r.cli.Set(ctx, key, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	return nil
+}
+
+// ListTyping returns the IDs of the users with an unexpired typing marker,
+// sorted alphabetically.
+func (r *Redis) ListTyping(ctx context.Context) ([]string, error) {
+	prefix := r.typingPrefix + ":"
+	var userIDs []string
+	iter := r.cli.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		userIDs = append(userIDs, strings.TrimPrefix(iter.Val(), prefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+	// SCAN may return a key more than once.
+	slices.Sort(userIDs)
+	return slices.Compact(userIDs), nil
+}
+
 func (r *Redis) evictOldest(ctx context.Context) error {
-	vals, err := r.cli.ZRange(ctx, messagePrefix, 0, int64(-maxSize-1)).Result()
+	vals, err := r.cli.ZRange(ctx, r.messagePrefix, 0, int64(-r.maxSize-1)).Result()
 	if err != nil {
 		return fmt.Errorf("zrevrange: %w", err)
 	}
 
 	for _, key := range vals {
-		_ = r.cli.ZRem(ctx, messagePrefix, key).Err()
-		_ = r.cli.Del(ctx, key).Err()
-		_ = r.cli.Del(ctx, fmt.Sprintf("%s:reactions", key)).Err()
+		_ = r.cli.ZRem(ctx, r.messagePrefix, key).Err()
+		if err := r.cli.ZScore(ctx, r.pinnedKey, key).Err(); err == nil {
+			// Pinned messages stay cached.
+			continue
+		}
+		_ = deleteMessageKeys(ctx, r.cli, key)
 	}
 
 	return nil
 }
+
+// evictLeastRecentlyUsed removes the least recently used messages from the
+// window of latest messages until it fits the cache size. Pinned messages stay
+// cached.
+func (r *Redis) evictLeastRecentlyUsed(ctx context.Context) error {
+	n, err := r.cli.ZCard(ctx, r.messagePrefix).Result()
+	if err != nil {
+		return fmt.Errorf("zcard: %w", err)
+	}
+	if n <= int64(r.maxSize) {
+		return nil
+	}
+	vals, err := r.cli.ZRange(ctx, r.accessKey, 0, n-int64(r.maxSize)-1).Result()
+	if err != nil {
+		return fmt.Errorf("zrange: %w", err)
+	}
+
+	var newest float64
+	for _, key := range vals {
+		if score, err := r.cli.ZScore(ctx, r.messagePrefix, key).Result(); err == nil {
+			newest = max(newest, score)
+		}
+		_ = r.cli.ZRem(ctx, r.messagePrefix, key).Err()
+		_ = r.cli.ZRem(ctx, r.accessKey, key).Err()
+		if err := r.cli.ZScore(ctx, r.pinnedKey, key).Err(); err == nil {
+			// Pinned messages stay cached.
+			continue
+		}
+		_ = deleteMessageKeys(ctx, r.cli, key)
+	}
+
+	evicted, err := r.cli.Get(ctx, r.evictedKey).Float64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("get evicted: %w", err)
+	}
+	if newest > evicted {
+		if err := r.cli.Set(ctx, r.evictedKey, strconv.FormatFloat(newest, 'f', -1, 64), 0).Err(); err != nil {
+			return fmt.Errorf("set evicted: %w", err)
+		}
+	}
+	return nil
+}
+
+// touch marks the given message keys as used now. Keys outside of the window
+// of latest messages are ignored. It is a no-op unless the policy is
+// EvictLRU.
+func (r *Redis) touch(ctx context.Context, keys ...string) error {
+	if r.policy != EvictLRU || len(keys) == 0 {
+		return nil
+	}
+	now := float64(time.Now().UnixNano())
+	members := make([]redis.Z, len(keys))
+	for i, key := range keys {
+		members[i] = redis.Z{Score: now, Member: key}
+	}
+	if err := r.cli.ZAddXX(ctx, r.accessKey, members...).Err(); err != nil {
+		return fmt.Errorf("touch: %w", err)
+	}
+	return nil
+}
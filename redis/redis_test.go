@@ -4,7 +4,9 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -99,7 +101,7 @@ func TestRedis_ListMessages(t *testing.T) {
 				}
 			}
 
-			got, err := r.ListMessages(ctx)
+			got, err := r.ListMessages(ctx, time.Now(), api.OrderDesc, 10, true)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -110,6 +112,234 @@ func TestRedis_ListMessages(t *testing.T) {
 	}
 }
 
+func TestRedis_ListMessages_before(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	members := map[string]message{}
+	for i := 1; i <= 3; i++ {
+		id := fmt.Sprintf("message-%d", i)
+		members[r.messagePrefix+":"+id] = message{
+			ID:        id,
+			Text:      fmt.Sprintf("Message %d", i),
+			UserID:    "test",
+			CreatedAt: time.Date(2024, 1, i, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	if err := set(t, r, members); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Only messages strictly before Jan 3rd, and at most one of them.
+	got, err := r.ListMessages(ctx, time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), api.OrderDesc, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != "message-2" {
+		t.Errorf("Got %+v, want only message-2", got)
+	}
+}
+
+func TestRedis_ListMessages_order(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	members := map[string]message{}
+	for i := 1; i <= 3; i++ {
+		id := fmt.Sprintf("message-%d", i)
+		members[r.messagePrefix+":"+id] = message{
+			ID:        id,
+			Text:      fmt.Sprintf("Message %d", i),
+			UserID:    "test",
+			CreatedAt: time.Date(2024, 1, i, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	if err := set(t, r, members); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	for order, want := range map[api.Order][]string{
+		api.OrderDesc: {"message-3", "message-2"},
+		api.OrderAsc:  {"message-1", "message-2"},
+	} {
+		got, err := r.ListMessages(ctx, time.Now(), order, 2, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, m := range got {
+			ids = append(ids, m.ID)
+		}
+		if diff := cmp.Diff(ids, want); diff != "" {
+			t.Errorf("Order %s diff (-got +want)\n%s", order, diff)
+		}
+	}
+}
+
+func TestRedis_ListMessages_withReactions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	msg := api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	for i := range 2 {
+		rc := api.Reaction{
+			ID:        fmt.Sprintf("reaction-%d", i),
+			MessageID: msg.ID,
+			UserID:    "test",
+			Type:      "like",
+			Score:     1,
+			CreatedAt: time.Now().Add(-time.Minute),
+		}
+		if err := r.InsertReaction(ctx, msg.ID, rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, withReactions := range []bool{false, true} {
+		got, err := r.ListMessages(ctx, time.Now(), api.OrderDesc, 10, withReactions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("Got %d messages, want 1", len(got))
+		}
+		wantReactions := 0
+		if withReactions {
+			wantReactions = 2
+		}
+		if got[0].ReactionCount != 2 || len(got[0].Reactions) != wantReactions {
+			t.Errorf("withReactions=%t: got reaction count %d and %d reactions, want 2 and %d",
+				withReactions, got[0].ReactionCount, len(got[0].Reactions), wantReactions)
+		}
+	}
+}
+
+func TestRedis_ListMessages_reactionLimit(t *testing.T) {
+	for name, format := range map[string]Format{"Hash": FormatHash, "JSON": FormatJSON} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			r := connect(t, WithFormat(format), WithReactionLimit(2))
+			msg := api.Message{
+				ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+				Text:      "hello",
+				UserID:    "test",
+				CreatedAt: time.Now().Add(-time.Hour),
+			}
+			if err := r.InsertMessage(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+			for i := range 4 {
+				rc := api.Reaction{
+					ID:        fmt.Sprintf("reaction-%d", i),
+					MessageID: msg.ID,
+					UserID:    fmt.Sprintf("user-%d", i),
+					Type:      "like",
+					Score:     1,
+					CreatedAt: time.Now().Add(time.Duration(i-10) * time.Minute),
+				}
+				if err := r.InsertReaction(ctx, msg.ID, rc); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := r.ListMessages(ctx, time.Now(), api.OrderDesc, 10, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("Got %d messages, want 1", len(got))
+			}
+			var ids []string
+			for _, rc := range got[0].Reactions {
+				ids = append(ids, rc.ID)
+			}
+			if diff := cmp.Diff(ids, []string{"reaction-2", "reaction-3"}); diff != "" {
+				t.Errorf("Reactions diff (-got +want)\n%s", diff)
+			}
+			if got[0].ReactionCount != 4 {
+				t.Errorf("Got reaction count %d, want 4", got[0].ReactionCount)
+			}
+
+			// A single message is loaded with all of its reactions.
+			one, err := r.GetMessage(ctx, msg.ID, api.ReactionSortCreated, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(one.Reactions) != 4 {
+				t.Errorf("Got %d reactions of the message, want 4", len(one.Reactions))
+			}
+		})
+	}
+}
+
+func TestRedis_SetMessagePinned(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	pinned := api.Message{
+		ID:        "message-0",
+		Text:      "Pinned",
+		UserID:    "testuser",
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	if err := r.InsertMessage(ctx, pinned); err != nil {
+		t.Fatal(err)
+	}
+	pinned.Pinned = true
+	if err := r.SetMessagePinned(ctx, pinned); err != nil {
+		t.Fatal(err)
+	}
+
+	// Push the pinned message out of the window of latest messages.
+	for i := 1; i <= defaultMaxSize; i++ {
+		msg := api.Message{
+			ID:        fmt.Sprintf("message-%d", i),
+			Text:      fmt.Sprintf("Message %d", i),
+			UserID:    "testuser",
+			CreatedAt: time.Now().Add(time.Millisecond * time.Duration(i)),
+		}
+		if err := r.InsertMessage(ctx, msg); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	got, err := r.ListMessages(ctx, time.Now().Add(time.Minute), api.OrderDesc, defaultMaxSize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != defaultMaxSize {
+		t.Fatalf("Got %d messages, want %d", len(got), defaultMaxSize)
+	}
+	if got[0].ID != "message-0" || !got[0].Pinned {
+		t.Errorf("Got first message %+v, want pinned message-0", got[0])
+	}
+	if got[1].ID != fmt.Sprintf("message-%d", defaultMaxSize) {
+		t.Errorf("Got second message %q, want the latest message", got[1].ID)
+	}
+
+	pinned.Pinned = false
+	if err := r.SetMessagePinned(ctx, pinned); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.cli.Exists(ctx, r.messagePrefix+":message-0").Result(); err != nil || n != 0 {
+		t.Errorf("Unpinned message outside of the window is still cached")
+	}
+}
+
 func TestRedis_InsertMessage(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -123,7 +353,7 @@ func TestRedis_InsertMessage(t *testing.T) {
 				UserID: "testuser",
 			},
 			check: func(t *testing.T, r *Redis) {
-				vals, err := r.cli.ZRange(context.Background(), messagePrefix, 0, 10).Result()
+				vals, err := r.cli.ZRange(context.Background(), r.messagePrefix, 0, 10).Result()
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -163,13 +393,159 @@ func TestRedis_InsertMessage(t *testing.T) {
 	}
 }
 
+func TestRedis_InsertMessage_replyCount(t *testing.T) {
+	formats := map[string]Format{"Hash": FormatHash, "JSON": FormatJSON}
+	for name, format := range formats {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			r := connect(t, WithFormat(format))
+			parent := api.Message{ID: "parent", Text: "hello", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Pinned: true}
+			if err := r.SetMessagePinned(ctx, parent); err != nil {
+				t.Fatal(err)
+			}
+
+			// Every refresh inserts the reply again.
+			reply := api.Message{ID: "reply", Text: "hi", UserID: "test", ParentID: parent.ID, CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+			for range 3 {
+				if err := r.InsertMessage(ctx, reply); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := r.GetMessage(ctx, parent.ID, api.ReactionSortCreated, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ReplyCount != 1 {
+				t.Errorf("Got reply count %d, want 1", got.ReplyCount)
+			}
+		})
+	}
+}
+
+func TestRedis_DeleteMessage_replies(t *testing.T) {
+	formats := map[string]Format{"Hash": FormatHash, "JSON": FormatJSON}
+	for name, format := range formats {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			r := connect(t, WithFormat(format))
+			msgs := []api.Message{
+				{ID: "parent", Text: "hello", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+				{ID: "reply", Text: "hi", UserID: "test", ParentID: "parent", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+				{ID: "nested", Text: "hey", UserID: "test", ParentID: "reply", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+			}
+			for _, m := range msgs {
+				if err := r.InsertMessage(ctx, m); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Deleting a reply decrements the reply count of its parent.
+			if err := r.DeleteMessage(ctx, "nested"); err != nil {
+				t.Fatal(err)
+			}
+			got, err := r.GetMessage(ctx, "reply", api.ReactionSortCreated, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ReplyCount != 0 {
+				t.Errorf("Got reply count %d, want 0", got.ReplyCount)
+			}
+
+			// Deleting a parent deletes its replies, like the DB does.
+			if err := r.DeleteMessage(ctx, "parent"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.GetMessage(ctx, "reply", api.ReactionSortCreated, ""); !errors.Is(err, api.ErrNotFound) {
+				t.Errorf("Got error %v for the reply, want %v", err, api.ErrNotFound)
+			}
+			listed, err := r.ListMessages(ctx, time.Now(), api.OrderDesc, 10, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listed) != 0 {
+				t.Errorf("Got %d listed messages, want none", len(listed))
+			}
+		})
+	}
+}
+
+func TestRedis_InsertMessage_atomic(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	// Make the ZADD in the transaction fail after the HSET succeeded.
+	if err := r.cli.Set(ctx, r.messagePrefix, "not a sorted set", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := r.InsertMessage(ctx, api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Now(),
+	})
+	if err == nil {
+		t.Fatal("InsertMessage() succeeded, want error")
+	}
+
+	n, err := r.cli.Exists(ctx, r.messagePrefix+":9cbf8127-299b-4a84-8920-cd35ea0c084c").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("Message hash was left behind without a sorted set entry")
+	}
+}
+
+func TestRedis_InsertMessage_atomicCached(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t, WithEvictionPolicy(EvictLRU))
+	msg := api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Now(),
+	}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	// Make the ZADD of the access time fail when the message is inserted
+	// again.
+	if err := r.cli.Set(ctx, r.accessKey, "not a sorted set", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.InsertMessage(ctx, msg); err == nil {
+		t.Fatal("InsertMessage() succeeded, want error")
+	}
+
+	// The copy cached before is still valid.
+	n, err := r.cli.Exists(ctx, r.messageKey(msg.ID)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Error("Cached message was deleted by the failed insert")
+	}
+	if err := r.cli.ZScore(ctx, r.messagePrefix, r.messageKey(msg.ID)).Err(); err != nil {
+		t.Errorf("Cached message was removed from the sorted set: %v", err)
+	}
+}
+
 func TestRedis_InsertMessage_MaxSize(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	r := connect(t)
 	// Insert 11 items.
-	for i := 0; i <= maxSize; i++ {
+	for i := 0; i <= defaultMaxSize; i++ {
 		msg := api.Message{
 			ID:        fmt.Sprintf("message-%d", i+1),
 			Text:      fmt.Sprintf("Message %d", i+1),
@@ -182,13 +558,13 @@ func TestRedis_InsertMessage_MaxSize(t *testing.T) {
 	}
 
 	// Fetching all 11 items should return 10 items because no more than 10 messages should be stored.
-	vals, err := r.cli.ZRevRange(ctx, messagePrefix, 0, 10).Result()
+	vals, err := r.cli.ZRevRange(ctx, r.messagePrefix, 0, 10).Result()
 
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(vals) != maxSize {
-		t.Fatalf("Expected %d items in Redis, got %d", maxSize, len(vals))
+	if len(vals) != defaultMaxSize {
+		t.Fatalf("Expected %d items in Redis, got %d", defaultMaxSize, len(vals))
 	}
 	for i, val := range vals {
 		var got message
@@ -196,19 +572,883 @@ func TestRedis_InsertMessage_MaxSize(t *testing.T) {
 			t.Fatalf("Could not get message: %v", err)
 		}
 		// First message in the list should be #11, then #10, ..., the last one #2.
-		want := fmt.Sprintf("Message %d", maxSize+1-i)
+		want := fmt.Sprintf("Message %d", defaultMaxSize+1-i)
 		if got.Text != want {
 			t.Errorf("Stored message text does not match; got %q, want %q", got.Text, want)
 		}
 	}
 }
 
-func connect(t *testing.T) *Redis {
+func TestRedis_InsertMessage_customMaxSize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const size, extra = 3, 2
+	r := connect(t, WithMaxSize(size))
+	for i := 0; i < size+extra; i++ {
+		msg := api.Message{
+			ID:        fmt.Sprintf("message-%d", i+1),
+			Text:      fmt.Sprintf("Message %d", i+1),
+			UserID:    "testuser",
+			CreatedAt: time.Now().Add(time.Millisecond * time.Duration(i)),
+		}
+		if err := r.InsertMessage(ctx, msg); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	n, err := r.cli.ZCard(ctx, r.messagePrefix).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != size {
+		t.Fatalf("Expected %d items in Redis, got %d", size, n)
+	}
+
+	got, err := r.ListMessages(ctx, time.Now().Add(time.Minute), api.OrderDesc, size+extra, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != size || got[0].ID != fmt.Sprintf("message-%d", size+extra) {
+		t.Errorf("Got %+v, want the %d latest messages", got, size)
+	}
+}
+
+func TestRedis_GetMessage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	want := api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Reactions: []api.Reaction{},
+	}
+	if err := r.InsertMessage(ctx, want); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	got, err := r.GetMessage(ctx, want.ID, api.ReactionSortCreated, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+
+	_, err = r.GetMessage(ctx, "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a", api.ReactionSortCreated, "")
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestRedis_GetMessage_reactions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	msg := api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	reactions := []api.Reaction{
+		{ID: "1", MessageID: msg.ID, Type: "like", Score: 1, UserID: "alice", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: "2", MessageID: msg.ID, Type: "love", Score: 2, UserID: "bob", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{ID: "3", MessageID: msg.ID, Type: "like", Score: 1, UserID: "carol", CreatedAt: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, rc := range reactions {
+		if err := r.InsertReaction(ctx, msg.ID, rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := r.GetMessage(ctx, msg.ID, api.ReactionSortCreated, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := msg
+	want.Reactions = reactions
+	want.ReactionCount = 3
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+
+	got, err = r.GetMessage(ctx, msg.ID, api.ReactionSortScore, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Reactions = []api.Reaction{reactions[1], reactions[2], reactions[0]}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff sorted by score (-got +want)\n%s", diff)
+	}
+
+	got, err = r.GetMessage(ctx, msg.ID, api.ReactionSortCreated, "like")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Reactions = []api.Reaction{reactions[0], reactions[2]}
+	want.ReactionCount = 2
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff filtered by type (-got +want)\n%s", diff)
+	}
+}
+
+func TestRedis_keyPrefix(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// connect flushes Redis, so both caches are connected before writing.
+	staging := connect(t, WithKeyPrefix("staging"))
+	prod := connect(t, WithKeyPrefix("prod"))
+
+	msg := api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if err := staging.InsertMessage(ctx, msg); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	rc := api.Reaction{ID: "1", MessageID: msg.ID, Type: "like", Score: 1, UserID: "test", CreatedAt: msg.CreatedAt}
+	if err := staging.InsertReaction(ctx, msg.ID, rc); err != nil {
+		t.Fatalf("Insert reaction failed: %v", err)
+	}
+
+	if got, err := staging.ListMessages(ctx, time.Now(), api.OrderDesc, 10, true); err != nil || len(got) != 1 {
+		t.Errorf("Got %d messages in staging (error %v), want 1", len(got), err)
+	}
+	if got, err := prod.ListMessages(ctx, time.Now(), api.OrderDesc, 10, true); err != nil || len(got) != 0 {
+		t.Errorf("Got %d messages in prod (error %v), want none", len(got), err)
+	}
+	if got, err := prod.ListReactions(ctx, msg.ID, 0); err != nil || len(got) != 0 {
+		t.Errorf("Got %d reactions in prod (error %v), want none", len(got), err)
+	}
+	if _, err := prod.GetMessage(ctx, msg.ID, api.ReactionSortCreated, ""); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v getting the message from prod, want %v", err, api.ErrNotFound)
+	}
+
+	keys, err := staging.cli.Keys(ctx, "*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "staging:") {
+			t.Errorf("Got key %q outside of the staging namespace", key)
+		}
+	}
+}
+
+func TestRedis_formatJSON(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t, WithFormat(FormatJSON))
+	msg := api.Message{
+		ID:          "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:        "hello",
+		UserID:      "test",
+		CreatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Attachments: []api.Attachment{{URL: "https://example.com/cat.png", Type: "image/png"}},
+	}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	reply := api.Message{
+		ID:        "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a",
+		Text:      "hi",
+		UserID:    "test",
+		ParentID:  msg.ID,
+		CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	if err := r.InsertMessage(ctx, reply); err != nil {
+		t.Fatalf("Insert reply failed: %v", err)
+	}
+	reactions := []api.Reaction{
+		{ID: "1", MessageID: msg.ID, Type: "like", Score: 1, UserID: "alice", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: "2", MessageID: msg.ID, Type: "love", Emoji: "❤️", Score: 2, UserID: "bob", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, rc := range reactions {
+		if err := r.InsertReaction(ctx, msg.ID, rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The message is a single string.
+	if typ := r.cli.Type(ctx, r.messagePrefix+":"+msg.ID).Val(); typ != "string" {
+		t.Errorf("Got message stored as %s, want string", typ)
+	}
+
+	want := msg
+	want.Reactions = reactions
+	want.ReactionCount = 2
+	want.ReplyCount = 1
+	got, err := r.GetMessage(ctx, msg.ID, api.ReactionSortCreated, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+
+	list, err := r.ListMessages(ctx, time.Now(), api.OrderAsc, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != msg.ID || list[0].ReactionCount != 2 || len(list[0].Reactions) != 0 {
+		t.Errorf("Got listed messages %+v, want the message with 2 counted reactions and its reply", list)
+	}
+
+	found, err := r.FindReaction(ctx, msg.ID, "bob", "love")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(found, reactions[1]); diff != "" {
+		t.Errorf("Found reaction diff (-got +want)\n%s", diff)
+	}
+
+	if err := r.DeleteReaction(ctx, msg.ID, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteReaction(ctx, msg.ID, "1"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v deleting a deleted reaction, want %v", err, api.ErrNotFound)
+	}
+	if err := r.SetMessageHidden(ctx, msg.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	msg.Pinned = true
+	if err := r.SetMessagePinned(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	want.Reactions = reactions[1:]
+	want.ReactionCount = 1
+	want.Hidden = true
+	want.Pinned = true
+	got, err = r.GetMessage(ctx, msg.ID, api.ReactionSortCreated, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff after updates (-got +want)\n%s", diff)
+	}
+}
+
+func TestRedis_evictReactions(t *testing.T) {
+	policies := map[string]EvictionPolicy{"FIFO": EvictFIFO, "LRU": EvictLRU}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			r := connect(t, WithMaxSize(1), WithEvictionPolicy(policy))
+			first := api.Message{ID: "message-1", Text: "first", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			if err := r.InsertMessage(ctx, first); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+			for i := range 3 {
+				rc := api.Reaction{ID: fmt.Sprint(i), MessageID: first.ID, Type: fmt.Sprintf("type-%d", i), Score: 1, UserID: "test", CreatedAt: first.CreatedAt}
+				if err := r.InsertReaction(ctx, first.ID, rc); err != nil {
+					t.Fatalf("Insert reaction failed: %v", err)
+				}
+			}
+			got, err := r.ListReactions(ctx, first.ID, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 3 {
+				t.Errorf("Listed %d reactions, want 3", len(got))
+			}
+
+			second := api.Message{ID: "message-2", Text: "second", UserID: "test", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+			if err := r.InsertMessage(ctx, second); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+			keys, err := r.cli.Keys(ctx, r.messageKey(first.ID)+"*").Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 0 {
+				t.Errorf("Got keys %v of the evicted message, want none", keys)
+			}
+		})
+	}
+}
+
+func TestRedis_InsertMessage_evictionPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      EvictionPolicy
+		wantCached  []string
+		wantEvicted []string
+		wantListed  []string
+	}{
+		{
+			name:        "FIFO",
+			policy:      EvictFIFO,
+			wantCached:  []string{"message-2", "message-3"},
+			wantEvicted: []string{"message-1"},
+			wantListed:  []string{"message-3", "message-2"},
+		},
+		{
+			// message-1 is fetched after message-2 is inserted, so
+			// message-2 is the least recently used. message-1 is not
+			// listed, as message-2 is missing between it and message-3.
+			name:        "LRU",
+			policy:      EvictLRU,
+			wantCached:  []string{"message-1", "message-3"},
+			wantEvicted: []string{"message-2"},
+			wantListed:  []string{"message-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			r := connect(t, WithMaxSize(2), WithEvictionPolicy(tt.policy))
+			insert := func(i int) {
+				t.Helper()
+				err := r.InsertMessage(ctx, api.Message{
+					ID:        fmt.Sprintf("message-%d", i),
+					Text:      fmt.Sprintf("Message %d", i),
+					UserID:    "test",
+					CreatedAt: time.Date(2024, 1, i, 0, 0, 0, 0, time.UTC),
+				})
+				if err != nil {
+					t.Fatalf("Insert failed: %v", err)
+				}
+			}
+
+			insert(1)
+			insert(2)
+			if _, err := r.GetMessage(ctx, "message-1", api.ReactionSortCreated, ""); err != nil {
+				t.Fatal(err)
+			}
+			// Refreshing the cache inserts message-2 again, which does not
+			// use it.
+			insert(2)
+			insert(3)
+
+			for _, id := range tt.wantCached {
+				if _, err := r.GetMessage(ctx, id, api.ReactionSortCreated, ""); err != nil {
+					t.Errorf("Get %s: %v", id, err)
+				}
+			}
+			for _, id := range tt.wantEvicted {
+				if _, err := r.GetMessage(ctx, id, api.ReactionSortCreated, ""); !errors.Is(err, api.ErrNotFound) {
+					t.Errorf("Got error %v for %s, want %v", err, id, api.ErrNotFound)
+				}
+			}
+
+			got, err := r.ListMessages(ctx, time.Now(), api.OrderDesc, 10, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, m := range got {
+				ids = append(ids, m.ID)
+			}
+			if diff := cmp.Diff(ids, tt.wantListed); diff != "" {
+				t.Errorf("Listed diff (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRedis_GetReaction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	want := api.Reaction{
+		ID:        "c1f0a7a4-5d7e-4f4f-9a53-0d9b8e0b6f11",
+		MessageID: "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		UserID:    "test",
+		Type:      "like",
+		Score:     2,
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	msg := api.Message{ID: want.MessageID, Text: "hello", UserID: "test", CreatedAt: time.Now()}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := r.InsertReaction(ctx, want.MessageID, want); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	got, err := r.GetReaction(ctx, want.MessageID, want.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+
+	_, err = r.GetReaction(ctx, want.MessageID, "0e8a3f4c-2b7d-4a55-8a0f-7f1c2d3e4b5a")
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestRedis_InsertReaction_uncached(t *testing.T) {
+	for name, format := range map[string]Format{"Hash": FormatHash, "JSON": FormatJSON} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			r := connect(t, WithFormat(format))
+			rc := api.Reaction{
+				ID:        "c1f0a7a4-5d7e-4f4f-9a53-0d9b8e0b6f11",
+				MessageID: "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+				UserID:    "test",
+				Type:      "like",
+				Score:     1,
+				CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			}
+			if err := r.InsertReaction(ctx, rc.MessageID, rc); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+
+			keys, err := r.cli.Keys(ctx, "*").Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 0 {
+				t.Errorf("Got keys %v, want none", keys)
+			}
+		})
+	}
+}
+
+func TestRedis_FindReaction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	want := api.Reaction{
+		ID:        "c1f0a7a4-5d7e-4f4f-9a53-0d9b8e0b6f11",
+		MessageID: "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		UserID:    "test",
+		Type:      "like",
+		Score:     1,
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	msg := api.Message{ID: want.MessageID, Text: "hello", UserID: "test", CreatedAt: time.Now()}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := r.InsertReaction(ctx, want.MessageID, want); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	got, err := r.FindReaction(ctx, want.MessageID, "test", "like")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+
+	if _, err := r.FindReaction(ctx, want.MessageID, "test", "love"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for another type, want %v", err, api.ErrNotFound)
+	}
+	if _, err := r.FindReaction(ctx, want.MessageID, "other", "like"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v for another user, want %v", err, api.ErrNotFound)
+	}
+
+	if err := r.DeleteReaction(ctx, want.MessageID, want.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.FindReaction(ctx, want.MessageID, "test", "like"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v after delete, want %v", err, api.ErrNotFound)
+	}
+}
+
+// Reaction counts are derived from the reactions on every read rather than
+// stored in the message hash, so deleting a reaction must be reflected by the
+// next ListMessages.
+func TestRedis_DeleteReaction_reactionCount(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	msg := api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	for i := range 2 {
+		rc := api.Reaction{
+			ID:        fmt.Sprintf("reaction-%d", i),
+			MessageID: msg.ID,
+			UserID:    "test",
+			Type:      "like",
+			Score:     1,
+			CreatedAt: time.Now().Add(-time.Minute),
+		}
+		if err := r.InsertReaction(ctx, msg.ID, rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reactionCount := func() int {
+		t.Helper()
+		msgs, err := r.ListMessages(ctx, time.Now(), api.OrderDesc, 10, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 {
+			t.Fatalf("Got %d messages, want 1", len(msgs))
+		}
+		return msgs[0].ReactionCount
+	}
+
+	if got := reactionCount(); got != 2 {
+		t.Errorf("Got reaction count %d, want 2", got)
+	}
+
+	if err := r.DeleteReaction(ctx, msg.ID, "reaction-0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := reactionCount(); got != 1 {
+		t.Errorf("Got reaction count %d after delete, want 1", got)
+	}
+
+	if err := r.DeleteReaction(ctx, msg.ID, "reaction-0"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestRedis_reactionCounts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	msg := api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	reactions := []api.Reaction{
+		{ID: "reaction-0", UserID: "alice", Type: "like"},
+		{ID: "reaction-1", UserID: "bob", Type: "like"},
+		{ID: "reaction-2", UserID: "alice", Type: "love"},
+	}
+	for _, rc := range reactions {
+		rc.MessageID = msg.ID
+		rc.Score = 1
+		rc.CreatedAt = time.Now().Add(-time.Minute)
+		if err := r.InsertReaction(ctx, msg.ID, rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(want map[string]string, wantTotal int) {
+		t.Helper()
+		got, err := r.cli.HGetAll(ctx, reactionCountsKey(r.messageKey(msg.ID))).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Counts diff (-got +want)\n%s", diff)
+		}
+		msgs, err := r.ListMessages(ctx, time.Now(), api.OrderDesc, 10, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 || msgs[0].ReactionCount != wantTotal {
+			t.Errorf("Got messages %+v, want one with %d reactions", msgs, wantTotal)
+		}
+	}
+	check(map[string]string{"like": "2", "love": "1"}, 3)
+
+	// Refreshing the cache inserts the cached reactions again.
+	rc := reactions[0]
+	rc.MessageID = msg.ID
+	if err := r.InsertReaction(ctx, msg.ID, rc); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]string{"like": "2", "love": "1"}, 3)
+
+	if err := r.DeleteReaction(ctx, msg.ID, "reaction-0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteReaction(ctx, msg.ID, "reaction-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteReaction(ctx, msg.ID, "reaction-2"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v deleting the reaction again, want %v", err, api.ErrNotFound)
+	}
+	check(map[string]string{"like": "1", "love": "0"}, 1)
+
+	// Reactions cached before the hash was introduced are counted once the
+	// hash is rebuilt.
+	if err := r.cli.Del(ctx, reactionCountsKey(r.messageKey(msg.ID))).Err(); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := r.ListMessages(ctx, time.Now(), api.OrderDesc, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].ReactionCount != 1 {
+		t.Errorf("Got messages %+v, want one with 1 reaction", msgs)
+	}
+	check(map[string]string{"like": "1"}, 1)
+}
+
+func TestRedis_Typing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	if err := r.SetTyping(ctx, "bob", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetTyping(ctx, "alice", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.ListTyping(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []string{"alice", "bob"}); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+
+	// alice's marker expires.
+	time.Sleep(1500 * time.Millisecond)
+	got, err = r.ListTyping(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []string{"bob"}); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+}
+
+func TestRedis_ReactionSummary(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	const msgID = "9cbf8127-299b-4a84-8920-cd35ea0c084c"
+	msg := api.Message{ID: msgID, Text: "hello", UserID: "test", CreatedAt: time.Now()}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	for i, rc := range []api.Reaction{
+		{Type: "like", Score: 1},
+		{Type: "like", Score: 3},
+		{Type: "love", Score: 2},
+	} {
+		rc.ID = fmt.Sprintf("reaction-%d", i)
+		rc.MessageID = msgID
+		rc.UserID = "test"
+		rc.CreatedAt = time.Now().Add(-time.Minute)
+		if err := r.InsertReaction(ctx, msgID, rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := r.ReactionSummary(ctx, msgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := api.ReactionSummary{Counts: map[string]int{"like": 2, "love": 1}, Total: 3, Score: 6}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+}
+
+func TestRedis_EmojiCounts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	const msgID = "9cbf8127-299b-4a84-8920-cd35ea0c084c"
+	msg := api.Message{ID: msgID, Text: "hello", UserID: "test", CreatedAt: time.Now()}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	for i, rc := range []api.Reaction{
+		{Type: "like", Emoji: "👍"},
+		{Type: "like", Emoji: "👍"},
+		{Type: "party", Emoji: "🎉"},
+		{Type: "love"},
+	} {
+		rc.ID = fmt.Sprintf("reaction-%d", i)
+		rc.MessageID = msgID
+		rc.UserID = "test"
+		rc.CreatedAt = time.Now().Add(-time.Minute)
+		if err := r.InsertReaction(ctx, msgID, rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := r.EmojiCounts(ctx, msgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"👍": 2, "🎉": 1}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+}
+
+func TestRedis_MessageCount(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	if _, err := r.GetMessageCount(ctx); !errors.Is(err, api.ErrNotFound) {
+		t.Fatalf("GetMessageCount() error = %v, want %v", err, api.ErrNotFound)
+	}
+
+	if err := r.SetMessageCount(ctx, 42, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetMessageCount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 42 {
+		t.Errorf("GetMessageCount() = %d, want 42", got)
+	}
+
+	// Inserting a message invalidates the count.
+	err = r.InsertMessage(ctx, api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetMessageCount(ctx); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("GetMessageCount() error = %v, want %v", err, api.ErrNotFound)
+	}
+
+	// Deleting a message invalidates the count too.
+	if err := r.SetMessageCount(ctx, 42, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteMessage(ctx, "9cbf8127-299b-4a84-8920-cd35ea0c084c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetMessageCount(ctx); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("GetMessageCount() error = %v after delete, want %v", err, api.ErrNotFound)
+	}
+}
+
+func TestRedis_Hit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	for want := 1; want <= 2; want++ {
+		got, reset, err := r.Hit(ctx, "127.0.0.1", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Got count %d, want %d", got, want)
+		}
+		if until := time.Until(reset); until <= 0 || until > time.Minute {
+			t.Errorf("Got reset in %s, want within a minute", until)
+		}
+	}
+
+	// Keys are counted independently.
+	got, _, err := r.Hit(ctx, "127.0.0.2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Errorf("Got count %d for other key, want 1", got)
+	}
+}
+
+func TestRedis_Flush(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r := connect(t)
+	msg := api.Message{
+		ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Now(),
+		Pinned:    true,
+	}
+	if err := r.InsertMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetMessagePinned(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	err := r.InsertReaction(ctx, msg.ID, api.Reaction{
+		ID:        "reaction-1",
+		MessageID: msg.ID,
+		Type:      "like",
+		Score:     1,
+		UserID:    "test",
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetTyping(ctx, "test", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The message hash, the latest and pinned sets and the reaction hash and
+	// set.
+	if got != 5 {
+		t.Errorf("Flush() deleted %d keys, want 5", got)
+	}
+
+	keys, err := r.cli.Keys(ctx, r.messagePrefix+"*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("Got keys %v after flush, want none", keys)
+	}
+	// Other keys are left alone.
+	if n, err := r.cli.Exists(ctx, r.typingPrefix+":test").Result(); err != nil || n != 1 {
+		t.Errorf("Typing marker was deleted")
+	}
+}
+
+func connect(t *testing.T, opts ...Option) *Redis {
 	t.Helper()
 	addr := "localhost:6379"
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	r, err := Connect(ctx, addr)
+	r, err := Connect(ctx, addr, opts...)
 	if err != nil {
 		t.Fatalf("Could not connect to Redis: %v", err)
 	}
@@ -228,7 +1468,7 @@ func set(t *testing.T, r *Redis, messages map[string]message) error {
 			return err
 		}
 
-		if err := r.cli.ZAdd(context.Background(), messagePrefix, redis.Z{
+		if err := r.cli.ZAdd(context.Background(), r.messagePrefix, redis.Z{
 			Score:  float64(msg.CreatedAt.UnixNano()),
 			Member: key,
 		}).Err(); err != nil {